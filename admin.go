@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/spf13/cast"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/webpa-common/v2/basculechecks" //nolint: staticcheck
+)
+
+// AdminConfig controls who may use the admin endpoints and options.  With
+// neither list set, nobody is an admin.
+type AdminConfig struct {
+	// Capabilities grants admin access to JWTs carrying any of these
+	// capabilities.
+	Capabilities []string
+
+	// BasicUsers grants admin access to these basic auth users.
+	BasicUsers []string
+}
+
+// isAdmin reports whether the request was authenticated as an admin.
+func (c AdminConfig) isAdmin(r *http.Request) bool {
+	auth, ok := bascule.FromContext(r.Context())
+	if !ok || auth.Token == nil {
+		return false
+	}
+
+	switch auth.Token.Type() {
+	case basicType:
+		return contains(c.BasicUsers, auth.Token.Principal())
+	case "jwt":
+		return overlaps(tokenCapabilities(auth.Token), c.Capabilities)
+	}
+	return false
+}
+
+// tokenCapabilities returns the capabilities claimed by a JWT.
+func tokenCapabilities(token bascule.Token) []string {
+	val, ok := token.Attributes().Get(basculechecks.CapabilityKey)
+	if !ok {
+		return nil
+	}
+	capabilities, err := cast.ToStringSliceE(val)
+	if err != nil {
+		return nil
+	}
+	return capabilities
+}
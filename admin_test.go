@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xmidt-org/bascule"
+)
+
+func TestIsAdmin(t *testing.T) {
+	config := AdminConfig{
+		Capabilities: []string{"x1:gungnir:admin"},
+		BasicUsers:   []string{"admin"},
+	}
+
+	tests := []struct {
+		description string
+		auth        *bascule.Authentication
+		config      AdminConfig
+		expected    bool
+	}{
+		{
+			description: "No Auth",
+			config:      config,
+		},
+		{
+			description: "JWT With Capability",
+			auth: &bascule.Authentication{
+				Token: bascule.NewToken("jwt", "owner", bascule.NewAttributes(
+					map[string]interface{}{"capabilities": []interface{}{"x1:webpa:api:.*:all", "x1:gungnir:admin"}})),
+			},
+			config:   config,
+			expected: true,
+		},
+		{
+			description: "JWT Without Capability",
+			auth: &bascule.Authentication{
+				Token: bascule.NewToken("jwt", "owner", bascule.NewAttributes(
+					map[string]interface{}{"capabilities": []interface{}{"x1:webpa:api:.*:all"}})),
+			},
+			config: config,
+		},
+		{
+			description: "JWT Without Capabilities Claim",
+			auth: &bascule.Authentication{
+				Token: bascule.NewToken("jwt", "owner", bascule.NewAttributes(map[string]interface{}{})),
+			},
+			config: config,
+		},
+		{
+			description: "Admin Basic User",
+			auth:        &bascule.Authentication{Token: bascule.NewToken("basic", "admin", bascule.NewAttributes(nil))},
+			config:      config,
+			expected:    true,
+		},
+		{
+			description: "Other Basic User",
+			auth:        &bascule.Authentication{Token: bascule.NewToken("basic", "user", bascule.NewAttributes(nil))},
+			config:      config,
+		},
+		{
+			description: "Nothing Configured",
+			auth:        &bascule.Authentication{Token: bascule.NewToken("basic", "admin", bascule.NewAttributes(nil))},
+		},
+		{
+			description: "Unknown Token Type",
+			auth:        &bascule.Authentication{Token: bascule.NewToken("spongebob", "admin", bascule.NewAttributes(nil))},
+			config:      config,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.auth != nil {
+				ctx = bascule.WithAuthentication(ctx, *tc.auth)
+			}
+			request, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, tc.config.isAdmin(request))
+		})
+	}
+}
@@ -0,0 +1,525 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/webpa-common/v2/logging" //nolint: staticcheck
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// The sinks audit records can be written to.
+const (
+	auditSinkFile   = "file"
+	auditSinkStdout = "stdout"
+	auditSinkHTTP   = "http"
+)
+
+// What happens to an audit record when the buffer is full.
+const (
+	auditBackpressureDrop  = "drop"
+	auditBackpressureBlock = "block"
+)
+
+// The outcomes of writing an audit record.
+const (
+	auditWritten = "written"
+	auditDropped = "dropped"
+	auditFailed  = "failed"
+)
+
+const (
+	defaultAuditBufferSize    = 1000
+	defaultAuditBatchSize     = 100
+	defaultAuditFlushInterval = time.Second
+	defaultAuditBlockTimeout  = 100 * time.Millisecond
+	defaultAuditHTTPTimeout   = 10 * time.Second
+	defaultAuditFileMaxSizeMB = 100
+)
+
+// AuditFileConfig configures the rotating file sink.
+type AuditFileConfig struct {
+	// Path is the file audit records are written to.
+	Path string
+
+	// MaxSizeMB is how large the file can grow before it is rotated.
+	// Defaults to 100.
+	MaxSizeMB int
+
+	// MaxBackups is how many rotated files are kept.  0 keeps them all.
+	MaxBackups int
+
+	// MaxAgeDays is how long rotated files are kept.  0 keeps them forever.
+	MaxAgeDays int
+
+	// Compress gzips the rotated files.
+	Compress bool
+}
+
+// AuditHTTPConfig configures the HTTP collector sink.
+type AuditHTTPConfig struct {
+	// URL is where batches of records are POSTed as newline delimited JSON.
+	URL string
+
+	// Timeout bounds each POST.  Defaults to 10s.
+	Timeout time.Duration
+}
+
+// AuditConfig configures the audit log of requests for device data.
+type AuditConfig struct {
+	// Sink is file, stdout or http.  Auditing is off if it is empty.
+	Sink string
+
+	File AuditFileConfig
+	HTTP AuditHTTPConfig
+
+	// BufferSize is how many records can wait to be written.  Defaults to
+	// 1000.
+	BufferSize int
+
+	// BatchSize is the most records written at once.  Defaults to 100.
+	BatchSize int
+
+	// FlushInterval is the longest a record waits for its batch to fill.
+	// Defaults to 1s.
+	FlushInterval time.Duration
+
+	// Backpressure is what happens when the buffer is full: drop the record
+	// (the default), or block the request for up to BlockTimeout before
+	// dropping it.
+	Backpressure string
+
+	// BlockTimeout defaults to 100ms.
+	BlockTimeout time.Duration
+}
+
+// validateAuditConfig fills in the defaults for an audit configuration and
+// returns every problem with it.
+func validateAuditConfig(config *AuditConfig) []error {
+	if config.Sink == "" {
+		return nil
+	}
+
+	if config.BufferSize == 0 {
+		config.BufferSize = defaultAuditBufferSize
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = defaultAuditBatchSize
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = defaultAuditFlushInterval
+	}
+	if config.Backpressure == "" {
+		config.Backpressure = auditBackpressureDrop
+	}
+	if config.BlockTimeout == 0 {
+		config.BlockTimeout = defaultAuditBlockTimeout
+	}
+	if config.HTTP.Timeout == 0 {
+		config.HTTP.Timeout = defaultAuditHTTPTimeout
+	}
+	if config.File.MaxSizeMB == 0 {
+		config.File.MaxSizeMB = defaultAuditFileMaxSizeMB
+	}
+
+	var errs []error
+	switch config.Sink {
+	case auditSinkStdout:
+	case auditSinkFile:
+		if config.File.Path == "" {
+			errs = append(errs, errors.New("audit.file.path is required for the file sink"))
+		}
+	case auditSinkHTTP:
+		if u, err := url.Parse(config.HTTP.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("audit.http.url must be an http or https URL, got %q", config.HTTP.URL))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("audit.sink must be \"file\", \"stdout\", \"http\" or empty, got %q", config.Sink))
+	}
+	if config.Backpressure != auditBackpressureDrop && config.Backpressure != auditBackpressureBlock {
+		errs = append(errs, fmt.Errorf("audit.backpressure must be \"drop\" or \"block\", got %q", config.Backpressure))
+	}
+	for _, v := range []struct {
+		name  string
+		value int
+	}{
+		{"bufferSize", config.BufferSize},
+		{"batchSize", config.BatchSize},
+		{"file.maxSizeMB", config.File.MaxSizeMB},
+	} {
+		if v.value < 0 {
+			errs = append(errs, fmt.Errorf("audit.%s must be positive, got %d", v.name, v.value))
+		}
+	}
+	for _, v := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"flushInterval", config.FlushInterval},
+		{"blockTimeout", config.BlockTimeout},
+		{"http.timeout", config.HTTP.Timeout},
+	} {
+		if v.value < 0 {
+			errs = append(errs, fmt.Errorf("audit.%s must be positive, got %s", v.name, v.value))
+		}
+	}
+	return errs
+}
+
+// AuditRecord describes one request for device data.
+type AuditRecord struct {
+	Time           time.Time `json:"time"`
+	Principal      string    `json:"principal"`
+	AuthType       string    `json:"auth_type"`
+	PartnerIDs     []string  `json:"partner_ids"`
+	DeviceID       string    `json:"device_id"`
+	Endpoint       string    `json:"endpoint"`
+	Method         string    `json:"method"`
+	ResultCount    int       `json:"result_count"`
+	StatusCode     int       `json:"status_code"`
+	LatencySeconds float64   `json:"latency_seconds"`
+}
+
+// AuditSink writes batches of audit records somewhere durable.
+type AuditSink interface {
+	Write(records []AuditRecord) error
+	Close() error
+}
+
+// writerAuditSink writes records as newline delimited JSON.
+type writerAuditSink struct {
+	w io.WriteCloser
+}
+
+func (s writerAuditSink) Write(records []AuditRecord) error {
+	data, err := encodeAuditRecords(records)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(data)
+	return err
+}
+
+func (s writerAuditSink) Close() error {
+	return s.w.Close()
+}
+
+// nopCloser keeps stdout open when the sink is closed.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// httpAuditSink POSTs each batch of records to a collector.
+type httpAuditSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s httpAuditSink) Write(records []AuditRecord) error {
+	data, err := encodeAuditRecords(records)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/x-ndjson", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+func (s httpAuditSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func encodeAuditRecords(records []AuditRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// newAuditSink creates the sink the configuration asks for.
+func newAuditSink(config AuditConfig) (AuditSink, error) {
+	switch config.Sink {
+	case auditSinkStdout:
+		return writerAuditSink{w: nopCloser{os.Stdout}}, nil
+	case auditSinkFile:
+		return writerAuditSink{w: &lumberjack.Logger{
+			Filename:   config.File.Path,
+			MaxSize:    config.File.MaxSizeMB,
+			MaxBackups: config.File.MaxBackups,
+			MaxAge:     config.File.MaxAgeDays,
+			Compress:   config.File.Compress,
+		}}, nil
+	case auditSinkHTTP:
+		return httpAuditSink{url: config.HTTP.URL, client: &http.Client{Timeout: config.HTTP.Timeout}}, nil
+	}
+	return nil, fmt.Errorf("unknown audit sink %q", config.Sink)
+}
+
+// Auditor buffers audit records and writes them to the sink in batches, in
+// the background, so that a slow sink doesn't slow down requests.  When the
+// buffer is full, records are dropped rather than making requests wait
+// forever, and every record dropped or failed is counted.
+type Auditor struct {
+	records       chan AuditRecord
+	sink          AuditSink
+	batchSize     int
+	flushInterval time.Duration
+	blockTimeout  time.Duration
+	logger        log.Logger
+	measures      *Measures
+	done          chan struct{}
+
+	// lock is held for reading while a record is sent, so that Stop only
+	// closes records once nothing can be sending on it.
+	lock    sync.RWMutex
+	stopped bool
+}
+
+// NewAuditor creates an Auditor for the configuration and starts writing
+// records.  If auditing is off, it returns nil, which audits nothing.
+func NewAuditor(config AuditConfig, logger log.Logger, measures *Measures) (*Auditor, error) {
+	if err := errors.Join(validateAuditConfig(&config)...); err != nil {
+		return nil, err
+	}
+	if config.Sink == "" {
+		return nil, nil
+	}
+	sink, err := newAuditSink(config)
+	if err != nil {
+		return nil, err
+	}
+	return newAuditor(sink, config, logger, measures), nil
+}
+
+func newAuditor(sink AuditSink, config AuditConfig, logger log.Logger, measures *Measures) *Auditor {
+	a := &Auditor{
+		records:       make(chan AuditRecord, config.BufferSize),
+		sink:          sink,
+		batchSize:     config.BatchSize,
+		flushInterval: config.FlushInterval,
+		logger:        logger,
+		measures:      measures,
+		done:          make(chan struct{}),
+	}
+	if config.Backpressure == auditBackpressureBlock {
+		a.blockTimeout = config.BlockTimeout
+	}
+	go a.run()
+	return a
+}
+
+// Record queues a record to be written.
+func (a *Auditor) Record(r AuditRecord) {
+	if a == nil {
+		return
+	}
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	if a.stopped {
+		return
+	}
+
+	select {
+	case a.records <- r:
+		a.measures.AuditQueueDepth.Set(float64(len(a.records)))
+		return
+	default:
+	}
+
+	if a.blockTimeout > 0 {
+		timer := time.NewTimer(a.blockTimeout)
+		defer timer.Stop()
+		select {
+		case a.records <- r:
+			a.measures.AuditQueueDepth.Set(float64(len(a.records)))
+			return
+		case <-timer.C:
+		}
+	}
+	a.measures.AuditRecords.With(outcomeLabel, auditDropped).Add(1.0)
+}
+
+// Stop writes the records still buffered and closes the sink.  Records
+// passed to Record once Stop has been called are discarded.
+func (a *Auditor) Stop() {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	if a.stopped {
+		a.lock.Unlock()
+		return
+	}
+	a.stopped = true
+	close(a.records)
+	a.lock.Unlock()
+
+	<-a.done
+	if err := a.sink.Close(); err != nil {
+		logging.Error(a.logger).Log(logging.MessageKey(), "failed to close audit sink", logging.ErrorKey(), err.Error())
+	}
+}
+
+func (a *Auditor) run() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]AuditRecord, 0, a.batchSize)
+	for {
+		select {
+		case r, ok := <-a.records:
+			if !ok {
+				a.flush(batch)
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) >= a.batchSize {
+				batch = a.flush(batch)
+			}
+		case <-ticker.C:
+			batch = a.flush(batch)
+		}
+	}
+}
+
+// flush writes the batch and returns it emptied.
+func (a *Auditor) flush(batch []AuditRecord) []AuditRecord {
+	a.measures.AuditQueueDepth.Set(float64(len(a.records)))
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := a.sink.Write(batch); err != nil {
+		a.measures.AuditRecords.With(outcomeLabel, auditFailed).Add(float64(len(batch)))
+		logging.Error(a.logger).Log(logging.MessageKey(), "failed to write audit records", "count", len(batch), logging.ErrorKey(), err.Error())
+	} else {
+		a.measures.AuditRecords.With(outcomeLabel, auditWritten).Add(float64(len(batch)))
+	}
+	return batch[:0]
+}
+
+type auditResultsKey struct{}
+
+// auditResults collects the devices a request read for its audit records.  A
+// request gets a record for each device it reports.
+type auditResults struct {
+	lock    sync.Mutex
+	devices []auditDevice
+}
+
+type auditDevice struct {
+	id    string
+	count int
+}
+
+// withAuditResults returns a context that collects the results a request
+// reports.
+func withAuditResults(ctx context.Context) (context.Context, *auditResults) {
+	results := new(auditResults)
+	return context.WithValue(ctx, auditResultsKey{}, results), results
+}
+
+// addAuditDevice reports that a request read the device and returned count
+// results for it.  Counts for a device already reported are added together.
+// It does nothing if the request isn't being audited.
+func addAuditDevice(ctx context.Context, deviceID string, count int) {
+	results, ok := ctx.Value(auditResultsKey{}).(*auditResults)
+	if !ok {
+		return
+	}
+	results.lock.Lock()
+	defer results.lock.Unlock()
+	for i := range results.devices {
+		if results.devices[i].id == deviceID {
+			results.devices[i].count += count
+			return
+		}
+	}
+	results.devices = append(results.devices, auditDevice{id: deviceID, count: count})
+}
+
+// newAuditRecord returns a record of the request's caller and partner ids.
+func newAuditRecord(request *http.Request, basicAuthPartnerIDHeaderKey string, start time.Time) AuditRecord {
+	record := AuditRecord{Time: start.UTC()}
+	if auth, ok := bascule.FromContext(request.Context()); ok && auth.Token != nil {
+		record.Principal = auth.Token.Principal()
+		record.AuthType = auth.Token.Type()
+	}
+	record.PartnerIDs, _ = extractPartnerIDs(request, basicAuthPartnerIDHeaderKey)
+	return record
+}
+
+// recordResults records the request once for each device it reported, or
+// once with the record's device if it reported none.
+func (a *Auditor) recordResults(record AuditRecord, results *auditResults) {
+	results.lock.Lock()
+	defer results.lock.Unlock()
+	if len(results.devices) == 0 {
+		a.Record(record)
+		return
+	}
+	for _, d := range results.devices {
+		record.DeviceID, record.ResultCount = d.id, d.count
+		a.Record(record)
+	}
+}
+
+// AuditRequest returns a decorator that records every request to the given
+// endpoint.  It must run after authentication, as requests rejected there
+// never reach device data.
+func AuditRequest(a *Auditor, endpoint string, basicAuthPartnerIDHeaderKey string) alice.Constructor {
+	return func(delegate http.Handler) http.Handler {
+		if a == nil {
+			return delegate
+		}
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				start := time.Now()
+				ctx, results := withAuditResults(r.Context())
+				mw := &measuredResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+				delegate.ServeHTTP(mw, r.WithContext(ctx))
+
+				// handlers report the device they resolved; one they rejected
+				// before then is recorded as it was asked for
+				record := newAuditRecord(r, basicAuthPartnerIDHeaderKey, start)
+				record.DeviceID = mux.Vars(r)["deviceID"]
+				record.Endpoint = endpoint
+				record.Method = r.Method
+				record.StatusCode = mw.statusCode
+				record.LatencySeconds = time.Since(start).Seconds()
+				a.recordResults(record, results)
+			})
+	}
+}
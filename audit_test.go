@@ -0,0 +1,394 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+)
+
+// testAuditSink collects the batches written to it.  If block is set, writes
+// wait for it to be closed.
+type testAuditSink struct {
+	lock    sync.Mutex
+	batches [][]AuditRecord
+	err     error
+	block   chan struct{}
+	closed  bool
+}
+
+func (s *testAuditSink) Write(records []AuditRecord) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.batches = append(s.batches, append([]AuditRecord(nil), records...))
+	return s.err
+}
+
+func (s *testAuditSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	return nil
+}
+
+// testAuditor returns an auditor writing to the returned sink.  The records
+// are only written once it is stopped.
+func testAuditor() (*Auditor, *testAuditSink) {
+	sink := &testAuditSink{}
+	config := AuditConfig{Sink: auditSinkStdout, FlushInterval: time.Hour}
+	validateAuditConfig(&config)
+	return newAuditor(sink, config, logging.DefaultLogger(), NewMeasures(xmetricstest.NewProvider(nil, Metrics))), sink
+}
+
+// records returns every record written, in order.
+func (s *testAuditSink) records() []AuditRecord {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var records []AuditRecord
+	for _, b := range s.batches {
+		records = append(records, b...)
+	}
+	return records
+}
+
+func TestValidateAuditConfig(t *testing.T) {
+	tests := []struct {
+		description  string
+		config       AuditConfig
+		expected     AuditConfig
+		expectedErrs []string
+	}{
+		{
+			description: "Off",
+		},
+		{
+			description: "Defaults",
+			config:      AuditConfig{Sink: auditSinkStdout},
+			expected: AuditConfig{
+				Sink:          auditSinkStdout,
+				File:          AuditFileConfig{MaxSizeMB: defaultAuditFileMaxSizeMB},
+				HTTP:          AuditHTTPConfig{Timeout: defaultAuditHTTPTimeout},
+				BufferSize:    defaultAuditBufferSize,
+				BatchSize:     defaultAuditBatchSize,
+				FlushInterval: defaultAuditFlushInterval,
+				Backpressure:  auditBackpressureDrop,
+				BlockTimeout:  defaultAuditBlockTimeout,
+			},
+		},
+		{
+			description:  "Missing File Path",
+			config:       AuditConfig{Sink: auditSinkFile},
+			expectedErrs: []string{"audit.file.path is required"},
+		},
+		{
+			description:  "Bad URL",
+			config:       AuditConfig{Sink: auditSinkHTTP, HTTP: AuditHTTPConfig{URL: "collector:8080"}},
+			expectedErrs: []string{"audit.http.url"},
+		},
+		{
+			description: "All Problems Reported",
+			config: AuditConfig{
+				Sink:          "syslog",
+				BufferSize:    -1,
+				BatchSize:     -1,
+				FlushInterval: -time.Second,
+				Backpressure:  "wait",
+				BlockTimeout:  -time.Second,
+				HTTP:          AuditHTTPConfig{Timeout: -time.Second},
+				File:          AuditFileConfig{MaxSizeMB: -1},
+			},
+			expectedErrs: []string{
+				"audit.sink",
+				"audit.backpressure",
+				"audit.bufferSize",
+				"audit.batchSize",
+				"audit.file.maxSizeMB",
+				"audit.flushInterval",
+				"audit.blockTimeout",
+				"audit.http.timeout",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			errs := validateAuditConfig(&tc.config)
+			if len(tc.expectedErrs) == 0 {
+				assert.Empty(errs)
+				assert.Equal(tc.expected, tc.config)
+				return
+			}
+			require.Len(t, errs, len(tc.expectedErrs))
+			for i, expected := range tc.expectedErrs {
+				assert.Contains(errs[i].Error(), expected)
+			}
+		})
+	}
+}
+
+func TestAuditor(t *testing.T) {
+	tests := []struct {
+		description     string
+		sinkErr         error
+		expectedOutcome string
+	}{
+		{
+			description:     "Written",
+			expectedOutcome: auditWritten,
+		},
+		{
+			description:     "Failed",
+			sinkErr:         errors.New("sink test error"),
+			expectedOutcome: auditFailed,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			p := xmetricstest.NewProvider(nil, Metrics)
+			sink := &testAuditSink{err: tc.sinkErr}
+			config := AuditConfig{Sink: auditSinkStdout, BatchSize: 2, FlushInterval: time.Hour}
+			validateAuditConfig(&config)
+			a := newAuditor(sink, config, logging.DefaultLogger(), NewMeasures(p))
+
+			for _, id := range []string{"a", "b", "c"} {
+				a.Record(AuditRecord{DeviceID: id})
+			}
+			// stopping writes the partial batch
+			a.Stop()
+			a.Record(AuditRecord{DeviceID: "after stop"})
+
+			assert.True(sink.closed)
+			require.Len(t, sink.batches, 2)
+			assert.Equal([]AuditRecord{{DeviceID: "a"}, {DeviceID: "b"}}, sink.batches[0])
+			assert.Equal([]AuditRecord{{DeviceID: "c"}}, sink.batches[1])
+			p.Assert(t, AuditRecordsCounter, outcomeLabel, tc.expectedOutcome)(xmetricstest.Value(3.0))
+			p.Assert(t, AuditRecordsCounter, outcomeLabel, auditDropped)(xmetricstest.Value(0.0))
+		})
+	}
+}
+
+func TestAuditorBackpressure(t *testing.T) {
+	tests := []struct {
+		description  string
+		backpressure string
+	}{
+		{description: "Drop", backpressure: auditBackpressureDrop},
+		{description: "Block", backpressure: auditBackpressureBlock},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			p := xmetricstest.NewProvider(nil, Metrics)
+			sink := &testAuditSink{block: make(chan struct{})}
+			config := AuditConfig{
+				Sink:          auditSinkStdout,
+				BufferSize:    1,
+				BatchSize:     1,
+				FlushInterval: time.Hour,
+				Backpressure:  tc.backpressure,
+				BlockTimeout:  10 * time.Millisecond,
+			}
+			validateAuditConfig(&config)
+			a := newAuditor(sink, config, logging.DefaultLogger(), NewMeasures(p))
+
+			// the first record is stuck in the sink, and the second fills the
+			// buffer
+			a.Record(AuditRecord{DeviceID: "a"})
+			assert.Eventually(func() bool { return len(a.records) == 0 }, time.Second, time.Millisecond)
+			a.Record(AuditRecord{DeviceID: "b"})
+
+			start := time.Now()
+			a.Record(AuditRecord{DeviceID: "c"})
+			if tc.backpressure == auditBackpressureBlock {
+				assert.GreaterOrEqual(time.Since(start), config.BlockTimeout)
+			}
+			p.Assert(t, AuditRecordsCounter, outcomeLabel, auditDropped)(xmetricstest.Value(1.0))
+			p.Assert(t, AuditQueueDepthGauge)(xmetricstest.Value(1.0))
+
+			close(sink.block)
+			a.Stop()
+			p.Assert(t, AuditRecordsCounter, outcomeLabel, auditWritten)(xmetricstest.Value(2.0))
+		})
+	}
+}
+
+func TestAuditorStopWhileRecording(t *testing.T) {
+	p := xmetricstest.NewProvider(nil, Metrics)
+	sink := &testAuditSink{block: make(chan struct{})}
+	config := AuditConfig{
+		Sink:          auditSinkStdout,
+		BufferSize:    1,
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+		Backpressure:  auditBackpressureBlock,
+		BlockTimeout:  time.Minute,
+	}
+	validateAuditConfig(&config)
+	a := newAuditor(sink, config, logging.DefaultLogger(), NewMeasures(p))
+
+	// with the first record stuck in the sink and the second filling the
+	// buffer, the rest wait to be sent
+	a.Record(AuditRecord{DeviceID: "a"})
+	assert.Eventually(t, func() bool { return len(a.records) == 0 }, time.Second, time.Millisecond)
+	a.Record(AuditRecord{DeviceID: "b"})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Record(AuditRecord{DeviceID: "c"})
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// stopping mustn't close the buffer while they are sending on it
+	stopped := make(chan struct{})
+	go func() {
+		a.Stop()
+		close(stopped)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(sink.block)
+	wg.Wait()
+	<-stopped
+	p.Assert(t, AuditRecordsCounter, outcomeLabel, auditWritten)(xmetricstest.Value(7.0))
+}
+
+func TestAuditRequest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	p := xmetricstest.NewProvider(nil, Metrics)
+	sink := &testAuditSink{}
+	config := AuditConfig{Sink: auditSinkStdout}
+	validateAuditConfig(&config)
+	a := newAuditor(sink, config, logging.DefaultLogger(), NewMeasures(p))
+
+	handler := AuditRequest(a, eventsEndpoint, "X-Codex-Partner-Ids")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addAuditDevice(r.Context(), "mac:1234", 3)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	auth := bascule.Authentication{Token: bascule.NewToken("basic", "user", bascule.NewAttributes(nil))}
+	request, err := http.NewRequestWithContext(bascule.WithAuthentication(context.Background(), auth),
+		http.MethodGet, "/device/MAC:1234/events", nil)
+	require.Nil(err)
+	request.Header.Set("X-Codex-Partner-Ids", "comcast, sky")
+	request = mux.SetURLVars(request, map[string]string{"deviceID": "MAC:1234"})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, request)
+	a.Stop()
+
+	assert.Equal(http.StatusTeapot, rr.Code)
+	require.Len(sink.batches, 1)
+	require.Len(sink.batches[0], 1)
+	record := sink.batches[0][0]
+	assert.Equal("user", record.Principal)
+	assert.Equal("basic", record.AuthType)
+	assert.Equal([]string{"comcast", "sky"}, record.PartnerIDs)
+	assert.Equal("mac:1234", record.DeviceID)
+	assert.Equal(eventsEndpoint, record.Endpoint)
+	assert.Equal(http.MethodGet, record.Method)
+	assert.Equal(3, record.ResultCount)
+	assert.Equal(http.StatusTeapot, record.StatusCode)
+	assert.False(record.Time.IsZero())
+
+	// without an auditor, requests pass straight through and reporting the
+	// results does nothing
+	rr = httptest.NewRecorder()
+	AuditRequest(nil, eventsEndpoint, "")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addAuditDevice(r.Context(), "mac:1234", 3)
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(rr, request)
+	assert.Equal(http.StatusNoContent, rr.Code)
+}
+
+func TestAuditSinks(t *testing.T) {
+	records := []AuditRecord{{DeviceID: "a", StatusCode: http.StatusOK}, {DeviceID: "b", StatusCode: http.StatusNotFound}}
+	readRecords := func(t *testing.T, r io.Reader) []AuditRecord {
+		var read []AuditRecord
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			var record AuditRecord
+			require.Nil(t, json.Unmarshal(scanner.Bytes(), &record))
+			read = append(read, record)
+		}
+		return read
+	}
+
+	t.Run("File", func(t *testing.T) {
+		assert := assert.New(t)
+		path := filepath.Join(t.TempDir(), "audit.log")
+		a, err := NewAuditor(AuditConfig{Sink: auditSinkFile, File: AuditFileConfig{Path: path}}, logging.DefaultLogger(), NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+		require.Nil(t, err)
+		for _, r := range records {
+			a.Record(r)
+		}
+		a.Stop()
+
+		f, err := os.Open(path)
+		require.Nil(t, err)
+		defer f.Close()
+		assert.Equal(records, readRecords(t, f))
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		assert := assert.New(t)
+		var (
+			received    []AuditRecord
+			contentType string
+			statusCode  = http.StatusAccepted
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			contentType = r.Header.Get("Content-Type")
+			body, _ := io.ReadAll(r.Body)
+			received = readRecords(t, bytes.NewReader(body))
+			w.WriteHeader(statusCode)
+		}))
+		defer server.Close()
+
+		sink, err := newAuditSink(AuditConfig{Sink: auditSinkHTTP, HTTP: AuditHTTPConfig{URL: server.URL, Timeout: time.Second}})
+		require.Nil(t, err)
+		assert.Nil(sink.Write(records))
+		assert.Equal("application/x-ndjson", contentType)
+		assert.Equal(records, received)
+
+		statusCode = http.StatusServiceUnavailable
+		assert.NotNil(sink.Write(records))
+		assert.Nil(sink.Close())
+	})
+
+	t.Run("Off", func(t *testing.T) {
+		a, err := NewAuditor(AuditConfig{}, logging.DefaultLogger(), nil)
+		assert.Nil(t, err)
+		assert.Nil(t, a)
+		// a nil auditor records nothing
+		a.Record(AuditRecord{})
+		a.Stop()
+	})
+}
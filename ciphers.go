@@ -0,0 +1,356 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/spf13/viper"
+	"github.com/xmidt-org/voynicrypto"
+	"github.com/xmidt-org/webpa-common/v2/logging" //nolint: staticcheck
+)
+
+const (
+	reloadSucceeded = "success"
+	reloadFailed    = "failure"
+)
+
+var errNoCipherOptions = errors.New("no cipher configuration found")
+
+// Decrypters finds the decrypter for a record's algorithm and key id.
+// voynicrypto.Ciphers implements this interface.
+type Decrypters interface {
+	Get(alg voynicrypto.AlgorithmType, KID string) (voynicrypto.Decrypt, bool)
+}
+
+// CipherReloadConfig configures how gungnir picks up changes to the cipher
+// configuration and the key files it references without a restart.
+type CipherReloadConfig struct {
+	// Interval is how often the configuration file and key files are checked
+	// for changes.  If zero, ciphers are only loaded at startup.
+	Interval time.Duration
+
+	// GracePeriod is how long a decrypter that was removed or replaced by a
+	// reload is still used for records with its algorithm and key id.
+	GracePeriod time.Duration
+}
+
+// CipherLoader produces the cipher options to load decrypters from.
+type CipherLoader func() (voynicrypto.Options, error)
+
+// NewFileCipherLoader returns a CipherLoader that re-reads the cipher
+// configuration from the given file each time it is called.
+func NewFileCipherLoader(configFile string) CipherLoader {
+	return func() (voynicrypto.Options, error) {
+		v := viper.New()
+		v.SetConfigFile(configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, err
+		}
+		return voynicrypto.FromViper(v)
+	}
+}
+
+type retiredDecrypter struct {
+	decrypter voynicrypto.Decrypt
+	expires   time.Time
+}
+
+// retiredDecrypters are the decrypters in their grace period, by algorithm
+// and key id.
+type retiredDecrypters map[voynicrypto.AlgorithmType]map[string]retiredDecrypter
+
+func (r retiredDecrypters) add(alg voynicrypto.AlgorithmType, kid string, d retiredDecrypter) {
+	if _, ok := r[alg]; !ok {
+		r[alg] = map[string]retiredDecrypter{}
+	}
+	r[alg][kid] = d
+}
+
+// rotatedDecrypter decrypts with the current key of a key id, falling back to
+// the key it replaced for records encrypted before the rotation.
+type rotatedDecrypter struct {
+	voynicrypto.Decrypt
+	previous voynicrypto.Decrypt
+}
+
+func (d rotatedDecrypter) DecryptMessage(cipher []byte, nonce []byte) ([]byte, error) {
+	message, err := d.Decrypt.DecryptMessage(cipher, nonce)
+	if err != nil {
+		if previous, perr := d.previous.DecryptMessage(cipher, nonce); perr == nil {
+			return previous, nil
+		}
+	}
+	return message, err
+}
+
+// CipherReloader holds the current set of decrypters and rebuilds it when
+// the cipher configuration or its key files change.  Lookups of loaded
+// decrypters never wait on a reload; the new set is swapped in atomically
+// once it is fully loaded.
+type CipherReloader struct {
+	load        CipherLoader
+	configFile  string
+	gracePeriod time.Duration
+	logger      log.Logger
+	measures    *Measures
+
+	current atomic.Pointer[voynicrypto.Ciphers]
+	retired atomic.Pointer[retiredDecrypters]
+
+	lock        sync.Mutex
+	options     voynicrypto.Options
+	fingerprint string
+	keys        map[voynicrypto.AlgorithmType]map[string]string
+	lastReload  time.Time
+	lastErr     error
+}
+
+// NewCipherReloader creates the initial set of decrypters from options.  As
+// with voynicrypto.PopulateCiphers, ciphers that fail to load are logged and
+// skipped so that the rest are still available.  Later reloads use load to
+// read the cipher configuration again.
+func NewCipherReloader(options voynicrypto.Options, load CipherLoader, configFile string, gracePeriod time.Duration, logger log.Logger, measures *Measures) *CipherReloader {
+	c := &CipherReloader{
+		load:        load,
+		configFile:  configFile,
+		gracePeriod: gracePeriod,
+		logger:      logger,
+		measures:    measures,
+		options:     options,
+		keys:        keyFingerprints(options),
+		lastReload:  time.Now(),
+	}
+	c.retired.Store(&retiredDecrypters{})
+
+	ciphers, errs := loadCiphers(options, logger)
+	for _, e := range errs {
+		logging.Error(logger).Log(logging.MessageKey(), "failed to load cipher", logging.ErrorKey(), e.Error())
+	}
+	c.current.Store(&ciphers)
+	c.fingerprint = c.computeFingerprint()
+	c.measures.DecryptersLoaded.Set(float64(countDecrypters(ciphers)))
+	return c
+}
+
+// Get returns the decrypter for the algorithm and key id, falling back to a
+// recently removed or replaced decrypter if it is still within the grace
+// period.
+func (c *CipherReloader) Get(alg voynicrypto.AlgorithmType, KID string) (voynicrypto.Decrypt, bool) {
+	d, ok := c.current.Load().Get(alg, KID)
+	r, retired := (*c.retired.Load())[alg][KID]
+	if !retired || !time.Now().Before(r.expires) {
+		return d, ok
+	}
+	if !ok {
+		return r.decrypter, true
+	}
+	return rotatedDecrypter{Decrypt: d, previous: r.decrypter}, true
+}
+
+// Len returns the number of decrypters currently loaded, not counting ones
+// kept for the grace period.
+func (c *CipherReloader) Len() int {
+	return countDecrypters(*c.current.Load())
+}
+
+// Reload rebuilds the decrypters from the cipher configuration.  If the
+// configuration cannot be read or any cipher fails to load, the current
+// decrypters are left in place and the error is returned.
+func (c *CipherReloader) Reload() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.reload()
+}
+
+func (c *CipherReloader) reload() error {
+	err := c.swap()
+	c.lastReload = time.Now()
+	c.lastErr = err
+	if err != nil {
+		c.measures.CipherReloadCount.With(outcomeLabel, reloadFailed).Add(1.0)
+		logging.Error(c.logger).Log(logging.MessageKey(), "failed to reload ciphers", logging.ErrorKey(), err.Error())
+		return err
+	}
+
+	c.measures.CipherReloadCount.With(outcomeLabel, reloadSucceeded).Add(1.0)
+	c.measures.DecryptersLoaded.Set(float64(countDecrypters(*c.current.Load())))
+	logging.Info(c.logger).Log(logging.MessageKey(), "reloaded ciphers")
+	return nil
+}
+
+func (c *CipherReloader) swap() error {
+	options, err := c.load()
+	if err != nil {
+		return fmt.Errorf("failed to read cipher config: %w", err)
+	}
+	if len(options) == 0 {
+		return errNoCipherOptions
+	}
+
+	ciphers, errs := loadCiphers(options, c.logger)
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	previous := c.current.Swap(&ciphers)
+	previousKeys := c.keys
+	c.options = options
+	c.fingerprint = c.computeFingerprint()
+	c.keys = keyFingerprints(options)
+
+	now := time.Now()
+	retired := retiredDecrypters{}
+	for alg, kids := range *c.retired.Load() {
+		for kid, r := range kids {
+			if now.Before(r.expires) {
+				retired.add(alg, kid, r)
+			}
+		}
+	}
+	if c.gracePeriod > 0 {
+		// a decrypter is retired when its key id goes away, and also when the
+		// keys behind its key id change, as records encrypted with the old
+		// keys can't be decrypted with the new ones
+		for alg, kids := range previous.Options {
+			for kid, d := range kids {
+				if _, ok := ciphers.Get(alg, kid); ok && previousKeys[alg][kid] == c.keys[alg][kid] {
+					continue
+				}
+				retired.add(alg, kid, retiredDecrypter{decrypter: d, expires: now.Add(c.gracePeriod)})
+			}
+		}
+	}
+	c.retired.Store(&retired)
+	return nil
+}
+
+// Watch checks the configuration file and key files for changes every
+// interval and reloads the ciphers when they change, until stop is closed.
+func (c *CipherReloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.lock.Lock()
+			if fp := c.computeFingerprint(); fp != c.fingerprint {
+				logging.Info(c.logger).Log(logging.MessageKey(), "cipher configuration changed, reloading")
+				// on failure the old fingerprint is kept so the reload is retried
+				c.reload()
+			}
+			c.lock.Unlock()
+		}
+	}
+}
+
+// Status reports the result of the most recent reload, for use as a health
+// check.
+func (c *CipherReloader) Status() (interface{}, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	details := map[string]interface{}{
+		"decrypters": countDecrypters(*c.current.Load()),
+		"lastReload": c.lastReload,
+	}
+	if c.lastErr != nil {
+		return details, fmt.Errorf("last cipher reload failed: %w", c.lastErr)
+	}
+	return details, nil
+}
+
+// computeFingerprint summarizes the modification time and size of the config
+// file and every key file referenced by the current options.
+func (c *CipherReloader) computeFingerprint() string {
+	files := []string{}
+	if c.configFile != "" {
+		files = append(files, c.configFile)
+	}
+	for _, o := range c.options {
+		for _, path := range o.Keys {
+			files = append(files, path)
+		}
+	}
+	sort.Strings(files)
+
+	fp := ""
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			fp += fmt.Sprintf("%s:missing;", f)
+			continue
+		}
+		fp += fmt.Sprintf("%s:%d:%d;", f, info.ModTime().UnixNano(), info.Size())
+	}
+	return fp
+}
+
+// keyFingerprints summarizes the keys and parameters of each cipher, by
+// algorithm and key id, so a reload can tell which ones were replaced.
+func keyFingerprints(options voynicrypto.Options) map[voynicrypto.AlgorithmType]map[string]string {
+	fingerprints := map[voynicrypto.AlgorithmType]map[string]string{}
+	for _, o := range options {
+		keyTypes := make([]string, 0, len(o.Keys))
+		for keyType := range o.Keys {
+			keyTypes = append(keyTypes, string(keyType))
+		}
+		sort.Strings(keyTypes)
+
+		h := sha256.New()
+		fmt.Fprintf(h, "%v;", o.Params)
+		for _, keyType := range keyTypes {
+			path := o.Keys[voynicrypto.KeyType(keyType)]
+			fmt.Fprintf(h, "%s:%s:", keyType, path)
+			if data, err := os.ReadFile(path); err == nil {
+				h.Write(data)
+			}
+		}
+		if _, ok := fingerprints[o.Type]; !ok {
+			fingerprints[o.Type] = map[string]string{}
+		}
+		fingerprints[o.Type][o.KID] = hex.EncodeToString(h.Sum(nil))
+	}
+	return fingerprints
+}
+
+// loadCiphers is voynicrypto.PopulateCiphers, except that it returns the
+// errors for the ciphers it could not load.
+func loadCiphers(o voynicrypto.Options, logger log.Logger) (voynicrypto.Ciphers, []error) {
+	var errs []error
+	c := voynicrypto.Ciphers{
+		Options: map[voynicrypto.AlgorithmType]map[string]voynicrypto.Decrypt{},
+	}
+	for _, elem := range o {
+		elem.Logger = logger
+		decrypter, err := elem.LoadDecrypt()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cipher %s/%s: %w", elem.Type, elem.KID, err))
+			continue
+		}
+		if _, ok := c.Options[elem.Type]; !ok {
+			c.Options[elem.Type] = map[string]voynicrypto.Decrypt{}
+		}
+		c.Options[elem.Type][elem.KID] = decrypter
+	}
+	return c, errs
+}
+
+func countDecrypters(c voynicrypto.Ciphers) int {
+	count := 0
+	for _, kids := range c.Options {
+		count += len(kids)
+	}
+	return count
+}
@@ -0,0 +1,236 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/voynicrypto"
+	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+)
+
+func noneCiphers(kids ...string) voynicrypto.Options {
+	options := voynicrypto.Options{}
+	for _, kid := range kids {
+		options = append(options, voynicrypto.Config{Type: voynicrypto.None, KID: kid})
+	}
+	return options
+}
+
+func TestCipherReloaderReload(t *testing.T) {
+	loadErr := errors.New("load test error")
+	badKey := voynicrypto.Config{
+		Type: voynicrypto.RSASymmetric,
+		KID:  "bad",
+		Keys: map[voynicrypto.KeyType]string{voynicrypto.PrivateKey: "/does/not/exist.pem"},
+	}
+
+	tests := []struct {
+		description      string
+		reloaded         voynicrypto.Options
+		loadErr          error
+		gracePeriod      time.Duration
+		expectedErr      bool
+		expectedOutcome  string
+		expectedFound    []string
+		expectedNotFound []string
+	}{
+		{
+			description:      "Success",
+			reloaded:         noneCiphers("b"),
+			expectedOutcome:  reloadSucceeded,
+			expectedFound:    []string{"b"},
+			expectedNotFound: []string{"a"},
+		},
+		{
+			description:     "Success With Grace Period",
+			reloaded:        noneCiphers("b"),
+			gracePeriod:     time.Hour,
+			expectedOutcome: reloadSucceeded,
+			expectedFound:   []string{"a", "b"},
+		},
+		{
+			description:      "Load Error",
+			loadErr:          loadErr,
+			expectedErr:      true,
+			expectedOutcome:  reloadFailed,
+			expectedFound:    []string{"a"},
+			expectedNotFound: []string{"b"},
+		},
+		{
+			description:     "Empty Config Error",
+			reloaded:        voynicrypto.Options{},
+			expectedErr:     true,
+			expectedOutcome: reloadFailed,
+			expectedFound:   []string{"a"},
+		},
+		{
+			description:      "Bad Cipher Error",
+			reloaded:         append(noneCiphers("b"), badKey),
+			expectedErr:      true,
+			expectedOutcome:  reloadFailed,
+			expectedFound:    []string{"a"},
+			expectedNotFound: []string{"b", "bad"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			p := xmetricstest.NewProvider(nil, Metrics)
+			load := func() (voynicrypto.Options, error) {
+				return tc.reloaded, tc.loadErr
+			}
+
+			c := NewCipherReloader(noneCiphers("a"), load, "", tc.gracePeriod, logging.DefaultLogger(), NewMeasures(p))
+			assert.Equal(1, c.Len())
+
+			err := c.Reload()
+			_, statusErr := c.Status()
+			if tc.expectedErr {
+				assert.NotNil(err)
+				assert.NotNil(statusErr)
+			} else {
+				assert.Nil(err)
+				assert.Nil(statusErr)
+			}
+			p.Assert(t, CipherReloadCounter, outcomeLabel, tc.expectedOutcome)(xmetricstest.Value(1.0))
+
+			for _, kid := range tc.expectedFound {
+				_, ok := c.Get(voynicrypto.None, kid)
+				assert.True(ok, kid)
+			}
+			for _, kid := range tc.expectedNotFound {
+				_, ok := c.Get(voynicrypto.None, kid)
+				assert.False(ok, kid)
+			}
+		})
+	}
+}
+
+func TestCipherReloaderGracePeriodExpires(t *testing.T) {
+	assert := assert.New(t)
+	p := xmetricstest.NewProvider(nil, Metrics)
+	load := func() (voynicrypto.Options, error) {
+		return noneCiphers("b"), nil
+	}
+
+	c := NewCipherReloader(noneCiphers("a"), load, "", time.Millisecond, logging.DefaultLogger(), NewMeasures(p))
+	assert.Nil(c.Reload())
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok := c.Get(voynicrypto.None, "a")
+	assert.False(ok)
+}
+
+// writeRSAKey writes a new private key to path and returns an encrypter for
+// it.
+func writeRSAKey(t *testing.T, path string, kid string) voynicrypto.Encrypt {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.Nil(t, os.WriteFile(path, data, 0600))
+	return voynicrypto.NewRSAEncrypter(crypto.SHA512, nil, &key.PublicKey, kid)
+}
+
+func TestCipherReloaderKeyRotation(t *testing.T) {
+	tests := []struct {
+		description     string
+		gracePeriod     time.Duration
+		expectedOldOpen bool
+	}{
+		{
+			description:     "With Grace Period",
+			gracePeriod:     time.Hour,
+			expectedOldOpen: true,
+		},
+		{
+			description: "Without Grace Period",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			keyFile := filepath.Join(t.TempDir(), "private.pem")
+			options := voynicrypto.Options{{
+				Type:   voynicrypto.RSASymmetric,
+				KID:    "rotated",
+				Keys:   map[voynicrypto.KeyType]string{voynicrypto.PrivateKey: keyFile},
+				Params: map[string]string{"hash": "SHA512"},
+			}}
+			oldKey := writeRSAKey(t, keyFile, "rotated")
+			load := func() (voynicrypto.Options, error) {
+				return options, nil
+			}
+			c := NewCipherReloader(options, load, "", tc.gracePeriod, logging.DefaultLogger(), NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+
+			oldCipher, oldNonce, err := oldKey.EncryptMessage([]byte("old"))
+			require.Nil(err)
+
+			// the key behind the key id changes, but the key id doesn't
+			newKey := writeRSAKey(t, keyFile, "rotated")
+			require.Nil(c.Reload())
+			newCipher, newNonce, err := newKey.EncryptMessage([]byte("new"))
+			require.Nil(err)
+
+			d, ok := c.Get(voynicrypto.RSASymmetric, "rotated")
+			require.True(ok)
+			message, err := d.DecryptMessage(newCipher, newNonce)
+			assert.Nil(err)
+			assert.Equal("new", string(message))
+
+			message, err = d.DecryptMessage(oldCipher, oldNonce)
+			if tc.expectedOldOpen {
+				assert.Nil(err)
+				assert.Equal("old", string(message))
+			} else {
+				assert.NotNil(err)
+			}
+		})
+	}
+}
+
+func TestCipherReloaderWatch(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	configFile := filepath.Join(t.TempDir(), "gungnir.yaml")
+	require.Nil(os.WriteFile(configFile, []byte("cipher:\n  - type: none\n    kid: a\n"), 0600))
+
+	p := xmetricstest.NewProvider(nil, Metrics)
+	load := NewFileCipherLoader(configFile)
+	options, err := load()
+	require.Nil(err)
+
+	c := NewCipherReloader(options, load, configFile, 0, logging.DefaultLogger(), NewMeasures(p))
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Watch(time.Millisecond, stop)
+
+	// make sure the modification time changes even on coarse filesystems
+	require.Nil(os.WriteFile(configFile, []byte("cipher:\n  - type: none\n    kid: b\n"), 0600))
+	later := time.Now().Add(time.Second)
+	require.Nil(os.Chtimes(configFile, later, later))
+
+	assert.Eventually(func() bool {
+		_, ok := c.Get(voynicrypto.None, "b")
+		return ok
+	}, time.Second, time.Millisecond)
+	_, ok := c.Get(voynicrypto.None, "a")
+	assert.False(ok)
+}
@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// deviceIDRouteVar matches a device id in a route, along with an optional
+// service suffix such as mac:112233445566/config.
+const deviceIDRouteVar = "{deviceID:[^/]+(?:/[^/]+)?}"
+
+// DeviceIDConfig configures how the device id in a request path is read.
+type DeviceIDConfig struct {
+	// StripServiceSuffix accepts ids with a service suffix, such as
+	// mac:112233445566/config, and drops the suffix.  Otherwise they're
+	// rejected.
+	StripServiceSuffix bool
+}
+
+// deviceIDError explains why a device id was rejected.  It is returned to the
+// caller as the body of a 400.
+type deviceIDError struct {
+	DeviceID string `json:"device_id"`
+	Reason   string `json:"reason"`
+}
+
+func (e deviceIDError) Error() string {
+	return fmt.Sprintf("invalid device id %q: %s", e.DeviceID, e.Reason)
+}
+
+func (e deviceIDError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// parseDeviceID parses a wrp device id, such as MAC:11-22-33-44-55-66, into
+// the form the ids are stored in, such as mac:112233445566.
+func parseDeviceID(raw string, stripServiceSuffix bool) (string, error) {
+	name := raw
+	if i := strings.Index(name, "/"); i >= 0 {
+		if !stripServiceSuffix {
+			return "", deviceIDError{DeviceID: raw, Reason: "service suffixes aren't allowed"}
+		}
+		name = name[:i]
+	}
+
+	scheme, id, found := strings.Cut(name, ":")
+	if !found {
+		return "", deviceIDError{DeviceID: raw, Reason: "missing scheme, expected mac:, uuid:, serial: or dns:"}
+	}
+	if id == "" {
+		return "", deviceIDError{DeviceID: raw, Reason: "missing id"}
+	}
+	scheme = strings.ToLower(scheme)
+	switch scheme {
+	case "mac", "uuid", "serial", "dns":
+	default:
+		return "", deviceIDError{DeviceID: raw, Reason: fmt.Sprintf("unknown scheme %q, expected mac, uuid, serial or dns", scheme)}
+	}
+
+	parsed, err := wrp.ParseDeviceID(name)
+	if err != nil {
+		if scheme == "mac" {
+			return "", deviceIDError{DeviceID: raw, Reason: "mac ids must be 12 hex digits"}
+		}
+		return "", deviceIDError{DeviceID: raw, Reason: err.Error()}
+	}
+	return strings.ToLower(string(parsed)), nil
+}
+
+// requestDeviceID reads the device id from the request path and reports it
+// to the audit log.  If there isn't a usable id, the response is written and
+// ok is false.
+func (app *App) requestDeviceID(writer http.ResponseWriter, request *http.Request) (id string, ok bool) {
+	raw := mux.Vars(request)["deviceID"]
+	if raw == "" {
+		writer.WriteHeader(http.StatusNotFound)
+		return "", false
+	}
+
+	id, err := parseDeviceID(raw, app.deviceIDs.StripServiceSuffix)
+	if err != nil {
+		writer.Header().Add("X-Codex-Error", err.Error())
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(writer).Encode(err)
+		return "", false
+	}
+	addAuditDevice(request.Context(), id, 0)
+	return id, true
+}
@@ -0,0 +1,195 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeviceID(t *testing.T) {
+	tests := []struct {
+		description        string
+		raw                string
+		stripServiceSuffix bool
+		expected           string
+		expectedReason     string
+	}{
+		{
+			description: "Normalized Mac",
+			raw:         "mac:112233445566",
+			expected:    "mac:112233445566",
+		},
+		{
+			description: "Mac With Separators",
+			raw:         "MAC:11-22-33-44-55-AA",
+			expected:    "mac:1122334455aa",
+		},
+		{
+			description: "Mac With Colons",
+			raw:         "mac:11:22:33:44:55:66",
+			expected:    "mac:112233445566",
+		},
+		{
+			description: "UUID",
+			raw:         "UUID:ABC-123",
+			expected:    "uuid:abc-123",
+		},
+		{
+			description: "Serial",
+			raw:         "serial:A1B2C3",
+			expected:    "serial:a1b2c3",
+		},
+		{
+			description: "DNS",
+			raw:         "dns:device.example.com",
+			expected:    "dns:device.example.com",
+		},
+		{
+			description:        "Service Suffix Stripped",
+			raw:                "mac:11.22.33.44.55.66/config",
+			stripServiceSuffix: true,
+			expected:           "mac:112233445566",
+		},
+		{
+			description:    "Service Suffix Rejected",
+			raw:            "mac:112233445566/config",
+			expectedReason: "service suffixes aren't allowed",
+		},
+		{
+			description:    "Missing Scheme",
+			raw:            "112233445566",
+			expectedReason: "missing scheme",
+		},
+		{
+			description:    "Unknown Scheme",
+			raw:            "imei:112233445566",
+			expectedReason: "unknown scheme \"imei\"",
+		},
+		{
+			description:    "Missing ID",
+			raw:            "uuid:",
+			expectedReason: "missing id",
+		},
+		{
+			description:    "Short Mac",
+			raw:            "mac:1234",
+			expectedReason: "mac ids must be 12 hex digits",
+		},
+		{
+			description:    "Bad Mac Character",
+			raw:            "mac:11223344556g",
+			expectedReason: "mac ids must be 12 hex digits",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			id, err := parseDeviceID(tc.raw, tc.stripServiceSuffix)
+			if tc.expectedReason == "" {
+				assert.Nil(err)
+				assert.Equal(tc.expected, id)
+				return
+			}
+			var idErr deviceIDError
+			require.ErrorAs(t, err, &idErr)
+			assert.Equal(tc.raw, idErr.DeviceID)
+			assert.Contains(idErr.Reason, tc.expectedReason)
+			assert.Equal(http.StatusBadRequest, idErr.StatusCode())
+			assert.Empty(id)
+		})
+	}
+}
+
+func TestRequestDeviceID(t *testing.T) {
+	tests := []struct {
+		description        string
+		path               string
+		stripServiceSuffix bool
+		expectedID         string
+		expectedStatusCode int
+		expectedReason     string
+		expectedAudited    string
+	}{
+		{
+			description:     "Success",
+			path:            "/device/MAC:11-22-33-44-55-66/events",
+			expectedID:      "mac:112233445566",
+			expectedAudited: "mac:112233445566",
+		},
+		{
+			description:        "Suffix Stripped",
+			path:               "/device/mac:112233445566/config/events",
+			stripServiceSuffix: true,
+			expectedID:         "mac:112233445566",
+			expectedAudited:    "mac:112233445566",
+		},
+		{
+			description:        "Suffix Rejected",
+			path:               "/device/mac:112233445566/config/events",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedReason:     "service suffixes aren't allowed",
+			expectedAudited:    "mac:112233445566/config",
+		},
+		{
+			description:        "Malformed",
+			path:               "/device/1234/events",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedReason:     "missing scheme, expected mac:, uuid:, serial: or dns:",
+			expectedAudited:    "1234",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			app := App{deviceIDs: DeviceIDConfig{StripServiceSuffix: tc.stripServiceSuffix}}
+			var (
+				id     string
+				called bool
+			)
+			auditor, sink := testAuditor()
+			router := mux.NewRouter()
+			router.Handle("/device/"+deviceIDRouteVar+"/events", AuditRequest(auditor, eventsEndpoint, "")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				var ok bool
+				if id, ok = app.requestDeviceID(w, r); ok {
+					w.WriteHeader(http.StatusOK)
+				}
+			})))
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			require.True(t, called)
+			auditor.Stop()
+			if records := sink.records(); assert.Len(records, 1) {
+				assert.Equal(tc.expectedAudited, records[0].DeviceID)
+			}
+			if tc.expectedStatusCode == 0 {
+				assert.Equal(http.StatusOK, rr.Code)
+				assert.Equal(tc.expectedID, id)
+				return
+			}
+			assert.Equal(tc.expectedStatusCode, rr.Code)
+			assert.NotEmpty(rr.Header().Get("X-Codex-Error"))
+			assert.Equal("application/json", rr.Header().Get("Content-Type"))
+			var body deviceIDError
+			require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+			assert.Equal(tc.expectedReason, body.Reason)
+		})
+	}
+
+	// without a device id there's nothing to find
+	rr := httptest.NewRecorder()
+	_, ok := (&App{}).requestDeviceID(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
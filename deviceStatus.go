@@ -8,18 +8,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"path"
-	"strings"
+	"strconv"
 	"time"
 
-	"github.com/xmidt-org/voynicrypto"
-
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/goph/emperror"
-	"github.com/gorilla/mux"
 	db "github.com/xmidt-org/codex-db"
 	"github.com/xmidt-org/webpa-common/v2/logging" //nolint: staticcheck
-	"github.com/xmidt-org/wrp-go/v3"
 )
 
 const (
@@ -37,7 +32,9 @@ type Status struct {
 	// example: 5
 	DeviceID string `json:"deviceid"`
 
-	// State of the device. Ex: online, offline
+	// State of the device. Ex: online, offline, a configured state, or
+	// unknown if the device seems to be online but hasn't sent an online
+	// event recently
 	//
 	// required: true
 	// example: online
@@ -67,14 +64,54 @@ type Status struct {
 	// required: true
 	// example: [".*", "example partner"]
 	PartnerIDs []string `json:"partner_ids"`
+
+	// the session id of the event that provides the state
+	//
+	// example: 1234567890
+	SessionID string `json:"session_id,omitempty"`
+
+	// how many whole seconds the device has been online, if it is online
+	//
+	// example: 1006
+	OnlineFor *int64 `json:"online_for,omitempty"`
+
+	// how many whole seconds the device has been offline, if it is offline
+	//
+	// example: 7384
+	OfflineFor *int64 `json:"offline_for,omitempty"`
+
+	// the time of the newest online event
+	//
+	// example: 2019-02-26T20:18:15.188881748Z
+	LastOnlineAt *time.Time `json:"last_online_at,omitempty"`
+
+	// the time of the newest offline event
+	//
+	// example: 2019-02-26T20:18:15.188881748Z
+	LastOfflineAt *time.Time `json:"last_offline_at,omitempty"`
+
+	// when the newest state event was stored
+	//
+	// example: 2019-02-26T20:18:15.188881748Z
+	LastEventAt *time.Time `json:"last_event_at,omitempty"`
+
+	// the configured values taken from the event that provides the state.
+	// Values it doesn't have come from the newest online event, so the last
+	// known values are kept while the device is offline.
+	//
+	// example: {"fw-name": "1.2.3", "hw-model": "tg1682"}
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
 }
 
 /*
  * swagger:route GET /device/{deviceID}/status device getStatus
  *
- * Get the status information for a specified device.
+ * Get the status information for a specified device.  With explain=true,
+ * admins instead get every state record considered, which were skipped and
+ * why, the online and offline candidates, and the rule that decided the
+ * status.
  *
- * Parameters: deviceID
+ * Parameters: deviceID, explain, include_expired
  *
  * Produces:
  *    - application/json
@@ -86,6 +123,8 @@ type Status struct {
  *
  * Responses:
  *    200: StatusResponse
+ *    400: ErrResponse
+ *    403: ErrResponse
  *    404: ErrResponse
  *    500: ErrResponse
  *
@@ -95,13 +134,30 @@ func (app *App) handleGetStatus(writer http.ResponseWriter, request *http.Reques
 		s   Status
 		err error
 	)
-	vars := mux.Vars(request)
-	id := strings.ToLower(vars["deviceID"])
-	if id == "" {
-		writer.WriteHeader(http.StatusNotFound)
+	id, ok := app.requestDeviceID(writer, request)
+	if !ok {
+		return
+	}
+
+	includeExpired, ok := app.requestIncludeExpired(writer, request)
+	if !ok {
 		return
 	}
-	if s, err = app.getStatusInfo(id); err != nil {
+
+	if e := request.FormValue("explain"); e != "" {
+		explain, err := strconv.ParseBool(e)
+		if err != nil {
+			writer.Header().Add("X-Codex-Error", fmt.Sprintf("invalid explain value %q", e))
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if explain {
+			app.handleExplainStatus(writer, request, id, includeExpired)
+			return
+		}
+	}
+
+	if s, err = app.getStatusInfo(id, includeExpired); err != nil {
 		logging.Error(app.logger, emperror.Context(err)...).Log(logging.MessageKey(),
 			"Failed to get status info", logging.ErrorKey(), err.Error())
 		writer.Header().Add("X-Codex-Error", err.Error())
@@ -114,6 +170,9 @@ func (app *App) handleGetStatus(writer http.ResponseWriter, request *http.Reques
 		return
 	}
 
+	s = app.statusRedaction(request).status(s, app.status.Metadata)
+	addAuditDevice(request.Context(), id, 1)
+
 	data, err := json.Marshal(&s)
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
@@ -124,13 +183,62 @@ func (app *App) handleGetStatus(writer http.ResponseWriter, request *http.Reques
 	writer.Write(data)
 }
 
+// statusRedaction returns the redaction of statuses for the request.  The
+// status isn't filtered by partner, so without partner ids only the
+// capability policies apply.
+func (app *App) statusRedaction(request *http.Request) *redaction {
+	partnerIDs, _ := extractPartnerIDs(request, app.basicAuthPartnerIDHeaderKey)
+	return app.redactor.forRequest(request, partnerIDs)
+}
+
+// handleExplainStatus responds with how the device's status was determined.
+// Not finding a status is part of the explanation, so only a failure to read
+// the state records is an error.
+func (app *App) handleExplainStatus(writer http.ResponseWriter, request *http.Request, id string, includeExpired bool) {
+	if !app.admin.isAdmin(request) {
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	explanation := StatusExplanation{Records: []StateRecordExplanation{}}
+	if _, err := app.resolveStatus(id, includeExpired, &explanation); err != nil && explanation.Rule == "" {
+		logging.Error(app.logger, emperror.Context(err)...).Log(logging.MessageKey(),
+			"Failed to explain status", logging.ErrorKey(), err.Error())
+		writer.Header().Add("X-Codex-Error", err.Error())
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logging.Info(app.logger).Log(logging.MessageKey(), "explained status", "device id", id, "rule", explanation.Rule)
+	addAuditDevice(request.Context(), id, len(explanation.Records))
+
+	data, err := json.Marshal(&explanation)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(data)
+}
+
 type eventTuple struct {
 	record    db.Record
 	status    Status
+	kind      string
 	sessionID string
+	metadata  map[string]interface{}
 }
 
-func (app *App) getStatusInfo(deviceID string) (Status, error) {
+// getStatusInfo determines the device's status, ignoring expired records
+// unless includeExpired is set.
+func (app *App) getStatusInfo(deviceID string, includeExpired bool) (Status, error) {
+	return app.resolveStatus(deviceID, includeExpired, nil)
+}
+
+// resolveStatus determines the device's status from its state records.  If
+// explanation isn't nil, every step of the decision is recorded in it.
+// Expired records are skipped unless includeExpired is set.
+func (app *App) resolveStatus(deviceID string, includeExpired bool, explanation *StatusExplanation) (Status, error) {
 
 	stateInfo, hErr := app.eventGetter.GetRecordsOfType(deviceID, app.getStatusLimit, db.State, "")
 	if hErr != nil {
@@ -141,70 +249,122 @@ func (app *App) getStatusInfo(deviceID string) (Status, error) {
 	var (
 		lastOfflineEvent eventTuple
 		lastOnlineEvent  eventTuple
+		lastBirthDate    int64
 	)
 	for _, record := range stateInfo {
 
 		// if the record is expired, don't include it
-		if time.Unix(0, record.DeathDate).Before(time.Now()) {
+		if !includeExpired && isExpired(record) {
+			explanation.skip(record, eventTuple{}, "expired")
 			continue
 		}
+		if record.BirthDate > lastBirthDate {
+			lastBirthDate = record.BirthDate
+		}
 
-		item, err := app.parseState(deviceID, record)
+		item, stage, err := app.parseState(deviceID, record)
 		if err != nil {
-			logging.Error(app.logger).Log(logging.MessageKey(), err)
+			// explaining a status is diagnostic, so its failures aren't counted
+			if explanation == nil {
+				app.countDecodeFailure(record, stage)
+			}
+			logging.Error(app.logger, recordFields(record)...).Log(logging.MessageKey(), "Failed to parse state event", logging.ErrorKey(), err.Error())
+			explanation.skip(record, item, err.Error())
+			continue
 		}
 
-		if item.status.State == "offline" {
+		switch item.kind {
+		case kindOffline:
 			if item.status.Since.After(lastOfflineEvent.status.Since) {
 				lastOfflineEvent = item
 			}
-		}
-		if item.status.State == "online" {
+		case kindOnline:
 			if item.status.Since.After(lastOnlineEvent.status.Since) {
 				lastOnlineEvent = item
 			}
+		default:
+			explanation.skip(record, item, fmt.Sprintf("unrecognized state %q", item.status.State))
+			continue
 		}
+		explanation.consider(record, item)
 	}
+	explanation.candidates(lastOnlineEvent, lastOfflineEvent)
 
 	if lastOfflineEvent.status.State == "" && lastOnlineEvent.status.State == "" {
+		explanation.decide(nil, ruleNoCandidates)
 		return Status{}, serverErr{emperror.With(errors.New("No events found for device id"), "device id", deviceID),
 			http.StatusNotFound}
 	}
 
-	return determineStatus(lastOnlineEvent, lastOfflineEvent), nil
+	chosen, rule := determineStatus(lastOnlineEvent, lastOfflineEvent)
+	status := chosen.status
+	kind := chosen.kind
+	if kind == kindOnline && app.status.isStale(lastOnlineEvent.status.Since, status.Now) {
+		status.State = stateUnknown
+		kind = ""
+		rule = ruleStaleOnline
+	}
+	summarizeSessions(&status, kind, lastOnlineEvent, lastOfflineEvent)
+	if lastBirthDate > 0 {
+		t := time.Unix(0, lastBirthDate)
+		status.LastEventAt = &t
+	}
+	status.Metadata = mergeMetadata(chosen.metadata, lastOnlineEvent.metadata)
+	explanation.decide(&status, rule)
+	return status, nil
 }
 
-func (app *App) parseState(deviceID string, record db.Record) (eventTuple, error) {
-	decrypter, ok := app.decrypters.Get(voynicrypto.ParseAlgorithmType(record.Alg), record.KID)
-	if !ok {
-		app.measures.GetDecryptFailure.Add(1.0)
-		return eventTuple{}, errors.New("failed to find decrypter")
+// summarizeSessions adds when the device was last online and offline, and
+// how long it has been in its current state, so that clients don't have to
+// work it out from clock skewed times.  The kind of the current state is
+// empty if it isn't known.
+func summarizeSessions(status *Status, kind string, lastOnline, lastOffline eventTuple) {
+	if lastOnline.status.State != "" {
+		t := lastOnline.status.Since
+		status.LastOnlineAt = &t
 	}
-	data, err := decrypter.DecryptMessage(record.Data, record.Nonce)
-	if err != nil {
-		app.measures.DecryptFailure.Add(1.0)
-		return eventTuple{}, fmt.Errorf("failed to decrypt event: %v", err)
+	if lastOffline.status.State != "" {
+		t := lastOffline.status.Since
+		status.LastOfflineAt = &t
 	}
 
-	var event wrp.Message
-	decoder := wrp.NewDecoderBytes(data, wrp.Msgpack)
-	err = decoder.Decode(&event)
-	if err != nil {
-		app.measures.UnmarshalFailure.Add(1.0)
-		return eventTuple{}, fmt.Errorf("failed to decode event: %v", err)
+	seconds := int64(status.Now.Sub(status.Since) / time.Second)
+	if seconds < 0 {
+		seconds = 0
+	}
+	switch kind {
+	case kindOnline:
+		status.OnlineFor = &seconds
+	case kindOffline:
+		status.OfflineFor = &seconds
 	}
+}
+
+func (app *App) parseState(deviceID string, record db.Record) (eventTuple, string, error) {
+	event, stage, err := app.decodeRecord(record)
+	switch stage {
+	case stageDecrypt:
+		return eventTuple{}, stage, fmt.Errorf("failed to decrypt event: %v", err)
+	case stageDecode:
+		return eventTuple{}, stage, fmt.Errorf("failed to decode event: %v", err)
+	case stageDecrypter:
+		return eventTuple{}, stage, err
+	}
+
 	var payload map[string]interface{}
 	err = json.Unmarshal(event.Payload, &payload)
 	if err != nil {
-		return eventTuple{}, fmt.Errorf("failed to unmarshal payload: %v", err)
+		return eventTuple{}, stagePayload, fmt.Errorf("failed to unmarshal payload: %v", err)
 	}
 
+	state, kind := app.status.stateOf(event.Destination)
 	s := Status{
 		DeviceID:   deviceID,
-		State:      path.Base(event.Destination),
+		State:      state,
 		Since:      time.Unix(0, record.BirthDate),
 		Now:        time.Now(),
 		PartnerIDs: event.PartnerIDs,
+		SessionID:  event.SessionID,
 	}
 
 	if value, ok := payload[payloadKey]; ok && s.LastOfflineReason == "" {
@@ -214,23 +374,27 @@ func (app *App) parseState(deviceID string, record db.Record) (eventTuple, error
 	item := eventTuple{
 		record:    record,
 		status:    s,
+		kind:      kind,
 		sessionID: event.SessionID,
+		metadata:  app.status.extractMetadata(payload, event.Metadata),
 	}
-	return item, nil
+	return item, "", nil
 }
 
-func determineStatus(lastOnline, lastOffline eventTuple) Status {
+// determineStatus picks the event that provides the device's status from the
+// newest online and offline events, returning the rule that decided it.
+func determineStatus(lastOnline, lastOffline eventTuple) (eventTuple, string) {
 	if lastOffline.status.State == "" {
-		return lastOnline.status
+		return lastOnline, ruleOnlyOnline
 	}
 	if lastOnline.status.State == "" {
-		return lastOffline.status
+		return lastOffline, ruleOnlyOffline
 	}
 	if (lastOnline.sessionID == "" || lastOffline.sessionID == "") && lastOnline.status.Since.After(lastOffline.status.Since) {
-		return lastOnline.status
+		return lastOnline, ruleNoSessionOnlineNewer
 	}
 	if lastOffline.sessionID == lastOnline.sessionID {
-		return lastOffline.status
+		return lastOffline, ruleSameSession
 	}
-	return lastOnline.status
+	return lastOnline, ruleDifferentSession
 }
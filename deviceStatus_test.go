@@ -52,6 +52,14 @@ func TestGetStatusInfo(t *testing.T) {
 	err = offlineEncoder.Encode(&goodOfflineEvent)
 	testassert.Nil(err)
 
+	at := func(ns int64) *time.Time {
+		t := time.Unix(0, ns)
+		return &t
+	}
+	seconds := func(n int64) *int64 {
+		return &n
+	}
+
 	tests := []struct {
 		description          string
 		recordsToReturn      []db.Record
@@ -165,6 +173,42 @@ func TestGetStatusInfo(t *testing.T) {
 				Since:             time.Unix(0, futureTime-500),
 				Now:               time.Now(),
 				LastOfflineReason: "ping miss",
+				SessionID:         goodOnlineEvent.SessionID,
+				OnlineFor:         seconds(0),
+				LastOnlineAt:      at(futureTime - 500),
+				LastEventAt:       at(futureTime - 500),
+			},
+		},
+		{
+			description: "Success-Newer-Expired",
+			recordsToReturn: []db.Record{
+				{
+					Type:      db.State,
+					BirthDate: futureTime - 100,
+					DeathDate: 1,
+					Data:      offlineData,
+					Alg:       string(voynicrypto.None),
+					KID:       "none",
+				},
+				{
+					Type:      db.State,
+					BirthDate: futureTime - 500,
+					DeathDate: futureTime,
+					Data:      goodData,
+					Alg:       string(voynicrypto.None),
+					KID:       "none",
+				},
+			},
+			expectedStatus: Status{
+				DeviceID:          "test",
+				State:             "online",
+				Since:             time.Unix(0, futureTime-500),
+				Now:               time.Now(),
+				LastOfflineReason: "ping miss",
+				SessionID:         goodOnlineEvent.SessionID,
+				OnlineFor:         seconds(0),
+				LastOnlineAt:      at(futureTime - 500),
+				LastEventAt:       at(futureTime - 500),
 			},
 		},
 		{
@@ -185,6 +229,10 @@ func TestGetStatusInfo(t *testing.T) {
 				Since:             time.Unix(0, futureTime-500),
 				Now:               time.Now(),
 				LastOfflineReason: "ping miss",
+				SessionID:         goodOfflineEvent.SessionID,
+				OfflineFor:        seconds(0),
+				LastOfflineAt:     at(futureTime - 500),
+				LastEventAt:       at(futureTime - 500),
 			},
 		},
 		{
@@ -213,6 +261,11 @@ func TestGetStatusInfo(t *testing.T) {
 				Since:             time.Unix(0, futureTime-700),
 				Now:               time.Now(),
 				LastOfflineReason: "ping miss",
+				SessionID:         goodOnlineEvent.SessionID,
+				OnlineFor:         seconds(0),
+				LastOnlineAt:      at(futureTime - 700),
+				LastOfflineAt:     at(futureTime - 500),
+				LastEventAt:       at(futureTime - 500),
 			},
 		},
 	}
@@ -241,16 +294,22 @@ func TestGetStatusInfo(t *testing.T) {
 				eventGetter:    mockGetter,
 				getStatusLimit: 5,
 				logger:         logging.DefaultLogger(),
-				decrypters:     ciphers,
+				decrypters:     &ciphers,
 				measures:       m,
 			}
-			status, err := app.getStatusInfo("test")
+			status, err := app.getStatusInfo("test", false)
 
 			// can't assert over the full status, since we can't check Now
 			assert.Equal(tc.expectedStatus.DeviceID, status.DeviceID)
 			assert.Equal(tc.expectedStatus.State, status.State)
 			assert.Equal(tc.expectedStatus.Since, status.Since)
 			assert.Equal(tc.expectedStatus.LastOfflineReason, status.LastOfflineReason)
+			assert.Equal(tc.expectedStatus.SessionID, status.SessionID)
+			assert.Equal(tc.expectedStatus.OnlineFor, status.OnlineFor)
+			assert.Equal(tc.expectedStatus.OfflineFor, status.OfflineFor)
+			assert.Equal(tc.expectedStatus.LastOnlineAt, status.LastOnlineAt)
+			assert.Equal(tc.expectedStatus.LastOfflineAt, status.LastOfflineAt)
+			assert.Equal(tc.expectedStatus.LastEventAt, status.LastEventAt)
 
 			if tc.expectedErr == nil || err == nil {
 				assert.Equal(tc.expectedErr, err)
@@ -289,12 +348,12 @@ func TestHandleGetStatus(t *testing.T) {
 		},
 		{
 			description:        "Get Device Info Error",
-			deviceID:           "1234",
+			deviceID:           "mac:112233445566",
 			expectedStatusCode: http.StatusNotFound,
 		},
 		{
 			description: "Success",
-			deviceID:    "1234",
+			deviceID:    "mac:112233445566",
 			recordsToReturn: []db.Record{
 				{
 					DeathDate: futureTime,
@@ -308,7 +367,7 @@ func TestHandleGetStatus(t *testing.T) {
 		},
 		{
 			description: "No Decrypter",
-			deviceID:    "1234",
+			deviceID:    "mac:112233445566",
 			recordsToReturn: []db.Record{
 				{
 					DeathDate: futureTime,
@@ -345,7 +404,7 @@ func TestHandleGetStatus(t *testing.T) {
 				eventGetter:    mockGetter,
 				getStatusLimit: 5,
 				logger:         logging.DefaultLogger(),
-				decrypters:     ciphers,
+				decrypters:     &ciphers,
 				measures:       m,
 			}
 			rr := httptest.NewRecorder()
@@ -358,3 +417,56 @@ func TestHandleGetStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestSummarizeSessions(t *testing.T) {
+	now := time.Now()
+	seconds := func(n int64) *int64 {
+		return &n
+	}
+	online := eventTuple{status: Status{State: "online", Since: now.Add(-time.Hour)}}
+	offline := eventTuple{status: Status{State: "offline", Since: now.Add(-2 * time.Hour)}}
+
+	tests := []struct {
+		description        string
+		status             Status
+		online             eventTuple
+		offline            eventTuple
+		expectedOnlineFor  *int64
+		expectedOfflineFor *int64
+	}{
+		{
+			description:       "Online",
+			status:            Status{State: "online", Since: online.status.Since, Now: now},
+			online:            online,
+			offline:           offline,
+			expectedOnlineFor: seconds(3600),
+		},
+		{
+			description:        "Offline",
+			status:             Status{State: "offline", Since: offline.status.Since, Now: now},
+			offline:            offline,
+			expectedOfflineFor: seconds(7200),
+		},
+		{
+			description:        "Clock Skew",
+			status:             Status{State: "offline", Since: now.Add(time.Minute), Now: now},
+			offline:            offline,
+			expectedOfflineFor: seconds(0),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			summarizeSessions(&tc.status, tc.status.State, tc.online, tc.offline)
+			assert.Equal(tc.expectedOnlineFor, tc.status.OnlineFor)
+			assert.Equal(tc.expectedOfflineFor, tc.status.OfflineFor)
+			if tc.online.status.State == "" {
+				assert.Nil(tc.status.LastOnlineAt)
+			} else {
+				assert.Equal(online.status.Since, *tc.status.LastOnlineAt)
+			}
+			assert.Equal(offline.status.Since, *tc.status.LastOfflineAt)
+		})
+	}
+}
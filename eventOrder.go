@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/xmidt-org/gungnir/model"
+)
+
+// The orders events can be returned in.  Without one, events are returned in
+// the order the database gives them.
+const (
+	orderAsc  = "asc"
+	orderDesc = "desc"
+)
+
+// dedupeTransactionUUID collapses events with the same transaction uuid,
+// source and destination.
+const dedupeTransactionUUID = "transaction_uuid"
+
+// duplicatesRemovedHeader is the number of events dedupe removed.
+const duplicatesRemovedHeader = "X-Codex-Duplicates-Removed"
+
+// eventOptions are the order and dedupe options of an events request.
+type eventOptions struct {
+	order  string
+	dedupe string
+}
+
+// requestEventOptions reads the order and dedupe options.
+func requestEventOptions(request *http.Request) (eventOptions, error) {
+	o := eventOptions{
+		order:  request.FormValue("order"),
+		dedupe: request.FormValue("dedupe"),
+	}
+	switch o.order {
+	case "", orderAsc, orderDesc:
+	default:
+		return eventOptions{}, fmt.Errorf("invalid order value %q, expected asc or desc", o.order)
+	}
+	switch o.dedupe {
+	case "", dedupeTransactionUUID:
+	default:
+		return eventOptions{}, fmt.Errorf("invalid dedupe value %q, expected transaction_uuid", o.dedupe)
+	}
+	return o, nil
+}
+
+// apply sorts and dedupes the events, returning them along with how many
+// duplicates were removed.
+func (o eventOptions) apply(events []model.Event) ([]model.Event, int) {
+	if o.order != "" {
+		sortEvents(events, o.order == orderDesc)
+	}
+	if o.dedupe == dedupeTransactionUUID {
+		return dedupeEvents(events)
+	}
+	return events, 0
+}
+
+// sortEvents sorts the events in place by birth date.  Events born at the
+// same time are ordered by transaction uuid, source and destination, and then
+// by their original order, so the result is the same every time.
+func sortEvents(events []model.Event, descending bool) {
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if descending {
+			a, b = b, a
+		}
+		switch {
+		case a.BirthDate != b.BirthDate:
+			return a.BirthDate < b.BirthDate
+		case a.TransactionUUID != b.TransactionUUID:
+			return a.TransactionUUID < b.TransactionUUID
+		case a.Source != b.Source:
+			return a.Source < b.Source
+		default:
+			return a.Destination < b.Destination
+		}
+	})
+}
+
+// dedupeEvents keeps the first of the events with the same transaction uuid,
+// source and destination, which codex stored more than once.  Events without
+// a transaction uuid, or that failed to decode, can't be told apart and are
+// all kept.
+func dedupeEvents(events []model.Event) ([]model.Event, int) {
+	type key struct {
+		transactionUUID, source, destination string
+	}
+	var (
+		seen = map[key]bool{}
+		kept = make([]model.Event, 0, len(events))
+	)
+	for _, e := range events {
+		if e.TransactionUUID == "" || e.Error != nil {
+			kept = append(kept, e)
+			continue
+		}
+		k := key{e.TransactionUUID, e.Source, e.Destination}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		kept = append(kept, e)
+	}
+	return kept, len(events) - len(kept)
+}
@@ -0,0 +1,204 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/gungnir/model"
+	"github.com/xmidt-org/voynicrypto"
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestRequestEventOptions(t *testing.T) {
+	tests := []struct {
+		description string
+		query       string
+		expected    eventOptions
+		expectedErr string
+	}{
+		{
+			description: "None",
+		},
+		{
+			description: "Both",
+			query:       "?order=asc&dedupe=transaction_uuid",
+			expected:    eventOptions{order: orderAsc, dedupe: dedupeTransactionUUID},
+		},
+		{
+			description: "Bad Order",
+			query:       "?order=newest",
+			expectedErr: `invalid order value "newest"`,
+		},
+		{
+			description: "Bad Dedupe",
+			query:       "?dedupe=source",
+			expectedErr: `invalid dedupe value "source"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			o, err := requestEventOptions(httptest.NewRequest(http.MethodGet, "/"+tc.query, nil))
+			if tc.expectedErr != "" {
+				require.NotNil(t, err)
+				assert.Contains(err.Error(), tc.expectedErr)
+				return
+			}
+			assert.Nil(err)
+			assert.Equal(tc.expected, o)
+		})
+	}
+}
+
+func TestSortEvents(t *testing.T) {
+	event := func(birthDate int64, transactionUUID, source, destination string) model.Event {
+		return model.Event{
+			Message:   wrp.Message{TransactionUUID: transactionUUID, Source: source, Destination: destination},
+			BirthDate: birthDate,
+		}
+	}
+	var (
+		a = event(1, "b", "dns:a", "event:a")
+		b = event(2, "a", "dns:a", "event:a")
+		c = event(2, "b", "dns:a", "event:a")
+		d = event(2, "b", "dns:b", "event:a")
+		e = event(2, "b", "dns:b", "event:b")
+		f = event(3, "", "", "")
+	)
+
+	events := []model.Event{e, f, c, a, d, b}
+	sortEvents(events, false)
+	assert.Equal(t, []model.Event{a, b, c, d, e, f}, events)
+
+	events = []model.Event{c, a, f, e, b, d}
+	sortEvents(events, true)
+	assert.Equal(t, []model.Event{f, e, d, c, b, a}, events)
+}
+
+func TestDedupeEvents(t *testing.T) {
+	assert := assert.New(t)
+	event := func(birthDate int64, transactionUUID, destination string) model.Event {
+		return model.Event{
+			Message:   wrp.Message{TransactionUUID: transactionUUID, Source: "dns:talaria", Destination: destination},
+			BirthDate: birthDate,
+		}
+	}
+	failed := model.Event{BirthDate: 1, Error: &model.EventError{Stage: stageDecode, Reason: "bad"}}
+	events := []model.Event{
+		event(5, "1", "event:online"),
+		event(4, "1", "event:online"),
+		event(3, "1", "event:offline"),
+		event(2, "", "event:online"),
+		event(2, "", "event:online"),
+		failed,
+		failed,
+	}
+
+	kept, removed := dedupeEvents(events)
+	assert.Equal(1, removed)
+	assert.Equal([]model.Event{events[0], events[2], events[3], events[4], failed, failed}, kept)
+
+	kept, removed = dedupeEvents(nil)
+	assert.Empty(kept)
+	assert.Zero(removed)
+}
+
+func TestHandleGetEventsOrderAndDedupe(t *testing.T) {
+	futureTime := time.Now().Add(time.Hour).UnixNano()
+	encode := func(transactionUUID string) []byte {
+		msg := goodOnlineEvent
+		msg.TransactionUUID = transactionUUID
+		var data []byte
+		require.Nil(t, wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(&msg))
+		return data
+	}
+	record := func(birthDate int64, transactionUUID string) db.Record {
+		return db.Record{Type: db.State, BirthDate: birthDate, DeathDate: futureTime, Data: encode(transactionUUID), Alg: string(voynicrypto.None), KID: "none"}
+	}
+	records := []db.Record{record(2, "b"), record(3, "a"), record(1, "a")}
+
+	tests := []struct {
+		description        string
+		query              string
+		expectedStatusCode int
+		expectedBirthDates []int64
+		expectedRemoved    string
+	}{
+		{
+			description:        "Database Order",
+			expectedStatusCode: http.StatusOK,
+			expectedBirthDates: []int64{2, 3, 1},
+		},
+		{
+			description:        "Ascending",
+			query:              "?order=asc",
+			expectedStatusCode: http.StatusOK,
+			expectedBirthDates: []int64{1, 2, 3},
+		},
+		{
+			description:        "Descending Deduped",
+			query:              "?order=desc&dedupe=transaction_uuid",
+			expectedStatusCode: http.StatusOK,
+			expectedBirthDates: []int64{3, 2},
+			expectedRemoved:    "1",
+		},
+		{
+			description:        "Invalid Order",
+			query:              "?order=sideways",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			mockGetter := new(mockRecordGetter)
+			mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return(records, nil).Maybe()
+			mockGetter.On("GetStateHash", mock.Anything).Return("123", nil).Maybe()
+			app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+			app.eventGetter = mockGetter
+
+			auth := bascule.Authentication{
+				Token: bascule.NewToken("jwt", "owner", bascule.NewAttributes(map[string]interface{}{
+					"allowedResources": map[string]interface{}{"allowedPartners": []string{"test1"}},
+				})),
+			}
+			request, err := http.NewRequestWithContext(bascule.WithAuthentication(context.Background(), auth),
+				http.MethodGet, "/device/mac:112233445566/events"+tc.query, nil)
+			require.Nil(err)
+			request = mux.SetURLVars(request, map[string]string{"deviceID": "mac:112233445566"})
+			rr := httptest.NewRecorder()
+			app.handleGetEvents(rr, request)
+			require.Equal(tc.expectedStatusCode, rr.Code)
+			if tc.expectedStatusCode != http.StatusOK {
+				assert.NotEmpty(rr.Header().Get("X-Codex-Error"))
+				return
+			}
+
+			var events []model.Event
+			require.Nil(json.Unmarshal(rr.Body.Bytes(), &events))
+			birthDates := make([]int64, 0, len(events))
+			for _, e := range events {
+				birthDates = append(birthDates, e.BirthDate)
+			}
+			assert.Equal(tc.expectedBirthDates, birthDates)
+			assert.Equal(tc.expectedRemoved, rr.Header().Get(duplicatesRemovedHeader))
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/xmidt-org/webpa-common/v2/logging" //nolint: staticcheck
+)
+
+// ExpiredRecordsConfig controls who may ask for records past their death date
+// with include_expired=true.  Expired records stay in the database until they
+// are cleaned up, which makes them useful during incidents.  With neither
+// list set, nobody may.
+type ExpiredRecordsConfig struct {
+	// Capabilities allows JWTs carrying any of these capabilities.
+	Capabilities []string
+
+	// BasicUsers allows these basic auth users.
+	BasicUsers []string
+}
+
+// allowed reports whether the request may include expired records.
+func (c ExpiredRecordsConfig) allowed(r *http.Request) bool {
+	return AdminConfig(c).isAdmin(r)
+}
+
+// requestIncludeExpired reads the include_expired option.  If the option is
+// invalid or the caller may not use it, the response is written and ok is
+// false.
+func (app *App) requestIncludeExpired(writer http.ResponseWriter, request *http.Request) (include bool, ok bool) {
+	e := request.FormValue("include_expired")
+	if e == "" {
+		return false, true
+	}
+	include, err := strconv.ParseBool(e)
+	if err != nil {
+		writer.Header().Add("X-Codex-Error", fmt.Sprintf("invalid include_expired value %q", e))
+		writer.WriteHeader(http.StatusBadRequest)
+		return false, false
+	}
+	if include && !app.expiredRecords.allowed(request) {
+		writer.Header().Add("X-Codex-Error", "not allowed to include expired records")
+		writer.WriteHeader(http.StatusForbidden)
+		return false, false
+	}
+	if include {
+		logging.Info(app.logger).Log(logging.MessageKey(), "including expired records", "path", request.URL.Path)
+	}
+	return include, true
+}
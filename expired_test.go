@@ -0,0 +1,205 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/voynicrypto"
+	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestRequestIncludeExpired(t *testing.T) {
+	config := ExpiredRecordsConfig{
+		Capabilities: []string{"x1:gungnir:expired"},
+		BasicUsers:   []string{"oncall"},
+	}
+	jwt := func(capabilities ...interface{}) bascule.Authentication {
+		return bascule.Authentication{
+			Token: bascule.NewToken("jwt", "owner", bascule.NewAttributes(map[string]interface{}{
+				"capabilities": capabilities,
+			})),
+		}
+	}
+	basic := func(user string) bascule.Authentication {
+		return bascule.Authentication{Token: bascule.NewToken(basicType, user, bascule.NewAttributes(nil))}
+	}
+
+	tests := []struct {
+		description        string
+		value              string
+		auth               bascule.Authentication
+		expectedInclude    bool
+		expectedStatusCode int
+	}{
+		{
+			description: "Not Asked For",
+			auth:        jwt(),
+		},
+		{
+			description: "False",
+			value:       "false",
+			auth:        jwt(),
+		},
+		{
+			description:     "Capability",
+			value:           "true",
+			auth:            jwt("x1:gungnir:expired"),
+			expectedInclude: true,
+		},
+		{
+			description:     "Basic User",
+			value:           "true",
+			auth:            basic("oncall"),
+			expectedInclude: true,
+		},
+		{
+			description:        "Not Allowed",
+			value:              "true",
+			auth:               jwt("x1:gungnir:events"),
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			description:        "Other Basic User",
+			value:              "true",
+			auth:               basic("user"),
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			description:        "Invalid",
+			value:              "sometimes",
+			auth:               jwt("x1:gungnir:expired"),
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			app := App{expiredRecords: config, logger: logging.DefaultLogger()}
+			request, err := http.NewRequestWithContext(bascule.WithAuthentication(context.Background(), tc.auth),
+				http.MethodGet, "/device/mac:112233445566/events?include_expired="+tc.value, nil)
+			require.Nil(t, err)
+			rr := httptest.NewRecorder()
+			include, ok := app.requestIncludeExpired(rr, request)
+			assert.Equal(tc.expectedInclude, include)
+			if tc.expectedStatusCode == 0 {
+				assert.True(ok)
+				return
+			}
+			assert.False(ok)
+			assert.Equal(tc.expectedStatusCode, rr.Code)
+			assert.NotEmpty(rr.Header().Get("X-Codex-Error"))
+		})
+	}
+
+	// without any configuration, nobody may include expired records
+	request, err := http.NewRequestWithContext(bascule.WithAuthentication(context.Background(), jwt("x1:gungnir:expired")),
+		http.MethodGet, "/?include_expired=true", nil)
+	require.Nil(t, err)
+	_, ok := (&App{logger: logging.DefaultLogger()}).requestIncludeExpired(httptest.NewRecorder(), request)
+	assert.False(t, ok)
+}
+
+func TestHandleGetEventsIncludeExpired(t *testing.T) {
+	futureTime := time.Now().Add(time.Hour).UnixNano()
+	pastTime := time.Now().Add(-time.Hour).UnixNano()
+	var data []byte
+	require.Nil(t, wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(&goodOnlineEvent))
+	records := []db.Record{
+		{Type: db.State, BirthDate: pastTime - 100, DeathDate: futureTime, Data: data, Alg: string(voynicrypto.None), KID: "none"},
+		{Type: db.State, BirthDate: pastTime - 200, DeathDate: pastTime, Data: data, Alg: string(voynicrypto.None), KID: "none"},
+	}
+
+	tests := []struct {
+		description        string
+		query              string
+		expectedDeathDates []int64
+	}{
+		{
+			description:        "Expired Skipped",
+			expectedDeathDates: []int64{futureTime},
+		},
+		{
+			description:        "Expired Included",
+			query:              "?include_expired=true",
+			expectedDeathDates: []int64{futureTime, pastTime},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			mockGetter := new(mockRecordGetter)
+			mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return(records, nil).Once()
+			mockGetter.On("GetStateHash", mock.Anything).Return("123", nil).Once()
+			app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+			app.eventGetter = mockGetter
+			app.expiredRecords = ExpiredRecordsConfig{Capabilities: []string{"x1:gungnir:expired"}}
+
+			auth := bascule.Authentication{
+				Token: bascule.NewToken("jwt", "owner", bascule.NewAttributes(map[string]interface{}{
+					"allowedResources": map[string]interface{}{"allowedPartners": []string{"test1"}},
+					"capabilities":     []interface{}{"x1:gungnir:expired"},
+				})),
+			}
+			request, err := http.NewRequestWithContext(bascule.WithAuthentication(context.Background(), auth),
+				http.MethodGet, "/device/mac:112233445566/events"+tc.query, nil)
+			require.Nil(err)
+			request = mux.SetURLVars(request, map[string]string{"deviceID": "mac:112233445566"})
+			rr := httptest.NewRecorder()
+			app.handleGetEvents(rr, request)
+			require.Equal(http.StatusOK, rr.Code)
+
+			var events []struct {
+				DeathDate int64 `json:"death_date"`
+			}
+			require.Nil(json.Unmarshal(rr.Body.Bytes(), &events))
+			deathDates := make([]int64, 0, len(events))
+			for _, e := range events {
+				deathDates = append(deathDates, e.DeathDate)
+			}
+			assert.Equal(tc.expectedDeathDates, deathDates)
+		})
+	}
+}
+
+func TestGetStatusInfoIncludeExpired(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	pastTime := time.Now().Add(-time.Hour).UnixNano()
+	var data []byte
+	require.Nil(wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(&goodOfflineEvent))
+	records := []db.Record{
+		{Type: db.State, BirthDate: pastTime - 100, DeathDate: pastTime, Data: data, Alg: string(voynicrypto.None), KID: "none"},
+	}
+
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecordsOfType", "mac:112233445566", 5, db.State, "").Return(records, nil)
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.eventGetter = mockGetter
+
+	_, err := app.getStatusInfo("mac:112233445566", false)
+	assert.NotNil(err)
+
+	s, err := app.getStatusInfo("mac:112233445566", true)
+	require.Nil(err)
+	assert.Equal("offline", s.State)
+	require.NotNil(s.LastEventAt)
+	assert.Equal(pastTime-100, s.LastEventAt.UnixNano())
+}
@@ -0,0 +1,212 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/goph/emperror"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/webpa-common/v2/logging" //nolint: staticcheck
+)
+
+// Outcomes of a pipeline stage.
+const (
+	stagePassed  = "passed"
+	stageFailed  = "failed"
+	stageSkipped = "skipped"
+)
+
+// Results of running a record through the pipeline.  Records that fail to
+// decrypt or decode are still returned, but as unknown message types.
+const (
+	resultReturned = "returned"
+	resultExpired  = "expired"
+	resultUnknown  = "unknown"
+)
+
+// StageResult is the outcome of one stage of the record pipeline.
+type StageResult struct {
+	Stage   string `json:"stage"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RecordExplanation describes what happened to a single record on its way to
+// becoming an event.
+type RecordExplanation struct {
+	RowID     string          `json:"row_id"`
+	Type      string          `json:"type"`
+	BirthDate time.Time       `json:"birth_date"`
+	DeathDate time.Time       `json:"death_date"`
+	Alg       string          `json:"alg"`
+	KID       string          `json:"kid"`
+	Stages    []StageResult   `json:"stages"`
+	Result    string          `json:"result"`
+	Event     json.RawMessage `json:"event,omitempty"`
+}
+
+// RecordsExplanation is the response of the explain endpoint.
+type RecordsExplanation struct {
+	DeviceID string              `json:"device_id"`
+	Now      time.Time           `json:"now"`
+	Records  []RecordExplanation `json:"records"`
+}
+
+/*
+ * swagger:route GET /admin/device/{deviceID}/explain admin explainRecords
+ *
+ * Explain what happened to each stored record of a device: whether it was
+ * dropped as expired, or which stage of decryption and decoding failed.
+ * Decoded events are only included when plaintext=true.  Only available to
+ * admins.
+ *
+ * Parameters: deviceID, plaintext
+ *
+ * Produces:
+ *    - application/json
+ *
+ * Schemes: https
+ *
+ * Security:
+ *    bearer_token:
+ *
+ * Responses:
+ *    200: RecordsExplanation
+ *    400: ErrResponse
+ *    403: ErrResponse
+ *    404: ErrResponse
+ *    500: ErrResponse
+ *
+ */
+func (app *App) handleExplainRecords(writer http.ResponseWriter, request *http.Request) {
+	if !app.admin.isAdmin(request) {
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	id, ok := app.requestDeviceID(writer, request)
+	if !ok {
+		return
+	}
+
+	plaintext := false
+	if p := request.FormValue("plaintext"); p != "" {
+		var err error
+		if plaintext, err = strconv.ParseBool(p); err != nil {
+			writer.Header().Add("X-Codex-Error", fmt.Sprintf("invalid plaintext value %q", p))
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	explanation, err := app.explainRecords(id, plaintext)
+	if err != nil {
+		logging.Error(app.logger, emperror.Context(err)...).Log(logging.MessageKey(),
+			"Failed to explain records", logging.ErrorKey(), err.Error())
+		writer.Header().Add("X-Codex-Error", err.Error())
+		var coder kithttp.StatusCoder
+		if errors.As(err, &coder) {
+			writer.WriteHeader(coder.StatusCode())
+			return
+		}
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logging.Info(app.logger).Log(logging.MessageKey(), "explained records", "device id", id, "plaintext", plaintext)
+
+	addAuditDevice(request.Context(), id, len(explanation.Records))
+	data, err := json.Marshal(&explanation)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(data)
+}
+
+func (app *App) explainRecords(deviceID string, plaintext bool) (RecordsExplanation, error) {
+	records, err := app.eventGetter.GetRecords(deviceID, app.getEventLimit, "")
+	if err != nil {
+		return RecordsExplanation{}, serverErr{emperror.WrapWith(err, "Failed to get events", "device id", deviceID),
+			http.StatusInternalServerError}
+	}
+	if len(records) == 0 {
+		return RecordsExplanation{}, serverErr{emperror.With(errors.New("no records found for device id"), "device id", deviceID),
+			http.StatusNotFound}
+	}
+
+	explanation := RecordsExplanation{
+		DeviceID: deviceID,
+		Now:      time.Now(),
+		Records:  make([]RecordExplanation, 0, len(records)),
+	}
+	for _, record := range records {
+		e, err := app.explainRecord(record, plaintext)
+		if err != nil {
+			return RecordsExplanation{}, serverErr{emperror.WrapWith(err, "Failed to encode event", "device id", deviceID),
+				http.StatusInternalServerError}
+		}
+		explanation.Records = append(explanation.Records, e)
+	}
+	return explanation, nil
+}
+
+// explainRecord runs a record through the same stages as parseRecords,
+// without counting or logging failures.
+func (app *App) explainRecord(record db.Record, plaintext bool) (RecordExplanation, error) {
+	e := RecordExplanation{
+		RowID:     record.RowID,
+		Type:      record.Type.String(),
+		BirthDate: time.Unix(0, record.BirthDate),
+		DeathDate: time.Unix(0, record.DeathDate),
+		Alg:       record.Alg,
+		KID:       record.KID,
+	}
+
+	if isExpired(record) {
+		e.Stages = []StageResult{
+			{Stage: stageExpiry, Outcome: stageFailed, Error: fmt.Sprintf("expired %s ago", time.Since(e.DeathDate).Round(time.Second))},
+			{Stage: stageDecrypter, Outcome: stageSkipped},
+			{Stage: stageDecrypt, Outcome: stageSkipped},
+			{Stage: stageDecode, Outcome: stageSkipped},
+		}
+		e.Result = resultExpired
+		return e, nil
+	}
+
+	event, failed, err := app.decodeRecord(record)
+	e.Stages = []StageResult{{Stage: stageExpiry, Outcome: stagePassed}}
+	outcome := stagePassed
+	for _, stage := range []string{stageDecrypter, stageDecrypt, stageDecode} {
+		result := StageResult{Stage: stage, Outcome: outcome}
+		if stage == failed {
+			result.Outcome = stageFailed
+			result.Error = err.Error()
+			outcome = stageSkipped
+		}
+		e.Stages = append(e.Stages, result)
+	}
+
+	if err != nil {
+		e.Result = resultUnknown
+		return e, nil
+	}
+	e.Result = resultReturned
+	if plaintext {
+		data, err := encodeEvents(event)
+		if err != nil {
+			return e, err
+		}
+		e.Event = data
+	}
+	return e, nil
+}
@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/voynicrypto"
+	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestHandleExplainRecords(t *testing.T) {
+	testassert := assert.New(t)
+	futureTime := time.Now().Add(time.Duration(50000) * time.Minute).UnixNano()
+	pastTime := time.Now().Add(-time.Hour).UnixNano()
+	var goodData []byte
+	encoder := wrp.NewEncoderBytes(&goodData, wrp.Msgpack)
+	testassert.Nil(encoder.Encode(&goodOnlineEvent))
+	badData, err := json.Marshal("")
+	testassert.Nil(err)
+
+	admin := bascule.Authentication{Token: bascule.NewToken("basic", "admin", bascule.NewAttributes(nil))}
+	records := []db.Record{
+		{RowID: "1", Type: db.State, BirthDate: pastTime - 500, DeathDate: pastTime, Data: goodData, Alg: string(voynicrypto.None), KID: "none"},
+		{RowID: "2", Type: db.State, BirthDate: futureTime - 500, DeathDate: futureTime, Data: goodData, Alg: string(voynicrypto.Box), KID: "test"},
+		{RowID: "3", Type: db.State, BirthDate: futureTime - 400, DeathDate: futureTime, Data: goodData, Alg: string(voynicrypto.None), KID: "bad"},
+		{RowID: "4", Type: db.State, BirthDate: futureTime - 300, DeathDate: futureTime, Data: badData, Alg: string(voynicrypto.None), KID: "none"},
+		{RowID: "5", Type: db.State, BirthDate: futureTime - 200, DeathDate: futureTime, Data: goodData, Alg: string(voynicrypto.None), KID: "none"},
+	}
+	expectedStages := [][]string{
+		{stageFailed, stageSkipped, stageSkipped, stageSkipped},
+		{stagePassed, stageFailed, stageSkipped, stageSkipped},
+		{stagePassed, stagePassed, stageFailed, stageSkipped},
+		{stagePassed, stagePassed, stagePassed, stageFailed},
+		{stagePassed, stagePassed, stagePassed, stagePassed},
+	}
+	expectedResults := []string{resultExpired, resultUnknown, resultUnknown, resultUnknown, resultReturned}
+
+	tests := []struct {
+		description        string
+		auth               bascule.Authentication
+		query              string
+		recordsToReturn    []db.Record
+		getRecordsErr      error
+		expectedStatusCode int
+		expectEvent        bool
+	}{
+		{
+			description: "Not Admin",
+			auth: bascule.Authentication{
+				Token: bascule.NewToken("jwt", "owner", bascule.NewAttributes(map[string]interface{}{})),
+			},
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			description:        "Bad Plaintext Value",
+			auth:               admin,
+			query:              "?plaintext=maybe",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Get Records Error",
+			auth:               admin,
+			getRecordsErr:      errors.New("get records test error"),
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			description:        "No Records",
+			auth:               admin,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			description:        "Success",
+			auth:               admin,
+			recordsToReturn:    records,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "Success With Plaintext",
+			auth:               admin,
+			query:              "?plaintext=true",
+			recordsToReturn:    records,
+			expectedStatusCode: http.StatusOK,
+			expectEvent:        true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			mockGetter := new(mockRecordGetter)
+			mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return(tc.recordsToReturn, tc.getRecordsErr).Once()
+
+			goodDecrypter := new(mockDecrypter)
+			goodDecrypter.On("DecryptMessage", mock.Anything, mock.Anything).Return(nil)
+			badDecrypter := new(mockDecrypter)
+			badDecrypter.On("DecryptMessage", mock.Anything, mock.Anything).Return(errors.New("decrypt test error"))
+			ciphers := voynicrypto.Ciphers{
+				Options: map[voynicrypto.AlgorithmType]map[string]voynicrypto.Decrypt{
+					voynicrypto.None: {
+						"none": goodDecrypter,
+						"bad":  badDecrypter,
+					},
+				},
+			}
+
+			p := xmetricstest.NewProvider(nil, Metrics)
+			app := App{
+				eventGetter:   mockGetter,
+				getEventLimit: 5,
+				logger:        logging.DefaultLogger(),
+				decrypters:    &ciphers,
+				measures:      NewMeasures(p),
+				admin:         AdminConfig{BasicUsers: []string{"admin"}},
+			}
+
+			rr := httptest.NewRecorder()
+			request, err := http.NewRequestWithContext(bascule.WithAuthentication(context.Background(), tc.auth),
+				http.MethodGet, "/admin/device/1234/explain"+tc.query, nil)
+			require.Nil(err)
+			request = mux.SetURLVars(request, map[string]string{"deviceID": "mac:112233445566"})
+			app.handleExplainRecords(rr, request)
+			require.Equal(tc.expectedStatusCode, rr.Code)
+
+			// explaining records is diagnostic and doesn't count failures
+			p.Assert(t, GetDecrypterFailureCounter)(xmetricstest.Value(0.0))
+			p.Assert(t, DecryptFailureCounter)(xmetricstest.Value(0.0))
+			p.Assert(t, UnmarshalFailureCounter)(xmetricstest.Value(0.0))
+			if tc.expectedStatusCode != http.StatusOK {
+				return
+			}
+
+			var explanation RecordsExplanation
+			require.Nil(json.Unmarshal(rr.Body.Bytes(), &explanation))
+			assert.Equal("mac:112233445566", explanation.DeviceID)
+			require.Len(explanation.Records, len(records))
+			for i, e := range explanation.Records {
+				assert.Equal(records[i].RowID, e.RowID)
+				assert.Equal("State", e.Type)
+				assert.Equal(records[i].KID, e.KID)
+				assert.Equal(expectedResults[i], e.Result, e.RowID)
+				require.Len(e.Stages, 4)
+				for j, stage := range e.Stages {
+					assert.Equal(expectedStages[i][j], stage.Outcome, "row %s stage %s", e.RowID, stage.Stage)
+					assert.Equal(stage.Outcome == stageFailed, stage.Error != "", "row %s stage %s", e.RowID, stage.Stage)
+				}
+				if tc.expectEvent && e.Result == resultReturned {
+					assert.Contains(string(e.Event), goodOnlineEvent.Destination)
+				} else {
+					assert.Empty(e.Event)
+				}
+			}
+		})
+	}
+}
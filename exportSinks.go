@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// errExportAborted stops an upload that is being abandoned.
+var errExportAborted = errors.New("export aborted")
+
+// ExportSink stores the files export jobs write.
+type ExportSink interface {
+	// Create starts a file with the name.  Nothing is visible until it is
+	// committed.
+	Create(ctx context.Context, name string, contentType string) (ExportObject, error)
+}
+
+// ExportObject is a file being written to a sink.
+type ExportObject interface {
+	io.Writer
+
+	// Commit finishes the file and returns where it can be found.
+	Commit() (string, error)
+
+	// Abort throws away what has been written.
+	Abort()
+}
+
+// newExportSink creates the sink the configuration asks for.
+func newExportSink(config ExportsConfig) (ExportSink, error) {
+	switch config.Sink {
+	case exportSinkFile:
+		return fileExportSink{directory: config.File.Directory}, nil
+	case exportSinkS3:
+		return newS3ExportSink(config.S3)
+	}
+	return nil, fmt.Errorf("unknown export sink %q", config.Sink)
+}
+
+// fileExportSink writes files to a local directory.  Each file is written
+// under a temporary name and renamed when it is committed, so a partial file
+// is never mistaken for a finished one.
+type fileExportSink struct {
+	directory string
+}
+
+func (s fileExportSink) Create(_ context.Context, name string, _ string) (ExportObject, error) {
+	f, err := os.CreateTemp(s.directory, "."+name+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &fileExportObject{f: f, path: filepath.Join(s.directory, name)}, nil
+}
+
+type fileExportObject struct {
+	f    *os.File
+	path string
+}
+
+func (o *fileExportObject) Write(p []byte) (int, error) {
+	return o.f.Write(p)
+}
+
+func (o *fileExportObject) Commit() (string, error) {
+	if err := o.f.Close(); err != nil {
+		_ = os.Remove(o.f.Name())
+		return "", err
+	}
+	if err := os.Rename(o.f.Name(), o.path); err != nil {
+		_ = os.Remove(o.f.Name())
+		return "", err
+	}
+	return o.path, nil
+}
+
+func (o *fileExportObject) Abort() {
+	_ = o.f.Close()
+	_ = os.Remove(o.f.Name())
+}
+
+// s3ExportSink uploads files to a bucket of an S3 compatible object store.
+// Files are streamed with a multipart upload, so they don't have to fit in
+// memory or on disk.
+type s3ExportSink struct {
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	partSize uint64
+}
+
+func newS3ExportSink(config ExportS3Config) (*s3ExportSink, error) {
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	creds := credentials.NewEnvAWS()
+	if config.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(config.AccessKeyID, config.SecretAccessKey, "")
+	}
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:  creds,
+		Secure: u.Scheme == "https",
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3ExportSink{
+		client:   client,
+		bucket:   config.Bucket,
+		prefix:   config.Prefix,
+		partSize: uint64(config.PartSizeMB) * 1024 * 1024,
+	}, nil
+}
+
+func (s *s3ExportSink) Create(ctx context.Context, name string, contentType string) (ExportObject, error) {
+	key := path.Join(s.prefix, name)
+	ctx, cancel := context.WithCancel(ctx)
+	r, w := io.Pipe()
+	o := &s3ExportObject{
+		w:        w,
+		cancel:   cancel,
+		done:     make(chan error, 1),
+		location: "s3://" + s.bucket + "/" + key,
+	}
+	go func() {
+		_, err := s.client.PutObject(ctx, s.bucket, key, r, -1, minio.PutObjectOptions{
+			ContentType: contentType,
+			PartSize:    s.partSize,
+		})
+		// stop the writer if the upload failed
+		r.CloseWithError(err)
+		o.done <- err
+	}()
+	return o, nil
+}
+
+type s3ExportObject struct {
+	w        *io.PipeWriter
+	cancel   context.CancelFunc
+	done     chan error
+	location string
+}
+
+func (o *s3ExportObject) Write(p []byte) (int, error) {
+	return o.w.Write(p)
+}
+
+func (o *s3ExportObject) Commit() (string, error) {
+	defer o.cancel()
+	o.w.Close()
+	if err := <-o.done; err != nil {
+		return "", err
+	}
+	return o.location, nil
+}
+
+func (o *s3ExportObject) Abort() {
+	o.cancel()
+	o.w.CloseWithError(errExportAborted)
+	<-o.done
+}
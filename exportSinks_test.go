@@ -0,0 +1,155 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileExportSink(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dir := t.TempDir()
+	sink := fileExportSink{directory: dir}
+
+	object, err := sink.Create(context.Background(), "job.ndjson", "application/x-ndjson")
+	require.Nil(err)
+	_, err = object.Write([]byte("line\n"))
+	require.Nil(err)
+	_, err = os.Stat(filepath.Join(dir, "job.ndjson"))
+	assert.True(os.IsNotExist(err))
+
+	location, err := object.Commit()
+	require.Nil(err)
+	assert.Equal(filepath.Join(dir, "job.ndjson"), location)
+	data, err := os.ReadFile(location)
+	require.Nil(err)
+	assert.Equal("line\n", string(data))
+
+	object, err = sink.Create(context.Background(), "aborted.ndjson", "application/x-ndjson")
+	require.Nil(err)
+	_, err = object.Write([]byte("partial"))
+	require.Nil(err)
+	object.Abort()
+
+	entries, err := os.ReadDir(dir)
+	require.Nil(err)
+	require.Len(entries, 1)
+	assert.Equal("job.ndjson", entries[0].Name())
+}
+
+// fakeS3 serves the multipart upload calls of an S3 compatible object store.
+type fakeS3 struct {
+	lock      sync.Mutex
+	parts     map[string][]byte
+	completed []string
+	aborted   []string
+	fail      bool
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	query := r.URL.Query()
+	switch {
+	case s.fail:
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>exports</Bucket><Key>%s</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>`, r.URL.Path)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		body, _ := io.ReadAll(r.Body)
+		s.parts[r.URL.Path] = append(s.parts[r.URL.Path], body...)
+		w.Header().Set("ETag", `"part"`)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.completed = append(s.completed, r.URL.Path)
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>exports</Bucket><Key>%s</Key><ETag>"object"</ETag></CompleteMultipartUploadResult>`, r.URL.Path)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.aborted = append(s.aborted, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func newFakeS3Sink(t *testing.T, s *fakeS3) *s3ExportSink {
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	config := ExportsConfig{Sink: exportSinkS3, S3: ExportS3Config{
+		Endpoint:        server.URL,
+		Bucket:          "exports",
+		Prefix:          "gungnir",
+		AccessKeyID:     "id",
+		SecretAccessKey: "secret",
+	}}
+	require.Empty(t, validateExportsConfig(&config))
+	sink, err := newS3ExportSink(config.S3)
+	require.Nil(t, err)
+	return sink
+}
+
+func TestS3ExportSink(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := &fakeS3{parts: map[string][]byte{}}
+	sink := newFakeS3Sink(t, s)
+
+	object, err := sink.Create(context.Background(), "job.ndjson", "application/x-ndjson")
+	require.Nil(err)
+	_, err = object.Write([]byte("first line\n"))
+	require.Nil(err)
+	_, err = object.Write([]byte("second line\n"))
+	require.Nil(err)
+	location, err := object.Commit()
+	require.Nil(err)
+	assert.Equal("s3://exports/gungnir/job.ndjson", location)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	assert.Equal([]string{"/exports/gungnir/job.ndjson"}, s.completed)
+	assert.Contains(string(s.parts["/exports/gungnir/job.ndjson"]), "first line\nsecond line\n")
+	assert.Empty(s.aborted)
+}
+
+func TestS3ExportSinkAbort(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := &fakeS3{parts: map[string][]byte{}}
+	sink := newFakeS3Sink(t, s)
+
+	object, err := sink.Create(context.Background(), "job.ndjson", "application/x-ndjson")
+	require.Nil(err)
+	_, err = object.Write([]byte("partial"))
+	require.Nil(err)
+	object.Abort()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	assert.Empty(s.completed)
+	assert.Empty(s.parts)
+}
+
+func TestS3ExportSinkFailure(t *testing.T) {
+	require := require.New(t)
+	sink := newFakeS3Sink(t, &fakeS3{parts: map[string][]byte{}, fail: true})
+
+	object, err := sink.Create(context.Background(), "job.ndjson", "application/x-ndjson")
+	require.Nil(err)
+	_, err = object.Write([]byte("line\n"))
+	if err == nil {
+		_, err = object.Commit()
+	}
+	require.ErrorContains(err, "Access Denied")
+}
@@ -0,0 +1,802 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/xitongsys/parquet-go/writer"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/gungnir/model"
+	"github.com/xmidt-org/webpa-common/v2/logging" //nolint: staticcheck
+)
+
+// exportIDRouteVar matches the id of an export job.
+const exportIDRouteVar = "{exportID}"
+
+// The sinks exports can be written to.
+const (
+	exportSinkFile = "file"
+	exportSinkS3   = "s3"
+)
+
+// The formats exports can be written in.
+const (
+	exportFormatNDJSON  = "ndjson"
+	exportFormatParquet = "parquet"
+)
+
+// The states of an export job.  Succeeded, failed and canceled are final.
+const (
+	exportQueued    = "queued"
+	exportRunning   = "running"
+	exportSucceeded = "succeeded"
+	exportFailed    = "failed"
+	exportCanceled  = "canceled"
+)
+
+const (
+	defaultExportMaxDevices   = 10000
+	defaultExportRecordLimit  = 10000
+	defaultExportWorkers      = 2
+	defaultExportMaxJobs      = 100
+	defaultExportRowGroupSize = 10000
+	defaultExportS3Region     = "us-east-1"
+	defaultExportS3PartSizeMB = 16
+
+	// minExportS3PartSizeMB is the smallest part S3 accepts.
+	minExportS3PartSizeMB = 5
+
+	// maxExportRequestSize bounds the body of an export request.
+	maxExportRequestSize = 4 * 1024 * 1024
+)
+
+// ExportFileConfig configures the local directory sink.
+type ExportFileConfig struct {
+	// Directory is where export files are written.  It must exist.
+	Directory string
+}
+
+// ExportS3Config configures the S3 compatible object store sink.
+type ExportS3Config struct {
+	// Endpoint is the http or https URL of the object store, such as
+	// https://s3.us-east-1.amazonaws.com.
+	Endpoint string
+
+	// Bucket is where export files are uploaded, under Prefix.
+	Bucket string
+	Prefix string
+
+	// Region defaults to us-east-1.
+	Region string
+
+	// AccessKeyID and SecretAccessKey are the credentials.  If they are
+	// empty, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are used.
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// PartSizeMB is the size of each part of the multipart upload, which
+	// bounds the memory each running job uses.  An upload can have at most
+	// 10000 parts.  Defaults to 16, and must be at least 5.
+	PartSizeMB int
+}
+
+// ExportsConfig configures export jobs, which write the events of a list of
+// devices to files in the background.  Jobs are kept in memory, so they
+// don't survive a restart and each instance has its own.
+type ExportsConfig struct {
+	// Sink is file or s3.  Exports are off if it is empty.
+	Sink string
+
+	File ExportFileConfig
+	S3   ExportS3Config
+
+	// MaxDevices is the most devices one job may export.  Defaults to 10000.
+	MaxDevices int
+
+	// RecordLimit is the most records read for each device.  Defaults to
+	// 10000.
+	RecordLimit int
+
+	// Workers is how many jobs run at once.  Defaults to 2.
+	Workers int
+
+	// MaxJobs is how many jobs are kept, including finished ones.  The
+	// oldest finished job is forgotten to make room for a new one.  Defaults
+	// to 100.
+	MaxJobs int
+
+	// RowGroupSize is how many events are in each row group of a parquet
+	// file.  Defaults to 10000.
+	RowGroupSize int
+}
+
+// validateExportsConfig fills in the defaults for an exports configuration
+// and returns every problem with it.
+func validateExportsConfig(config *ExportsConfig) []error {
+	if config.Sink == "" {
+		return nil
+	}
+
+	var errs []error
+	for _, v := range []struct {
+		name     string
+		value    *int
+		fallback int
+	}{
+		{"maxDevices", &config.MaxDevices, defaultExportMaxDevices},
+		{"recordLimit", &config.RecordLimit, defaultExportRecordLimit},
+		{"workers", &config.Workers, defaultExportWorkers},
+		{"maxJobs", &config.MaxJobs, defaultExportMaxJobs},
+		{"rowGroupSize", &config.RowGroupSize, defaultExportRowGroupSize},
+	} {
+		if *v.value == 0 {
+			*v.value = v.fallback
+		}
+		if *v.value < 0 {
+			errs = append(errs, fmt.Errorf("exports.%s must be positive, got %d", v.name, *v.value))
+		}
+	}
+
+	switch config.Sink {
+	case exportSinkFile:
+		if config.File.Directory == "" {
+			errs = append(errs, errors.New("exports.file.directory is required for the file sink"))
+		} else if info, err := os.Stat(config.File.Directory); err != nil {
+			errs = append(errs, fmt.Errorf("exports.file.directory: %w", err))
+		} else if !info.IsDir() {
+			errs = append(errs, fmt.Errorf("exports.file.directory %q is not a directory", config.File.Directory))
+		}
+	case exportSinkS3:
+		if config.S3.Region == "" {
+			config.S3.Region = defaultExportS3Region
+		}
+		if config.S3.PartSizeMB == 0 {
+			config.S3.PartSizeMB = defaultExportS3PartSizeMB
+		}
+		if u, err := url.Parse(config.S3.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("exports.s3.endpoint must be an http or https URL, got %q", config.S3.Endpoint))
+		}
+		if config.S3.Bucket == "" {
+			errs = append(errs, errors.New("exports.s3.bucket is required for the s3 sink"))
+		}
+		if config.S3.PartSizeMB < minExportS3PartSizeMB {
+			errs = append(errs, fmt.Errorf("exports.s3.partSizeMB must be at least %d, got %d", minExportS3PartSizeMB, config.S3.PartSizeMB))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("exports.sink must be \"file\", \"s3\" or empty, got %q", config.Sink))
+	}
+	return errs
+}
+
+// ExportJob asks for the events of a list of devices to be written to a
+// file, and reports how that is going.
+type ExportJob struct {
+	// ID is assigned when the job is created.
+	ID string `json:"id"`
+
+	// Owner is the principal that created the job.  Only the owner and
+	// admins may see or cancel it.
+	Owner string `json:"owner"`
+
+	// State is queued, running, succeeded, failed or canceled.
+	State string `json:"state"`
+
+	// Format is ndjson, the default, or parquet.
+	Format string `json:"format"`
+
+	// DeviceIDs are the devices exported.
+	DeviceIDs []string `json:"device_ids"`
+
+	// PartnerIDs limits the events exported to those of these partners.  It
+	// defaults to the partners of the caller, and may not include any others.
+	PartnerIDs []string `json:"partner_ids"`
+
+	// Types limits the events exported to these wrp message types, such as
+	// SimpleEvent.
+	Types []string `json:"types,omitempty"`
+
+	// From and To limit the events exported to those born at or after From
+	// and before To.
+	From *time.Time `json:"from,omitempty"`
+	To   *time.Time `json:"to,omitempty"`
+
+	Progress ExportProgress `json:"progress"`
+
+	// Location is where the file is, once the job has succeeded.
+	Location string `json:"location,omitempty"`
+
+	// Error is why the job failed.
+	Error string `json:"error,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ExportProgress counts what a job has done so far.
+type ExportProgress struct {
+	DevicesTotal int `json:"devices_total"`
+	DevicesDone  int `json:"devices_done"`
+
+	// Events is how many events have been written.
+	Events int64 `json:"events"`
+
+	// Failed is how many records couldn't be decrypted or decoded, and were
+	// left out.
+	Failed int64 `json:"failed"`
+}
+
+// exportJob is a job along with what is needed to run it.  The ExportJob is
+// guarded by the Exports lock.
+type exportJob struct {
+	ExportJob
+	ownerType    string
+	capabilities []string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// matches reports whether the event passes the job's type and time filters.
+func (j *exportJob) matches(e model.Event) bool {
+	if len(j.Types) > 0 && !contains(j.Types, e.Type.FriendlyName()) {
+		return false
+	}
+	if j.From != nil && e.BirthDate < j.From.UnixNano() {
+		return false
+	}
+	if j.To != nil && e.BirthDate >= j.To.UnixNano() {
+		return false
+	}
+	return true
+}
+
+// Exports keeps the export jobs and runs them in the background.
+type Exports struct {
+	config   ExportsConfig
+	sink     ExportSink
+	logger   log.Logger
+	measures *Measures
+	auditor  *Auditor
+
+	lock sync.Mutex
+	jobs map[string]*exportJob
+
+	// queued are the jobs waiting for a worker, oldest first.  Jobs canceled
+	// while queued are taken out.  Both are guarded by lock.
+	queued []*exportJob
+
+	// ready wakes a worker once a job is queued.  It may hold more wakeups
+	// than there are jobs queued, but never fewer.
+	ready   chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	started atomic.Bool
+}
+
+// NewExports creates the export jobs for the configuration.  If exports are
+// off, it returns nil.  No job runs until Start is called.  Every device
+// exported is audited by auditor.
+func NewExports(config ExportsConfig, logger log.Logger, measures *Measures, auditor *Auditor) (*Exports, error) {
+	if err := errors.Join(validateExportsConfig(&config)...); err != nil {
+		return nil, err
+	}
+	if config.Sink == "" {
+		return nil, nil
+	}
+	sink, err := newExportSink(config)
+	if err != nil {
+		return nil, err
+	}
+	return newExports(config, sink, logger, measures, auditor), nil
+}
+
+func newExports(config ExportsConfig, sink ExportSink, logger log.Logger, measures *Measures, auditor *Auditor) *Exports {
+	e := &Exports{
+		config:   config,
+		sink:     sink,
+		logger:   logger,
+		measures: measures,
+		auditor:  auditor,
+		jobs:     map[string]*exportJob{},
+		// there are never more jobs queued than kept, so there is room for
+		// a wakeup for each of them
+		ready: make(chan struct{}, config.MaxJobs),
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	return e
+}
+
+// Start runs the queued jobs with whichever App is current when each one
+// starts.
+func (e *Exports) Start(apps *ReloadableApp) {
+	if e == nil || e.started.Swap(true) {
+		return
+	}
+	for i := 0; i < e.config.Workers; i++ {
+		e.wg.Add(1)
+		go e.work(apps)
+	}
+}
+
+// Stop cancels the running jobs and waits for them to stop.
+func (e *Exports) Stop() {
+	if e == nil {
+		return
+	}
+	e.cancel()
+	e.wg.Wait()
+}
+
+func (e *Exports) work(apps *ReloadableApp) {
+	defer e.wg.Done()
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-e.ready:
+			if j := e.next(); j != nil {
+				e.run(apps.current.Load(), j)
+			}
+		}
+	}
+}
+
+// next takes the oldest queued job, if there is one.
+func (e *Exports) next() *exportJob {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.queued) == 0 {
+		return nil
+	}
+	j := e.queued[0]
+	e.queued = e.queued[1:]
+	return j
+}
+
+// dequeue takes a canceled job out of the queue.  The lock must be held.
+func (e *Exports) dequeue(j *exportJob) {
+	for i, other := range e.queued {
+		if other == j {
+			e.queued = append(e.queued[:i], e.queued[i+1:]...)
+			return
+		}
+	}
+}
+
+// add keeps and queues the job, forgetting the oldest finished job if there
+// are too many.
+func (e *Exports) add(j *exportJob) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.jobs) >= e.config.MaxJobs {
+		var oldest *exportJob
+		for _, other := range e.jobs {
+			if other.FinishedAt != nil && (oldest == nil || other.FinishedAt.Before(*oldest.FinishedAt)) {
+				oldest = other
+			}
+		}
+		if oldest == nil {
+			return serverErr{errors.New("too many export jobs"), http.StatusConflict}
+		}
+		delete(e.jobs, oldest.ID)
+	}
+	j.ctx, j.cancel = context.WithCancel(e.ctx)
+	e.jobs[j.ID] = j
+	e.queued = append(e.queued, j)
+	select {
+	case e.ready <- struct{}{}:
+	default:
+		// every queued job already has a wakeup waiting
+	}
+	return nil
+}
+
+// run exports the job, unless it was canceled while it was queued.
+func (e *Exports) run(app *App, j *exportJob) {
+	e.lock.Lock()
+	if j.State != exportQueued {
+		e.lock.Unlock()
+		return
+	}
+	now := time.Now().UTC()
+	j.State = exportRunning
+	j.StartedAt = &now
+	e.lock.Unlock()
+
+	location, err := e.export(app, j)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	now = time.Now().UTC()
+	j.FinishedAt = &now
+	switch {
+	case err == nil:
+		j.State = exportSucceeded
+		j.Location = location
+		logging.Info(e.logger).Log(logging.MessageKey(), "export job succeeded", "job", j.ID, "owner", j.Owner,
+			"location", location, "events", j.Progress.Events)
+	case j.ctx.Err() != nil:
+		j.State = exportCanceled
+		logging.Info(e.logger).Log(logging.MessageKey(), "export job canceled", "job", j.ID, "owner", j.Owner)
+	default:
+		j.State = exportFailed
+		j.Error = err.Error()
+		logging.Error(e.logger).Log(logging.MessageKey(), "export job failed", "job", j.ID, "owner", j.Owner,
+			logging.ErrorKey(), err.Error())
+	}
+	e.measures.ExportJobs.With(outcomeLabel, j.State).Add(1.0)
+	j.cancel()
+}
+
+// export writes the events of each of the job's devices, oldest first, and
+// returns where the file is.  The devices are written in the order they were
+// asked for.
+func (e *Exports) export(app *App, j *exportJob) (string, error) {
+	name, contentType := j.ID+".ndjson", "application/x-ndjson"
+	if j.Format == exportFormatParquet {
+		name, contentType = j.ID+".parquet", "application/vnd.apache.parquet"
+	}
+	object, err := e.sink.Create(j.ctx, name, contentType)
+	if err != nil {
+		return "", err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			object.Abort()
+		}
+	}()
+
+	w, err := newExportWriter(j.Format, object, e.config.RowGroupSize)
+	if err != nil {
+		return "", err
+	}
+	redaction := app.redactor.forCaller(j.PartnerIDs, j.capabilities)
+	for _, deviceID := range j.DeviceIDs {
+		if err := j.ctx.Err(); err != nil {
+			return "", err
+		}
+		start := time.Now()
+		records, err := app.eventGetter.GetRecords(deviceID, e.config.RecordLimit, "")
+		if err != nil {
+			return "", fmt.Errorf("failed to get events for %s: %w", deviceID, err)
+		}
+		events := filterPartners(app.parseRecords(records, false), j.PartnerIDs)
+		failed := countFailed(events)
+		var matched []model.Event
+		for _, event := range omitFailed(events) {
+			if j.matches(event) {
+				matched = append(matched, event)
+			}
+		}
+		sortEvents(matched, false)
+		for _, event := range redaction.events(matched) {
+			if err := w.Write(deviceID, event); err != nil {
+				return "", err
+			}
+		}
+		e.audit(j, deviceID, start, len(matched))
+
+		e.lock.Lock()
+		j.Progress.DevicesDone++
+		j.Progress.Events += int64(len(matched))
+		j.Progress.Failed += int64(failed)
+		e.lock.Unlock()
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	committed = true
+	return object.Commit()
+}
+
+// audit records a device's events being exported as a request for them by
+// the job's owner.
+func (e *Exports) audit(j *exportJob, deviceID string, start time.Time, results int) {
+	e.auditor.Record(AuditRecord{
+		Time:           start.UTC(),
+		Principal:      j.Owner,
+		AuthType:       j.ownerType,
+		PartnerIDs:     j.PartnerIDs,
+		DeviceID:       deviceID,
+		Endpoint:       exportsEndpoint,
+		Method:         http.MethodPost,
+		ResultCount:    results,
+		StatusCode:     http.StatusOK,
+		LatencySeconds: time.Since(start).Seconds(),
+	})
+}
+
+// exportWriter writes events in one of the export formats.
+type exportWriter interface {
+	Write(deviceID string, event model.Event) error
+	Close() error
+}
+
+func newExportWriter(format string, w io.Writer, rowGroupSize int) (exportWriter, error) {
+	if format == exportFormatParquet {
+		p, err := writer.NewParquetWriterFromWriter(w, new(exportRow), 1)
+		if err != nil {
+			return nil, err
+		}
+		return &parquetExportWriter{p: p, rowGroupSize: rowGroupSize}, nil
+	}
+	return ndjsonExportWriter{bufio.NewWriter(w)}, nil
+}
+
+// ndjsonExportWriter writes a line for each event with the device id and the
+// event as the events endpoint returns it.
+type ndjsonExportWriter struct {
+	w *bufio.Writer
+}
+
+type ndjsonExportLine struct {
+	DeviceID string          `json:"device_id"`
+	Event    json.RawMessage `json:"event"`
+}
+
+func (n ndjsonExportWriter) Write(deviceID string, event model.Event) error {
+	data, err := encodeEvents(event)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(ndjsonExportLine{DeviceID: deviceID, Event: data})
+	if err != nil {
+		return err
+	}
+	n.w.Write(line)
+	return n.w.WriteByte('\n')
+}
+
+func (n ndjsonExportWriter) Close() error {
+	return n.w.Flush()
+}
+
+// exportRow is a row of a parquet export.  The dates are unix nanoseconds,
+// and the partner ids and metadata are JSON.
+type exportRow struct {
+	DeviceID        string `parquet:"name=device_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	BirthDate       int64  `parquet:"name=birth_date, type=INT64"`
+	DeathDate       int64  `parquet:"name=death_date, type=INT64"`
+	MsgType         string `parquet:"name=msg_type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Source          string `parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Destination     string `parquet:"name=destination, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TransactionUUID string `parquet:"name=transaction_uuid, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ContentType     string `parquet:"name=content_type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SessionID       string `parquet:"name=session_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PartnerIDs      string `parquet:"name=partner_ids, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Metadata        string `parquet:"name=metadata, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Payload         string `parquet:"name=payload, type=BYTE_ARRAY"`
+}
+
+// parquetExportWriter writes a row group every rowGroupSize events.
+type parquetExportWriter struct {
+	p            *writer.ParquetWriter
+	rowGroupSize int
+	rows         int
+}
+
+func (p *parquetExportWriter) Write(deviceID string, event model.Event) error {
+	partnerIDs, err := json.Marshal(event.PartnerIDs)
+	if err != nil {
+		return err
+	}
+	metadata, err := json.Marshal(event.Metadata)
+	if err != nil {
+		return err
+	}
+	err = p.p.Write(exportRow{
+		DeviceID:        deviceID,
+		BirthDate:       event.BirthDate,
+		DeathDate:       event.DeathDate,
+		MsgType:         event.Type.FriendlyName(),
+		Source:          event.Source,
+		Destination:     event.Destination,
+		TransactionUUID: event.TransactionUUID,
+		ContentType:     event.ContentType,
+		SessionID:       event.SessionID,
+		PartnerIDs:      string(partnerIDs),
+		Metadata:        string(metadata),
+		Payload:         string(event.Payload),
+	})
+	if err != nil {
+		return err
+	}
+	p.rows++
+	if p.rows%p.rowGroupSize == 0 {
+		return p.p.Flush(true)
+	}
+	return nil
+}
+
+func (p *parquetExportWriter) Close() error {
+	return p.p.WriteStop()
+}
+
+// newExportJob checks the job asked for and fills in what the request
+// doesn't say.
+func (app *App) newExportJob(request *http.Request, job ExportJob) (*exportJob, error) {
+	principal, authType, ok := requestCaller(request)
+	if !ok {
+		return nil, serverErr{errors.New("unable to identify the caller"), http.StatusForbidden}
+	}
+	callerPartnerIDs, err := extractPartnerIDs(request, app.basicAuthPartnerIDHeaderKey)
+	if err != nil || len(callerPartnerIDs) == 0 {
+		return nil, serverErr{errGettingPartnerIDs, http.StatusBadRequest}
+	}
+
+	switch job.Format {
+	case "":
+		job.Format = exportFormatNDJSON
+	case exportFormatNDJSON, exportFormatParquet:
+	default:
+		return nil, serverErr{fmt.Errorf("format must be ndjson or parquet, got %q", job.Format), http.StatusBadRequest}
+	}
+
+	if len(job.DeviceIDs) == 0 {
+		return nil, serverErr{errors.New("device_ids is required"), http.StatusBadRequest}
+	}
+	if len(job.DeviceIDs) > app.exports.config.MaxDevices {
+		return nil, serverErr{fmt.Errorf("at most %d device_ids may be exported, got %d", app.exports.config.MaxDevices, len(job.DeviceIDs)), http.StatusBadRequest}
+	}
+	deviceIDs := make([]string, 0, len(job.DeviceIDs))
+	seen := make(map[string]bool, len(job.DeviceIDs))
+	for _, raw := range job.DeviceIDs {
+		id, err := parseDeviceID(raw, app.deviceIDs.StripServiceSuffix)
+		if err != nil {
+			return nil, serverErr{err, http.StatusBadRequest}
+		}
+		if !seen[id] {
+			seen[id] = true
+			deviceIDs = append(deviceIDs, id)
+		}
+	}
+
+	if len(job.PartnerIDs) == 0 {
+		job.PartnerIDs = callerPartnerIDs
+	} else if !contains(callerPartnerIDs, "*") {
+		for _, p := range job.PartnerIDs {
+			if !contains(callerPartnerIDs, p) {
+				return nil, serverErr{fmt.Errorf("not allowed to export partner %q", p), http.StatusForbidden}
+			}
+		}
+	}
+
+	types, err := parseMessageTypes(job.Types)
+	if err != nil {
+		return nil, serverErr{err, http.StatusBadRequest}
+	}
+	if job.From != nil && job.To != nil && !job.From.Before(*job.To) {
+		return nil, serverErr{errors.New("from must be before to"), http.StatusBadRequest}
+	}
+
+	j := &exportJob{
+		ExportJob: ExportJob{
+			ID:         uuid.NewString(),
+			Owner:      principal,
+			State:      exportQueued,
+			Format:     job.Format,
+			DeviceIDs:  deviceIDs,
+			PartnerIDs: job.PartnerIDs,
+			Types:      types,
+			From:       job.From,
+			To:         job.To,
+			Progress:   ExportProgress{DevicesTotal: len(deviceIDs)},
+			CreatedAt:  time.Now().UTC(),
+		},
+		ownerType: authType,
+	}
+	if auth, ok := bascule.FromContext(request.Context()); ok && authType == "jwt" {
+		j.capabilities = tokenCapabilities(auth.Token)
+	}
+	return j, nil
+}
+
+// exportJob returns the job named in the request path, if the caller owns it
+// or is an admin.  Other callers are told it doesn't exist.
+func (app *App) exportJob(request *http.Request) (*exportJob, error) {
+	id := mux.Vars(request)["exportID"]
+	app.exports.lock.Lock()
+	j, ok := app.exports.jobs[id]
+	app.exports.lock.Unlock()
+	if !ok || !app.ownsExport(request, j) {
+		return nil, serverErr{fmt.Errorf("no export job %q", id), http.StatusNotFound}
+	}
+	return j, nil
+}
+
+// ownsExport reports whether the caller may see and cancel the job.
+func (app *App) ownsExport(request *http.Request, j *exportJob) bool {
+	if app.admin.isAdmin(request) {
+		return true
+	}
+	principal, authType, ok := requestCaller(request)
+	return ok && principal == j.Owner && authType == j.ownerType
+}
+
+// handleCreateExport queues an export of devices' events.
+func (app *App) handleCreateExport(writer http.ResponseWriter, request *http.Request) {
+	var job ExportJob
+	if err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxExportRequestSize)).Decode(&job); err != nil {
+		writeError(writer, serverErr{fmt.Errorf("invalid export job: %w", err), http.StatusBadRequest})
+		return
+	}
+	j, err := app.newExportJob(request, job)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+	if err := app.exports.add(j); err != nil {
+		writeError(writer, err)
+		return
+	}
+
+	logging.Info(app.logger).Log(logging.MessageKey(), "created export job", "job", j.ID, "owner", j.Owner,
+		"format", j.Format, "devices", len(j.DeviceIDs))
+	app.exports.lock.Lock()
+	created := j.ExportJob
+	app.exports.lock.Unlock()
+	writeJSON(writer, http.StatusAccepted, created)
+}
+
+// handleGetExport responds with one job and its progress.
+func (app *App) handleGetExport(writer http.ResponseWriter, request *http.Request) {
+	j, err := app.exportJob(request)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+	app.exports.lock.Lock()
+	job := j.ExportJob
+	app.exports.lock.Unlock()
+	writeJSON(writer, http.StatusOK, job)
+}
+
+// handleCancelExport cancels a job that hasn't finished.  A running job stops
+// after the device it is exporting, and nothing is left in the sink.
+func (app *App) handleCancelExport(writer http.ResponseWriter, request *http.Request) {
+	j, err := app.exportJob(request)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+	app.exports.lock.Lock()
+	switch j.State {
+	case exportQueued:
+		now := time.Now().UTC()
+		j.State = exportCanceled
+		j.FinishedAt = &now
+		app.exports.measures.ExportJobs.With(outcomeLabel, exportCanceled).Add(1.0)
+		app.exports.dequeue(j)
+		j.cancel()
+	case exportRunning:
+		j.cancel()
+	default:
+		app.exports.lock.Unlock()
+		writeError(writer, serverErr{fmt.Errorf("export job %q has already %s", j.ID, j.State), http.StatusConflict})
+		return
+	}
+	job := j.ExportJob
+	app.exports.lock.Unlock()
+
+	logging.Info(app.logger).Log(logging.MessageKey(), "canceled export job", "job", j.ID, "owner", j.Owner)
+	writeJSON(writer, http.StatusAccepted, job)
+}
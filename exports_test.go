@@ -0,0 +1,598 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xmidt-org/bascule"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/gungnir/model"
+	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestValidateExportsConfig(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.Nil(t, os.WriteFile(file, nil, 0600))
+
+	config := ExportsConfig{}
+	assert.Empty(validateExportsConfig(&config))
+	assert.Equal(ExportsConfig{}, config)
+
+	config = ExportsConfig{Sink: exportSinkFile, File: ExportFileConfig{Directory: dir}}
+	assert.Empty(validateExportsConfig(&config))
+	assert.Equal(ExportsConfig{
+		Sink:         exportSinkFile,
+		File:         ExportFileConfig{Directory: dir},
+		MaxDevices:   defaultExportMaxDevices,
+		RecordLimit:  defaultExportRecordLimit,
+		Workers:      defaultExportWorkers,
+		MaxJobs:      defaultExportMaxJobs,
+		RowGroupSize: defaultExportRowGroupSize,
+	}, config)
+
+	config = ExportsConfig{Sink: exportSinkS3, S3: ExportS3Config{Endpoint: "https://s3.example.com", Bucket: "exports"}}
+	assert.Empty(validateExportsConfig(&config))
+	assert.Equal(defaultExportS3Region, config.S3.Region)
+	assert.Equal(defaultExportS3PartSizeMB, config.S3.PartSizeMB)
+
+	tests := []struct {
+		description  string
+		config       ExportsConfig
+		expectedErrs []string
+	}{
+		{
+			description:  "No Directory",
+			config:       ExportsConfig{Sink: exportSinkFile},
+			expectedErrs: []string{"exports.file.directory is required"},
+		},
+		{
+			description:  "Missing Directory",
+			config:       ExportsConfig{Sink: exportSinkFile, File: ExportFileConfig{Directory: filepath.Join(dir, "missing")}},
+			expectedErrs: []string{"exports.file.directory: stat"},
+		},
+		{
+			description:  "Not A Directory",
+			config:       ExportsConfig{Sink: exportSinkFile, File: ExportFileConfig{Directory: file}},
+			expectedErrs: []string{"is not a directory"},
+		},
+		{
+			description: "Bad S3",
+			config:      ExportsConfig{Sink: exportSinkS3, S3: ExportS3Config{Endpoint: "s3.example.com", PartSizeMB: 4}},
+			expectedErrs: []string{
+				"exports.s3.endpoint must be an http or https URL",
+				"exports.s3.bucket is required",
+				"exports.s3.partSizeMB must be at least 5, got 4",
+			},
+		},
+		{
+			description:  "Negative",
+			config:       ExportsConfig{Sink: exportSinkFile, File: ExportFileConfig{Directory: dir}, Workers: -1, MaxJobs: -1},
+			expectedErrs: []string{"exports.workers must be positive", "exports.maxJobs must be positive"},
+		},
+		{
+			description:  "Unknown Sink",
+			config:       ExportsConfig{Sink: "ftp"},
+			expectedErrs: []string{`exports.sink must be "file", "s3" or empty, got "ftp"`},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			errs := validateExportsConfig(&tc.config)
+			require.Len(t, errs, len(tc.expectedErrs))
+			for i, expected := range tc.expectedErrs {
+				assert.Contains(errs[i].Error(), expected)
+			}
+		})
+	}
+}
+
+// testExports returns the app's exports, written to a temporary directory.
+func testExports(t *testing.T, app *App, config ExportsConfig) *Exports {
+	config.Sink = exportSinkFile
+	config.File.Directory = t.TempDir()
+	require.Empty(t, validateExportsConfig(&config))
+	return newExports(config, fileExportSink{directory: config.File.Directory}, app.logger, app.measures, nil)
+}
+
+// createTestExport creates a job with the body and returns it.
+func createTestExport(t *testing.T, app *App, auth bascule.Authentication, body string) *exportJob {
+	rr := httptest.NewRecorder()
+	app.handleCreateExport(rr, testRequest(t, auth, http.MethodPost, "/exports", body, nil))
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Header().Get("X-Codex-Error"))
+	var job ExportJob
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &job))
+	return app.exports.jobs[job.ID]
+}
+
+func TestHandleCreateExport(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		description        string
+		body               string
+		full               bool
+		expectedStatusCode int
+		expected           ExportJob
+	}{
+		{
+			description:        "Success",
+			body:               `{"device_ids": ["MAC:112233445566", "mac:112233445566", "mac:112233445567"], "types": ["event"]}`,
+			expectedStatusCode: http.StatusAccepted,
+			expected: ExportJob{
+				Owner:      "owner",
+				State:      exportQueued,
+				Format:     exportFormatNDJSON,
+				DeviceIDs:  []string{"mac:112233445566", "mac:112233445567"},
+				PartnerIDs: []string{"test1"},
+				Types:      []string{"SimpleEvent"},
+				Progress:   ExportProgress{DevicesTotal: 2},
+			},
+		},
+		{
+			description:        "Parquet Window",
+			body:               `{"device_ids": ["mac:112233445566"], "partner_ids": ["test1"], "format": "parquet", "from": "2025-01-01T00:00:00Z", "to": "2025-02-01T00:00:00Z"}`,
+			expectedStatusCode: http.StatusAccepted,
+			expected: ExportJob{
+				Owner:      "owner",
+				State:      exportQueued,
+				Format:     exportFormatParquet,
+				DeviceIDs:  []string{"mac:112233445566"},
+				PartnerIDs: []string{"test1"},
+				From:       &from,
+				To:         &to,
+				Progress:   ExportProgress{DevicesTotal: 1},
+			},
+		},
+		{
+			description:        "Invalid JSON",
+			body:               `{"device_ids":`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Invalid Format",
+			body:               `{"device_ids": ["mac:112233445566"], "format": "csv"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "No Devices",
+			body:               `{}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Too Many Devices",
+			body:               `{"device_ids": ["mac:112233445566", "mac:112233445567", "mac:112233445568", "mac:112233445569"]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Invalid Device",
+			body:               `{"device_ids": ["mac:1122"]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Other Partner",
+			body:               `{"device_ids": ["mac:112233445566"], "partner_ids": ["test2"]}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			description:        "Invalid Type",
+			body:               `{"device_ids": ["mac:112233445566"], "types": ["Loud"]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Backwards Window",
+			body:               `{"device_ids": ["mac:112233445566"], "from": "2025-02-01T00:00:00Z", "to": "2025-01-01T00:00:00Z"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Too Many Jobs",
+			body:               `{"device_ids": ["mac:112233445566"]}`,
+			full:               true,
+			expectedStatusCode: http.StatusConflict,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+			app.exports = testExports(t, app, ExportsConfig{MaxDevices: 3, MaxJobs: 1})
+			if tc.full {
+				app.exports.jobs["running"] = &exportJob{ExportJob: ExportJob{ID: "running", State: exportRunning}}
+			}
+
+			rr := httptest.NewRecorder()
+			app.handleCreateExport(rr, testRequest(t, testAuth("owner", "test1"), http.MethodPost, "/exports", tc.body, nil))
+			require.Equal(tc.expectedStatusCode, rr.Code)
+			if tc.expectedStatusCode != http.StatusAccepted {
+				assert.NotEmpty(rr.Header().Get("X-Codex-Error"))
+				return
+			}
+
+			var job ExportJob
+			require.Nil(json.Unmarshal(rr.Body.Bytes(), &job))
+			assert.NotEmpty(job.ID)
+			assert.False(job.CreatedAt.IsZero())
+			tc.expected.ID = job.ID
+			tc.expected.CreatedAt = job.CreatedAt
+			assert.Equal(tc.expected, job)
+			assert.Len(app.exports.queued, 1)
+		})
+	}
+}
+
+func TestExportsForgetFinishedJobs(t *testing.T) {
+	assert := assert.New(t)
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.exports = testExports(t, app, ExportsConfig{MaxJobs: 2})
+	earlier, later := time.Now().Add(-time.Hour), time.Now()
+	app.exports.jobs["old"] = &exportJob{ExportJob: ExportJob{ID: "old", State: exportSucceeded, FinishedAt: &earlier}}
+	app.exports.jobs["new"] = &exportJob{ExportJob: ExportJob{ID: "new", State: exportFailed, FinishedAt: &later}}
+
+	j := createTestExport(t, app, testAuth("owner", "test1"), `{"device_ids": ["mac:112233445566"]}`)
+	assert.Len(app.exports.jobs, 2)
+	assert.NotContains(app.exports.jobs, "old")
+	assert.Contains(app.exports.jobs, "new")
+	assert.Contains(app.exports.jobs, j.ID)
+}
+
+func TestExportsCancelQueuedJobs(t *testing.T) {
+	assert := assert.New(t)
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.exports = testExports(t, app, ExportsConfig{MaxJobs: 2})
+	owner := testAuth("owner", "test1")
+
+	// without workers, jobs canceled while queued must not fill the queue up
+	// and block the next job from being added
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			j := createTestExport(t, app, owner, `{"device_ids": ["mac:112233445566"]}`)
+			rr := httptest.NewRecorder()
+			app.handleCancelExport(rr, testRequest(t, owner, http.MethodDelete, "/exports/"+j.ID, "", map[string]string{"exportID": j.ID}))
+			assert.Equal(http.StatusAccepted, rr.Code)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("adding a job blocked")
+	}
+	assert.Empty(app.exports.queued)
+	assert.Nil(app.exports.next())
+}
+
+func testExportRecords(t *testing.T) []db.Record {
+	online, offline, other := goodOnlineEvent, goodOfflineEvent, goodOnlineEvent
+	online.Type, offline.Type, other.Type = wrp.SimpleEventMessageType, wrp.SimpleEventMessageType, wrp.SimpleEventMessageType
+	other.PartnerIDs = []string{"other"}
+	failed := testRecord(t, online, 400)
+	failed.KID = "missing"
+	return []db.Record{
+		failed,
+		testRecord(t, online, 300),
+		testRecord(t, other, 250),
+		testRecord(t, offline, 200),
+	}
+}
+
+func TestExportJobNDJSON(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	p := xmetricstest.NewProvider(nil, Metrics)
+	app := testApp(NewMeasures(p))
+	app.exports = testExports(t, app, ExportsConfig{})
+	auditor, sink := testAuditor()
+	app.exports.auditor = auditor
+
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", defaultExportRecordLimit, "").Return(testExportRecords(t), nil)
+	mockGetter.On("GetRecords", "mac:112233445567", defaultExportRecordLimit, "").Return([]db.Record{}, nil)
+	app.eventGetter = mockGetter
+
+	j := createTestExport(t, app, testAuth("owner", "test1"), `{"device_ids": ["mac:112233445566", "mac:112233445567"]}`)
+	app.exports.run(app, j)
+
+	assert.Equal(exportSucceeded, j.State)
+	assert.Empty(j.Error)
+	assert.NotNil(j.StartedAt)
+	assert.NotNil(j.FinishedAt)
+	// the record that couldn't be decrypted has no partner ids, so it isn't
+	// counted for a job limited to test1
+	assert.Equal(ExportProgress{DevicesTotal: 2, DevicesDone: 2, Events: 2}, j.Progress)
+	assert.Equal(filepath.Join(app.exports.config.File.Directory, j.ID+".ndjson"), j.Location)
+	p.Assert(t, ExportJobsCounter, outcomeLabel, exportSucceeded)(xmetricstest.Value(1.0))
+
+	data, err := os.ReadFile(j.Location)
+	require.Nil(err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(lines, 2)
+	var birthDates []int64
+	for _, line := range lines {
+		var l struct {
+			DeviceID string `json:"device_id"`
+			Event    struct {
+				BirthDate   int64  `json:"birth_date"`
+				Destination string `json:"dest"`
+			} `json:"event"`
+		}
+		require.Nil(json.Unmarshal([]byte(line), &l))
+		assert.Equal("mac:112233445566", l.DeviceID)
+		assert.NotEmpty(l.Event.Destination)
+		birthDates = append(birthDates, l.Event.BirthDate)
+	}
+	assert.Equal([]int64{200, 300}, birthDates)
+
+	// each device exported is audited as the owner's request for its events
+	auditor.Stop()
+	audited := sink.records()
+	require.Len(audited, 2)
+	for i, expected := range []struct {
+		deviceID string
+		results  int
+	}{{"mac:112233445566", 2}, {"mac:112233445567", 0}} {
+		assert.Equal("owner", audited[i].Principal)
+		assert.Equal("jwt", audited[i].AuthType)
+		assert.Equal([]string{"test1"}, audited[i].PartnerIDs)
+		assert.Equal(expected.deviceID, audited[i].DeviceID)
+		assert.Equal(exportsEndpoint, audited[i].Endpoint)
+		assert.Equal(expected.results, audited[i].ResultCount)
+	}
+}
+
+func TestExportJobParquet(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.exports = testExports(t, app, ExportsConfig{})
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", defaultExportRecordLimit, "").Return(testExportRecords(t), nil)
+	app.eventGetter = mockGetter
+
+	j := createTestExport(t, app, testAuth("owner", "*"), `{"device_ids": ["mac:112233445566"], "format": "parquet", "from": "1970-01-01T00:00:00.00000025Z"}`)
+	app.exports.run(app, j)
+	require.Equal(exportSucceeded, j.State, j.Error)
+	assert.True(strings.HasSuffix(j.Location, ".parquet"))
+
+	data, err := os.ReadFile(j.Location)
+	require.Nil(err)
+	rows, rowGroups := readParquetRows(t, data)
+	assert.Equal(1, rowGroups)
+	if assert.Len(rows, 2) {
+		assert.Equal(int64(250), rows[0].BirthDate)
+		assert.Equal(int64(300), rows[1].BirthDate)
+	}
+}
+
+// readParquetRows reads the rows of a parquet export, and returns them with
+// how many row groups they're in.
+func readParquetRows(t *testing.T, data []byte) ([]exportRow, int) {
+	require := require.New(t)
+	file, err := buffer.NewBufferFile(data)
+	require.Nil(err)
+	r, err := reader.NewParquetReader(file, new(exportRow), 1)
+	require.Nil(err)
+	defer r.ReadStop()
+
+	rows := make([]exportRow, r.GetNumRows())
+	require.Nil(r.Read(&rows))
+	return rows, len(r.Footer.RowGroups)
+}
+
+func TestParquetExportWriter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	event := model.Event{
+		Message: wrp.Message{
+			Type:            wrp.SimpleEventMessageType,
+			Source:          "dns:talaria",
+			Destination:     "event:device-status/mac:112233445566/online",
+			TransactionUUID: "abc",
+			ContentType:     "json",
+			SessionID:       "session",
+			PartnerIDs:      []string{"test1"},
+			Metadata:        map[string]string{"/boot-time": "1"},
+			Payload:         []byte(`{"id":1}`),
+		},
+		BirthDate: 200,
+		DeathDate: 300,
+	}
+
+	var buf bytes.Buffer
+	w, err := newExportWriter(exportFormatParquet, &buf, 2)
+	require.Nil(err)
+	for i := 0; i < 5; i++ {
+		require.Nil(w.Write("mac:112233445566", event))
+	}
+	require.Nil(w.Close())
+
+	rows, rowGroups := readParquetRows(t, buf.Bytes())
+	assert.Equal(3, rowGroups)
+	require.Len(rows, 5)
+	assert.Equal(exportRow{
+		DeviceID:        "mac:112233445566",
+		BirthDate:       200,
+		DeathDate:       300,
+		MsgType:         event.Type.FriendlyName(),
+		Source:          "dns:talaria",
+		Destination:     "event:device-status/mac:112233445566/online",
+		TransactionUUID: "abc",
+		ContentType:     "json",
+		SessionID:       "session",
+		PartnerIDs:      `["test1"]`,
+		Metadata:        `{"/boot-time":"1"}`,
+		Payload:         `{"id":1}`,
+	}, rows[4])
+}
+
+func TestParquetExportWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newExportWriter(exportFormatParquet, &buf, defaultExportRowGroupSize)
+	require.Nil(t, err)
+	require.Nil(t, w.Close())
+
+	rows, rowGroups := readParquetRows(t, buf.Bytes())
+	assert.Empty(t, rows)
+	assert.Zero(t, rowGroups)
+}
+
+func TestExportJobFailed(t *testing.T) {
+	assert := assert.New(t)
+	p := xmetricstest.NewProvider(nil, Metrics)
+	app := testApp(NewMeasures(p))
+	app.exports = testExports(t, app, ExportsConfig{})
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", defaultExportRecordLimit, "").Return([]db.Record{}, errors.New("database down"))
+	app.eventGetter = mockGetter
+
+	j := createTestExport(t, app, testAuth("owner", "test1"), `{"device_ids": ["mac:112233445566"]}`)
+	app.exports.run(app, j)
+	assert.Equal(exportFailed, j.State)
+	assert.Contains(j.Error, "database down")
+	assert.Empty(j.Location)
+	p.Assert(t, ExportJobsCounter, outcomeLabel, exportFailed)(xmetricstest.Value(1.0))
+
+	entries, err := os.ReadDir(app.exports.config.File.Directory)
+	require.Nil(t, err)
+	assert.Empty(entries)
+}
+
+func TestExportAccessAndCancel(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.exports = testExports(t, app, ExportsConfig{})
+	var (
+		owner = testAuth("owner", "test1")
+		other = testAuth("other", "test1")
+		admin = testAuth("admin", "*")
+	)
+	j := createTestExport(t, app, owner, `{"device_ids": ["mac:112233445566"]}`)
+
+	for _, tc := range []struct {
+		auth     bascule.Authentication
+		expected int
+	}{
+		{owner, http.StatusOK},
+		{other, http.StatusNotFound},
+		{admin, http.StatusOK},
+	} {
+		rr := httptest.NewRecorder()
+		app.handleGetExport(rr, testRequest(t, tc.auth, http.MethodGet, "/exports/"+j.ID, "", map[string]string{"exportID": j.ID}))
+		assert.Equal(tc.expected, rr.Code)
+	}
+
+	rr := httptest.NewRecorder()
+	app.handleCancelExport(rr, testRequest(t, other, http.MethodDelete, "/exports/"+j.ID, "", map[string]string{"exportID": j.ID}))
+	assert.Equal(http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	app.handleCancelExport(rr, testRequest(t, owner, http.MethodDelete, "/exports/"+j.ID, "", map[string]string{"exportID": j.ID}))
+	require.Equal(http.StatusAccepted, rr.Code)
+	var job ExportJob
+	require.Nil(json.Unmarshal(rr.Body.Bytes(), &job))
+	assert.Equal(exportCanceled, job.State)
+	assert.NotNil(job.FinishedAt)
+
+	// a job canceled while queued is taken out of the queue, and never run
+	assert.Empty(app.exports.queued)
+	app.exports.run(app, j)
+	assert.Equal(exportCanceled, j.State)
+	assert.Nil(j.StartedAt)
+
+	rr = httptest.NewRecorder()
+	app.handleCancelExport(rr, testRequest(t, admin, http.MethodDelete, "/exports/"+j.ID, "", map[string]string{"exportID": j.ID}))
+	assert.Equal(http.StatusConflict, rr.Code)
+	assert.Contains(rr.Header().Get("X-Codex-Error"), "has already canceled")
+}
+
+func TestExportCancelRunning(t *testing.T) {
+	assert := assert.New(t)
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.exports = testExports(t, app, ExportsConfig{})
+	owner := testAuth("owner", "test1")
+	j := createTestExport(t, app, owner, `{"device_ids": ["mac:112233445566", "mac:112233445567"]}`)
+
+	// cancel the job while it reads the first device
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", defaultExportRecordLimit, "").Return(testExportRecords(t), nil).Run(func(mock.Arguments) {
+		rr := httptest.NewRecorder()
+		app.handleCancelExport(rr, testRequest(t, owner, http.MethodDelete, "/exports/"+j.ID, "", map[string]string{"exportID": j.ID}))
+		assert.Equal(http.StatusAccepted, rr.Code)
+	})
+	app.eventGetter = mockGetter
+
+	app.exports.run(app, j)
+	assert.Equal(exportCanceled, j.State)
+	assert.Equal(1, j.Progress.DevicesDone)
+	mockGetter.AssertNotCalled(t, "GetRecords", "mac:112233445567", defaultExportRecordLimit, "")
+
+	entries, err := os.ReadDir(app.exports.config.File.Directory)
+	require.Nil(t, err)
+	assert.Empty(entries)
+}
+
+func TestExportsStartStop(t *testing.T) {
+	require := require.New(t)
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.exports = testExports(t, app, ExportsConfig{})
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", defaultExportRecordLimit, "").Return(testExportRecords(t), nil)
+	app.eventGetter = mockGetter
+
+	app.exports.Start(NewReloadableApp(app))
+	j := createTestExport(t, app, testAuth("owner", "test1"), `{"device_ids": ["mac:112233445566"]}`)
+	require.Eventually(func() bool {
+		app.exports.lock.Lock()
+		defer app.exports.lock.Unlock()
+		return j.State == exportSucceeded
+	}, 5*time.Second, 5*time.Millisecond)
+	app.exports.Stop()
+
+	// stopping without starting, or without exports, does nothing
+	testExports(t, app, ExportsConfig{}).Stop()
+	(*Exports)(nil).Start(nil)
+	(*Exports)(nil).Stop()
+}
+
+func TestNewExports(t *testing.T) {
+	assert := assert.New(t)
+	measures := NewMeasures(xmetricstest.NewProvider(nil, Metrics))
+
+	e, err := NewExports(ExportsConfig{}, logging.DefaultLogger(), measures, nil)
+	assert.Nil(e)
+	assert.Nil(err)
+
+	e, err = NewExports(ExportsConfig{Sink: exportSinkFile}, logging.DefaultLogger(), measures, nil)
+	assert.Nil(e)
+	assert.NotNil(err)
+
+	e, err = NewExports(ExportsConfig{Sink: exportSinkFile, File: ExportFileConfig{Directory: t.TempDir()}}, logging.DefaultLogger(), measures, nil)
+	assert.Nil(err)
+	assert.Equal(defaultExportWorkers, e.config.Workers)
+
+	e, err = NewExports(ExportsConfig{Sink: exportSinkS3, S3: ExportS3Config{Endpoint: "https://s3.example.com", Bucket: "exports"}}, logging.DefaultLogger(), measures, nil)
+	assert.Nil(err)
+	assert.IsType(&s3ExportSink{}, e.sink)
+}
@@ -0,0 +1,732 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/goph/emperror"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/gungnir/model"
+	"github.com/xmidt-org/webpa-common/v2/logging" //nolint: staticcheck
+)
+
+const (
+	defaultGraphQLMaxComplexity = 1000
+	defaultGraphQLMaxDepth      = 10
+	defaultGraphQLMaxDevices    = 10
+
+	// maxGraphQLRequestSize bounds the body of a GraphQL request.
+	maxGraphQLRequestSize = 64 * 1024
+)
+
+// GraphQLConfig configures the GraphQL endpoint, which fetches the status,
+// events and status history of devices in one request.
+type GraphQLConfig struct {
+	// Enabled turns on POST /graphql.
+	Enabled bool
+
+	// MaxComplexity bounds the cost of a query, which is roughly how many
+	// values it can return.  Lists multiply the cost of what is selected in
+	// them by how many items they may have.  Defaults to 1000.
+	MaxComplexity int
+
+	// MaxDepth bounds how deeply selections may be nested.  Defaults to 10.
+	MaxDepth int
+
+	// MaxDevices is the most devices one devices field may ask for.
+	// Defaults to 10.
+	MaxDevices int
+}
+
+// validateGraphQLConfig fills in the defaults for a GraphQL configuration and
+// returns every problem with it.
+func validateGraphQLConfig(config *GraphQLConfig) []error {
+	if !config.Enabled {
+		return nil
+	}
+
+	if config.MaxComplexity == 0 {
+		config.MaxComplexity = defaultGraphQLMaxComplexity
+	}
+	if config.MaxDepth == 0 {
+		config.MaxDepth = defaultGraphQLMaxDepth
+	}
+	if config.MaxDevices == 0 {
+		config.MaxDevices = defaultGraphQLMaxDevices
+	}
+
+	var errs []error
+	if config.MaxComplexity < 0 {
+		errs = append(errs, fmt.Errorf("graphql.maxComplexity must be positive, got %d", config.MaxComplexity))
+	}
+	if config.MaxDepth < 0 {
+		errs = append(errs, fmt.Errorf("graphql.maxDepth must be positive, got %d", config.MaxDepth))
+	}
+	if config.MaxDevices < 0 {
+		errs = append(errs, fmt.Errorf("graphql.maxDevices must be positive, got %d", config.MaxDevices))
+	}
+	return errs
+}
+
+// GraphQL holds the schema and limits of the GraphQL endpoint.
+type GraphQL struct {
+	config GraphQLConfig
+	schema graphql.Schema
+}
+
+// NewGraphQL creates the GraphQL schema for the configuration.  If the
+// endpoint is off, it returns nil.
+func NewGraphQL(config GraphQLConfig) (*GraphQL, error) {
+	if err := errors.Join(validateGraphQLConfig(&config)...); err != nil {
+		return nil, err
+	}
+	if !config.Enabled {
+		return nil, nil
+	}
+	schema, err := newGraphQLSchema(config)
+	if err != nil {
+		return nil, emperror.Wrap(err, "failed to create GraphQL schema")
+	}
+	return &GraphQL{config: config, schema: schema}, nil
+}
+
+// graphqlRequest is the body of a GraphQL request.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlQuery is what the resolvers need to know about the request.
+type graphqlQuery struct {
+	app     *App
+	request *http.Request
+}
+
+type graphqlQueryKey struct{}
+
+func graphqlQueryFrom(ctx context.Context) *graphqlQuery {
+	q, _ := ctx.Value(graphqlQueryKey{}).(*graphqlQuery)
+	return q
+}
+
+// graphqlDevice is the source of the Device fields.
+type graphqlDevice struct {
+	id string
+}
+
+/*
+ * swagger:route POST /graphql device graphql
+ *
+ * Run a GraphQL query for the status, events and status history of one or
+ * more devices.  Queries over the configured complexity or depth are
+ * rejected before anything is read.
+ *
+ * Consumes:
+ *    - application/json
+ *
+ * Produces:
+ *    - application/json
+ *
+ * Schemes: https
+ *
+ * Security:
+ *    bearer_token:
+ *
+ * Responses:
+ *    200: GraphQLResponse
+ *    400: GraphQLResponse
+ *
+ */
+func (app *App) handleGraphQL(writer http.ResponseWriter, request *http.Request) {
+	var body graphqlRequest
+	if err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxGraphQLRequestSize)).Decode(&body); err != nil {
+		writeGraphQLError(writer, fmt.Sprintf("invalid request body: %s", err))
+		return
+	}
+	if body.Query == "" {
+		writeGraphQLError(writer, "query is required")
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: body.Query})
+	if err != nil {
+		writeJSON(writer, http.StatusBadRequest, &graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+		return
+	}
+	limits := graphqlLimits{
+		devices:       app.graphql.config.MaxDevices,
+		events:        app.getEventLimit,
+		statusHistory: app.getStatusLimit,
+	}
+	complexity, depth := limits.analyze(doc, body.Variables)
+	if depth > app.graphql.config.MaxDepth {
+		writeGraphQLError(writer, fmt.Sprintf("query depth %d is more than the limit of %d", depth, app.graphql.config.MaxDepth))
+		return
+	}
+	if complexity > app.graphql.config.MaxComplexity {
+		writeGraphQLError(writer, fmt.Sprintf("query complexity %d is more than the limit of %d", complexity, app.graphql.config.MaxComplexity))
+		return
+	}
+
+	q := &graphqlQuery{app: app, request: request}
+	result := graphql.Do(graphql.Params{
+		Schema:         app.graphql.schema,
+		RequestString:  body.Query,
+		VariableValues: body.Variables,
+		OperationName:  body.OperationName,
+		Context:        context.WithValue(request.Context(), graphqlQueryKey{}, q),
+	})
+	writeJSON(writer, http.StatusOK, result)
+}
+
+// writeGraphQLError rejects a request with a GraphQL error response.
+func writeGraphQLError(writer http.ResponseWriter, message string) {
+	writer.Header().Add("X-Codex-Error", message)
+	writeJSON(writer, http.StatusBadRequest, &graphql.Result{Errors: []gqlerrors.FormattedError{{Message: message}}})
+}
+
+// graphqlLimits are the most items each list field can return, which is how
+// much they multiply the cost of what is selected in them.
+type graphqlLimits struct {
+	devices       int
+	events        int
+	statusHistory int
+}
+
+// analyze returns the complexity and depth of the most expensive operation
+// in the document.  Every field costs 1, plus the cost of its selections
+// times the number of items it may return.  Introspection fields are free,
+// as they don't read the database.
+func (l graphqlLimits) analyze(doc *ast.Document, variables map[string]interface{}) (complexity int, depth int) {
+	fragments := map[string]*ast.FragmentDefinition{}
+	for _, d := range doc.Definitions {
+		if f, ok := d.(*ast.FragmentDefinition); ok {
+			fragments[f.Name.Value] = f
+		}
+	}
+	a := graphqlAnalyzer{limits: l, fragments: fragments, variables: variables, visiting: map[string]bool{}}
+	for _, d := range doc.Definitions {
+		if op, ok := d.(*ast.OperationDefinition); ok {
+			c, dp := a.selections(op.SelectionSet)
+			complexity, depth = max(complexity, c), max(depth, dp)
+		}
+	}
+	return complexity, depth
+}
+
+type graphqlAnalyzer struct {
+	limits    graphqlLimits
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	// visiting holds the fragments being analyzed, so a cycle, which
+	// validation rejects later, can't recurse forever
+	visiting map[string]bool
+}
+
+func (a graphqlAnalyzer) selections(set *ast.SelectionSet) (complexity int, depth int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, s := range set.Selections {
+		var c, d int
+		switch s := s.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name.Value, "__") {
+				continue
+			}
+			c, d = a.selections(s.SelectionSet)
+			c, d = 1+a.items(s)*c, d+1
+		case *ast.InlineFragment:
+			c, d = a.selections(s.SelectionSet)
+		case *ast.FragmentSpread:
+			f, ok := a.fragments[s.Name.Value]
+			if !ok || a.visiting[s.Name.Value] {
+				continue
+			}
+			a.visiting[s.Name.Value] = true
+			c, d = a.selections(f.SelectionSet)
+			delete(a.visiting, s.Name.Value)
+		}
+		complexity += c
+		depth = max(depth, d)
+	}
+	return complexity, depth
+}
+
+// items returns how many items the field may return.
+func (a graphqlAnalyzer) items(field *ast.Field) int {
+	switch field.Name.Value {
+	case "devices":
+		if n, ok := a.listLength(graphqlArgument(field, "ids")); ok {
+			return min(n, a.limits.devices)
+		}
+		return a.limits.devices
+	case "events":
+		return a.last(field, a.limits.events)
+	case "statusHistory":
+		return a.last(field, a.limits.statusHistory)
+	}
+	return 1
+}
+
+// last returns the value of the field's last argument, or limit if it isn't
+// known or is more than the limit.
+func (a graphqlAnalyzer) last(field *ast.Field, limit int) int {
+	if n, ok := a.intValue(graphqlArgument(field, "last")); ok && n > 0 && n < limit {
+		return n
+	}
+	return limit
+}
+
+func (a graphqlAnalyzer) intValue(value ast.Value) (int, bool) {
+	switch v := value.(type) {
+	case *ast.IntValue:
+		n, err := strconv.Atoi(v.Value)
+		return n, err == nil
+	case *ast.Variable:
+		// numbers decoded from JSON are float64
+		if n, ok := a.variables[v.Name.Value].(float64); ok {
+			return int(n), true
+		}
+	}
+	return 0, false
+}
+
+func (a graphqlAnalyzer) listLength(value ast.Value) (int, bool) {
+	switch v := value.(type) {
+	case *ast.ListValue:
+		return len(v.Values), true
+	case *ast.Variable:
+		if l, ok := a.variables[v.Name.Value].([]interface{}); ok {
+			return len(l), true
+		}
+	}
+	return 0, false
+}
+
+func graphqlArgument(field *ast.Field, name string) ast.Value {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value == name {
+			return arg.Value
+		}
+	}
+	return nil
+}
+
+// newGraphQLSchema builds the schema:
+//
+//	type Query {
+//	  device(id: String!): Device
+//	  devices(ids: [String!]!): [Device!]!
+//	}
+//	type Device {
+//	  id: String!
+//	  status(includeExpired: Boolean = false): Status
+//	  events(last: Int, types: [String!], includeExpired: Boolean = false, omitFailed: Boolean = false): [Event!]!
+//	  statusHistory(last: Int, includeExpired: Boolean = false): [StatusChange!]!
+//	}
+//
+// Times are RFC 3339 strings, and payloads are base64.
+func newGraphQLSchema(config GraphQLConfig) (graphql.Schema, error) {
+	jsonType := graphql.NewScalar(graphql.ScalarConfig{
+		Name:         "JSON",
+		Description:  "Any JSON value.",
+		Serialize:    func(v interface{}) interface{} { return v },
+		ParseValue:   func(v interface{}) interface{} { return v },
+		ParseLiteral: func(ast.Value) interface{} { return nil },
+	})
+	stringList := graphql.NewList(graphql.NewNonNull(graphql.String))
+
+	eventErrorType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "EventError",
+		Description: "Why a record couldn't be decrypted or decoded.",
+		Fields: graphql.Fields{
+			"stage":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"reason": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+	eventType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Event",
+		Description: "A WRP message as stored by codex.",
+		Fields: graphql.Fields{
+			"msgType":         &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"type":            &graphql.Field{Type: graphql.NewNonNull(graphql.String), Description: "The friendly name of the message type, such as SimpleEvent."},
+			"source":          &graphql.Field{Type: graphql.String},
+			"dest":            &graphql.Field{Type: graphql.String},
+			"transactionUuid": &graphql.Field{Type: graphql.String},
+			"contentType":     &graphql.Field{Type: graphql.String},
+			"accept":          &graphql.Field{Type: graphql.String},
+			"status":          &graphql.Field{Type: graphql.Int},
+			"headers":         &graphql.Field{Type: stringList},
+			"metadata":        &graphql.Field{Type: jsonType},
+			"payload":         &graphql.Field{Type: graphql.String, Description: "The payload, base64 encoded."},
+			"partnerIds":      &graphql.Field{Type: stringList},
+			"sessionId":       &graphql.Field{Type: graphql.String},
+			"birthDate":       &graphql.Field{Type: graphql.String},
+			"deathDate":       &graphql.Field{Type: graphql.String},
+			"error":           &graphql.Field{Type: eventErrorType},
+		},
+	})
+	statusType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Status",
+		Description: "The device's current state, as returned by the status endpoint.",
+		Fields: graphql.Fields{
+			"deviceId":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"state":             &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"since":             &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"now":               &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"lastOfflineReason": &graphql.Field{Type: graphql.String},
+			"partnerIds":        &graphql.Field{Type: stringList},
+			"sessionId":         &graphql.Field{Type: graphql.String},
+			"onlineFor":         &graphql.Field{Type: graphql.Int},
+			"offlineFor":        &graphql.Field{Type: graphql.Int},
+			"lastOnlineAt":      &graphql.Field{Type: graphql.String},
+			"lastOfflineAt":     &graphql.Field{Type: graphql.String},
+			"lastEventAt":       &graphql.Field{Type: graphql.String},
+			"metadata":          &graphql.Field{Type: jsonType},
+		},
+	})
+	statusChangeType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "StatusChange",
+		Description: "A state record of the device.",
+		Fields: graphql.Fields{
+			"state":             &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"at":                &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"sessionId":         &graphql.Field{Type: graphql.String},
+			"lastOfflineReason": &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	includeExpired := &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false}
+	deviceType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Device",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(graphqlDevice).id, nil
+				},
+			},
+			"status": &graphql.Field{
+				Type:        statusType,
+				Description: "The device's status, or null if it doesn't have one.",
+				Args:        graphql.FieldConfigArgument{"includeExpired": includeExpired},
+				Resolve:     resolveGraphQLStatus,
+			},
+			"events": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(eventType))),
+				Description: "The device's newest events that the caller's partners may see, newest first.",
+				Args: graphql.FieldConfigArgument{
+					"last":           &graphql.ArgumentConfig{Type: graphql.Int},
+					"types":          &graphql.ArgumentConfig{Type: stringList},
+					"includeExpired": includeExpired,
+					"omitFailed":     &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+				},
+				Resolve: resolveGraphQLEvents,
+			},
+			"statusHistory": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(statusChangeType))),
+				Description: "The device's newest state records, newest first.",
+				Args: graphql.FieldConfigArgument{
+					"last":           &graphql.ArgumentConfig{Type: graphql.Int},
+					"includeExpired": includeExpired,
+				},
+				Resolve: resolveGraphQLStatusHistory,
+			},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"device": &graphql.Field{
+				Type: deviceType,
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return graphqlQueryFrom(p.Context).device(p.Args["id"].(string))
+				},
+			},
+			"devices": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(deviceType))),
+				Args: graphql.FieldConfigArgument{"ids": &graphql.ArgumentConfig{Type: graphql.NewNonNull(stringList)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					ids := p.Args["ids"].([]interface{})
+					if len(ids) > config.MaxDevices {
+						return nil, fmt.Errorf("at most %d devices may be asked for, got %d", config.MaxDevices, len(ids))
+					}
+					q := graphqlQueryFrom(p.Context)
+					devices := make([]interface{}, 0, len(ids))
+					for _, id := range ids {
+						d, err := q.device(id.(string))
+						if err != nil {
+							return nil, err
+						}
+						devices = append(devices, d)
+					}
+					return devices, nil
+				},
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: queryType})
+}
+
+// device checks the device id, the same way the REST endpoints do, and
+// reports it for the audit.  The resolvers of its fields report how many
+// results they returned for it.
+func (q *graphqlQuery) device(raw string) (interface{}, error) {
+	id, err := parseDeviceID(raw, q.app.deviceIDs.StripServiceSuffix)
+	if err != nil {
+		return nil, err
+	}
+	addAuditDevice(q.request.Context(), id, 0)
+	return graphqlDevice{id: id}, nil
+}
+
+// includeExpired reads the includeExpired argument, which only callers
+// allowed to see expired records may set.
+func (q *graphqlQuery) includeExpired(args map[string]interface{}) (bool, error) {
+	include, _ := args["includeExpired"].(bool)
+	if include && !q.app.expiredRecords.allowed(q.request) {
+		return false, errors.New("not allowed to include expired records")
+	}
+	return include, nil
+}
+
+// last reads the last argument, which defaults to and may not be more than
+// limit.
+func graphqlLast(args map[string]interface{}, limit int) (int, error) {
+	last, ok := args["last"].(int)
+	if !ok {
+		return limit, nil
+	}
+	if last < 1 || last > limit {
+		return 0, fmt.Errorf("last must be between 1 and %d, got %d", limit, last)
+	}
+	return last, nil
+}
+
+// isNotFound reports whether the App error is a 404.
+func isNotFound(err error) bool {
+	var coder kithttp.StatusCoder
+	return errors.As(err, &coder) && coder.StatusCode() == http.StatusNotFound
+}
+
+func resolveGraphQLStatus(p graphql.ResolveParams) (interface{}, error) {
+	q := graphqlQueryFrom(p.Context)
+	app := q.app
+	includeExpired, err := q.includeExpired(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	s, err := app.getStatusInfo(p.Source.(graphqlDevice).id, includeExpired)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		logging.Error(app.logger, emperror.Context(err)...).Log(logging.MessageKey(),
+			"Failed to get status info", logging.ErrorKey(), err.Error())
+		return nil, errors.New("failed to get status")
+	}
+	s = app.statusRedaction(q.request).status(s, app.status.Metadata)
+	addAuditDevice(q.request.Context(), p.Source.(graphqlDevice).id, 1)
+	return graphqlStatus(s), nil
+}
+
+func resolveGraphQLEvents(p graphql.ResolveParams) (interface{}, error) {
+	q := graphqlQueryFrom(p.Context)
+	app := q.app
+	partnerIDs, err := extractPartnerIDs(q.request, app.basicAuthPartnerIDHeaderKey)
+	if err != nil || len(partnerIDs) == 0 {
+		return nil, errGettingPartnerIDs
+	}
+	includeExpired, err := q.includeExpired(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	last, err := graphqlLast(p.Args, app.getEventLimit)
+	if err != nil {
+		return nil, err
+	}
+	var types []string
+	if t, ok := p.Args["types"].([]interface{}); ok {
+		names := make([]string, 0, len(t))
+		for _, name := range t {
+			names = append(names, name.(string))
+		}
+		if types, err = parseMessageTypes(names); err != nil {
+			return nil, err
+		}
+	}
+
+	events, _, err := app.getDeviceInfo(p.Source.(graphqlDevice).id, includeExpired)
+	if isNotFound(err) {
+		return []interface{}{}, nil
+	}
+	if err != nil {
+		logging.Error(app.logger, emperror.Context(err)...).Log(logging.MessageKey(),
+			"Failed to get events", logging.ErrorKey(), err.Error())
+		return nil, errors.New("failed to get events")
+	}
+	if omit, _ := p.Args["omitFailed"].(bool); omit {
+		events = omitFailed(events)
+	}
+	events = filterPartners(events, partnerIDs)
+	matched := make([]model.Event, 0, len(events))
+	for _, e := range events {
+		if len(types) == 0 || contains(types, e.Type.FriendlyName()) {
+			matched = append(matched, e)
+		}
+	}
+	sortEvents(matched, true)
+	if len(matched) > last {
+		matched = matched[:last]
+	}
+	matched = app.redactor.forRequest(q.request, partnerIDs).events(matched)
+
+	addAuditDevice(q.request.Context(), p.Source.(graphqlDevice).id, len(matched))
+	results := make([]interface{}, 0, len(matched))
+	for _, e := range matched {
+		results = append(results, graphqlEvent(e))
+	}
+	return results, nil
+}
+
+// resolveGraphQLStatusHistory returns the state records used to determine
+// the status, newest first.  Records that can't be parsed are left out.
+func resolveGraphQLStatusHistory(p graphql.ResolveParams) (interface{}, error) {
+	q := graphqlQueryFrom(p.Context)
+	app := q.app
+	includeExpired, err := q.includeExpired(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	last, err := graphqlLast(p.Args, app.getStatusLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	id := p.Source.(graphqlDevice).id
+	records, err := app.eventGetter.GetRecordsOfType(id, app.getStatusLimit, db.State, "")
+	if err != nil {
+		logging.Error(app.logger).Log(logging.MessageKey(), "Failed to get state records", "device id", id,
+			logging.ErrorKey(), err.Error())
+		return nil, errors.New("failed to get status history")
+	}
+	redaction := app.statusRedaction(q.request)
+
+	var changes []Status
+	for _, record := range records {
+		if !includeExpired && isExpired(record) {
+			continue
+		}
+		item, stage, err := app.parseState(id, record)
+		if err != nil {
+			app.countDecodeFailure(record, stage)
+			logging.Error(app.logger, recordFields(record)...).Log(logging.MessageKey(), "Failed to parse state event", logging.ErrorKey(), err.Error())
+			continue
+		}
+		changes = append(changes, redaction.status(item.status, app.status.Metadata))
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Since.After(changes[j].Since)
+	})
+	if len(changes) > last {
+		changes = changes[:last]
+	}
+	addAuditDevice(q.request.Context(), id, len(changes))
+
+	results := make([]interface{}, 0, len(changes))
+	for _, s := range changes {
+		results = append(results, map[string]interface{}{
+			"state":             s.State,
+			"at":                graphqlTime(s.Since),
+			"sessionId":         s.SessionID,
+			"lastOfflineReason": s.LastOfflineReason,
+		})
+	}
+	return results, nil
+}
+
+func graphqlTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func graphqlOptionalTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return graphqlTime(*t)
+}
+
+// graphqlUnixTime formats a time in Unix nanoseconds, or returns nil if it
+// isn't set.
+func graphqlUnixTime(t int64) interface{} {
+	if t == 0 {
+		return nil
+	}
+	return graphqlTime(time.Unix(0, t))
+}
+
+func graphqlStatus(s Status) map[string]interface{} {
+	return map[string]interface{}{
+		"deviceId":          s.DeviceID,
+		"state":             s.State,
+		"since":             graphqlTime(s.Since),
+		"now":               graphqlTime(s.Now),
+		"lastOfflineReason": s.LastOfflineReason,
+		"partnerIds":        s.PartnerIDs,
+		"sessionId":         s.SessionID,
+		"onlineFor":         s.OnlineFor,
+		"offlineFor":        s.OfflineFor,
+		"lastOnlineAt":      graphqlOptionalTime(s.LastOnlineAt),
+		"lastOfflineAt":     graphqlOptionalTime(s.LastOfflineAt),
+		"lastEventAt":       graphqlOptionalTime(s.LastEventAt),
+		"metadata":          s.Metadata,
+	}
+}
+
+func graphqlEvent(e model.Event) map[string]interface{} {
+	event := map[string]interface{}{
+		"msgType":         int(e.Type),
+		"type":            e.Type.FriendlyName(),
+		"source":          e.Source,
+		"dest":            e.Destination,
+		"transactionUuid": e.TransactionUUID,
+		"contentType":     e.ContentType,
+		"accept":          e.Accept,
+		"headers":         e.Headers,
+		"metadata":        e.Metadata,
+		"partnerIds":      e.PartnerIDs,
+		"sessionId":       e.SessionID,
+		"birthDate":       graphqlUnixTime(e.BirthDate),
+		"deathDate":       graphqlUnixTime(e.DeathDate),
+	}
+	if e.Status != nil {
+		event["status"] = int(*e.Status)
+	}
+	if len(e.Payload) > 0 {
+		event["payload"] = base64.StdEncoding.EncodeToString(e.Payload)
+	}
+	if e.Error != nil {
+		event["error"] = map[string]interface{}{"stage": e.Error.Stage, "reason": e.Error.Reason}
+	}
+	return event
+}
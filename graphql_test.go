@@ -0,0 +1,410 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestValidateGraphQLConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	off := GraphQLConfig{}
+	assert.Empty(validateGraphQLConfig(&off))
+	assert.Zero(off.MaxComplexity)
+
+	config := GraphQLConfig{Enabled: true}
+	assert.Empty(validateGraphQLConfig(&config))
+	assert.Equal(GraphQLConfig{Enabled: true, MaxComplexity: defaultGraphQLMaxComplexity,
+		MaxDepth: defaultGraphQLMaxDepth, MaxDevices: defaultGraphQLMaxDevices}, config)
+
+	bad := GraphQLConfig{Enabled: true, MaxComplexity: -1, MaxDepth: -2, MaxDevices: -3}
+	errs := validateGraphQLConfig(&bad)
+	assert.Len(errs, 3)
+	assert.ErrorContains(errs[0], "graphql.maxComplexity must be positive, got -1")
+	assert.ErrorContains(errs[1], "graphql.maxDepth must be positive, got -2")
+	assert.ErrorContains(errs[2], "graphql.maxDevices must be positive, got -3")
+}
+
+func TestNewGraphQL(t *testing.T) {
+	assert := assert.New(t)
+
+	g, err := NewGraphQL(GraphQLConfig{})
+	assert.Nil(err)
+	assert.Nil(g)
+
+	g, err = NewGraphQL(GraphQLConfig{Enabled: true})
+	assert.Nil(err)
+	assert.NotNil(g)
+
+	g, err = NewGraphQL(GraphQLConfig{Enabled: true, MaxDepth: -1})
+	assert.NotNil(err)
+	assert.Nil(g)
+}
+
+func TestGraphQLAnalyze(t *testing.T) {
+	limits := graphqlLimits{devices: 10, events: 5, statusHistory: 4}
+	tests := []struct {
+		description        string
+		query              string
+		variables          map[string]interface{}
+		expectedComplexity int
+		expectedDepth      int
+	}{
+		{
+			description:        "Scalar Fields",
+			query:              `{ device(id: "mac:112233445566") { id status { state since } } }`,
+			expectedComplexity: 1 + 1 + 1 + 2,
+			expectedDepth:      3,
+		},
+		{
+			description:        "Lists Use Their Limits",
+			query:              `{ device(id: "a") { events { type } statusHistory { state at } } }`,
+			expectedComplexity: 1 + (1 + 5*1) + (1 + 4*2),
+			expectedDepth:      3,
+		},
+		{
+			description:        "Last Lowers The Cost",
+			query:              `{ device(id: "a") { events(last: 2) { type } } }`,
+			expectedComplexity: 1 + (1 + 2*1),
+			expectedDepth:      3,
+		},
+		{
+			description:        "Last Above The Limit",
+			query:              `{ device(id: "a") { events(last: 50) { type } } }`,
+			expectedComplexity: 1 + (1 + 5*1),
+			expectedDepth:      3,
+		},
+		{
+			description:        "Devices Multiply",
+			query:              `{ devices(ids: ["a", "b", "c"]) { id events { type } } }`,
+			expectedComplexity: 1 + 3*(1+(1+5*1)),
+			expectedDepth:      3,
+		},
+		{
+			description:        "Variables",
+			query:              `query($ids: [String!]!, $n: Int) { devices(ids: $ids) { events(last: $n) { type } } }`,
+			variables:          map[string]interface{}{"ids": []interface{}{"a", "b"}, "n": float64(1)},
+			expectedComplexity: 1 + 2*(1+1*1),
+			expectedDepth:      3,
+		},
+		{
+			description:        "Missing Variables Use The Limits",
+			query:              `query($ids: [String!]!) { devices(ids: $ids) { id } }`,
+			expectedComplexity: 1 + 10*1,
+			expectedDepth:      2,
+		},
+		{
+			description: "Fragments",
+			query: `{ device(id: "a") { ...f ... on Device { id } } }
+				fragment f on Device { status { state } }`,
+			expectedComplexity: 1 + 2 + 1,
+			expectedDepth:      3,
+		},
+		{
+			description:        "Fragment Cycle",
+			query:              `{ device(id: "a") { ...f } } fragment f on Device { id ...f }`,
+			expectedComplexity: 1 + 1,
+			expectedDepth:      2,
+		},
+		{
+			description:        "Introspection Is Free",
+			query:              `{ __schema { types { name fields { name } } } }`,
+			expectedComplexity: 0,
+			expectedDepth:      0,
+		},
+		{
+			description:        "Most Expensive Operation",
+			query:              `query a { device(id: "a") { id } } query b { device(id: "a") { events { type } } }`,
+			expectedComplexity: 1 + (1 + 5*1),
+			expectedDepth:      3,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			doc, err := parser.Parse(parser.ParseParams{Source: tc.query})
+			require.Nil(t, err)
+			complexity, depth := limits.analyze(doc, tc.variables)
+			assert.Equal(tc.expectedComplexity, complexity)
+			assert.Equal(tc.expectedDepth, depth)
+		})
+	}
+}
+
+// testGraphQLServer returns the GraphQL endpoint with the config.
+func testGraphQLServer(t *testing.T, config GraphQLConfig) *GraphQL {
+	g, err := NewGraphQL(config)
+	require.Nil(t, err)
+	return g
+}
+
+// testGraphQL runs the query as a caller allowed the partners, and returns
+// the response code and body.
+func testGraphQL(t *testing.T, app *App, query string, variables map[string]interface{}, partners ...string) (int, map[string]interface{}) {
+	body, err := json.Marshal(graphqlRequest{Query: query, Variables: variables})
+	require.Nil(t, err)
+	request := testRequest(t, testAuth("user", partners...), http.MethodPost, "/api/v1/graphql", string(body), nil)
+	rr := httptest.NewRecorder()
+	app.handleGraphQL(rr, request)
+
+	var result map[string]interface{}
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	return rr.Code, result
+}
+
+func TestGraphQLDevice(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	online, offline, event, other := goodOnlineEvent, goodOfflineEvent, goodOnlineEvent, goodOnlineEvent
+	online.Type, offline.Type = wrp.SimpleEventMessageType, wrp.SimpleEventMessageType
+	event.Type, event.Destination = wrp.SimpleRequestResponseMessageType, "/test/request"
+	other.Type, other.PartnerIDs = wrp.SimpleEventMessageType, []string{"other"}
+	now := time.Now()
+	onlineRecord := testRecord(t, online, now.Add(-time.Minute).UnixNano())
+	offlineRecord := testRecord(t, offline, now.Add(-2*time.Minute).UnixNano())
+	records := []db.Record{
+		testRecord(t, event, now.Add(-30*time.Second).UnixNano()),
+		onlineRecord,
+		testRecord(t, other, now.Add(-90*time.Second).UnixNano()),
+		offlineRecord,
+	}
+	states := []db.Record{offlineRecord, onlineRecord}
+
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecordsOfType", "mac:112233445566", 5, db.State, "").Return(states, nil)
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return(records, nil)
+	mockGetter.On("GetStateHash", records).Return("hash", nil)
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.eventGetter = mockGetter
+	app.graphql = testGraphQLServer(t, GraphQLConfig{Enabled: true})
+
+	code, result := testGraphQL(t, app, `{
+		device(id: "mac:112233445566") {
+			id
+			status { state sessionId }
+			events(last: 2, types: ["SimpleEvent"]) { type dest partnerIds }
+			statusHistory { state sessionId }
+		}
+	}`, nil, "test1")
+	require.Equal(http.StatusOK, code)
+	require.Nil(result["errors"])
+	assert.Equal(map[string]interface{}{
+		"device": map[string]interface{}{
+			"id":     "mac:112233445566",
+			"status": map[string]interface{}{"state": "online", "sessionId": "54321"},
+			// the request response event has the wrong type, and the other
+			// event the wrong partner
+			"events": []interface{}{
+				map[string]interface{}{"type": "SimpleEvent", "dest": "/test/online", "partnerIds": []interface{}{"test1", "test2"}},
+				map[string]interface{}{"type": "SimpleEvent", "dest": "/test/offline", "partnerIds": []interface{}{"test1", "test2"}},
+			},
+			"statusHistory": []interface{}{
+				map[string]interface{}{"state": "online", "sessionId": "54321"},
+				map[string]interface{}{"state": "offline", "sessionId": "1234"},
+			},
+		},
+	}, result["data"])
+
+	code, result = testGraphQL(t, app, `{ device(id: "mac:112233445566") { events(last: 1) { type } statusHistory(last: 1) { state } } }`, nil, "test1")
+	require.Equal(http.StatusOK, code)
+	assert.Equal(map[string]interface{}{
+		"device": map[string]interface{}{
+			"events":        []interface{}{map[string]interface{}{"type": "SimpleRequestResponse"}},
+			"statusHistory": []interface{}{map[string]interface{}{"state": "online"}},
+		},
+	}, result["data"])
+}
+
+func TestGraphQLDevices(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	event := goodOnlineEvent
+	event.Type = wrp.SimpleEventMessageType
+	online := []db.Record{testRecord(t, event, time.Now().Add(-time.Minute).UnixNano())}
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecordsOfType", "mac:112233445566", 5, db.State, "").Return(online, nil)
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return(online, nil)
+	mockGetter.On("GetStateHash", online).Return("hash", nil)
+	mockGetter.On("GetRecordsOfType", "mac:665544332211", 5, db.State, "").Return([]db.Record{}, nil)
+	mockGetter.On("GetRecords", "mac:665544332211", 5, "").Return([]db.Record{}, nil)
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.eventGetter = mockGetter
+	app.graphql = testGraphQLServer(t, GraphQLConfig{Enabled: true, MaxDevices: 2})
+
+	query := `query($ids: [String!]!) { devices(ids: $ids) { id status { state } events { type } } }`
+	code, result := testGraphQL(t, app, query, map[string]interface{}{"ids": []string{"mac:112233445566", "mac:665544332211"}}, "test1")
+	require.Equal(http.StatusOK, code)
+	require.Nil(result["errors"])
+	assert.Equal(map[string]interface{}{
+		"devices": []interface{}{
+			map[string]interface{}{"id": "mac:112233445566", "status": map[string]interface{}{"state": "online"},
+				"events": []interface{}{map[string]interface{}{"type": "SimpleEvent"}}},
+			// a device without records has no status and no events
+			map[string]interface{}{"id": "mac:665544332211", "status": nil, "events": []interface{}{}},
+		},
+	}, result["data"])
+}
+
+func TestGraphQLAudit(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	online, other := goodOnlineEvent, goodOnlineEvent
+	online.Type, other.Type = wrp.SimpleEventMessageType, wrp.SimpleEventMessageType
+	other.PartnerIDs = []string{"other"}
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return([]db.Record{testRecord(t, online, 300), testRecord(t, other, 200)}, nil)
+	mockGetter.On("GetStateHash", mock.Anything).Return("hash", nil)
+	mockGetter.On("GetRecords", "mac:665544332211", 5, "").Return([]db.Record{}, nil)
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.eventGetter = mockGetter
+	app.graphql = testGraphQLServer(t, GraphQLConfig{Enabled: true})
+
+	auditor, sink := testAuditor()
+	handler := AuditRequest(auditor, graphqlEndpoint, "X-Codex-Partner-Ids")(http.HandlerFunc(app.handleGraphQL))
+	body, err := json.Marshal(graphqlRequest{Query: `{
+		devices(ids: ["mac:112233445566", "MAC:665544332211"]) { events { type } }
+		device(id: "mac:112233445566") { events(last: 1) { type } }
+	}`})
+	require.Nil(err)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, testRequest(t, testAuth("user", "test1"), http.MethodPost, "/api/v1/graphql", string(body), nil))
+	require.Equal(http.StatusOK, rr.Code)
+
+	auditor.Stop()
+	audited := sink.records()
+	require.Len(audited, 2)
+	// the device asked for twice has one record with the events of both
+	assert.Equal("mac:112233445566", audited[0].DeviceID)
+	assert.Equal(2, audited[0].ResultCount)
+	assert.Equal("mac:665544332211", audited[1].DeviceID)
+	assert.Equal(0, audited[1].ResultCount)
+	for _, r := range audited {
+		assert.Equal("user", r.Principal)
+		assert.Equal(graphqlEndpoint, r.Endpoint)
+		assert.Equal(http.StatusOK, r.StatusCode)
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return([]db.Record{}, nil).Maybe()
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.eventGetter = mockGetter
+	app.graphql = testGraphQLServer(t, GraphQLConfig{Enabled: true, MaxComplexity: 50, MaxDepth: 3, MaxDevices: 2})
+
+	tests := []struct {
+		description   string
+		query         string
+		partners      []string
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			description:   "Empty Query",
+			query:         "",
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "query is required",
+		},
+		{
+			description:   "Body Too Large",
+			query:         strings.Repeat(" ", maxGraphQLRequestSize) + `{ device(id: "mac:112233445566") { id } }`,
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "request body too large",
+		},
+		{
+			description:   "Syntax Error",
+			query:         `{ device(id: "mac:112233445566") {`,
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "Syntax Error",
+		},
+		{
+			description:   "Too Complex",
+			query:         `{ devices(ids: ["mac:112233445566", "mac:665544332211"]) { events { type dest source } statusHistory { state at } } }`,
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "query complexity 55 is more than the limit of 50",
+		},
+		{
+			description:   "Too Deep",
+			query:         `{ device(id: "mac:112233445566") { events { error { stage } } } }`,
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "query depth 4 is more than the limit of 3",
+		},
+		{
+			description:   "Too Many Devices",
+			query:         `{ devices(ids: ["a", "b", "c"]) { id } }`,
+			partners:      []string{"test1"},
+			expectedCode:  http.StatusOK,
+			expectedError: "at most 2 devices may be asked for, got 3",
+		},
+		{
+			description:   "Bad Device ID",
+			query:         `{ device(id: "bad") { id } }`,
+			partners:      []string{"test1"},
+			expectedCode:  http.StatusOK,
+			expectedError: "invalid device id",
+		},
+		{
+			description:   "No Partners",
+			query:         `{ device(id: "mac:112233445566") { events { type } } }`,
+			expectedCode:  http.StatusOK,
+			expectedError: errGettingPartnerIDs.Error(),
+		},
+		{
+			description:   "Bad Type",
+			query:         `{ device(id: "mac:112233445566") { events(types: ["nope"]) { type } } }`,
+			partners:      []string{"test1"},
+			expectedCode:  http.StatusOK,
+			expectedError: `invalid type "nope"`,
+		},
+		{
+			description:   "Bad Last",
+			query:         `{ device(id: "mac:112233445566") { events(last: 0) { type } } }`,
+			partners:      []string{"test1"},
+			expectedCode:  http.StatusOK,
+			expectedError: "last must be between 1 and 5, got 0",
+		},
+		{
+			description:   "Expired Not Allowed",
+			query:         `{ device(id: "mac:112233445566") { statusHistory(includeExpired: true) { state } } }`,
+			partners:      []string{"test1"},
+			expectedCode:  http.StatusOK,
+			expectedError: "not allowed to include expired records",
+		},
+		{
+			description:   "Unknown Field",
+			query:         `{ device(id: "mac:112233445566") { serial } }`,
+			partners:      []string{"test1"},
+			expectedCode:  http.StatusOK,
+			expectedError: `Cannot query field "serial"`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			code, result := testGraphQL(t, app, tc.query, nil, tc.partners...)
+			assert.Equal(tc.expectedCode, code)
+			errs, ok := result["errors"].([]interface{})
+			if assert.True(ok) && assert.NotEmpty(errs) {
+				assert.Contains(errs[0].(map[string]interface{})["message"], tc.expectedError)
+			}
+		})
+	}
+}
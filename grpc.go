@@ -0,0 +1,625 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/go-kit/log"
+	"github.com/goph/emperror"
+	"github.com/justinas/alice"
+	"github.com/xmidt-org/gungnir/gungnirpb"
+	"github.com/xmidt-org/gungnir/model"
+	"github.com/xmidt-org/webpa-common/v2/logging" //nolint: staticcheck
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultGRPCMaxBatchSize     = 100
+	defaultGRPCMaxWatchDuration = 30 * time.Minute
+)
+
+// GRPCConfig configures the gRPC API, which serves the same device events and
+// statuses as the REST endpoints on its own port.
+type GRPCConfig struct {
+	// Address is where the gRPC server listens, such as ":7004".  The gRPC
+	// API is off if it is empty.
+	Address string
+
+	// CertificateFile and KeyFile are the PEM encoded certificate chain and
+	// private key, if TLS is used.
+	CertificateFile string
+	KeyFile         string
+
+	// MaxBatchSize is the most devices one GetStatuses call may ask for.
+	// Defaults to 100.
+	MaxBatchSize int
+
+	// MaxWatchDuration is the longest a WatchEvents stream runs before the
+	// server ends it, and the caller starts a new one from the last hash.
+	// Defaults to 30m.
+	MaxWatchDuration time.Duration
+}
+
+// validateGRPCConfig fills in the defaults for a gRPC configuration and
+// returns every problem with it.
+func validateGRPCConfig(config *GRPCConfig) []error {
+	if config.Address == "" {
+		return nil
+	}
+
+	if config.MaxBatchSize == 0 {
+		config.MaxBatchSize = defaultGRPCMaxBatchSize
+	}
+	if config.MaxWatchDuration == 0 {
+		config.MaxWatchDuration = defaultGRPCMaxWatchDuration
+	}
+
+	var errs []error
+	if _, _, err := net.SplitHostPort(config.Address); err != nil {
+		errs = append(errs, fmt.Errorf("grpc.address must be of the form host:port, got %q", config.Address))
+	}
+	if (config.CertificateFile == "") != (config.KeyFile == "") {
+		errs = append(errs, errors.New("grpc.certificateFile and grpc.keyFile must be set together"))
+	}
+	if config.MaxBatchSize < 0 {
+		errs = append(errs, fmt.Errorf("grpc.maxBatchSize must be positive, got %d", config.MaxBatchSize))
+	}
+	if config.MaxWatchDuration < 0 {
+		errs = append(errs, fmt.Errorf("grpc.maxWatchDuration must be positive, got %s", config.MaxWatchDuration))
+	}
+	return errs
+}
+
+// GRPCServer serves the gRPC API with whichever App is current, the same way
+// the REST endpoints do.
+type GRPCServer struct {
+	gungnirpb.UnimplementedGungnirServer
+
+	config   GRPCConfig
+	apps     *ReloadableApp
+	auth     alice.Chain
+	logger   log.Logger
+	measures *Measures
+	auditor  *Auditor
+
+	server *grpc.Server
+	errs   chan error
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewGRPCServer creates the gRPC server for the configuration.  Calls are
+// authorized by auth, the chain that protects the REST endpoints, and audited
+// like REST requests.  If the gRPC API is off, it returns nil.
+func NewGRPCServer(config GRPCConfig, apps *ReloadableApp, auth alice.Chain, logger log.Logger, measures *Measures, auditor *Auditor) (*GRPCServer, error) {
+	if err := errors.Join(validateGRPCConfig(&config)...); err != nil {
+		return nil, err
+	}
+	if config.Address == "" {
+		return nil, nil
+	}
+
+	s := &GRPCServer{
+		config:   config,
+		apps:     apps,
+		auth:     auth,
+		logger:   logger,
+		measures: measures,
+		auditor:  auditor,
+		errs:     make(chan error, 1),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.interceptUnary),
+		grpc.ChainStreamInterceptor(s.interceptStream),
+	}
+	if config.CertificateFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(config.CertificateFile, config.KeyFile)
+		if err != nil {
+			return nil, emperror.Wrap(err, "failed to load gRPC certificate")
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+	s.server = grpc.NewServer(options...)
+	gungnirpb.RegisterGungnirServer(s.server, s)
+	return s, nil
+}
+
+// Start listens on the configured address and serves in the background.
+func (s *GRPCServer) Start() error {
+	if s == nil {
+		return nil
+	}
+	l, err := net.Listen("tcp", s.config.Address)
+	if err != nil {
+		return err
+	}
+	s.serve(l)
+	return nil
+}
+
+func (s *GRPCServer) serve(l net.Listener) {
+	go func() {
+		s.errs <- s.server.Serve(l)
+	}()
+}
+
+// Errors reports why the server stopped serving, if it stops on its own.
+func (s *GRPCServer) Errors() <-chan error {
+	if s == nil {
+		return nil
+	}
+	return s.errs
+}
+
+// Stop ends the WatchEvents streams and waits for the other calls to finish.
+func (s *GRPCServer) Stop() {
+	if s == nil {
+		return
+	}
+	s.cancel()
+	s.server.GracefulStop()
+}
+
+func (s *GRPCServer) interceptUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	var resp interface{}
+	if err == nil {
+		var results *auditResults
+		ctx, results = withAuditResults(ctx)
+		resp, err = handler(ctx, req)
+		s.audit(ctx, info.FullMethod, results, err, start)
+	}
+	s.observe(info.FullMethod, err, start)
+	return resp, err
+}
+
+func (s *GRPCServer) interceptStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := s.authenticate(stream.Context(), info.FullMethod)
+	if err == nil {
+		var results *auditResults
+		ctx, results = withAuditResults(ctx)
+		err = handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+		s.audit(ctx, info.FullMethod, results, err, start)
+	}
+	s.observe(info.FullMethod, err, start)
+	return err
+}
+
+// audit records an authenticated call like AuditRequest records a REST
+// request, with the grpc endpoint, the method name and the HTTP status code
+// matching the call's code.  There is a record for each device the call
+// read.
+func (s *GRPCServer) audit(ctx context.Context, fullMethod string, results *auditResults, err error, start time.Time) {
+	if s.auditor == nil {
+		return
+	}
+	record := newAuditRecord(grpcRequest(ctx), s.apps.current.Load().basicAuthPartnerIDHeaderKey, start)
+	record.Endpoint = grpcEndpoint
+	record.Method = path.Base(fullMethod)
+	record.StatusCode = httpStatusCode(status.Code(err))
+	record.LatencySeconds = time.Since(start).Seconds()
+	s.auditor.recordResults(record, results)
+}
+
+func (s *GRPCServer) observe(fullMethod string, err error, start time.Time) {
+	s.measures.GRPCRequestDuration.With(methodLabel, path.Base(fullMethod), codeLabel, status.Code(err).String()).
+		Observe(time.Since(start).Seconds())
+}
+
+// authenticatedStream carries the context authenticate returned.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (a *authenticatedStream) Context() context.Context {
+	return a.ctx
+}
+
+// grpcRequestKey holds the request the auth chain authorized for a call.
+type grpcRequestKey struct{}
+
+// authenticate runs the call's metadata through the REST auth chain as a POST
+// to apiBase followed by the full method name, so capability checks can
+// match gRPC methods like endpoints.  The request the chain authorized is
+// kept in the context, so partner ids, admin checks and redaction work as
+// they do for REST requests.
+func (s *GRPCServer) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, apiBase+fullMethod, nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, values := range md {
+		if strings.HasPrefix(k, ":") || strings.HasSuffix(k, "-bin") {
+			continue
+		}
+		for _, v := range values {
+			request.Header.Add(k, v)
+		}
+	}
+
+	var (
+		authorized *http.Request
+		w          = &codeResponseWriter{header: http.Header{}, code: http.StatusOK}
+	)
+	s.auth.ThenFunc(func(_ http.ResponseWriter, r *http.Request) {
+		authorized = r
+	}).ServeHTTP(w, request)
+	if authorized == nil {
+		return nil, status.Error(grpcCode(w.code), http.StatusText(w.code))
+	}
+	return context.WithValue(authorized.Context(), grpcRequestKey{}, authorized), nil
+}
+
+// grpcRequest returns the request authorized for the call.
+func grpcRequest(ctx context.Context) *http.Request {
+	request, _ := ctx.Value(grpcRequestKey{}).(*http.Request)
+	return request
+}
+
+// codeResponseWriter keeps the status code the auth chain responds with and
+// discards the rest.
+type codeResponseWriter struct {
+	header http.Header
+	code   int
+}
+
+func (w *codeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *codeResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *codeResponseWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+// grpcCode returns the gRPC code for an HTTP status code.
+func grpcCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case 499:
+		return codes.Canceled
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+// httpStatusCode returns the HTTP status code for a gRPC code, the reverse of
+// grpcCode.
+func httpStatusCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Canceled:
+		return 499
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// grpcError converts an error from the App to a gRPC status, with the code
+// matching the HTTP status code the REST endpoints would respond with.
+func grpcError(err error) error {
+	var coder kithttp.StatusCoder
+	if errors.As(err, &coder) {
+		return status.Error(grpcCode(coder.StatusCode()), err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// checkDevice normalizes the device id, reports it for the audit and checks
+// that the caller may include expired records, if it asked to.
+func checkDevice(ctx context.Context, app *App, deviceID string, includeExpired bool) (string, error) {
+	id, err := parseDeviceID(deviceID, app.deviceIDs.StripServiceSuffix)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	addAuditDevice(ctx, id, 0)
+	if includeExpired && !app.expiredRecords.allowed(grpcRequest(ctx)) {
+		return "", status.Error(codes.PermissionDenied, "not allowed to include expired records")
+	}
+	return id, nil
+}
+
+// GetEvents returns the device's events, filtered by the caller's partner
+// ids, like the events endpoint.
+func (s *GRPCServer) GetEvents(ctx context.Context, in *gungnirpb.GetEventsRequest) (*gungnirpb.GetEventsResponse, error) {
+	app := s.apps.current.Load()
+	request := grpcRequest(ctx)
+	id, err := checkDevice(ctx, app, in.DeviceId, in.IncludeExpired)
+	if err != nil {
+		return nil, err
+	}
+	partnerIDs, err := extractPartnerIDs(request, app.basicAuthPartnerIDHeaderKey)
+	if err != nil || len(partnerIDs) == 0 {
+		return nil, status.Error(codes.InvalidArgument, errGettingPartnerIDs.Error())
+	}
+
+	events, hash, err := app.getDeviceInfo(id, in.IncludeExpired)
+	if err != nil {
+		logging.Error(app.logger, emperror.Context(err)...).Log(logging.MessageKey(),
+			"Failed to get events", logging.ErrorKey(), err.Error())
+		return nil, grpcError(err)
+	}
+	events = filterPartners(events, partnerIDs)
+	warnings := countFailed(events)
+	if in.OmitFailed {
+		events = omitFailed(events)
+	}
+	events = app.redactor.forRequest(request, partnerIDs).events(events)
+	addAuditDevice(ctx, id, len(events))
+	return &gungnirpb.GetEventsResponse{
+		Events:   eventsToProto(events),
+		Hash:     hash,
+		Warnings: int32(warnings),
+	}, nil
+}
+
+// GetStatus returns the device's status, like the status endpoint.
+func (s *GRPCServer) GetStatus(ctx context.Context, in *gungnirpb.GetStatusRequest) (*gungnirpb.Status, error) {
+	app := s.apps.current.Load()
+	request := grpcRequest(ctx)
+	id, err := checkDevice(ctx, app, in.DeviceId, in.IncludeExpired)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := app.getStatusInfo(id, in.IncludeExpired)
+	if err != nil {
+		logging.Error(app.logger, emperror.Context(err)...).Log(logging.MessageKey(),
+			"Failed to get status info", logging.ErrorKey(), err.Error())
+		return nil, grpcError(err)
+	}
+	st = app.statusRedaction(request).status(st, app.status.Metadata)
+	addAuditDevice(ctx, id, 1)
+	return statusToProto(st)
+}
+
+// GetStatuses returns the statuses of up to MaxBatchSize devices.  Each
+// device is looked up on its own, so one that fails doesn't fail the others.
+func (s *GRPCServer) GetStatuses(ctx context.Context, in *gungnirpb.GetStatusesRequest) (*gungnirpb.GetStatusesResponse, error) {
+	if len(in.DeviceIds) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "device_ids is required")
+	}
+	if len(in.DeviceIds) > s.config.MaxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d device ids may be asked for, got %d", s.config.MaxBatchSize, len(in.DeviceIds))
+	}
+	app := s.apps.current.Load()
+	request := grpcRequest(ctx)
+	if in.IncludeExpired && !app.expiredRecords.allowed(request) {
+		// the devices asked for are audited, as checkDevice does for one
+		for _, deviceID := range in.DeviceIds {
+			if id, err := parseDeviceID(deviceID, app.deviceIDs.StripServiceSuffix); err == nil {
+				addAuditDevice(ctx, id, 0)
+			}
+		}
+		return nil, status.Error(codes.PermissionDenied, "not allowed to include expired records")
+	}
+	redaction := app.statusRedaction(request)
+
+	results := make([]*gungnirpb.StatusResult, 0, len(in.DeviceIds))
+	for _, deviceID := range in.DeviceIds {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		result := &gungnirpb.StatusResult{DeviceId: deviceID}
+		results = append(results, result)
+
+		id, err := parseDeviceID(deviceID, app.deviceIDs.StripServiceSuffix)
+		if err != nil {
+			result.Code, result.Error = int32(codes.InvalidArgument), err.Error()
+			continue
+		}
+		st, err := app.getStatusInfo(id, in.IncludeExpired)
+		if err == nil {
+			result.Status, err = statusToProto(redaction.status(st, app.status.Metadata))
+		}
+		if err != nil {
+			result.Code, result.Error = int32(status.Code(grpcError(err))), err.Error()
+			addAuditDevice(ctx, id, 0)
+			continue
+		}
+		addAuditDevice(ctx, id, 1)
+	}
+	return &gungnirpb.GetStatusesResponse{Results: results}, nil
+}
+
+// WatchEvents polls the device for new records every long poll sleep, and
+// sends the events the caller may see, oldest first.  Events that couldn't
+// be decrypted or decoded are left out.  The stream ends after the configured
+// MaxWatchDuration.
+func (s *GRPCServer) WatchEvents(in *gungnirpb.WatchEventsRequest, stream gungnirpb.Gungnir_WatchEventsServer) error {
+	ctx := stream.Context()
+	app := s.apps.current.Load()
+	request := grpcRequest(ctx)
+	id, err := checkDevice(ctx, app, in.DeviceId, in.IncludeExpired)
+	if err != nil {
+		return err
+	}
+	partnerIDs, err := extractPartnerIDs(request, app.basicAuthPartnerIDHeaderKey)
+	if err != nil || len(partnerIDs) == 0 {
+		return status.Error(codes.InvalidArgument, errGettingPartnerIDs.Error())
+	}
+
+	hash := in.After
+	if hash == "" {
+		// start from the newest record, so only new events are sent
+		records, err := app.eventGetter.GetRecords(id, app.getEventLimit, "")
+		if err == nil && len(records) > 0 {
+			hash, err = app.eventGetter.GetStateHash(records)
+		}
+		if err != nil {
+			return s.watchFailed(app, id, err)
+		}
+	}
+
+	expired := time.NewTimer(s.config.MaxWatchDuration)
+	defer expired.Stop()
+	sleep := app.longPollSleep
+	ticker := time.NewTicker(sleep)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-s.ctx.Done():
+			return status.Error(codes.Unavailable, "server is stopping")
+		case <-expired.C:
+			return nil
+		case <-ticker.C:
+		}
+
+		app = s.apps.current.Load()
+		if app.longPollSleep != sleep {
+			// the configuration was reloaded
+			sleep = app.longPollSleep
+			ticker.Reset(sleep)
+		}
+		records, err := app.eventGetter.GetRecords(id, app.getEventLimit, hash)
+		if err != nil {
+			return s.watchFailed(app, id, err)
+		}
+		if len(records) == 0 {
+			continue
+		}
+		if hash, err = app.eventGetter.GetStateHash(records); err != nil {
+			return s.watchFailed(app, id, err)
+		}
+
+		events := filterPartners(omitFailed(app.parseRecords(records, in.IncludeExpired)), partnerIDs)
+		if len(events) == 0 {
+			continue
+		}
+		sortEvents(events, false)
+		events = app.redactor.forRequest(request, partnerIDs).events(events)
+		if err := stream.Send(&gungnirpb.WatchEventsResponse{Events: eventsToProto(events), Hash: hash}); err != nil {
+			return err
+		}
+		addAuditDevice(ctx, id, len(events))
+	}
+}
+
+func (s *GRPCServer) watchFailed(app *App, deviceID string, err error) error {
+	logging.Error(app.logger).Log(logging.MessageKey(), "Failed to watch events", "device id", deviceID,
+		logging.ErrorKey(), err.Error())
+	return status.Error(codes.Internal, "failed to get events")
+}
+
+func eventsToProto(events []model.Event) []*gungnirpb.Event {
+	converted := make([]*gungnirpb.Event, 0, len(events))
+	for _, e := range events {
+		converted = append(converted, eventToProto(e))
+	}
+	return converted
+}
+
+func eventToProto(e model.Event) *gungnirpb.Event {
+	converted := &gungnirpb.Event{
+		MsgType:                 int32(e.Type),
+		Source:                  e.Source,
+		Dest:                    e.Destination,
+		TransactionUuid:         e.TransactionUUID,
+		ContentType:             e.ContentType,
+		Accept:                  e.Accept,
+		Status:                  e.Status,
+		RequestDeliveryResponse: e.RequestDeliveryResponse,
+		Headers:                 e.Headers,
+		Metadata:                e.Metadata,
+		Payload:                 e.Payload,
+		ServiceName:             e.ServiceName,
+		Url:                     e.URL,
+		PartnerIds:              e.PartnerIDs,
+		SessionId:               e.SessionID,
+		Qos:                     int32(e.QualityOfService),
+		BirthDate:               e.BirthDate,
+		DeathDate:               e.DeathDate,
+	}
+	if e.Error != nil {
+		converted.Error = &gungnirpb.EventError{Stage: e.Error.Stage, Reason: e.Error.Reason}
+	}
+	return converted
+}
+
+func statusToProto(s Status) (*gungnirpb.Status, error) {
+	converted := &gungnirpb.Status{
+		DeviceId:          s.DeviceID,
+		State:             s.State,
+		Since:             timestamppb.New(s.Since),
+		Now:               timestamppb.New(s.Now),
+		LastOfflineReason: s.LastOfflineReason,
+		PartnerIds:        s.PartnerIDs,
+		SessionId:         s.SessionID,
+		OnlineFor:         optionalSeconds(s.OnlineFor),
+		OfflineFor:        optionalSeconds(s.OfflineFor),
+		LastOnlineAt:      optionalTimestamp(s.LastOnlineAt),
+		LastOfflineAt:     optionalTimestamp(s.LastOfflineAt),
+		LastEventAt:       optionalTimestamp(s.LastEventAt),
+	}
+	if len(s.Metadata) > 0 {
+		m, err := structpb.NewStruct(s.Metadata)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to convert status metadata: %v", err)
+		}
+		converted.Metadata = m
+	}
+	return converted, nil
+}
+
+func optionalTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func optionalSeconds(seconds *int64) int64 {
+	if seconds == nil {
+		return 0
+	}
+	return *seconds
+}
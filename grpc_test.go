@@ -0,0 +1,457 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/justinas/alice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/bascule/basculechecks"
+	"github.com/xmidt-org/bascule/basculehttp"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/gungnir/gungnirpb"
+	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestValidateGRPCConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	config := GRPCConfig{}
+	assert.Empty(validateGRPCConfig(&config))
+	assert.Equal(GRPCConfig{}, config)
+
+	config = GRPCConfig{Address: ":7004"}
+	assert.Empty(validateGRPCConfig(&config))
+	assert.Equal(GRPCConfig{Address: ":7004", MaxBatchSize: defaultGRPCMaxBatchSize, MaxWatchDuration: defaultGRPCMaxWatchDuration}, config)
+
+	config = GRPCConfig{Address: "7004", KeyFile: "/etc/gungnir/private.pem", MaxBatchSize: -1, MaxWatchDuration: -time.Second}
+	errs := validateGRPCConfig(&config)
+	require.Len(t, errs, 4)
+	assert.Contains(errs[0].Error(), "grpc.address must be of the form host:port")
+	assert.Contains(errs[1].Error(), "grpc.certificateFile and grpc.keyFile must be set together")
+	assert.Contains(errs[2].Error(), "grpc.maxBatchSize must be positive")
+	assert.Contains(errs[3].Error(), "grpc.maxWatchDuration must be positive")
+}
+
+func TestNewGRPCServer(t *testing.T) {
+	s, err := NewGRPCServer(GRPCConfig{}, nil, alice.New(), logging.DefaultLogger(), nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, s)
+	assert.Nil(t, s.Start())
+	assert.Nil(t, s.Errors())
+	s.Stop()
+
+	_, err = NewGRPCServer(GRPCConfig{Address: ":7004", CertificateFile: "/does/not/exist.pem", KeyFile: "/does/not/exist.pem"},
+		nil, alice.New(), logging.DefaultLogger(), nil, nil)
+	assert.ErrorContains(t, err, "failed to load gRPC certificate")
+}
+
+// testGRPCAuth accepts basic auth for user:pass, like the REST auth chain.
+func testGRPCAuth() alice.Chain {
+	return alice.New(
+		basculehttp.NewConstructor(basculehttp.WithTokenFactory("Basic", basculehttp.BasicTokenFactory{"user": "pass"})),
+		basculehttp.NewEnforcer(basculehttp.WithRules("Basic", bascule.Validators{basculechecks.AllowAll()})),
+	)
+}
+
+// testGRPCContext returns a context with user:pass basic auth and the partner
+// ids, if there are any.
+func testGRPCContext(t *testing.T, partnerIDs string) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	md := []string{"authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))}
+	if partnerIDs != "" {
+		md = append(md, "x-codex-partner-ids", partnerIDs)
+	}
+	return metadata.AppendToOutgoingContext(ctx, md...)
+}
+
+// testGRPCClient serves the app over an in memory connection.
+func testGRPCClient(t *testing.T, app *App, auditor *Auditor) (gungnirpb.GungnirClient, *GRPCServer) {
+	s, err := NewGRPCServer(GRPCConfig{Address: "localhost:0", MaxBatchSize: 3}, NewReloadableApp(app), testGRPCAuth(),
+		logging.DefaultLogger(), app.measures, auditor)
+	require.Nil(t, err)
+	l := bufconn.Listen(1 << 20)
+	s.serve(l)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return gungnirpb.NewGungnirClient(conn), s
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	assert := assert.New(t)
+	p := xmetricstest.NewProvider(nil, Metrics)
+	app := testApp(NewMeasures(p))
+	app.eventGetter = new(mockRecordGetter)
+	client, _ := testGRPCClient(t, app, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := client.GetStatus(ctx, &gungnirpb.GetStatusRequest{DeviceId: "mac:112233445566"})
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("user:wrong")))
+	_, err = client.GetStatus(ctx, &gungnirpb.GetStatusRequest{DeviceId: "mac:112233445566"})
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	stream, err := client.WatchEvents(ctx, &gungnirpb.WatchEventsRequest{DeviceId: "mac:112233445566"})
+	require.Nil(t, err)
+	_, err = stream.Recv()
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+}
+
+func TestGRPCGetEvents(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	online, other := goodOnlineEvent, goodOnlineEvent
+	online.Type, other.Type = wrp.SimpleEventMessageType, wrp.SimpleEventMessageType
+	other.PartnerIDs = []string{"other"}
+	failed := testRecord(t, online, 100)
+	failed.KID = "missing"
+	mockGetter := new(mockRecordGetter)
+	records := []db.Record{testRecord(t, online, 300), testRecord(t, other, 200), failed}
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return(records, nil)
+	mockGetter.On("GetStateHash", records).Return("hash", nil)
+	mockGetter.On("GetRecords", "mac:665544332211", 5, "").Return([]db.Record{}, nil)
+
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.eventGetter = mockGetter
+	client, _ := testGRPCClient(t, app, nil)
+
+	resp, err := client.GetEvents(testGRPCContext(t, "test1"), &gungnirpb.GetEventsRequest{DeviceId: "MAC:11-22-33-44-55-66"})
+	require.Nil(err)
+	assert.Equal("hash", resp.Hash)
+	// the record that couldn't be decrypted has no partner ids, so it's only
+	// counted for callers allowed to see every partner's events
+	assert.Equal(int32(0), resp.Warnings)
+	require.Len(resp.Events, 1)
+	assert.Equal(int32(wrp.SimpleEventMessageType), resp.Events[0].MsgType)
+	assert.Equal(online.Destination, resp.Events[0].Dest)
+	assert.Equal(online.Payload, resp.Events[0].Payload)
+	assert.Equal([]string{"test1", "test2"}, resp.Events[0].PartnerIds)
+	assert.Equal(int64(300), resp.Events[0].BirthDate)
+
+	resp, err = client.GetEvents(testGRPCContext(t, "*"), &gungnirpb.GetEventsRequest{DeviceId: "mac:112233445566"})
+	require.Nil(err)
+	assert.Equal(int32(1), resp.Warnings)
+	assert.Len(resp.Events, 3)
+
+	tests := []struct {
+		description  string
+		partnerIDs   string
+		request      *gungnirpb.GetEventsRequest
+		expectedCode codes.Code
+	}{
+		{
+			description:  "Invalid Device ID",
+			partnerIDs:   "test1",
+			request:      &gungnirpb.GetEventsRequest{DeviceId: "bad"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			description:  "No Partner IDs",
+			request:      &gungnirpb.GetEventsRequest{DeviceId: "mac:112233445566"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			description:  "Expired Records Not Allowed",
+			partnerIDs:   "test1",
+			request:      &gungnirpb.GetEventsRequest{DeviceId: "mac:112233445566", IncludeExpired: true},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			description:  "No Events",
+			partnerIDs:   "test1",
+			request:      &gungnirpb.GetEventsRequest{DeviceId: "mac:665544332211"},
+			expectedCode: codes.NotFound,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := client.GetEvents(testGRPCContext(t, tc.partnerIDs), tc.request)
+			assert.Equal(tc.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func TestGRPCGetStatus(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	online := []db.Record{testRecord(t, goodOnlineEvent, time.Now().Add(-time.Minute).UnixNano())}
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecordsOfType", "mac:112233445566", 5, db.State, "").Return(online, nil)
+	mockGetter.On("GetRecordsOfType", "mac:665544332211", 5, db.State, "").Return([]db.Record{}, nil)
+
+	p := xmetricstest.NewProvider(nil, Metrics)
+	app := testApp(NewMeasures(p))
+	app.eventGetter = mockGetter
+	client, _ := testGRPCClient(t, app, nil)
+
+	resp, err := client.GetStatus(testGRPCContext(t, ""), &gungnirpb.GetStatusRequest{DeviceId: "mac:112233445566"})
+	require.Nil(err)
+	assert.Equal("mac:112233445566", resp.DeviceId)
+	assert.Equal(kindOnline, resp.State)
+	assert.Equal("54321", resp.SessionId)
+	assert.Equal(online[0].BirthDate, resp.Since.AsTime().UnixNano())
+	assert.Equal(online[0].BirthDate, resp.LastEventAt.AsTime().UnixNano())
+	assert.GreaterOrEqual(resp.OnlineFor, int64(60))
+	assert.Zero(resp.OfflineFor)
+	assert.Nil(resp.LastOfflineAt)
+
+	_, err = client.GetStatus(testGRPCContext(t, ""), &gungnirpb.GetStatusRequest{DeviceId: "mac:665544332211"})
+	assert.Equal(codes.NotFound, status.Code(err))
+	_, err = client.GetStatus(testGRPCContext(t, ""), &gungnirpb.GetStatusRequest{DeviceId: "bad"})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCGetStatuses(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	online := []db.Record{testRecord(t, goodOnlineEvent, time.Now().Add(-time.Minute).UnixNano())}
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecordsOfType", "mac:112233445566", 5, db.State, "").Return(online, nil)
+	mockGetter.On("GetRecordsOfType", "mac:665544332211", 5, db.State, "").Return([]db.Record{}, nil)
+
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.eventGetter = mockGetter
+	client, _ := testGRPCClient(t, app, nil)
+
+	resp, err := client.GetStatuses(testGRPCContext(t, ""), &gungnirpb.GetStatusesRequest{
+		DeviceIds: []string{"MAC:112233445566", "bad", "mac:665544332211"},
+	})
+	require.Nil(err)
+	require.Len(resp.Results, 3)
+
+	assert.Equal("MAC:112233445566", resp.Results[0].DeviceId)
+	assert.Equal(int32(codes.OK), resp.Results[0].Code)
+	require.NotNil(resp.Results[0].Status)
+	assert.Equal(kindOnline, resp.Results[0].Status.State)
+
+	assert.Equal(int32(codes.InvalidArgument), resp.Results[1].Code)
+	assert.Contains(resp.Results[1].Error, "invalid device id")
+	assert.Nil(resp.Results[1].Status)
+
+	assert.Equal(int32(codes.NotFound), resp.Results[2].Code)
+	assert.Nil(resp.Results[2].Status)
+
+	_, err = client.GetStatuses(testGRPCContext(t, ""), &gungnirpb.GetStatusesRequest{})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = client.GetStatuses(testGRPCContext(t, ""), &gungnirpb.GetStatusesRequest{
+		DeviceIds: []string{"mac:1", "mac:2", "mac:3", "mac:4"},
+	})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = client.GetStatuses(testGRPCContext(t, ""), &gungnirpb.GetStatusesRequest{
+		DeviceIds: []string{"mac:112233445566"}, IncludeExpired: true,
+	})
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+}
+
+func TestGRPCAudit(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	online := goodOnlineEvent
+	online.Type = wrp.SimpleEventMessageType
+	records := []db.Record{testRecord(t, online, 300)}
+	states := []db.Record{testRecord(t, goodOnlineEvent, time.Now().Add(-time.Minute).UnixNano())}
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return(records, nil)
+	mockGetter.On("GetStateHash", records).Return("hash", nil)
+	mockGetter.On("GetRecordsOfType", "mac:112233445566", 5, db.State, "").Return(states, nil)
+	mockGetter.On("GetRecordsOfType", "mac:665544332211", 5, db.State, "").Return([]db.Record{}, nil)
+
+	auditor, sink := testAuditor()
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.eventGetter = mockGetter
+	client, s := testGRPCClient(t, app, auditor)
+
+	_, err := client.GetEvents(testGRPCContext(t, "test1"), &gungnirpb.GetEventsRequest{DeviceId: "MAC:11-22-33-44-55-66"})
+	require.Nil(err)
+	_, err = client.GetStatuses(testGRPCContext(t, "test1"), &gungnirpb.GetStatusesRequest{
+		DeviceIds: []string{"mac:112233445566", "bad", "mac:665544332211"},
+	})
+	require.Nil(err)
+	_, err = client.GetStatus(testGRPCContext(t, "test1"), &gungnirpb.GetStatusRequest{DeviceId: "mac:665544332211"})
+	assert.Equal(codes.NotFound, status.Code(err))
+	_, err = client.GetStatuses(testGRPCContext(t, "test1"), &gungnirpb.GetStatusesRequest{
+		DeviceIds:      []string{"MAC:11-22-33-44-55-66", "bad"},
+		IncludeExpired: true,
+	})
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+
+	// calls rejected by authentication aren't audited
+	_, err = client.GetStatus(context.Background(), &gungnirpb.GetStatusRequest{DeviceId: "mac:112233445566"})
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
+	s.Stop()
+	auditor.Stop()
+	audited := sink.records()
+	require.Len(audited, 5)
+	for i := range audited {
+		assert.False(audited[i].Time.IsZero())
+		audited[i].Time, audited[i].LatencySeconds = time.Time{}, 0
+	}
+	record := func(method, deviceID string, results, code int) AuditRecord {
+		return AuditRecord{Principal: "user", AuthType: "basic", PartnerIDs: []string{"test1"}, DeviceID: deviceID,
+			Endpoint: grpcEndpoint, Method: method, ResultCount: results, StatusCode: code}
+	}
+	assert.Equal([]AuditRecord{
+		record("GetEvents", "mac:112233445566", 1, http.StatusOK),
+		record("GetStatuses", "mac:112233445566", 1, http.StatusOK),
+		record("GetStatuses", "mac:665544332211", 0, http.StatusOK),
+		record("GetStatus", "mac:665544332211", 0, http.StatusNotFound),
+		record("GetStatuses", "mac:112233445566", 0, http.StatusForbidden),
+	}, audited)
+}
+
+func TestGRPCWatchEvents(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	online, offline, other := goodOnlineEvent, goodOfflineEvent, goodOnlineEvent
+	online.Type, offline.Type, other.Type = wrp.SimpleEventMessageType, wrp.SimpleEventMessageType, wrp.SimpleEventMessageType
+	other.PartnerIDs = []string{"other"}
+	records := []db.Record{
+		testRecord(t, online, 300),
+		testRecord(t, other, 250),
+		testRecord(t, offline, 200),
+		testRecord(t, online, 100),
+	}
+
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return(records[3:], nil).Once()
+	mockGetter.On("GetStateHash", records[3:]).Return("first", nil).Once()
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "first").Return(records[:3], nil).Once()
+	mockGetter.On("GetStateHash", records[:3]).Return("second", nil).Once()
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "second").Return([]db.Record{}, nil).Maybe()
+
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.eventGetter = mockGetter
+	app.longPollSleep = 10 * time.Millisecond
+	client, s := testGRPCClient(t, app, nil)
+
+	stream, err := client.WatchEvents(testGRPCContext(t, "test1"), &gungnirpb.WatchEventsRequest{DeviceId: "mac:112233445566"})
+	require.Nil(err)
+	resp, err := stream.Recv()
+	require.Nil(err)
+	assert.Equal("second", resp.Hash)
+	// the events already stored when the watch started aren't sent, and
+	// the other partner's event is filtered out
+	require.Len(resp.Events, 2)
+	assert.Equal(int64(200), resp.Events[0].BirthDate)
+	assert.Equal(offline.Destination, resp.Events[0].Dest)
+	assert.Equal(int64(300), resp.Events[1].BirthDate)
+
+	// stopping the server ends the watch
+	s.Stop()
+	_, err = stream.Recv()
+	assert.Equal(codes.Unavailable, status.Code(err))
+	mockGetter.AssertExpectations(t)
+}
+
+// countingRecordGetter counts the GetRecords calls it answers.
+type countingRecordGetter struct {
+	mockRecordGetter
+	calls atomic.Int32
+}
+
+func (c *countingRecordGetter) GetRecords(deviceID string, limit int, stateHash string) ([]db.Record, error) {
+	c.calls.Add(1)
+	return c.mockRecordGetter.GetRecords(deviceID, limit, stateHash)
+}
+
+func TestGRPCWatchEventsReload(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	before, after := new(countingRecordGetter), new(countingRecordGetter)
+	before.On("GetRecords", "mac:112233445566", 5, "seen").Return([]db.Record{}, nil)
+	after.On("GetRecords", "mac:112233445566", 5, "seen").Return([]db.Record{}, nil)
+
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.eventGetter = before
+	app.longPollSleep = 10 * time.Millisecond
+	client, s := testGRPCClient(t, app, nil)
+	s.config.MaxWatchDuration = 300 * time.Millisecond
+
+	start := time.Now()
+	stream, err := client.WatchEvents(testGRPCContext(t, "*"), &gungnirpb.WatchEventsRequest{DeviceId: "mac:112233445566", After: "seen"})
+	require.Nil(err)
+	require.Eventually(func() bool { return before.calls.Load() >= 2 }, time.Second, 5*time.Millisecond)
+
+	// after a reload, the watch polls at the new long poll sleep
+	reloaded := *app
+	reloaded.eventGetter = after
+	reloaded.longPollSleep = time.Hour
+	s.apps.current.Store(&reloaded)
+
+	// and it ends after the max watch duration
+	_, err = stream.Recv()
+	assert.Equal(io.EOF, err)
+	assert.GreaterOrEqual(time.Since(start), 300*time.Millisecond)
+	assert.LessOrEqual(after.calls.Load(), int32(1))
+}
+
+func TestGRPCWatchEventsAfter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	online := goodOnlineEvent
+	online.Type = wrp.SimpleEventMessageType
+	records := []db.Record{testRecord(t, online, 300)}
+
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "seen").Return(records, nil).Once()
+	mockGetter.On("GetStateHash", records).Return("next", nil).Once()
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "next").Return([]db.Record{}, nil)
+
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.eventGetter = mockGetter
+	app.longPollSleep = 10 * time.Millisecond
+	client, _ := testGRPCClient(t, app, nil)
+
+	ctx, cancel := context.WithCancel(testGRPCContext(t, "*"))
+	stream, err := client.WatchEvents(ctx, &gungnirpb.WatchEventsRequest{DeviceId: "mac:112233445566", After: "seen"})
+	require.Nil(err)
+	resp, err := stream.Recv()
+	require.Nil(err)
+	assert.Equal("next", resp.Hash)
+	require.Len(resp.Events, 1)
+
+	cancel()
+	_, err = stream.Recv()
+	assert.Equal(codes.Canceled, status.Code(err))
+
+	stream, err = client.WatchEvents(testGRPCContext(t, ""), &gungnirpb.WatchEventsRequest{DeviceId: "mac:112233445566"})
+	require.Nil(err)
+	_, err = stream.Recv()
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,8 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+// Package gungnirpb holds the messages and service of gungnir's gRPC API,
+// generated from gungnir.proto.
+package gungnirpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative gungnir.proto
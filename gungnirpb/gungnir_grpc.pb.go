@@ -0,0 +1,264 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: gungnir.proto
+
+package gungnirpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Gungnir_GetEvents_FullMethodName   = "/gungnir.v1.Gungnir/GetEvents"
+	Gungnir_GetStatus_FullMethodName   = "/gungnir.v1.Gungnir/GetStatus"
+	Gungnir_GetStatuses_FullMethodName = "/gungnir.v1.Gungnir/GetStatuses"
+	Gungnir_WatchEvents_FullMethodName = "/gungnir.v1.Gungnir/WatchEvents"
+)
+
+// GungnirClient is the client API for Gungnir service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Gungnir serves the same device events and statuses as the REST API.  Every
+// call is authorized like a REST request, with the authorization metadata
+// holding a Basic or Bearer credential.
+type GungnirClient interface {
+	// GetEvents returns the device's events, filtered by the caller's partner
+	// ids.
+	GetEvents(ctx context.Context, in *GetEventsRequest, opts ...grpc.CallOption) (*GetEventsResponse, error)
+	// GetStatus returns the device's status.
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error)
+	// GetStatuses returns the statuses of several devices.  A device whose
+	// status can't be found doesn't fail the call; its result has the error.
+	GetStatuses(ctx context.Context, in *GetStatusesRequest, opts ...grpc.CallOption) (*GetStatusesResponse, error)
+	// WatchEvents sends the device's new events as they are stored, until the
+	// call is canceled or the server's grpc.maxWatchDuration passes.
+	WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEventsResponse], error)
+}
+
+type gungnirClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGungnirClient(cc grpc.ClientConnInterface) GungnirClient {
+	return &gungnirClient{cc}
+}
+
+func (c *gungnirClient) GetEvents(ctx context.Context, in *GetEventsRequest, opts ...grpc.CallOption) (*GetEventsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetEventsResponse)
+	err := c.cc.Invoke(ctx, Gungnir_GetEvents_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gungnirClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Status)
+	err := c.cc.Invoke(ctx, Gungnir_GetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gungnirClient) GetStatuses(ctx context.Context, in *GetStatusesRequest, opts ...grpc.CallOption) (*GetStatusesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetStatusesResponse)
+	err := c.cc.Invoke(ctx, Gungnir_GetStatuses_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gungnirClient) WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEventsResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Gungnir_ServiceDesc.Streams[0], Gungnir_WatchEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchEventsRequest, WatchEventsResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Gungnir_WatchEventsClient = grpc.ServerStreamingClient[WatchEventsResponse]
+
+// GungnirServer is the server API for Gungnir service.
+// All implementations must embed UnimplementedGungnirServer
+// for forward compatibility.
+//
+// Gungnir serves the same device events and statuses as the REST API.  Every
+// call is authorized like a REST request, with the authorization metadata
+// holding a Basic or Bearer credential.
+type GungnirServer interface {
+	// GetEvents returns the device's events, filtered by the caller's partner
+	// ids.
+	GetEvents(context.Context, *GetEventsRequest) (*GetEventsResponse, error)
+	// GetStatus returns the device's status.
+	GetStatus(context.Context, *GetStatusRequest) (*Status, error)
+	// GetStatuses returns the statuses of several devices.  A device whose
+	// status can't be found doesn't fail the call; its result has the error.
+	GetStatuses(context.Context, *GetStatusesRequest) (*GetStatusesResponse, error)
+	// WatchEvents sends the device's new events as they are stored, until the
+	// call is canceled or the server's grpc.maxWatchDuration passes.
+	WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[WatchEventsResponse]) error
+	mustEmbedUnimplementedGungnirServer()
+}
+
+// UnimplementedGungnirServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedGungnirServer struct{}
+
+func (UnimplementedGungnirServer) GetEvents(context.Context, *GetEventsRequest) (*GetEventsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEvents not implemented")
+}
+func (UnimplementedGungnirServer) GetStatus(context.Context, *GetStatusRequest) (*Status, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedGungnirServer) GetStatuses(context.Context, *GetStatusesRequest) (*GetStatusesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatuses not implemented")
+}
+func (UnimplementedGungnirServer) WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[WatchEventsResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+func (UnimplementedGungnirServer) mustEmbedUnimplementedGungnirServer() {}
+func (UnimplementedGungnirServer) testEmbeddedByValue()                 {}
+
+// UnsafeGungnirServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GungnirServer will
+// result in compilation errors.
+type UnsafeGungnirServer interface {
+	mustEmbedUnimplementedGungnirServer()
+}
+
+func RegisterGungnirServer(s grpc.ServiceRegistrar, srv GungnirServer) {
+	// If the following call pancis, it indicates UnimplementedGungnirServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Gungnir_ServiceDesc, srv)
+}
+
+func _Gungnir_GetEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEventsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GungnirServer).GetEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gungnir_GetEvents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GungnirServer).GetEvents(ctx, req.(*GetEventsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gungnir_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GungnirServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gungnir_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GungnirServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gungnir_GetStatuses_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GungnirServer).GetStatuses(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gungnir_GetStatuses_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GungnirServer).GetStatuses(ctx, req.(*GetStatusesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gungnir_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GungnirServer).WatchEvents(m, &grpc.GenericServerStream[WatchEventsRequest, WatchEventsResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Gungnir_WatchEventsServer = grpc.ServerStreamingServer[WatchEventsResponse]
+
+// Gungnir_ServiceDesc is the grpc.ServiceDesc for Gungnir service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Gungnir_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gungnir.v1.Gungnir",
+	HandlerType: (*GungnirServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetEvents",
+			Handler:    _Gungnir_GetEvents_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _Gungnir_GetStatus_Handler,
+		},
+		{
+			MethodName: "GetStatuses",
+			Handler:    _Gungnir_GetStatuses_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _Gungnir_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "gungnir.proto",
+}
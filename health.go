@@ -0,0 +1,291 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xmidt-org/clortho"
+)
+
+const (
+	defaultLivenessEndpoint  = "/live"
+	defaultReadinessEndpoint = "/ready"
+	defaultReadinessTimeout  = 2 * time.Second
+	healthShutdownTimeout    = 5 * time.Second
+)
+
+var (
+	errNoDecrypters = errors.New("no decrypters loaded")
+	errNoJWTKeys    = errors.New("no JWT keys fetched yet")
+)
+
+// ReadinessCheck reports whether one of gungnir's dependencies is ready for
+// requests.  It should give up once ctx is done.
+type ReadinessCheck func(ctx context.Context) error
+
+// CheckResult is the outcome of a single readiness check.
+type CheckResult struct {
+	Ready   bool          `json:"ready"`
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latency"`
+}
+
+// ReadinessReport is the body written by the readiness endpoint.
+type ReadinessReport struct {
+	Ready  bool                   `json:"ready"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Readiness is the readiness endpoint.  It runs every check concurrently,
+// giving them timeout to finish, and responds with 503 unless all of them
+// pass.
+type Readiness struct {
+	checks  map[string]ReadinessCheck
+	timeout time.Duration
+}
+
+// NewReadiness creates the readiness endpoint for the named checks.
+func NewReadiness(timeout time.Duration, checks map[string]ReadinessCheck) *Readiness {
+	return &Readiness{
+		checks:  checks,
+		timeout: timeout,
+	}
+}
+
+// Check runs every readiness check.
+func (r *Readiness) Check(ctx context.Context) ReadinessReport {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var (
+		lock   sync.Mutex
+		wg     sync.WaitGroup
+		report = ReadinessReport{Ready: true, Checks: make(map[string]CheckResult, len(r.checks))}
+	)
+	for name, check := range r.checks {
+		wg.Add(1)
+		go func(name string, check ReadinessCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			result := CheckResult{Ready: err == nil, Latency: time.Since(start)}
+			if err != nil {
+				result.Error = err.Error()
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			report.Checks[name] = result
+			report.Ready = report.Ready && result.Ready
+		}(name, check)
+	}
+	wg.Wait()
+	return report
+}
+
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	report := r.Check(req.Context())
+	code := http.StatusOK
+	if !report.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealthJSON(w, code, report)
+}
+
+// handleLiveness reports that the process is up and able to serve HTTP.  It
+// deliberately checks nothing else, so that a dependency outage doesn't get
+// gungnir restarted.
+func handleLiveness(w http.ResponseWriter, _ *http.Request) {
+	writeHealthJSON(w, http.StatusOK, map[string]bool{"alive": true})
+}
+
+func writeHealthJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// DecryptersReady fails when no decrypters are loaded, since every record
+// would then fail to decrypt.
+func DecryptersReady(d interface{ Len() int }) ReadinessCheck {
+	return func(context.Context) error {
+		if d.Len() == 0 {
+			return errNoDecrypters
+		}
+		return nil
+	}
+}
+
+// Pinger is a database connection that can check that it is usable.
+// cassandra.Connection implements this interface.
+type Pinger interface {
+	Ping() error
+}
+
+// DatabaseReady runs a lightweight query against the database, failing if it
+// doesn't complete before the readiness deadline.
+func DatabaseReady(db Pinger) ReadinessCheck {
+	p := &sharedPing{db: db}
+	return p.check
+}
+
+// sharedPing allows one Ping in flight at a time.  Ping doesn't take a
+// context, so a ping that outlives the readiness deadline is left to finish
+// on its own, and probes arriving meanwhile wait on it rather than starting
+// another one against a database that is already slow.
+type sharedPing struct {
+	db      Pinger
+	lock    sync.Mutex
+	current *pingCall
+}
+
+type pingCall struct {
+	done chan struct{}
+	err  error
+}
+
+func (p *sharedPing) check(ctx context.Context) error {
+	call := p.start()
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return fmt.Errorf("database ping failed: %w", call.err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("database ping: %w", ctx.Err())
+	}
+}
+
+// start returns the ping in flight, starting one if there isn't one.
+func (p *sharedPing) start() *pingCall {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.current != nil {
+		return p.current
+	}
+
+	call := &pingCall{done: make(chan struct{})}
+	p.current = call
+	go func() {
+		call.err = p.db.Ping()
+		p.lock.Lock()
+		p.current = nil
+		p.lock.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+// KeyRefreshStatus listens to the clortho refresher and records whether any
+// JWT keys have been fetched.
+type KeyRefreshStatus struct {
+	required bool
+	fetched  atomic.Bool
+	lastErr  atomic.Pointer[string]
+}
+
+// NewKeyRefreshStatus creates a KeyRefreshStatus for the refresh
+// configuration.  If no sources are configured the refresher never fetches
+// anything, so there is nothing to wait for.
+func NewKeyRefreshStatus(config clortho.RefreshConfig) *KeyRefreshStatus {
+	return &KeyRefreshStatus{required: len(config.Sources) > 0}
+}
+
+// OnRefreshEvent implements clortho.RefreshListener.
+func (k *KeyRefreshStatus) OnRefreshEvent(event clortho.RefreshEvent) {
+	if event.Err != nil {
+		msg := fmt.Sprintf("%s: %s", event.URI, event.Err)
+		k.lastErr.Store(&msg)
+		return
+	}
+	if len(event.Keys) > 0 {
+		k.fetched.Store(true)
+	}
+}
+
+// Ready is the ReadinessCheck for JWT keys.  Once keys have been fetched it
+// stays ready, as the refresher keeps serving the last good keys when a
+// later fetch fails.
+func (k *KeyRefreshStatus) Ready(context.Context) error {
+	if !k.required || k.fetched.Load() {
+		return nil
+	}
+	if msg := k.lastErr.Load(); msg != nil {
+		return fmt.Errorf("%w: last refresh failed: %s", errNoJWTKeys, *msg)
+	}
+	return errNoJWTKeys
+}
+
+// HealthServer serves the health, liveness and readiness endpoints on their
+// own port.
+type HealthServer struct {
+	server *http.Server
+	addr   net.Addr
+	errs   chan error
+}
+
+// StartHealthServer binds to port and starts serving handler.  An
+// error binding the port is returned immediately; an error while serving is
+// sent on Errors.
+func StartHealthServer(port string, handler http.Handler) (*HealthServer, error) {
+	listener, err := net.Listen("tcp", port)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &HealthServer{
+		server: &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: 3 * time.Second,
+		},
+		addr: listener.Addr(),
+		errs: make(chan error, 1),
+	}
+	go func() {
+		if err := h.server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			h.errs <- err
+		}
+	}()
+	return h, nil
+}
+
+// Addr returns the address the server is listening on.
+func (h *HealthServer) Addr() string {
+	return h.addr.String()
+}
+
+// Errors receives the error if the server stops unexpectedly.
+func (h *HealthServer) Errors() <-chan error {
+	return h.errs
+}
+
+// Shutdown stops the server, waiting up to healthShutdownTimeout for requests
+// in progress to finish.
+func (h *HealthServer) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthShutdownTimeout)
+	defer cancel()
+	return h.server.Shutdown(ctx)
+}
+
+// checkNames returns the names of the checks in a stable order, for logging.
+func (r *Readiness) checkNames() []string {
+	names := make([]string, 0, len(r.checks))
+	for name := range r.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/clortho"
+)
+
+type testPinger struct {
+	delay time.Duration
+	err   error
+}
+
+func (p testPinger) Ping() error {
+	time.Sleep(p.delay)
+	return p.err
+}
+
+type testLen int
+
+func (l testLen) Len() int {
+	return int(l)
+}
+
+func TestReadiness(t *testing.T) {
+	pingErr := errors.New("ping test error")
+	ready := func(context.Context) error { return nil }
+
+	tests := []struct {
+		description    string
+		checks         map[string]ReadinessCheck
+		expectedCode   int
+		expectedFailed map[string]string
+	}{
+		{
+			description: "Ready",
+			checks: map[string]ReadinessCheck{
+				"decrypters": DecryptersReady(testLen(1)),
+				"database":   DatabaseReady(testPinger{}),
+				"other":      ready,
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			description: "No Decrypters",
+			checks: map[string]ReadinessCheck{
+				"decrypters": DecryptersReady(testLen(0)),
+				"database":   DatabaseReady(testPinger{}),
+			},
+			expectedCode:   http.StatusServiceUnavailable,
+			expectedFailed: map[string]string{"decrypters": errNoDecrypters.Error()},
+		},
+		{
+			description: "Database Error",
+			checks: map[string]ReadinessCheck{
+				"decrypters": DecryptersReady(testLen(1)),
+				"database":   DatabaseReady(testPinger{err: pingErr}),
+			},
+			expectedCode:   http.StatusServiceUnavailable,
+			expectedFailed: map[string]string{"database": pingErr.Error()},
+		},
+		{
+			description: "Database Timeout",
+			checks: map[string]ReadinessCheck{
+				"database": DatabaseReady(testPinger{delay: time.Second}),
+			},
+			expectedCode:   http.StatusServiceUnavailable,
+			expectedFailed: map[string]string{"database": context.DeadlineExceeded.Error()},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			rr := httptest.NewRecorder()
+			start := time.Now()
+			NewReadiness(50*time.Millisecond, tc.checks).ServeHTTP(rr, httptest.NewRequest("GET", "/ready", nil))
+			assert.Less(time.Since(start), time.Second)
+			assert.Equal(tc.expectedCode, rr.Code)
+			assert.Equal("application/json", rr.Header().Get("Content-Type"))
+
+			var report ReadinessReport
+			require.Nil(json.Unmarshal(rr.Body.Bytes(), &report))
+			assert.Equal(tc.expectedCode == http.StatusOK, report.Ready)
+			require.Len(report.Checks, len(tc.checks))
+			for name, result := range report.Checks {
+				expected, failed := tc.expectedFailed[name]
+				assert.Equal(!failed, result.Ready, name)
+				if failed {
+					assert.Contains(result.Error, expected, name)
+				}
+			}
+		})
+	}
+}
+
+// countingPinger counts its pings, blocking each one until release is
+// closed.
+type countingPinger struct {
+	pings   atomic.Int32
+	release chan struct{}
+}
+
+func (p *countingPinger) Ping() error {
+	p.pings.Add(1)
+	<-p.release
+	return nil
+}
+
+func TestDatabaseReadySinglePing(t *testing.T) {
+	assert := assert.New(t)
+	p := &countingPinger{release: make(chan struct{})}
+	check := DatabaseReady(p)
+
+	// probes that time out while the ping hangs don't start more pings
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		assert.ErrorIs(check(ctx), context.DeadlineExceeded)
+		cancel()
+	}
+	assert.Equal(int32(1), p.pings.Load())
+
+	// a probe waiting when the ping finishes gets its result
+	result := make(chan error, 1)
+	go func() {
+		result <- check(context.Background())
+	}()
+	close(p.release)
+	assert.Nil(<-result)
+
+	// once it has finished the next probe pings again
+	pings := p.pings.Load()
+	assert.Nil(check(context.Background()))
+	assert.Equal(pings+1, p.pings.Load())
+}
+
+func TestHandleLiveness(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handleLiveness(rr, httptest.NewRequest("GET", "/live", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"alive":true}`, rr.Body.String())
+}
+
+func TestKeyRefreshStatus(t *testing.T) {
+	assert := assert.New(t)
+	refreshErr := errors.New("refresh test error")
+	sources := clortho.RefreshConfig{Sources: []clortho.RefreshSource{{URI: "http://keys.example.com"}}}
+
+	assert.Nil(NewKeyRefreshStatus(clortho.RefreshConfig{}).Ready(context.Background()), "no sources means nothing to wait for")
+
+	k := NewKeyRefreshStatus(sources)
+	assert.ErrorIs(k.Ready(context.Background()), errNoJWTKeys)
+
+	k.OnRefreshEvent(clortho.RefreshEvent{URI: "http://keys.example.com", Err: refreshErr})
+	err := k.Ready(context.Background())
+	assert.ErrorIs(err, errNoJWTKeys)
+	assert.Contains(err.Error(), refreshErr.Error())
+
+	k.OnRefreshEvent(clortho.RefreshEvent{URI: "http://keys.example.com", Keys: clortho.Keys{nil}})
+	assert.Nil(k.Ready(context.Background()))
+
+	// a later failure doesn't matter, the last good keys are still used
+	k.OnRefreshEvent(clortho.RefreshEvent{URI: "http://keys.example.com", Err: refreshErr})
+	assert.Nil(k.Ready(context.Background()))
+}
+
+func TestHealthServer(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live", handleLiveness)
+	h, err := StartHealthServer("127.0.0.1:0", mux)
+	require.Nil(err)
+
+	// binding the same port again fails right away
+	_, err = StartHealthServer(h.Addr(), mux)
+	assert.NotNil(err)
+
+	resp, err := http.Get("http://" + h.Addr() + "/live")
+	require.Nil(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	assert.Nil(h.Shutdown())
+	select {
+	case err := <-h.Errors():
+		assert.Fail("unexpected server error", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	_, err = http.Get("http://" + h.Addr() + "/live")
+	assert.NotNil(err)
+}
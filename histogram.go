@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/goph/emperror"
+	"github.com/xmidt-org/gungnir/model"
+	"github.com/xmidt-org/webpa-common/v2/logging" //nolint: staticcheck
+)
+
+const defaultHistogramBucket = time.Hour
+
+// Histogram counts a device's events per time bucket.
+type Histogram struct {
+	DeviceID string            `json:"device_id"`
+	Bucket   string            `json:"bucket"`
+	Total    int               `json:"total"`
+	Buckets  []HistogramBucket `json:"buckets"`
+}
+
+// HistogramBucket counts the events born in [Start, Start+bucket), broken
+// down by wrp message type and destination prefix.
+type HistogramBucket struct {
+	Start        time.Time      `json:"start"`
+	Count        int            `json:"count"`
+	Types        map[string]int `json:"types"`
+	Destinations map[string]int `json:"destinations"`
+}
+
+// buildHistogram counts the events per bucket.  Only buckets with events are
+// included, oldest first.
+func buildHistogram(events []model.Event, bucket time.Duration) []HistogramBucket {
+	byStart := map[int64]*HistogramBucket{}
+	for _, e := range events {
+		start := time.Unix(0, e.BirthDate).UTC().Truncate(bucket)
+		b, ok := byStart[start.UnixNano()]
+		if !ok {
+			b = &HistogramBucket{Start: start, Types: map[string]int{}, Destinations: map[string]int{}}
+			byStart[start.UnixNano()] = b
+		}
+		b.Count++
+		b.Types[e.Type.FriendlyName()]++
+		b.Destinations[destinationPrefix(e.Destination)]++
+	}
+
+	buckets := make([]HistogramBucket, 0, len(byStart))
+	for _, b := range byStart {
+		buckets = append(buckets, *b)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+	return buckets
+}
+
+// destinationPrefix is the destination up to the first /, such as
+// event:device-status, so events about the same thing are counted together
+// regardless of the device and the details that follow.
+func destinationPrefix(destination string) string {
+	prefix, _, _ := strings.Cut(destination, "/")
+	return prefix
+}
+
+/*
+ * swagger:route GET /device/{deviceID}/events/histogram device getEventHistogram
+ *
+ * Get a histogram of the events for a device.
+ *
+ * Counts the events that would be returned by the events endpoint per time
+ * bucket, broken down by message type and destination prefix.  The bucket
+ * query parameter is a duration such as 15m or 1h, and defaults to 1h.
+ *
+ * Parameters: deviceID, bucket, include_expired, omit_failed
+ *
+ * Produces:
+ *    - application/json
+ *
+ * Schemes: https
+ *
+ * Security:
+ *    bearer_token:
+ *
+ * Responses:
+ *    200: HistogramResponse
+ *    400: ErrResponse
+ *    403: ErrResponse
+ *    404: ErrResponse
+ *    500: ErrResponse
+ *
+ */
+func (app *App) handleGetEventHistogram(writer http.ResponseWriter, request *http.Request) {
+	id, ok := app.requestDeviceID(writer, request)
+	if !ok {
+		return
+	}
+
+	requestPartnerIDs, err := extractPartnerIDs(request, app.basicAuthPartnerIDHeaderKey)
+	if err != nil || len(requestPartnerIDs) == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	includeExpired, ok := app.requestIncludeExpired(writer, request)
+	if !ok {
+		return
+	}
+
+	omit, err := requestOmitFailed(request)
+	if err != nil {
+		writer.Header().Add("X-Codex-Error", err.Error())
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	bucket := defaultHistogramBucket
+	if b := request.FormValue("bucket"); b != "" {
+		if bucket, err = time.ParseDuration(b); err != nil || bucket <= 0 {
+			writer.Header().Add("X-Codex-Error", fmt.Sprintf("invalid bucket value %q, expected a positive duration such as 1h", b))
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, _, err := app.getDeviceInfo(id, includeExpired)
+	if err != nil {
+		logging.Error(app.logger, emperror.Context(err)...).Log(logging.MessageKey(),
+			"Failed to get events", logging.ErrorKey(), err.Error())
+		writer.Header().Add("X-Codex-Error", err.Error())
+		var coder kithttp.StatusCoder
+		if errors.As(err, &coder) {
+			writer.WriteHeader(coder.StatusCode())
+			return
+		}
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	filtered := filterPartners(events, requestPartnerIDs)
+	warnings := countFailed(filtered)
+	if omit {
+		filtered = omitFailed(filtered)
+	}
+	addAuditDevice(request.Context(), id, len(filtered))
+	histogram := Histogram{
+		DeviceID: id,
+		Bucket:   bucket.String(),
+		Total:    len(filtered),
+		Buckets:  buildHistogram(filtered, bucket),
+	}
+
+	data, err := json.Marshal(&histogram)
+	if err != nil {
+		writer.Header().Add("X-Codex-Error", err.Error())
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set(warningsHeader, strconv.Itoa(warnings))
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(data)
+}
@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/gungnir/model"
+	"github.com/xmidt-org/voynicrypto"
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestBuildHistogram(t *testing.T) {
+	assert := assert.New(t)
+	base := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	event := func(offset time.Duration, msgType wrp.MessageType, destination string) model.Event {
+		return model.Event{
+			Message:   wrp.Message{Type: msgType, Destination: destination},
+			BirthDate: base.Add(offset).UnixNano(),
+		}
+	}
+	events := []model.Event{
+		event(2*time.Hour+time.Minute, wrp.SimpleEventMessageType, "event:device-status/mac:112233445566/online"),
+		event(59*time.Minute, wrp.SimpleEventMessageType, "event:device-status/mac:112233445566/offline"),
+		event(time.Minute, wrp.SimpleEventMessageType, "event:device-status/mac:112233445566/online"),
+		event(0, wrp.SimpleRequestResponseMessageType, "event:config"),
+	}
+
+	assert.Equal([]HistogramBucket{
+		{
+			Start:        base,
+			Count:        3,
+			Types:        map[string]int{"SimpleEvent": 2, "SimpleRequestResponse": 1},
+			Destinations: map[string]int{"event:device-status": 2, "event:config": 1},
+		},
+		{
+			Start:        base.Add(2 * time.Hour),
+			Count:        1,
+			Types:        map[string]int{"SimpleEvent": 1},
+			Destinations: map[string]int{"event:device-status": 1},
+		},
+	}, buildHistogram(events, time.Hour))
+
+	buckets := buildHistogram(events, 30*time.Minute)
+	assert.Len(buckets, 3)
+	assert.Equal(base.Add(30*time.Minute), buckets[1].Start)
+
+	assert.Empty(buildHistogram(nil, time.Hour))
+}
+
+func TestHandleGetEventHistogram(t *testing.T) {
+	futureTime := time.Now().Add(time.Duration(50000) * time.Minute).UnixNano()
+	birthDate := time.Date(2025, 3, 1, 10, 15, 0, 0, time.UTC).UnixNano()
+	encode := func(msg wrp.Message) []byte {
+		var data []byte
+		require.Nil(t, wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(&msg))
+		return data
+	}
+	records := []db.Record{
+		{Type: db.State, BirthDate: birthDate, DeathDate: futureTime, Data: encode(goodOnlineEvent), Alg: string(voynicrypto.None), KID: "none"},
+		{Type: db.State, BirthDate: birthDate - int64(time.Hour), DeathDate: futureTime, Data: encode(goodOfflineEvent), Alg: string(voynicrypto.None), KID: "none"},
+		{Type: db.State, BirthDate: birthDate, DeathDate: futureTime, Data: encode(wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Destination: "event:other/thing",
+			PartnerIDs:  []string{"other"},
+		}), Alg: string(voynicrypto.None), KID: "none"},
+		{Type: db.State, BirthDate: birthDate, DeathDate: futureTime, Data: encode(goodOnlineEvent), Alg: string(voynicrypto.Box), KID: "missing"},
+	}
+
+	tests := []struct {
+		description        string
+		bucket             string
+		recordsToReturn    []db.Record
+		expectedStatusCode int
+		expectedTotal      int
+		expectedBuckets    int
+	}{
+		{
+			description:        "Success",
+			recordsToReturn:    records,
+			expectedStatusCode: http.StatusOK,
+			expectedTotal:      2,
+			expectedBuckets:    2,
+		},
+		{
+			description:        "Wide Bucket",
+			bucket:             "24h",
+			recordsToReturn:    records,
+			expectedStatusCode: http.StatusOK,
+			expectedTotal:      2,
+			expectedBuckets:    1,
+		},
+		{
+			description:        "Invalid Bucket",
+			bucket:             "hourly",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Negative Bucket",
+			bucket:             "-1h",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "No Events",
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			mockGetter := new(mockRecordGetter)
+			mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return(tc.recordsToReturn, nil).Maybe()
+			mockGetter.On("GetStateHash", mock.Anything).Return("123", nil).Maybe()
+			app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+			app.eventGetter = mockGetter
+
+			auth := bascule.Authentication{
+				Token: bascule.NewToken("jwt", "owner", bascule.NewAttributes(map[string]interface{}{
+					"allowedResources": map[string]interface{}{"allowedPartners": []string{"test1"}},
+				})),
+			}
+			request, err := http.NewRequestWithContext(bascule.WithAuthentication(context.Background(), auth),
+				http.MethodGet, "/device/mac:112233445566/events/histogram?bucket="+tc.bucket, nil)
+			require.Nil(err)
+			request = mux.SetURLVars(request, map[string]string{"deviceID": "mac:112233445566"})
+			rr := httptest.NewRecorder()
+			app.handleGetEventHistogram(rr, request)
+			require.Equal(tc.expectedStatusCode, rr.Code)
+			if tc.expectedStatusCode != http.StatusOK {
+				assert.NotEmpty(rr.Header().Get("X-Codex-Error"))
+				return
+			}
+
+			var histogram Histogram
+			require.Nil(json.Unmarshal(rr.Body.Bytes(), &histogram))
+			assert.Equal("mac:112233445566", histogram.DeviceID)
+			// neither the other partner's event nor the record that couldn't
+			// be decrypted is counted
+			assert.Equal(tc.expectedTotal, histogram.Total)
+			assert.Equal("0", rr.Header().Get(warningsHeader))
+			assert.Len(histogram.Buckets, tc.expectedBuckets)
+		})
+	}
+}
@@ -5,14 +5,20 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
-	olog "log"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"reflect"
+	"regexp"
 	"runtime"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/xmidt-org/clortho"
@@ -29,6 +35,7 @@ import (
 	"github.com/go-kit/log"
 	"github.com/goph/emperror"
 	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
@@ -58,6 +65,7 @@ type Config struct {
 	Db                          cassandra.Config
 	GetEventsLimit              int
 	GetStatusLimit              int
+	DecodeParallelism           int
 	Health                      HealthConfig
 	AuthHeader                  []string
 	JwtValidator                JWTValidator
@@ -67,11 +75,37 @@ type Config struct {
 	LongPollSleep               time.Duration
 	LongPollTimeout             time.Duration
 	BasicAuthPartnerIDHeaderKey string
+	CipherReload                CipherReloadConfig
+	WatchConfig                 bool
+	Admin                       AdminConfig
+	PayloadDecoders             PayloadDecodersConfig
+	Redaction                   RedactionConfig
+	Audit                       AuditConfig
+	Status                      StatusConfig
+	DeviceID                    DeviceIDConfig
+	ExpiredRecords              ExpiredRecordsConfig
+	Webhooks                    WebhooksConfig
+	Exports                     ExportsConfig
+	Replay                      ReplayConfig
+	GRPC                        GRPCConfig
+	GraphQL                     GraphQLConfig
 }
 
 type HealthConfig struct {
 	Port     string
 	Endpoint string
+
+	// LivenessEndpoint reports whether the process is up.  Defaults to /live.
+	LivenessEndpoint string
+
+	// ReadinessEndpoint reports whether gungnir can serve requests: decrypters
+	// are loaded, JWT keys have been fetched and the database answers.
+	// Defaults to /ready.
+	ReadinessEndpoint string
+
+	// ReadinessTimeout bounds how long the readiness checks may take.
+	// Defaults to 2s.
+	ReadinessTimeout time.Duration
 }
 
 type CapabilityConfig struct {
@@ -111,12 +145,12 @@ func gungnir(arguments []string) {
 
 	var (
 		f, v                                = pflag.NewFlagSet(applicationName, pflag.ContinueOnError), viper.New()
-		logger, metricsRegistry, codex, err = server.Initialize(applicationName, arguments, f, v, cassandra.Metrics, dbretry.Metrics, basculechecks.Metrics, basculemetrics.Metrics)
+		logger, metricsRegistry, codex, err = server.Initialize(applicationName, arguments, f, v, Metrics, cassandra.Metrics, dbretry.Metrics, basculechecks.Metrics, basculemetrics.Metrics)
 	)
 
-	if parseErr, done := printVersion(f, arguments); done {
+	if parseErr, done := printVersion(f, v, arguments); done {
 		// if we're done, we're exiting no matter what
-		exitIfError(logger, emperror.Wrap(parseErr, "failed to parse arguments"))
+		exitIfError(logger, emperror.Wrap(parseErr, "failed to process arguments"))
 		os.Exit(0)
 	}
 
@@ -126,52 +160,134 @@ func gungnir(arguments []string) {
 	serverHealth := health.New()
 	serverHealth.Logger = healthlogger.NewHealthLogger(logger)
 
-	config := new(Config)
-	v.Unmarshal(config)
-	validateConfig(config)
+	config, cipherOptions, err := loadConfig(v)
+	exitIfError(logger, emperror.Wrap(err, "invalid configuration"))
 
 	database, err := cassandra.CreateDbConnection(config.Db, metricsRegistry, serverHealth)
 	exitIfError(logger, emperror.Wrap(err, "failed to initialize database connection"))
 
-	cipherOptions, err := voynicrypto.FromViper(v)
-	exitIfError(logger, emperror.Wrap(err, "failed to initialize cipher config"))
-	decrypters := voynicrypto.PopulateCiphers(cipherOptions, logger)
+	measures := NewMeasures(metricsRegistry)
+
+	decrypters := NewCipherReloader(cipherOptions, NewFileCipherLoader(v.ConfigFileUsed()), v.ConfigFileUsed(), config.CipherReload.GracePeriod, logger, measures)
+	stopCipherWatch := make(chan struct{})
+	if config.CipherReload.Interval > 0 {
+		go decrypters.Watch(config.CipherReload.Interval, stopCipherWatch)
+	}
+	err = serverHealth.AddCheck(&health.Config{
+		Name:     "ciphers",
+		Checker:  decrypters,
+		Interval: cipherHealthInterval,
+	})
+	exitIfError(logger, emperror.Wrap(err, "failed to add cipher health check"))
+
+	payloadDecoders, err := NewPayloadDecoders(config.PayloadDecoders)
+	exitIfError(logger, emperror.Wrap(err, "failed to create payload decoders"))
+
+	redactor, err := NewRedactor(config.Redaction)
+	exitIfError(logger, emperror.Wrap(err, "failed to create redaction policies"))
+
+	auditor, err := NewAuditor(config.Audit, logger, measures)
+	exitIfError(logger, emperror.Wrap(err, "failed to create auditor"))
+
+	webhooks, err := NewWebhooks(config.Webhooks, logger, measures, auditor)
+	exitIfError(logger, emperror.Wrap(err, "failed to create webhooks"))
+
+	exports, err := NewExports(config.Exports, logger, measures, auditor)
+	exitIfError(logger, emperror.Wrap(err, "failed to create exports"))
 
-	gungnirHandler, err := authChain(config.AuthHeader, config.JwtValidator, config.TouchStone, config.Zap, config.CapabilityCheck, logger, metricsRegistry)
+	replayer, err := NewReplayer(config.Replay, measures)
+	exitIfError(logger, emperror.Wrap(err, "failed to create replayer"))
+
+	graphQL, err := NewGraphQL(config.GraphQL)
+	exitIfError(logger, emperror.Wrap(err, "failed to create GraphQL endpoint"))
+
+	gungnirHandler, authSettings, err := authChain(config.AuthHeader, config.JwtValidator, config.TouchStone, config.Zap, config.CapabilityCheck, logger, metricsRegistry)
 	exitIfError(logger, emperror.Wrap(err, "failed to setup auth chain"))
 
 	router := mux.NewRouter()
-	measures := NewMeasures(metricsRegistry)
 	// MARK: Actual server logic
 	app := &App{
-		eventGetter:                 database,
+		eventGetter:                 NewMeasuredRecordGetter(database, measures),
 		logger:                      logger,
 		getEventLimit:               config.GetEventsLimit,
+		decodeParallelism:           config.DecodeParallelism,
 		getStatusLimit:              config.GetStatusLimit,
 		longPollSleep:               config.LongPollSleep,
 		longPollTimeout:             config.LongPollTimeout,
 		decrypters:                  decrypters,
 		measures:                    measures,
 		basicAuthPartnerIDHeaderKey: config.BasicAuthPartnerIDHeaderKey,
+		admin:                       config.Admin,
+		payloadDecoders:             payloadDecoders,
+		redactor:                    redactor,
+		status:                      config.Status,
+		deviceIDs:                   config.DeviceID,
+		expiredRecords:              config.ExpiredRecords,
+		webhooks:                    webhooks,
+		exports:                     exports,
+		replayer:                    replayer,
+		graphql:                     graphQL,
 	}
 
-	router.Handle(apiBase+"/device/{deviceID}/events", gungnirHandler.ThenFunc(app.handleGetEvents))
-	router.Handle(apiBase+"/device/{deviceID}/status", gungnirHandler.ThenFunc(app.handleGetStatus))
+	reloadableApp := NewReloadableApp(app)
+	webhooks.Start(reloadableApp)
+	exports.Start(reloadableApp)
+	grpcServer, err := NewGRPCServer(config.GRPC, reloadableApp, gungnirHandler, logger, measures, auditor)
+	exitIfError(logger, emperror.Wrap(err, "failed to create gRPC server"))
+	exitIfError(logger, emperror.Wrap(grpcServer.Start(), "unable to start gRPC server"))
+	if grpcServer != nil {
+		logging.Info(logger).Log(logging.MessageKey(), "gRPC server started", "address", config.GRPC.Address)
+	}
+	configReloader := NewConfigReloader(v, reloadableApp, authSettings, logger, measures)
+	if config.WatchConfig {
+		exitIfError(logger, configReloader.Watch())
+	}
 
+	router.Handle(apiBase+"/device/"+deviceIDRouteVar+"/events/histogram", alice.New(InstrumentRequest(measures, histogramEndpoint)).Extend(gungnirHandler).Append(AuditRequest(auditor, histogramEndpoint, config.BasicAuthPartnerIDHeaderKey)).Then(reloadableApp.Handle((*App).handleGetEventHistogram)))
+	router.Handle(apiBase+"/device/"+deviceIDRouteVar+"/events", alice.New(InstrumentRequest(measures, eventsEndpoint)).Extend(gungnirHandler).Append(AuditRequest(auditor, eventsEndpoint, config.BasicAuthPartnerIDHeaderKey)).Then(reloadableApp.Handle((*App).handleGetEvents)))
+	router.Handle(apiBase+"/device/"+deviceIDRouteVar+"/status", alice.New(InstrumentRequest(measures, statusEndpoint)).Extend(gungnirHandler).Append(AuditRequest(auditor, statusEndpoint, config.BasicAuthPartnerIDHeaderKey)).Then(reloadableApp.Handle((*App).handleGetStatus)))
+	router.Handle(apiBase+"/admin/device/"+deviceIDRouteVar+"/explain", alice.New(InstrumentRequest(measures, explainEndpoint)).Extend(gungnirHandler).Append(AuditRequest(auditor, explainEndpoint, config.BasicAuthPartnerIDHeaderKey)).Then(reloadableApp.Handle((*App).handleExplainRecords)))
+	if replayer != nil {
+		router.Handle(apiBase+"/admin/device/"+deviceIDRouteVar+"/replay", alice.New(InstrumentRequest(measures, replayEndpoint)).Extend(gungnirHandler).Append(AuditRequest(auditor, replayEndpoint, config.BasicAuthPartnerIDHeaderKey)).Then(reloadableApp.Handle((*App).handleReplay))).Methods(http.MethodPost)
+	}
+	if graphQL != nil {
+		router.Handle(apiBase+"/graphql", alice.New(InstrumentRequest(measures, graphqlEndpoint)).Extend(gungnirHandler).Append(AuditRequest(auditor, graphqlEndpoint, config.BasicAuthPartnerIDHeaderKey)).Then(reloadableApp.Handle((*App).handleGraphQL))).Methods(http.MethodPost)
+	}
+	if webhooks != nil {
+		webhooksChain := alice.New(InstrumentRequest(measures, webhooksEndpoint)).Extend(gungnirHandler).Append(AuditRequest(auditor, webhooksEndpoint, config.BasicAuthPartnerIDHeaderKey))
+		router.Handle(apiBase+"/webhooks", webhooksChain.Then(reloadableApp.Handle((*App).handleCreateWebhook))).Methods(http.MethodPost)
+		router.Handle(apiBase+"/webhooks", webhooksChain.Then(reloadableApp.Handle((*App).handleListWebhooks))).Methods(http.MethodGet)
+		router.Handle(apiBase+"/webhooks/"+webhookIDRouteVar, webhooksChain.Then(reloadableApp.Handle((*App).handleGetWebhook))).Methods(http.MethodGet)
+		router.Handle(apiBase+"/webhooks/"+webhookIDRouteVar, webhooksChain.Then(reloadableApp.Handle((*App).handleDeleteWebhook))).Methods(http.MethodDelete)
+		router.Handle(apiBase+"/webhooks/"+webhookIDRouteVar+"/deadletters", webhooksChain.Then(reloadableApp.Handle((*App).handleGetWebhookDeadLetters))).Methods(http.MethodGet)
+	}
+	if exports != nil {
+		exportsChain := alice.New(InstrumentRequest(measures, exportsEndpoint)).Extend(gungnirHandler).Append(AuditRequest(auditor, exportsEndpoint, config.BasicAuthPartnerIDHeaderKey))
+		router.Handle(apiBase+"/exports", exportsChain.Then(reloadableApp.Handle((*App).handleCreateExport))).Methods(http.MethodPost)
+		router.Handle(apiBase+"/exports/"+exportIDRouteVar, exportsChain.Then(reloadableApp.Handle((*App).handleGetExport))).Methods(http.MethodGet)
+		router.Handle(apiBase+"/exports/"+exportIDRouteVar, exportsChain.Then(reloadableApp.Handle((*App).handleCancelExport))).Methods(http.MethodDelete)
+	}
+
+	var healthServer *HealthServer
 	if config.Health.Endpoint != "" && config.Health.Port != "" {
 		err = serverHealth.Start()
 		if err != nil {
 			logging.Error(logger).Log(logging.MessageKey(), "failed to start health", logging.ErrorKey(), err)
 		}
-		http.HandleFunc(config.Health.Endpoint, handlers.NewJSONHandlerFunc(serverHealth, nil))
-		server := &http.Server{
-			Addr:              config.Health.Port,
-			ReadHeaderTimeout: 3 * time.Second,
-		}
-		go func() {
-
-			olog.Fatal(server.ListenAndServe())
-		}()
+		readiness := NewReadiness(config.Health.ReadinessTimeout, map[string]ReadinessCheck{
+			"decrypters": DecryptersReady(decrypters),
+			"jwtKeys":    authSettings.JWTKeys().Ready,
+			"database":   DatabaseReady(database),
+		})
+
+		healthMux := http.NewServeMux()
+		healthMux.HandleFunc(config.Health.Endpoint, handlers.NewJSONHandlerFunc(serverHealth, nil))
+		healthMux.HandleFunc(config.Health.LivenessEndpoint, handleLiveness)
+		healthMux.Handle(config.Health.ReadinessEndpoint, readiness)
+		healthServer, err = StartHealthServer(config.Health.Port, healthMux)
+		exitIfError(logger, emperror.Wrap(err, "unable to start health server"))
+		logging.Info(logger).Log(logging.MessageKey(), "health server started", "port", config.Health.Port,
+			"liveness", config.Health.LivenessEndpoint, "readiness", config.Health.ReadinessEndpoint, "checks", readiness.checkNames())
 	}
 
 	// MARK: Starting the server
@@ -183,10 +299,18 @@ func gungnir(arguments []string) {
 	logging.Info(logger).Log(logging.MessageKey(), fmt.Sprintf("%s is up and running!", applicationName), "elapsedTime", time.Since(start))
 	signals := make(chan os.Signal, 10)
 	signal.Notify(signals)
+	var healthErrs <-chan error
+	if healthServer != nil {
+		healthErrs = healthServer.Errors()
+	}
 	for exit := false; !exit; {
 		select {
 		case s := <-signals:
-			if s != os.Kill && s != os.Interrupt {
+			if s == syscall.SIGHUP {
+				logging.Info(logger).Log(logging.MessageKey(), "reloading configuration due to signal", "signal", s)
+				configReloader.Reload()
+				decrypters.Reload()
+			} else if s != os.Kill && s != os.Interrupt {
 				logging.Info(logger).Log(logging.MessageKey(), "ignoring signal", "signal", s)
 			} else {
 				logging.Error(logger).Log(logging.MessageKey(), "exiting due to signal", "signal", s)
@@ -195,21 +319,43 @@ func gungnir(arguments []string) {
 		case <-done:
 			logging.Error(logger).Log(logging.MessageKey(), "one or more servers exited")
 			exit = true
+		case err := <-healthErrs:
+			logging.Error(logger).Log(logging.MessageKey(), "health server exited", logging.ErrorKey(), err.Error())
+			exit = true
+		case err := <-grpcServer.Errors():
+			logging.Error(logger).Log(logging.MessageKey(), "gRPC server exited", logging.ErrorKey(), err)
+			exit = true
 		}
 	}
 
+	if healthServer != nil {
+		if err := healthServer.Shutdown(); err != nil {
+			logging.Error(logger).Log(logging.MessageKey(), "health server shutdown failed", logging.ErrorKey(), err.Error())
+		}
+		serverHealth.Stop()
+	}
+
+	// stop serving gRPC calls, deliveries and exports before the database
+	// they read goes away, and before the auditor they're audited with
+	grpcServer.Stop()
+	webhooks.Stop()
+	exports.Stop()
 	err = database.Close()
 	if err != nil {
 		logging.Error(logger, emperror.Context(err)...).Log(logging.MessageKey(), "closing database threads failed",
 			logging.ErrorKey(), err.Error())
 	}
+	configReloader.Stop()
+	close(stopCipherWatch)
 	close(shutdown)
 	waitGroup.Wait()
+	auditor.Stop()
 	logging.Info(logger).Log(logging.MessageKey(), "Gungnir has shut down")
 }
 
-func printVersion(f *pflag.FlagSet, arguments []string) (error, bool) {
+func printVersion(f *pflag.FlagSet, v *viper.Viper, arguments []string) (error, bool) {
 	printVer := f.BoolP("version", "v", false, "displays the version number")
+	checkConfig := f.Bool("check-config", false, "validates the configuration file and exits")
 	if err := f.Parse(arguments); err != nil {
 		return err, true
 	}
@@ -218,9 +364,36 @@ func printVersion(f *pflag.FlagSet, arguments []string) (error, bool) {
 		printVersionInfo(os.Stdout)
 		return nil, true
 	}
+	if *checkConfig {
+		return printConfigCheck(os.Stdout, f, v), true
+	}
 	return nil, false
 }
 
+// printConfigCheck loads and validates the configuration file, writing every
+// problem found to writer.
+func printConfigCheck(writer io.Writer, f *pflag.FlagSet, v *viper.Viper) error {
+	// server.Initialize stops before reading the configuration when it sees
+	// flags it doesn't know about, so viper may not be set up yet.
+	if err := server.ConfigureViper(applicationName, f, v); err != nil {
+		return err
+	}
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+
+	_, _, err := loadConfig(v)
+	if err != nil {
+		fmt.Fprintf(writer, "%s: invalid configuration:\n", v.ConfigFileUsed())
+		for _, e := range unwrapErrors(err) {
+			fmt.Fprintf(writer, "  - %s\n", e)
+		}
+		return errInvalidConfig
+	}
+	fmt.Fprintf(writer, "%s: configuration is valid\n", v.ConfigFileUsed())
+	return nil
+}
+
 func printVersionInfo(writer io.Writer) {
 	fmt.Fprintf(writer, "%s:\n", applicationName)
 	fmt.Fprintf(writer, "  version: \t%s\n", Version)
@@ -245,22 +418,230 @@ const (
 	defaultGetStatusLimit  = 10
 	defaultLongPollSleep   = time.Second
 	defaultLongPollTimeout = time.Minute
+	cipherHealthInterval   = 30 * time.Second
 )
 
-func validateConfig(config *Config) {
-	var emptyDuration time.Duration
+var errInvalidConfig = errors.New("invalid configuration")
+
+// externalConfigKeys are the top level configuration keys read by something
+// other than Config: webpa-common's server package, voynicrypto, and the
+// command line flags that server.Initialize binds to viper.
+var externalConfigKeys = []string{
+	"applicationname", "primary", "alternate", "health", "pprof", "metric",
+	"build", "server", "region", "flavor", "log", "project",
+	voynicrypto.CipherKey,
+	"file", "cpuprofile", "memprofile",
+}
+
+// legacyConfigKeys are top level keys that older configurations set but
+// nothing reads anymore.  They are accepted so that those files still load.
+var legacyConfigKeys = []string{"getlimit", "getretries", "retryinterval"}
+
+// strictConfigSections are the sections of Config that nothing else reads, so
+// every key under them must match a field.
+var strictConfigSections = map[string]reflect.Type{
+	"capabilitycheck": reflect.TypeOf(CapabilityConfig{}),
+	"cipherreload":    reflect.TypeOf(CipherReloadConfig{}),
+	"admin":           reflect.TypeOf(AdminConfig{}),
+	"payloaddecoders": reflect.TypeOf(PayloadDecodersConfig{}),
+	"redaction":       reflect.TypeOf(RedactionConfig{}),
+	"audit":           reflect.TypeOf(AuditConfig{}),
+	"status":          reflect.TypeOf(StatusConfig{}),
+	"deviceid":        reflect.TypeOf(DeviceIDConfig{}),
+	"expiredrecords":  reflect.TypeOf(ExpiredRecordsConfig{}),
+	"webhooks":        reflect.TypeOf(WebhooksConfig{}),
+	"exports":         reflect.TypeOf(ExportsConfig{}),
+	"replay":          reflect.TypeOf(ReplayConfig{}),
+	"grpc":            reflect.TypeOf(GRPCConfig{}),
+	"graphql":         reflect.TypeOf(GraphQLConfig{}),
+}
+
+// loadConfig unmarshals and validates the configuration held by v, returning
+// every problem found rather than stopping at the first.
+func loadConfig(v *viper.Viper) (*Config, voynicrypto.Options, error) {
+	var errs []error
+	config := new(Config)
+	if err := v.Unmarshal(config); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, unknownConfigKeys(v)...)
+
+	cipherOptions, err := voynicrypto.FromViper(v)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("cipher: %w", err))
+	}
+	if err := validateConfig(config, cipherOptions); err != nil {
+		errs = append(errs, err)
+	}
+	return config, cipherOptions, errors.Join(errs...)
+}
+
+func unknownConfigKeys(v *viper.Viper) []error {
+	known := fieldNames(reflect.TypeOf(Config{}))
+	for _, k := range externalConfigKeys {
+		known[k] = true
+	}
+	for _, k := range legacyConfigKeys {
+		known[k] = true
+	}
+
+	keys := v.AllKeys()
+	sort.Strings(keys)
+	var errs []error
+	for _, key := range keys {
+		parts := strings.Split(key, ".")
+		if !known[parts[0]] {
+			errs = append(errs, fmt.Errorf("unknown configuration key %q", key))
+			continue
+		}
+		if t, ok := strictConfigSections[parts[0]]; ok && len(parts) > 1 && !fieldNames(t)[parts[1]] {
+			errs = append(errs, fmt.Errorf("unknown configuration key %q", key))
+		}
+	}
+	return errs
+}
+
+// fieldNames returns the lowercased field names of a struct type, which is
+// how viper reports keys.
+func fieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		names[strings.ToLower(t.Field(i).Name)] = true
+	}
+	return names
+}
+
+// validateConfig fills in defaults for the values that weren't set and then
+// checks the configuration, returning every problem found.  A zero duration
+// can't be told apart from an unset one, so it also means the default.
+func validateConfig(config *Config, cipherOptions voynicrypto.Options) error {
+	var (
+		emptyDuration time.Duration
+		errs          []error
+	)
 	if config.GetEventsLimit < 1 {
 		config.GetEventsLimit = defaultGetEventsLimit
 	}
 	if config.GetStatusLimit < 1 {
 		config.GetStatusLimit = defaultGetStatusLimit
 	}
+	if config.DecodeParallelism < 0 {
+		errs = append(errs, fmt.Errorf("decodeParallelism must not be negative, got %d", config.DecodeParallelism))
+	}
+	if config.DecodeParallelism == 0 {
+		config.DecodeParallelism = runtime.GOMAXPROCS(0)
+	}
 	if config.LongPollSleep == emptyDuration {
 		config.LongPollSleep = defaultLongPollSleep
 	}
 	if config.LongPollTimeout == emptyDuration {
 		config.LongPollTimeout = defaultLongPollTimeout
 	}
+
+	if config.LongPollSleep < 0 {
+		errs = append(errs, fmt.Errorf("longPollSleep must not be negative, got %s", config.LongPollSleep))
+	}
+	if config.LongPollTimeout < 0 {
+		errs = append(errs, fmt.Errorf("longPollTimeout must not be negative, got %s", config.LongPollTimeout))
+	}
+	if config.LongPollSleep >= config.LongPollTimeout {
+		errs = append(errs, fmt.Errorf("longPollSleep (%s) must be less than longPollTimeout (%s)", config.LongPollSleep, config.LongPollTimeout))
+	}
+	if config.Health.LivenessEndpoint == "" {
+		config.Health.LivenessEndpoint = defaultLivenessEndpoint
+	}
+	if config.Health.ReadinessEndpoint == "" {
+		config.Health.ReadinessEndpoint = defaultReadinessEndpoint
+	}
+	if config.Health.ReadinessTimeout == emptyDuration {
+		config.Health.ReadinessTimeout = defaultReadinessTimeout
+	}
+	if config.Health.ReadinessTimeout < 0 {
+		errs = append(errs, fmt.Errorf("health.readinessTimeout must not be negative, got %s", config.Health.ReadinessTimeout))
+	}
+	endpoints := map[string]string{}
+	for _, e := range []struct{ name, endpoint string }{
+		{"endpoint", config.Health.Endpoint},
+		{"livenessEndpoint", config.Health.LivenessEndpoint},
+		{"readinessEndpoint", config.Health.ReadinessEndpoint},
+	} {
+		if other, ok := endpoints[e.endpoint]; ok && e.endpoint != "" {
+			errs = append(errs, fmt.Errorf("health.%s and health.%s are both %q", other, e.name, e.endpoint))
+			continue
+		}
+		endpoints[e.endpoint] = e.name
+	}
+	if config.CipherReload.Interval < 0 {
+		errs = append(errs, fmt.Errorf("cipherReload.interval must not be negative, got %s", config.CipherReload.Interval))
+	}
+	if config.CipherReload.GracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("cipherReload.gracePeriod must not be negative, got %s", config.CipherReload.GracePeriod))
+	}
+
+	for i, a := range config.AuthHeader {
+		// the header itself is a credential, so only its position is reported.
+		// Headers that aren't of the form user:password, like the sample's
+		// placeholder, are skipped by decodeBasicAuth.
+		if _, err := base64.StdEncoding.DecodeString(a); err != nil {
+			errs = append(errs, fmt.Errorf("authHeader[%d] is not valid base64: %w", i, err))
+		}
+	}
+
+	switch config.CapabilityCheck.Type {
+	case "", "monitor", "enforce":
+	default:
+		errs = append(errs, fmt.Errorf("capabilityCheck.type must be \"monitor\", \"enforce\" or empty, got %q", config.CapabilityCheck.Type))
+	}
+	if _, err := regexp.Compile(config.CapabilityCheck.Prefix); err != nil {
+		errs = append(errs, fmt.Errorf("capabilityCheck.prefix: %w", err))
+	}
+	for i, e := range config.CapabilityCheck.EndpointBuckets {
+		if _, err := regexp.Compile(e); err != nil {
+			errs = append(errs, fmt.Errorf("capabilityCheck.endpointBuckets[%d]: %w", i, err))
+		}
+	}
+
+	if _, err := NewPayloadDecoders(config.PayloadDecoders); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := NewRedactor(config.Redaction); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, validateAuditConfig(&config.Audit)...)
+	errs = append(errs, validateStatusConfig(&config.Status)...)
+	errs = append(errs, validateWebhooksConfig(&config.Webhooks)...)
+	errs = append(errs, validateExportsConfig(&config.Exports)...)
+	errs = append(errs, validateReplayConfig(&config.Replay)...)
+	errs = append(errs, validateGRPCConfig(&config.GRPC)...)
+	errs = append(errs, validateGraphQLConfig(&config.GraphQL)...)
+
+	for _, o := range cipherOptions {
+		keyTypes := make([]string, 0, len(o.Keys))
+		for keyType := range o.Keys {
+			keyTypes = append(keyTypes, string(keyType))
+		}
+		sort.Strings(keyTypes)
+		for _, keyType := range keyTypes {
+			path := o.Keys[voynicrypto.KeyType(keyType)]
+			if _, err := os.Stat(path); err != nil {
+				errs = append(errs, fmt.Errorf("cipher %s/%s %s: %w", o.Type, o.KID, keyType, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// unwrapErrors flattens errors joined by errors.Join.
+func unwrapErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok { //nolint: errorlint
+		var errs []error
+		for _, e := range joined.Unwrap() {
+			errs = append(errs, unwrapErrors(e)...)
+		}
+		return errs
+	}
+	return []error{err}
 }
 
 func main() {
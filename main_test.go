@@ -3,6 +3,287 @@
 
 package main
 
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/voynicrypto"
+	"github.com/xmidt-org/webpa-common/v2/server" //nolint: staticcheck
+)
+
+func TestValidateConfig(t *testing.T) {
+	goodAuth := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	keyFile := filepath.Join(t.TempDir(), "private.pem")
+	require.Nil(t, os.WriteFile(keyFile, []byte("key"), 0600))
+	defaultHealth := HealthConfig{
+		LivenessEndpoint:  defaultLivenessEndpoint,
+		ReadinessEndpoint: defaultReadinessEndpoint,
+		ReadinessTimeout:  defaultReadinessTimeout,
+	}
+
+	tests := []struct {
+		description    string
+		config         Config
+		ciphers        voynicrypto.Options
+		expectedConfig Config
+		expectedErrs   []string
+	}{
+		{
+			description: "Defaults",
+			expectedConfig: Config{
+				GetEventsLimit:    defaultGetEventsLimit,
+				GetStatusLimit:    defaultGetStatusLimit,
+				DecodeParallelism: runtime.GOMAXPROCS(0),
+				LongPollSleep:     defaultLongPollSleep,
+				LongPollTimeout:   defaultLongPollTimeout,
+				Health:            defaultHealth,
+			},
+		},
+		{
+			description: "Success",
+			config: Config{
+				GetEventsLimit:    5,
+				GetStatusLimit:    6,
+				DecodeParallelism: 3,
+				LongPollSleep:     time.Millisecond,
+				LongPollTimeout:   time.Second,
+				AuthHeader:        []string{goodAuth},
+				CapabilityCheck:   CapabilityConfig{Type: "enforce", Prefix: "x1:.*", EndpointBuckets: []string{"device/.*/events\\b"}},
+			},
+			ciphers: voynicrypto.Options{
+				{Type: voynicrypto.RSASymmetric, KID: "test", Keys: map[voynicrypto.KeyType]string{voynicrypto.PrivateKey: keyFile}},
+			},
+			expectedConfig: Config{
+				GetEventsLimit:    5,
+				GetStatusLimit:    6,
+				DecodeParallelism: 3,
+				LongPollSleep:     time.Millisecond,
+				LongPollTimeout:   time.Second,
+				AuthHeader:        []string{goodAuth},
+				CapabilityCheck:   CapabilityConfig{Type: "enforce", Prefix: "x1:.*", EndpointBuckets: []string{"device/.*/events\\b"}},
+				Health:            defaultHealth,
+			},
+		},
+		{
+			description: "All Problems Reported",
+			config: Config{
+				LongPollSleep:     -time.Second,
+				LongPollTimeout:   -time.Minute,
+				DecodeParallelism: -1,
+				AuthHeader:        []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("nocolon"))},
+				CapabilityCheck:   CapabilityConfig{Type: "enforced", Prefix: "(", EndpointBuckets: []string{"["}},
+				CipherReload:      CipherReloadConfig{Interval: -time.Second, GracePeriod: -time.Second},
+				Health:            HealthConfig{Endpoint: "/ready", ReadinessTimeout: -time.Second},
+				Redaction:         RedactionConfig{Policies: []RedactionPolicy{{PartnerIDs: []string{"partner"}, Payload: "hide"}}},
+				Audit:             AuditConfig{Sink: auditSinkFile},
+				Status:            StatusConfig{Metadata: []StatusMetadataKey{{Source: metadataFromPayload}}},
+				Webhooks:          WebhooksConfig{Enabled: true, QueueSize: -1},
+				Exports:           ExportsConfig{Sink: exportSinkS3, S3: ExportS3Config{Endpoint: "https://s3.example.com"}},
+				Replay:            ReplayConfig{Target: "https://example.com/events", Format: "xml"},
+				GRPC:              GRPCConfig{Address: ":7004", CertificateFile: "/etc/gungnir/public.pem"},
+				GraphQL:           GraphQLConfig{Enabled: true, MaxDepth: -1},
+			},
+			ciphers: voynicrypto.Options{
+				{Type: voynicrypto.RSASymmetric, KID: "test", Keys: map[voynicrypto.KeyType]string{voynicrypto.PrivateKey: "/does/not/exist.pem"}},
+			},
+			expectedErrs: []string{
+				"decodeParallelism must not be negative",
+				"longPollSleep must not be negative",
+				"longPollTimeout must not be negative",
+				"must be less than longPollTimeout",
+				"cipherReload.interval",
+				"cipherReload.gracePeriod",
+				"health.readinessTimeout must not be negative",
+				`health.endpoint and health.readinessEndpoint are both "/ready"`,
+				"authHeader[0] is not valid base64",
+				"capabilityCheck.type",
+				"capabilityCheck.prefix",
+				"capabilityCheck.endpointBuckets[0]",
+				"redaction.policies[0].payload",
+				"audit.file.path is required",
+				"status.metadata[0].path is required",
+				"webhooks.queueSize must be positive",
+				"exports.s3.bucket is required",
+				`replay.format must be "msgpack" or "json", got "xml"`,
+				"grpc.certificateFile and grpc.keyFile must be set together",
+				"graphql.maxDepth must be positive, got -1",
+				"cipher rsa-sym/test privateKey",
+			},
+		},
+		{
+			description: "Sleep Longer Than Timeout",
+			config: Config{
+				LongPollSleep:   time.Minute,
+				LongPollTimeout: time.Second,
+			},
+			expectedErrs: []string{"must be less than longPollTimeout"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			config := tc.config
+			err := validateConfig(&config, tc.ciphers)
+			if len(tc.expectedErrs) == 0 {
+				assert.Nil(err)
+				assert.Equal(tc.expectedConfig, config)
+				return
+			}
+
+			errs := unwrapErrors(err)
+			assert.Len(errs, len(tc.expectedErrs))
+			for _, expected := range tc.expectedErrs {
+				assert.Contains(err.Error(), expected)
+			}
+			// credentials must never end up in the error
+			for _, a := range tc.config.AuthHeader {
+				assert.NotContains(err.Error(), a)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		description  string
+		config       string
+		expectedErrs []string
+	}{
+		{
+			description: "Success",
+			config:      "getEventsLimit: 5\ncipher:\n  - type: none\n    kid: none\nprimary:\n  address: \":7000\"\n",
+		},
+		{
+			description: "Unknown Keys",
+			config:      "getEventLimit: 5\ncapabilityCheck:\n  typo: enforce\n",
+			expectedErrs: []string{
+				`unknown configuration key "capabilitycheck.typo"`,
+				`unknown configuration key "geteventlimit"`,
+			},
+		},
+		{
+			description:  "Unmarshal Error",
+			config:       "longPollSleep: forever\n",
+			expectedErrs: []string{"LongPollSleep"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			configFile := filepath.Join(t.TempDir(), "gungnir.yaml")
+			require.Nil(os.WriteFile(configFile, []byte(tc.config), 0600))
+			v := viper.New()
+			v.SetConfigFile(configFile)
+			require.Nil(v.ReadInConfig())
+
+			_, _, err := loadConfig(v)
+			if len(tc.expectedErrs) == 0 {
+				assert.Nil(err)
+				return
+			}
+			require.NotNil(err)
+			assert.Len(unwrapErrors(err), len(tc.expectedErrs))
+			for _, expected := range tc.expectedErrs {
+				assert.Contains(err.Error(), expected)
+			}
+		})
+	}
+}
+
+func TestSampleConfig(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// the sample expects a key file on a deployed machine, so point it at one
+	// written for the test
+	sample, err := os.ReadFile("gungnir.yaml")
+	require.Nil(err)
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "private.pem")
+	writeRSAKey(t, keyFile, "")
+	file := filepath.Join(dir, "gungnir.yaml")
+	require.Nil(os.WriteFile(file, bytes.ReplaceAll(sample, []byte("/etc/gungnir/private.pem"), []byte(keyFile)), 0600))
+
+	v := viper.New()
+	v.SetConfigFile(file)
+	require.Nil(v.ReadInConfig())
+	_, _, err = loadConfig(v)
+	assert.Nil(err)
+}
+
+func TestPrintConfigCheck(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "good.yaml"), []byte("getEventsLimit: 5\n"), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "bad.yaml"), []byte("longPollSleep: 1h\nbogus: true\n"), 0600))
+
+	tests := []struct {
+		description      string
+		file             string
+		expectedErr      error
+		expectedLines    int
+		expectedContains []string
+	}{
+		{
+			description:      "Valid",
+			file:             "good",
+			expectedLines:    1,
+			expectedContains: []string{"configuration is valid"},
+		},
+		{
+			description:   "Invalid",
+			file:          "bad",
+			expectedErr:   errInvalidConfig,
+			expectedLines: 3,
+			expectedContains: []string{
+				"invalid configuration",
+				`unknown configuration key "bogus"`,
+				"must be less than longPollTimeout",
+			},
+		},
+		{
+			description: "Missing File",
+			file:        "missing",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			f := pflag.NewFlagSet(applicationName, pflag.ContinueOnError)
+			server.ConfigureFlagSet(applicationName, f)
+			require.Nil(f.Parse([]string{"--file", tc.file}))
+			v := viper.New()
+			v.AddConfigPath(dir)
+
+			buf := &bytes.Buffer{}
+			err := printConfigCheck(buf, f, v)
+			if tc.expectedLines == 0 {
+				assert.NotNil(err)
+				return
+			}
+			assert.Equal(tc.expectedErr, err)
+			assert.Len(strings.Split(strings.TrimSpace(buf.String()), "\n"), tc.expectedLines)
+			for _, expected := range tc.expectedContains {
+				assert.Contains(buf.String(), expected)
+			}
+		})
+	}
+}
+
 // func TestPrintVersionInfo(t *testing.T) {
 // 	testCases := []struct {
 // 		name           string
@@ -4,56 +4,325 @@
 package main
 
 import (
+	"net/http"
+	"strconv"
+	"time"
+
 	"github.com/go-kit/kit/metrics"
 	"github.com/go-kit/kit/metrics/provider"
+	"github.com/justinas/alice"
+	db "github.com/xmidt-org/codex-db"
 	"github.com/xmidt-org/webpa-common/v2/xmetrics" //nolint: staticcheck
 )
 
 const (
-	UnmarshalFailureCounter    = "unmarshal_failure_count"
-	DecryptFailureCounter      = "decrypt_failure_count"
-	GetDecrypterFailureCounter = "get_decrypter_failure_count"
-	EventsReturnedCounter      = "events_returned_counter"
+	UnmarshalFailureCounter      = "unmarshal_failure_count"
+	DecryptFailureCounter        = "decrypt_failure_count"
+	GetDecrypterFailureCounter   = "get_decrypter_failure_count"
+	EventsReturnedCounter        = "events_returned_counter"
+	RequestDurationHistogram     = "request_duration_seconds"
+	ResponseSizeHistogram        = "response_size_bytes"
+	DBQueryDurationHistogram     = "db_query_duration_seconds"
+	DecryptDurationHistogram     = "decrypt_duration_seconds"
+	LongPollDurationHistogram    = "long_poll_duration_seconds"
+	CipherReloadCounter          = "cipher_reload_count"
+	DecryptersLoadedGauge        = "decrypters_loaded"
+	ConfigReloadCounter          = "config_reload_count"
+	PayloadDecodeFailureCounter  = "payload_decode_failure_count"
+	AuditRecordsCounter          = "audit_records_count"
+	AuditQueueDepthGauge         = "audit_queue_depth"
+	WebhookDeliveriesCounter     = "webhook_deliveries_count"
+	ExportJobsCounter            = "export_jobs_count"
+	ReplayEventsCounter          = "replay_events_count"
+	GRPCRequestDurationHistogram = "grpc_request_duration_seconds"
+)
+
+const (
+	endpointLabel  = "endpoint"
+	codeLabel      = "code"
+	methodLabel    = "method"
+	algorithmLabel = "algorithm"
+	kidLabel       = "kid"
+	outcomeLabel   = "outcome"
+	eventTypeLabel = "event_type"
+	decoderLabel   = "decoder"
+)
+
+const (
+	eventsEndpoint    = "events"
+	statusEndpoint    = "status"
+	explainEndpoint   = "explain"
+	histogramEndpoint = "histogram"
+	webhooksEndpoint  = "webhooks"
+	exportsEndpoint   = "exports"
+	replayEndpoint    = "replay"
+	graphqlEndpoint   = "graphql"
+	grpcEndpoint      = "grpc"
+
+	getRecordsMethod       = "GetRecords"
+	getRecordsOfTypeMethod = "GetRecordsOfType"
+	getStateHashMethod     = "GetStateHash"
+
+	longPollReturnedEvents = "events"
+	longPollTimedOut       = "timeout"
+	longPollCanceled       = "canceled"
+	longPollFailed         = "error"
 )
 
 func Metrics() []xmetrics.Metric {
 	return []xmetrics.Metric{
 		{
-			Name: UnmarshalFailureCounter,
-			Help: "The total number of failures to unmarshal an event",
-			Type: "counter",
+			Name:       UnmarshalFailureCounter,
+			Help:       "The total number of failures to unmarshal an event",
+			Type:       "counter",
+			LabelNames: []string{algorithmLabel, kidLabel, eventTypeLabel},
 		},
 		{
-			Name: DecryptFailureCounter,
-			Help: "The total number of failures to decypt an event",
-			Type: "counter",
+			Name:       DecryptFailureCounter,
+			Help:       "The total number of failures to decypt an event",
+			Type:       "counter",
+			LabelNames: []string{algorithmLabel, kidLabel, eventTypeLabel},
 		},
 		{
-			Name: GetDecrypterFailureCounter,
-			Help: "The total number of failures to get the decypter",
-			Type: "counter",
+			Name:       GetDecrypterFailureCounter,
+			Help:       "The total number of failures to get the decypter",
+			Type:       "counter",
+			LabelNames: []string{algorithmLabel, kidLabel, eventTypeLabel},
 		},
 		{
 			Name: EventsReturnedCounter,
 			Help: "The total number of events gungnir has responded with",
 			Type: "counter",
 		},
+		{
+			Name:       RequestDurationHistogram,
+			Help:       "A histogram of latencies for requests to the primary endpoints",
+			Type:       "histogram",
+			Buckets:    []float64{0.0625, 0.125, .25, .5, 1, 5, 10, 20, 40, 80},
+			LabelNames: []string{endpointLabel, codeLabel},
+		},
+		{
+			Name:       ResponseSizeHistogram,
+			Help:       "A histogram of response body sizes for the primary endpoints",
+			Type:       "histogram",
+			Buckets:    []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576},
+			LabelNames: []string{endpointLabel, codeLabel},
+		},
+		{
+			Name:       DBQueryDurationHistogram,
+			Help:       "A histogram of latencies for database queries",
+			Type:       "histogram",
+			Buckets:    []float64{0.0625, 0.125, .25, .5, 1, 5, 10, 20},
+			LabelNames: []string{methodLabel},
+		},
+		{
+			Name:       DecryptDurationHistogram,
+			Help:       "A histogram of latencies for decrypting an event",
+			Type:       "histogram",
+			Buckets:    []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5},
+			LabelNames: []string{algorithmLabel, kidLabel},
+		},
+		{
+			Name:       LongPollDurationHistogram,
+			Help:       "A histogram of how long long poll requests waited before responding",
+			Type:       "histogram",
+			Buckets:    []float64{0.5, 1, 5, 10, 30, 60, 120, 300},
+			LabelNames: []string{outcomeLabel},
+		},
+		{
+			Name:       CipherReloadCounter,
+			Help:       "The total number of attempts to reload the ciphers",
+			Type:       "counter",
+			LabelNames: []string{outcomeLabel},
+		},
+		{
+			Name: DecryptersLoadedGauge,
+			Help: "The number of decrypters currently loaded",
+			Type: "gauge",
+		},
+		{
+			Name:       ConfigReloadCounter,
+			Help:       "The total number of attempts to reload the configuration",
+			Type:       "counter",
+			LabelNames: []string{outcomeLabel},
+		},
+		{
+			Name:       PayloadDecodeFailureCounter,
+			Help:       "The total number of event payloads that failed to decode when a decoded payload was asked for",
+			Type:       "counter",
+			LabelNames: []string{decoderLabel},
+		},
+		{
+			Name:       AuditRecordsCounter,
+			Help:       "The total number of audit records written, dropped because the buffer was full, or failed to write",
+			Type:       "counter",
+			LabelNames: []string{outcomeLabel},
+		},
+		{
+			Name: AuditQueueDepthGauge,
+			Help: "The number of audit records waiting to be written",
+			Type: "gauge",
+		},
+		{
+			Name:       WebhookDeliveriesCounter,
+			Help:       "The total number of webhook deliveries made, retried, or dead lettered",
+			Type:       "counter",
+			LabelNames: []string{outcomeLabel},
+		},
+		{
+			Name:       ExportJobsCounter,
+			Help:       "The total number of export jobs that succeeded, failed, or were canceled",
+			Type:       "counter",
+			LabelNames: []string{outcomeLabel},
+		},
+		{
+			Name:       ReplayEventsCounter,
+			Help:       "The total number of events replayed to the replay target, or that failed to be",
+			Type:       "counter",
+			LabelNames: []string{outcomeLabel},
+		},
+		{
+			Name:       GRPCRequestDurationHistogram,
+			Help:       "A histogram of latencies for gRPC calls, labeled by method and gRPC status code",
+			Type:       "histogram",
+			Buckets:    []float64{0.0625, 0.125, .25, .5, 1, 5, 10, 20, 40, 80},
+			LabelNames: []string{methodLabel, codeLabel},
+		},
 	}
 }
 
 type Measures struct {
-	UnmarshalFailure    metrics.Counter
-	DecryptFailure      metrics.Counter
-	GetDecryptFailure   metrics.Counter
-	EventsReturnedCount metrics.Counter
+	UnmarshalFailure     metrics.Counter
+	DecryptFailure       metrics.Counter
+	GetDecryptFailure    metrics.Counter
+	EventsReturnedCount  metrics.Counter
+	RequestDuration      metrics.Histogram
+	ResponseSize         metrics.Histogram
+	DBQueryDuration      metrics.Histogram
+	DecryptDuration      metrics.Histogram
+	LongPollDuration     metrics.Histogram
+	CipherReloadCount    metrics.Counter
+	DecryptersLoaded     metrics.Gauge
+	ConfigReloadCount    metrics.Counter
+	PayloadDecodeFailure metrics.Counter
+	AuditRecords         metrics.Counter
+	AuditQueueDepth      metrics.Gauge
+	WebhookDeliveries    metrics.Counter
+	ExportJobs           metrics.Counter
+	ReplayEvents         metrics.Counter
+	GRPCRequestDuration  metrics.Histogram
 }
 
 // NewMeasures constructs a Measures given a go-kit metrics Provider
 func NewMeasures(p provider.Provider) *Measures {
 	return &Measures{
-		UnmarshalFailure:    p.NewCounter(UnmarshalFailureCounter),
-		DecryptFailure:      p.NewCounter(DecryptFailureCounter),
-		GetDecryptFailure:   p.NewCounter(GetDecrypterFailureCounter),
-		EventsReturnedCount: p.NewCounter(EventsReturnedCounter),
+		UnmarshalFailure:     p.NewCounter(UnmarshalFailureCounter),
+		DecryptFailure:       p.NewCounter(DecryptFailureCounter),
+		GetDecryptFailure:    p.NewCounter(GetDecrypterFailureCounter),
+		EventsReturnedCount:  p.NewCounter(EventsReturnedCounter),
+		RequestDuration:      p.NewHistogram(RequestDurationHistogram, 10),
+		ResponseSize:         p.NewHistogram(ResponseSizeHistogram, 8),
+		DBQueryDuration:      p.NewHistogram(DBQueryDurationHistogram, 8),
+		DecryptDuration:      p.NewHistogram(DecryptDurationHistogram, 8),
+		LongPollDuration:     p.NewHistogram(LongPollDurationHistogram, 8),
+		CipherReloadCount:    p.NewCounter(CipherReloadCounter),
+		DecryptersLoaded:     p.NewGauge(DecryptersLoadedGauge),
+		ConfigReloadCount:    p.NewCounter(ConfigReloadCounter),
+		PayloadDecodeFailure: p.NewCounter(PayloadDecodeFailureCounter),
+		AuditRecords:         p.NewCounter(AuditRecordsCounter),
+		AuditQueueDepth:      p.NewGauge(AuditQueueDepthGauge),
+		WebhookDeliveries:    p.NewCounter(WebhookDeliveriesCounter),
+		ExportJobs:           p.NewCounter(ExportJobsCounter),
+		ReplayEvents:         p.NewCounter(ReplayEventsCounter),
+		GRPCRequestDuration:  p.NewHistogram(GRPCRequestDurationHistogram, 10),
+	}
+}
+
+// recordLabels returns the labels and values identifying how a record was
+// encrypted and what type of event it holds.
+func recordLabels(record db.Record) []string {
+	return []string{algorithmLabel, record.Alg, kidLabel, record.KID, eventTypeLabel, record.Type.String()}
+}
+
+// measuredResponseWriter records the status code and number of bytes written
+// so they can be reported once the request completes.
+type measuredResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	size       int
+}
+
+func (w *measuredResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *measuredResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Flush sends any buffered data to the client, if the underlying writer
+// supports it.
+func (w *measuredResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *measuredResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// InstrumentRequest returns a decorator that records the duration and response
+// size of every request to the given endpoint, labeled by status code.
+func InstrumentRequest(m *Measures, endpoint string) alice.Constructor {
+	return func(delegate http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				start := time.Now()
+				mw := &measuredResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+				delegate.ServeHTTP(mw, r)
+
+				code := strconv.Itoa(mw.statusCode)
+				m.RequestDuration.With(endpointLabel, endpoint, codeLabel, code).Observe(time.Since(start).Seconds())
+				m.ResponseSize.With(endpointLabel, endpoint, codeLabel, code).Observe(float64(mw.size))
+			})
+	}
+}
+
+// measuredRecordGetter wraps a RecordGetter and records the latency of each
+// database call, labeled by method.
+type measuredRecordGetter struct {
+	db.RecordGetter
+	duration metrics.Histogram
+}
+
+// NewMeasuredRecordGetter decorates getter so that every call is timed.
+func NewMeasuredRecordGetter(getter db.RecordGetter, m *Measures) db.RecordGetter {
+	return &measuredRecordGetter{
+		RecordGetter: getter,
+		duration:     m.DBQueryDuration,
 	}
 }
+
+func (g *measuredRecordGetter) GetRecords(deviceID string, limit int, stateHash string) ([]db.Record, error) {
+	defer g.observe(getRecordsMethod, time.Now())
+	return g.RecordGetter.GetRecords(deviceID, limit, stateHash)
+}
+
+func (g *measuredRecordGetter) GetRecordsOfType(deviceID string, limit int, eventType db.EventType, stateHash string) ([]db.Record, error) {
+	defer g.observe(getRecordsOfTypeMethod, time.Now())
+	return g.RecordGetter.GetRecordsOfType(deviceID, limit, eventType, stateHash)
+}
+
+func (g *measuredRecordGetter) GetStateHash(records []db.Record) (string, error) {
+	defer g.observe(getStateHashMethod, time.Now())
+	return g.RecordGetter.GetStateHash(records)
+}
+
+func (g *measuredRecordGetter) observe(method string, start time.Time) {
+	g.duration.With(methodLabel, method).Observe(time.Since(start).Seconds())
+}
@@ -4,9 +4,15 @@
 package main
 
 import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
 )
 
 func TestMetrics(t *testing.T) {
@@ -16,3 +22,121 @@ func TestMetrics(t *testing.T) {
 
 	assert.NotNil(m)
 }
+
+func TestInstrumentRequest(t *testing.T) {
+	tests := []struct {
+		description  string
+		statusCode   int
+		body         []byte
+		expectedCode string
+	}{
+		{
+			description:  "Success",
+			statusCode:   http.StatusOK,
+			body:         []byte("hello"),
+			expectedCode: "200",
+		},
+		{
+			description:  "Implicit Success",
+			body:         []byte("hello"),
+			expectedCode: "200",
+		},
+		{
+			description:  "Not Found",
+			statusCode:   http.StatusNotFound,
+			expectedCode: "404",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			duration := new(mockHistogram)
+			duration.On("Observe", mock.Anything).Once()
+			duration.On("With", mock.Anything)
+			size := new(mockHistogram)
+			size.On("Observe", float64(len(tc.body))).Once()
+			size.On("With", mock.Anything)
+
+			m := &Measures{RequestDuration: duration, ResponseSize: size}
+			handler := InstrumentRequest(m, eventsEndpoint)(http.HandlerFunc(
+				func(w http.ResponseWriter, _ *http.Request) {
+					if tc.statusCode > 0 {
+						w.WriteHeader(tc.statusCode)
+					}
+					w.Write(tc.body)
+				}))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			duration.AssertExpectations(t)
+			size.AssertExpectations(t)
+			duration.AssertCalled(t, "With", endpointLabel)
+			duration.AssertCalled(t, "With", eventsEndpoint)
+			duration.AssertCalled(t, "With", codeLabel)
+			duration.AssertCalled(t, "With", tc.expectedCode)
+			assert.Equal(tc.body, rr.Body.Bytes())
+		})
+	}
+}
+
+func TestMeasuredResponseWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	rr := httptest.NewRecorder()
+	w := &measuredResponseWriter{ResponseWriter: rr, statusCode: http.StatusOK}
+	w.Write([]byte("hello"))
+	w.Flush()
+	assert.True(rr.Flushed)
+	assert.Equal(5, w.size)
+
+	// handlers can flush through a response controller, which unwraps the
+	// writer for anything it doesn't implement
+	rr = httptest.NewRecorder()
+	w = &measuredResponseWriter{ResponseWriter: rr, statusCode: http.StatusOK}
+	assert.Nil(http.NewResponseController(w).Flush())
+	assert.True(rr.Flushed)
+	assert.Equal(rr, w.Unwrap())
+
+	// flushing a writer that can't is a no-op
+	w = &measuredResponseWriter{ResponseWriter: struct{ http.ResponseWriter }{httptest.NewRecorder()}, statusCode: http.StatusOK}
+	assert.NotPanics(w.Flush)
+}
+
+func TestMeasuredRecordGetter(t *testing.T) {
+	assert := assert.New(t)
+	getErr := errors.New("test error")
+	records := []db.Record{{DeviceID: "test"}}
+
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "test", 5, "").Return(records, nil).Once()
+	mockGetter.On("GetRecordsOfType", "test", 5, db.State, "").Return([]db.Record{}, getErr).Once()
+	mockGetter.On("GetStateHash", records).Return("123", nil).Once()
+
+	p := xmetricstest.NewProvider(nil, Metrics)
+	getter := NewMeasuredRecordGetter(mockGetter, NewMeasures(p))
+
+	r, err := getter.GetRecords("test", 5, "")
+	assert.Equal(records, r)
+	assert.Nil(err)
+
+	_, err = getter.GetRecordsOfType("test", 5, db.State, "")
+	assert.Equal(getErr, err)
+
+	hash, err := getter.GetStateHash(records)
+	assert.Equal("123", hash)
+	assert.Nil(err)
+
+	mockGetter.AssertExpectations(t)
+	p.Assert(t, DBQueryDurationHistogram, methodLabel, getRecordsMethod)(xmetricstest.Histogram)
+	p.Assert(t, DBQueryDurationHistogram, methodLabel, getRecordsOfTypeMethod)(xmetricstest.Histogram)
+	p.Assert(t, DBQueryDurationHistogram, methodLabel, getStateHashMethod)(xmetricstest.Histogram)
+}
+
+func TestRecordLabels(t *testing.T) {
+	assert := assert.New(t)
+	record := db.Record{Type: db.State, Alg: "box", KID: "current"}
+
+	assert.Equal([]string{algorithmLabel, "box", kidLabel, "current", eventTypeLabel, "State"}, recordLabels(record))
+}
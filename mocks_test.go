@@ -4,9 +4,21 @@
 package main
 
 import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
 	"github.com/xmidt-org/codex-db"
 	"github.com/xmidt-org/voynicrypto"
+	"github.com/xmidt-org/webpa-common/v2/logging" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
 )
 
 type mockRecordGetter struct {
@@ -44,3 +56,66 @@ func (*mockDecrypter) GetAlgorithm() voynicrypto.AlgorithmType {
 func (*mockDecrypter) GetKID() string {
 	return "none"
 }
+
+type mockHistogram struct {
+	mock.Mock
+}
+
+func (mh *mockHistogram) With(labelsAndValues ...string) metrics.Histogram {
+	for _, v := range labelsAndValues {
+		mh.Called(v)
+	}
+	return mh
+}
+
+func (mh *mockHistogram) Observe(value float64) {
+	mh.Called(value)
+}
+
+// testApp returns an app with the admin capability x1:gungnir:admin that
+// reads five records at a time, decrypts them with no encryption and takes
+// basic auth partner ids from X-Codex-Partner-Ids.
+func testApp(measures *Measures) *App {
+	return &App{
+		getEventLimit:               5,
+		getStatusLimit:              5,
+		logger:                      logging.DefaultLogger(),
+		measures:                    measures,
+		admin:                       AdminConfig{Capabilities: []string{"x1:gungnir:admin"}},
+		basicAuthPartnerIDHeaderKey: "X-Codex-Partner-Ids",
+		decrypters: &voynicrypto.Ciphers{
+			Options: map[voynicrypto.AlgorithmType]map[string]voynicrypto.Decrypt{
+				voynicrypto.None: {"none": new(voynicrypto.NOOP)},
+			},
+		},
+	}
+}
+
+// testAuth returns a jwt for the principal, allowed the partners and given
+// the capability x1:gungnir:<principal>.
+func testAuth(principal string, partners ...string) bascule.Authentication {
+	return bascule.Authentication{
+		Token: bascule.NewToken("jwt", principal, bascule.NewAttributes(map[string]interface{}{
+			"allowedResources": map[string]interface{}{"allowedPartners": partners},
+			"capabilities":     []interface{}{"x1:gungnir:" + principal},
+		})),
+	}
+}
+
+// testRequest returns a request made with the auth and the mux vars.
+func testRequest(t *testing.T, auth bascule.Authentication, method, target, body string, vars map[string]string) *http.Request {
+	request, err := http.NewRequestWithContext(bascule.WithAuthentication(context.Background(), auth), method, target, strings.NewReader(body))
+	require.Nil(t, err)
+	if vars != nil {
+		request = mux.SetURLVars(request, vars)
+	}
+	return request
+}
+
+// testRecord returns an unencrypted record of the message.
+func testRecord(t *testing.T, msg wrp.Message, birthDate int64) db.Record {
+	var data []byte
+	require.Nil(t, wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(&msg))
+	return db.Record{Type: db.State, BirthDate: birthDate, DeathDate: time.Now().Add(time.Hour).UnixNano(),
+		Data: data, Alg: string(voynicrypto.None), KID: "none"}
+}
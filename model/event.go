@@ -22,4 +22,16 @@ type Event struct {
 	// required: false
 	// example: 1555639704
 	BirthDate int64 `json:"birth_date,omitempty"`
+
+	// DeathDate the time the record expires
+	//
+	// required: false
+	// example: 1555726104
+	DeathDate int64 `json:"death_date,omitempty"`
+
+	// Error says why the record couldn't be turned into an event.  Only the
+	// birth and death dates of such an event are known.
+	//
+	// required: false
+	Error *EventError `json:"error,omitempty"`
 }
@@ -0,0 +1,17 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package model
+
+// EventError describes a record that couldn't be decrypted or decoded.
+type EventError struct {
+	// Stage is the step that failed: decrypter, decrypt or decode.
+	//
+	// example: decrypt
+	Stage string `json:"stage" wrp:"stage"`
+
+	// Reason is the error from that step.
+	//
+	// example: failed to find decrypter
+	Reason string `json:"reason" wrp:"reason"`
+}
@@ -70,7 +70,8 @@ func (x *Event) CodecEncodeSelf(e *codec1978.Encoder) {
 		var yyn9 bool = x.Message.Status == nil
 		var yyn10 bool = x.Message.RequestDeliveryResponse == nil
 		var yyn14 bool = x.Message.IncludeSpans == nil
-		var yyq2 = [19]bool{ // should field at this index be written?
+		var yyn24 bool = x.Error == nil
+		var yyq2 = [22]bool{ // should field at this index be written?
 			true,                             // msg_type
 			x.Source != "",                   // source
 			x.Destination != "",              // dest
@@ -89,14 +90,17 @@ func (x *Event) CodecEncodeSelf(e *codec1978.Encoder) {
 			x.URL != "",                      // url
 			len(x.PartnerIDs) != 0,           // partner_ids
 			x.SessionID != "",                // session_id
+			true,                             // qos
 			x.BirthDate != 0,                 // birth_date
+			x.DeathDate != 0,                 // death_date
+			x.Error != nil,                   // error
 		}
 		_ = yyq2
 		if yyr2 || yy2arr2 {
-			z.EncWriteArrayStart(19)
+			z.EncWriteArrayStart(22)
 			z.EncWriteArrayElem()
-			if yyxt22 := z.Extension(x.Message.Type); yyxt22 != nil {
-				z.EncExtension(x.Message.Type, yyxt22)
+			if yyxt25 := z.Extension(x.Message.Type); yyxt25 != nil {
+				z.EncExtension(x.Message.Type, yyxt25)
 			} else {
 				r.EncodeInt(int64(x.Message.Type))
 			}
@@ -136,8 +140,8 @@ func (x *Event) CodecEncodeSelf(e *codec1978.Encoder) {
 			} else {
 				z.EncWriteArrayElem()
 				if yyq2[6] {
-					yy28 := *x.Message.Status
-					r.EncodeInt(int64(yy28))
+					yy31 := *x.Message.Status
+					r.EncodeInt(int64(yy31))
 				} else {
 					r.EncodeNil()
 				}
@@ -148,8 +152,8 @@ func (x *Event) CodecEncodeSelf(e *codec1978.Encoder) {
 			} else {
 				z.EncWriteArrayElem()
 				if yyq2[7] {
-					yy30 := *x.Message.RequestDeliveryResponse
-					r.EncodeInt(int64(yy30))
+					yy33 := *x.Message.RequestDeliveryResponse
+					r.EncodeInt(int64(yy33))
 				} else {
 					r.EncodeNil()
 				}
@@ -190,8 +194,8 @@ func (x *Event) CodecEncodeSelf(e *codec1978.Encoder) {
 			} else {
 				z.EncWriteArrayElem()
 				if yyq2[11] {
-					yy35 := *x.Message.IncludeSpans
-					r.EncodeBool(bool(yy35))
+					yy38 := *x.Message.IncludeSpans
+					r.EncodeBool(bool(yy38))
 				} else {
 					r.EncodeNil()
 				}
@@ -241,11 +245,38 @@ func (x *Event) CodecEncodeSelf(e *codec1978.Encoder) {
 				r.EncodeString("")
 			}
 			z.EncWriteArrayElem()
-			if yyq2[18] {
+			if yyxt46 := z.Extension(x.Message.QualityOfService); yyxt46 != nil {
+				z.EncExtension(x.Message.QualityOfService, yyxt46)
+			} else {
+				r.EncodeInt(int64(x.Message.QualityOfService))
+			}
+			z.EncWriteArrayElem()
+			if yyq2[19] {
 				r.EncodeInt(int64(x.BirthDate))
 			} else {
 				r.EncodeInt(0)
 			}
+			z.EncWriteArrayElem()
+			if yyq2[20] {
+				r.EncodeInt(int64(x.DeathDate))
+			} else {
+				r.EncodeInt(0)
+			}
+			if yyn24 {
+				z.EncWriteArrayElem()
+				r.EncodeNil()
+			} else {
+				z.EncWriteArrayElem()
+				if yyq2[21] {
+					if yyxt49 := z.Extension(x.Error); yyxt49 != nil {
+						z.EncExtension(x.Error, yyxt49)
+					} else {
+						z.EncFallback(x.Error)
+					}
+				} else {
+					r.EncodeNil()
+				}
+			}
 			z.EncWriteArrayEnd()
 		} else {
 			var yynn2 int
@@ -259,8 +290,8 @@ func (x *Event) CodecEncodeSelf(e *codec1978.Encoder) {
 			z.EncWriteMapElemKey()
 			r.EncodeString(`msg_type`)
 			z.EncWriteMapElemValue()
-			if yyxt44 := z.Extension(x.Message.Type); yyxt44 != nil {
-				z.EncExtension(x.Message.Type, yyxt44)
+			if yyxt50 := z.Extension(x.Message.Type); yyxt50 != nil {
+				z.EncExtension(x.Message.Type, yyxt50)
 			} else {
 				r.EncodeInt(int64(x.Message.Type))
 			}
@@ -317,8 +348,8 @@ func (x *Event) CodecEncodeSelf(e *codec1978.Encoder) {
 				if yyn9 {
 					r.EncodeNil()
 				} else {
-					yy50 := *x.Message.Status
-					r.EncodeInt(int64(yy50))
+					yy56 := *x.Message.Status
+					r.EncodeInt(int64(yy56))
 				}
 			}
 			if yyq2[7] {
@@ -332,8 +363,8 @@ func (x *Event) CodecEncodeSelf(e *codec1978.Encoder) {
 				if yyn10 {
 					r.EncodeNil()
 				} else {
-					yy52 := *x.Message.RequestDeliveryResponse
-					r.EncodeInt(int64(yy52))
+					yy58 := *x.Message.RequestDeliveryResponse
+					r.EncodeInt(int64(yy58))
 				}
 			}
 			if yyq2[8] {
@@ -385,8 +416,8 @@ func (x *Event) CodecEncodeSelf(e *codec1978.Encoder) {
 				if yyn14 {
 					r.EncodeNil()
 				} else {
-					yy57 := *x.Message.IncludeSpans
-					r.EncodeBool(bool(yy57))
+					yy63 := *x.Message.IncludeSpans
+					r.EncodeBool(bool(yy63))
 				}
 			}
 			if yyq2[12] {
@@ -445,12 +476,48 @@ func (x *Event) CodecEncodeSelf(e *codec1978.Encoder) {
 				z.EncWriteMapElemValue()
 				r.EncodeString(string(x.Message.SessionID))
 			}
-			if yyq2[18] {
+			z.EncWriteMapElemKey()
+			if z.IsJSONHandle() {
+				z.WriteStr("\"qos\"")
+			} else {
+				r.EncodeString(`qos`)
+			}
+			z.EncWriteMapElemValue()
+			if yyxt71 := z.Extension(x.Message.QualityOfService); yyxt71 != nil {
+				z.EncExtension(x.Message.QualityOfService, yyxt71)
+			} else {
+				r.EncodeInt(int64(x.Message.QualityOfService))
+			}
+			if yyq2[19] {
 				z.EncWriteMapElemKey()
 				r.EncodeString(`birth_date`)
 				z.EncWriteMapElemValue()
 				r.EncodeInt(int64(x.BirthDate))
 			}
+			if yyq2[20] {
+				z.EncWriteMapElemKey()
+				r.EncodeString(`death_date`)
+				z.EncWriteMapElemValue()
+				r.EncodeInt(int64(x.DeathDate))
+			}
+			if yyq2[21] {
+				z.EncWriteMapElemKey()
+				if z.IsJSONHandle() {
+					z.WriteStr("\"error\"")
+				} else {
+					r.EncodeString(`error`)
+				}
+				z.EncWriteMapElemValue()
+				if yyn24 {
+					r.EncodeNil()
+				} else {
+					if yyxt74 := z.Extension(x.Error); yyxt74 != nil {
+						z.EncExtension(x.Error, yyxt74)
+					} else {
+						z.EncFallback(x.Error)
+					}
+				}
+			}
 			z.EncWriteMapEnd()
 		}
 	}
@@ -567,8 +634,31 @@ func (x *Event) codecDecodeSelfFromMap(l int, d *codec1978.Decoder) {
 			z.F.DecSliceStringX(&x.Message.PartnerIDs, d)
 		case "session_id":
 			x.Message.SessionID = (string)(z.DecStringZC(r.DecodeStringAsBytes()))
+		case "qos":
+			if yyxt32 := z.Extension(x.Message.QualityOfService); yyxt32 != nil {
+				z.DecExtension(&x.Message.QualityOfService, yyxt32)
+			} else {
+				x.Message.QualityOfService = (pkg1_v3.QOSValue)(z.C.IntV(r.DecodeInt64(), codecSelferBitsize3762))
+			}
 		case "birth_date":
 			x.BirthDate = (int64)(r.DecodeInt64())
+		case "death_date":
+			x.DeathDate = (int64)(r.DecodeInt64())
+		case "error":
+			if r.TryNil() {
+				if x.Error != nil { // remove the if-true
+					x.Error = nil
+				}
+			} else {
+				if x.Error == nil {
+					x.Error = new(EventError)
+				}
+				if yyxt36 := z.Extension(x.Error); yyxt36 != nil {
+					z.DecExtension(x.Error, yyxt36)
+				} else {
+					z.DecFallback(x.Error, false)
+				}
+			}
 		default:
 			z.DecStructFieldNotFound(-1, string(yys3))
 		} // end switch yys3
@@ -579,92 +669,92 @@ func (x *Event) codecDecodeSelfFromArray(l int, d *codec1978.Decoder) {
 	var h codecSelfer3762
 	z, r := codec1978.GenHelper().Decoder(d)
 	_, _, _ = h, z, r
-	var yyj32 int
-	var yyb32 bool
-	var yyhl32 bool = l >= 0
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	var yyj37 int
+	var yyb37 bool
+	var yyhl37 bool = l >= 0
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
-	if yyxt34 := z.Extension(x.Message.Type); yyxt34 != nil {
-		z.DecExtension(&x.Message.Type, yyxt34)
+	if yyxt39 := z.Extension(x.Message.Type); yyxt39 != nil {
+		z.DecExtension(&x.Message.Type, yyxt39)
 	} else {
 		x.Message.Type = (pkg1_v3.MessageType)(r.DecodeInt64())
 	}
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	x.Message.Source = (string)(z.DecStringZC(r.DecodeStringAsBytes()))
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	x.Message.Destination = (string)(z.DecStringZC(r.DecodeStringAsBytes()))
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	x.Message.TransactionUUID = (string)(z.DecStringZC(r.DecodeStringAsBytes()))
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	x.Message.ContentType = (string)(z.DecStringZC(r.DecodeStringAsBytes()))
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	x.Message.Accept = (string)(z.DecStringZC(r.DecodeStringAsBytes()))
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
@@ -679,13 +769,13 @@ func (x *Event) codecDecodeSelfFromArray(l int, d *codec1978.Decoder) {
 		}
 		*x.Message.Status = (int64)(r.DecodeInt64())
 	}
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
@@ -700,49 +790,49 @@ func (x *Event) codecDecodeSelfFromArray(l int, d *codec1978.Decoder) {
 		}
 		*x.Message.RequestDeliveryResponse = (int64)(r.DecodeInt64())
 	}
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	z.F.DecSliceStringX(&x.Message.Headers, d)
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	z.F.DecMapStringStringX(&x.Message.Metadata, d)
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	h.decSliceSlicestring((*[][]string)(&x.Message.Spans), d)
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
@@ -757,107 +847,160 @@ func (x *Event) codecDecodeSelfFromArray(l int, d *codec1978.Decoder) {
 		}
 		*x.Message.IncludeSpans = (bool)(r.DecodeBool())
 	}
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	x.Message.Path = (string)(z.DecStringZC(r.DecodeStringAsBytes()))
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	x.Message.Payload = z.DecodeBytesInto(([]byte)(x.Message.Payload))
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	x.Message.ServiceName = (string)(z.DecStringZC(r.DecodeStringAsBytes()))
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	x.Message.URL = (string)(z.DecStringZC(r.DecodeStringAsBytes()))
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	z.F.DecSliceStringX(&x.Message.PartnerIDs, d)
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	x.Message.SessionID = (string)(z.DecStringZC(r.DecodeStringAsBytes()))
-	yyj32++
-	if yyhl32 {
-		yyb32 = yyj32 > l
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
 	} else {
-		yyb32 = z.DecCheckBreak()
+		yyb37 = z.DecCheckBreak()
 	}
-	if yyb32 {
+	if yyb37 {
+		z.DecReadArrayEnd()
+		return
+	}
+	z.DecReadArrayElem()
+	if yyxt66 := z.Extension(x.Message.QualityOfService); yyxt66 != nil {
+		z.DecExtension(&x.Message.QualityOfService, yyxt66)
+	} else {
+		x.Message.QualityOfService = (pkg1_v3.QOSValue)(z.C.IntV(r.DecodeInt64(), codecSelferBitsize3762))
+	}
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
+	} else {
+		yyb37 = z.DecCheckBreak()
+	}
+	if yyb37 {
 		z.DecReadArrayEnd()
 		return
 	}
 	z.DecReadArrayElem()
 	x.BirthDate = (int64)(r.DecodeInt64())
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
+	} else {
+		yyb37 = z.DecCheckBreak()
+	}
+	if yyb37 {
+		z.DecReadArrayEnd()
+		return
+	}
+	z.DecReadArrayElem()
+	x.DeathDate = (int64)(r.DecodeInt64())
+	yyj37++
+	if yyhl37 {
+		yyb37 = yyj37 > l
+	} else {
+		yyb37 = z.DecCheckBreak()
+	}
+	if yyb37 {
+		z.DecReadArrayEnd()
+		return
+	}
+	z.DecReadArrayElem()
+	if r.TryNil() {
+		if x.Error != nil { // remove the if-true
+			x.Error = nil
+		}
+	} else {
+		if x.Error == nil {
+			x.Error = new(EventError)
+		}
+		if yyxt70 := z.Extension(x.Error); yyxt70 != nil {
+			z.DecExtension(x.Error, yyxt70)
+		} else {
+			z.DecFallback(x.Error, false)
+		}
+	}
 	for {
-		yyj32++
-		if yyhl32 {
-			yyb32 = yyj32 > l
+		yyj37++
+		if yyhl37 {
+			yyb37 = yyj37 > l
 		} else {
-			yyb32 = z.DecCheckBreak()
+			yyb37 = z.DecCheckBreak()
 		}
-		if yyb32 {
+		if yyb37 {
 			break
 		}
 		z.DecReadArrayElem()
-		z.DecStructFieldNotFound(yyj32-1, "")
+		z.DecStructFieldNotFound(yyj37-1, "")
 	}
 }
 
 func (x *Event) IsCodecEmpty() bool {
-	return !(false || x.Message.Type != 0 || x.Message.Source != "" || x.Message.Destination != "" || x.Message.TransactionUUID != "" || x.Message.ContentType != "" || x.Message.Accept != "" || x.Message.Status != nil || x.Message.RequestDeliveryResponse != nil || len(x.Message.Headers) != 0 || len(x.Message.Metadata) != 0 || len(x.Message.Spans) != 0 || x.Message.IncludeSpans != nil || x.Message.Path != "" || len(x.Message.Payload) != 0 || x.Message.ServiceName != "" || x.Message.URL != "" || len(x.Message.PartnerIDs) != 0 || x.Message.SessionID != "" || x.BirthDate != 0 || false)
+	return !(false || x.Message.Type != 0 || x.Message.Source != "" || x.Message.Destination != "" || x.Message.TransactionUUID != "" || x.Message.ContentType != "" || x.Message.Accept != "" || x.Message.Status != nil || x.Message.RequestDeliveryResponse != nil || len(x.Message.Headers) != 0 || len(x.Message.Metadata) != 0 || len(x.Message.Spans) != 0 || x.Message.IncludeSpans != nil || x.Message.Path != "" || len(x.Message.Payload) != 0 || x.Message.ServiceName != "" || x.Message.URL != "" || len(x.Message.PartnerIDs) != 0 || x.Message.SessionID != "" || x.Message.QualityOfService != 0 || x.BirthDate != 0 || x.DeathDate != 0 || false)
 }
 
 func (x codecSelfer3762) encSliceSlicestring(v [][]string, e *codec1978.Encoder) {
@@ -0,0 +1,320 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ugorji/go/codec"
+	"github.com/xmidt-org/gungnir/model"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// The payload decoders that can be configured.
+const (
+	jsonDecoder     = "json"
+	msgpackDecoder  = "msgpack"
+	protobufDecoder = "protobuf"
+	textDecoder     = "text"
+)
+
+const (
+	decodedPayloadKey      = "decoded_payload"
+	decodedPayloadErrorKey = "decoded_payload_error"
+)
+
+var errInvalidJSON = errors.New("payload is not valid JSON")
+
+// PayloadContentType maps a content type to the decoder used for payloads of
+// that type.
+type PayloadContentType struct {
+	// ContentType is compared to the event's content type, ignoring case and
+	// any parameters such as charset.
+	ContentType string
+
+	// Decoder is one of json, msgpack, protobuf or text.
+	Decoder string
+
+	// Message is the full name of the protobuf message the payload holds.
+	// Only used by the protobuf decoder, which requires it.
+	Message string
+}
+
+// PayloadDecodersConfig configures how event payloads are decoded when a
+// client asks for decoded payloads.
+type PayloadDecodersConfig struct {
+	// DescriptorFiles are protobuf FileDescriptorSet files, as written by
+	// protoc --include_imports --descriptor_set_out.  The protobuf messages
+	// named in ContentTypes are looked up in them.
+	DescriptorFiles []string
+
+	// ContentTypes adds to or replaces the built in decoders: json for
+	// application/json and any +json type, msgpack for application/msgpack
+	// and application/x-msgpack, and text for any text/ type.
+	ContentTypes []PayloadContentType
+}
+
+// PayloadDecoder renders a payload as JSON.
+type PayloadDecoder interface {
+	Decode(payload []byte) (json.RawMessage, error)
+}
+
+// PayloadDecoderFunc is a function that implements PayloadDecoder.
+type PayloadDecoderFunc func([]byte) (json.RawMessage, error)
+
+func (f PayloadDecoderFunc) Decode(payload []byte) (json.RawMessage, error) {
+	return f(payload)
+}
+
+// PayloadDecodeError explains why a payload couldn't be decoded.
+type PayloadDecodeError struct {
+	Decoder string `json:"decoder,omitempty"`
+	Error   string `json:"error"`
+}
+
+type namedDecoder struct {
+	name    string
+	decoder PayloadDecoder
+}
+
+// PayloadDecoders finds the decoder for a content type.
+type PayloadDecoders struct {
+	byContentType map[string]namedDecoder
+}
+
+var defaultPayloadDecoders, _ = NewPayloadDecoders(PayloadDecodersConfig{})
+
+// NewPayloadDecoders creates the built in decoders plus the ones configured,
+// loading any protobuf descriptor files.  Every problem found is returned.
+func NewPayloadDecoders(config PayloadDecodersConfig) (*PayloadDecoders, error) {
+	p := &PayloadDecoders{
+		byContentType: map[string]namedDecoder{
+			"application/json":      {jsonDecoder, PayloadDecoderFunc(decodeJSONPayload)},
+			"text/json":             {jsonDecoder, PayloadDecoderFunc(decodeJSONPayload)},
+			"application/msgpack":   {msgpackDecoder, PayloadDecoderFunc(decodeMsgpackPayload)},
+			"application/x-msgpack": {msgpackDecoder, PayloadDecoderFunc(decodeMsgpackPayload)},
+		},
+	}
+
+	var (
+		errs  []error
+		files *protoregistry.Files
+	)
+	if len(config.DescriptorFiles) > 0 {
+		var err error
+		if files, err = loadDescriptorFiles(config.DescriptorFiles); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for i, c := range config.ContentTypes {
+		contentType := normalizeContentType(c.ContentType)
+		if contentType == "" {
+			errs = append(errs, fmt.Errorf("payloadDecoders.contentTypes[%d]: contentType is required", i))
+			continue
+		}
+
+		var decoder PayloadDecoder
+		switch c.Decoder {
+		case jsonDecoder:
+			decoder = PayloadDecoderFunc(decodeJSONPayload)
+		case msgpackDecoder:
+			decoder = PayloadDecoderFunc(decodeMsgpackPayload)
+		case textDecoder:
+			decoder = PayloadDecoderFunc(decodeTextPayload)
+		case protobufDecoder:
+			if c.Message == "" {
+				errs = append(errs, fmt.Errorf("payloadDecoders.contentTypes[%d]: message is required for the protobuf decoder", i))
+				continue
+			}
+			if files == nil {
+				// a missing descriptor file has already been reported
+				if len(config.DescriptorFiles) == 0 {
+					errs = append(errs, fmt.Errorf("payloadDecoders.contentTypes[%d]: descriptorFiles are required for the protobuf decoder", i))
+				}
+				continue
+			}
+			d, err := protobufMessageDecoder(files, c.Message)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("payloadDecoders.contentTypes[%d]: %w", i, err))
+				continue
+			}
+			decoder = d
+		default:
+			errs = append(errs, fmt.Errorf("payloadDecoders.contentTypes[%d]: decoder must be one of json, msgpack, protobuf or text, got %q", i, c.Decoder))
+			continue
+		}
+		p.byContentType[contentType] = namedDecoder{name: c.Decoder, decoder: decoder}
+	}
+
+	return p, errors.Join(errs...)
+}
+
+// Decode renders the payload as JSON using the decoder for the content type,
+// returning the name of the decoder used.
+func (p *PayloadDecoders) Decode(contentType string, payload []byte) (json.RawMessage, string, error) {
+	if p == nil {
+		p = defaultPayloadDecoders
+	}
+
+	contentType = normalizeContentType(contentType)
+	d, ok := p.byContentType[contentType]
+	if !ok {
+		switch {
+		case strings.HasSuffix(contentType, "+json"):
+			d = namedDecoder{jsonDecoder, PayloadDecoderFunc(decodeJSONPayload)}
+		case strings.HasPrefix(contentType, "text/"):
+			d = namedDecoder{textDecoder, PayloadDecoderFunc(decodeTextPayload)}
+		default:
+			return nil, "", fmt.Errorf("no decoder for content type %q", contentType)
+		}
+	}
+
+	decoded, err := d.decoder.Decode(payload)
+	return decoded, d.name, err
+}
+
+// normalizeContentType lowercases the media type and drops any parameters.
+func normalizeContentType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+func decodeJSONPayload(payload []byte) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidJSON, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeMsgpackPayload(payload []byte) (json.RawMessage, error) {
+	// decode maps with string keys and strings as strings, so that the result
+	// can be rendered as JSON
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.RawToString = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+
+	var v interface{}
+	if err := codec.NewDecoderBytes(payload, h).Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func decodeTextPayload(payload []byte) (json.RawMessage, error) {
+	if !utf8.Valid(payload) {
+		return nil, errors.New("payload is not valid UTF-8")
+	}
+	return json.Marshal(string(payload))
+}
+
+func loadDescriptorFiles(paths []string) (*protoregistry.Files, error) {
+	var (
+		errs []error
+		set  descriptorpb.FileDescriptorSet
+		seen = map[string]bool{}
+	)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("payloadDecoders.descriptorFiles: %w", err))
+			continue
+		}
+		var fileSet descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(data, &fileSet); err != nil {
+			errs = append(errs, fmt.Errorf("payloadDecoders.descriptorFiles: %s is not a FileDescriptorSet: %w", path, err))
+			continue
+		}
+		// the same imports are often included in more than one set
+		for _, f := range fileSet.File {
+			if !seen[f.GetName()] {
+				seen[f.GetName()] = true
+				set.File = append(set.File, f)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("payloadDecoders.descriptorFiles: %w", err)
+	}
+	return files, nil
+}
+
+// protobufMessageDecoder returns a decoder for the named message, rendering
+// it with the protobuf JSON mapping.
+func protobufMessageDecoder(files *protoregistry.Files, name string) (PayloadDecoder, error) {
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("protobuf message %q: %w", name, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("protobuf message %q: not a message", name)
+	}
+
+	return PayloadDecoderFunc(func(payload []byte) (json.RawMessage, error) {
+		msg := dynamicpb.NewMessage(md)
+		if err := proto.Unmarshal(payload, msg); err != nil {
+			return nil, err
+		}
+		return protojson.Marshal(msg)
+	}), nil
+}
+
+// encodeEventsWithPayloads encodes events like encodeEvents, adding each
+// payload decoded as JSON.  A payload that can't be decoded gets an error
+// explaining why instead, rather than failing the response.
+func (app *App) encodeEventsWithPayloads(events []model.Event) ([]byte, error) {
+	if events == nil {
+		return encodeEvents(events)
+	}
+
+	out := make([]map[string]json.RawMessage, 0, len(events))
+	for _, event := range events {
+		data, err := encodeEvents(event)
+		if err != nil {
+			return nil, err
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+
+		if len(event.Payload) > 0 {
+			decoded, decoder, err := app.payloadDecoders.Decode(event.ContentType, event.Payload)
+			if err != nil {
+				if decoder != "" {
+					app.measures.PayloadDecodeFailure.With(decoderLabel, decoder).Add(1.0)
+				}
+				fields[decodedPayloadErrorKey], _ = json.Marshal(PayloadDecodeError{Decoder: decoder, Error: err.Error()})
+			} else {
+				fields[decodedPayloadKey] = decoded
+			}
+		}
+		out = append(out, fields)
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,410 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+	"github.com/xmidt-org/bascule"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/gungnir/model"
+	"github.com/xmidt-org/voynicrypto"
+	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// writeTestDescriptor writes a descriptor set holding test.Reboot to a file
+// and returns its path along with an encoded test.Reboot message.
+func writeTestDescriptor(t *testing.T) (string, []byte) {
+	require := require.New(t)
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Reboot"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{
+						Name:     proto.String("reason"),
+						JsonName: proto.String("reason"),
+						Number:   proto.Int32(1),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+						Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					},
+					{
+						Name:     proto.String("count"),
+						JsonName: proto.String("count"),
+						Number:   proto.Int32(2),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+						Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					},
+				},
+			},
+		},
+	}
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	require.Nil(err)
+	path := filepath.Join(t.TempDir(), "test.pb")
+	require.Nil(os.WriteFile(path, data, 0600))
+
+	fd, err := protodesc.NewFile(file, nil)
+	require.Nil(err)
+	md := fd.Messages().ByName("Reboot")
+	msg := dynamicpb.NewMessage(md)
+	msg.Set(md.Fields().ByName("reason"), protoreflect.ValueOfString("power loss"))
+	msg.Set(md.Fields().ByName("count"), protoreflect.ValueOfInt32(3))
+	payload, err := proto.Marshal(msg)
+	require.Nil(err)
+	return path, payload
+}
+
+func TestNewPayloadDecoders(t *testing.T) {
+	descriptorFile, _ := writeTestDescriptor(t)
+	notDescriptor := filepath.Join(t.TempDir(), "bad.pb")
+	require.Nil(t, os.WriteFile(notDescriptor, []byte("not a descriptor"), 0600))
+
+	tests := []struct {
+		description  string
+		config       PayloadDecodersConfig
+		expectedErrs []string
+	}{
+		{
+			description: "Defaults",
+		},
+		{
+			description: "Success",
+			config: PayloadDecodersConfig{
+				DescriptorFiles: []string{descriptorFile},
+				ContentTypes: []PayloadContentType{
+					{ContentType: "application/x-protobuf", Decoder: protobufDecoder, Message: "test.Reboot"},
+					{ContentType: "application/octet-stream", Decoder: textDecoder},
+				},
+			},
+		},
+		{
+			description: "All Problems Reported",
+			config: PayloadDecodersConfig{
+				ContentTypes: []PayloadContentType{
+					{Decoder: jsonDecoder},
+					{ContentType: "application/x-yaml", Decoder: "yaml"},
+					{ContentType: "application/x-protobuf", Decoder: protobufDecoder},
+					{ContentType: "application/x-protobuf", Decoder: protobufDecoder, Message: "test.Reboot"},
+				},
+			},
+			expectedErrs: []string{
+				"contentTypes[0]: contentType is required",
+				`contentTypes[1]: decoder must be one of json, msgpack, protobuf or text, got "yaml"`,
+				"contentTypes[2]: message is required",
+				"contentTypes[3]: descriptorFiles are required",
+			},
+		},
+		{
+			description: "Bad Descriptor Files",
+			config: PayloadDecodersConfig{
+				DescriptorFiles: []string{"/does/not/exist.pb", notDescriptor},
+				ContentTypes: []PayloadContentType{
+					{ContentType: "application/x-protobuf", Decoder: protobufDecoder, Message: "test.Reboot"},
+				},
+			},
+			expectedErrs: []string{
+				"exist.pb",
+				"is not a FileDescriptorSet",
+			},
+		},
+		{
+			description: "Unknown Message",
+			config: PayloadDecodersConfig{
+				DescriptorFiles: []string{descriptorFile},
+				ContentTypes: []PayloadContentType{
+					{ContentType: "application/x-protobuf", Decoder: protobufDecoder, Message: "test.Shutdown"},
+				},
+			},
+			expectedErrs: []string{`protobuf message "test.Shutdown"`},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			_, err := NewPayloadDecoders(tc.config)
+			if len(tc.expectedErrs) == 0 {
+				assert.Nil(err)
+				return
+			}
+			assert.Len(unwrapErrors(err), len(tc.expectedErrs))
+			for _, expected := range tc.expectedErrs {
+				assert.Contains(err.Error(), expected)
+			}
+		})
+	}
+}
+
+func TestPayloadDecodersDecode(t *testing.T) {
+	descriptorFile, protoPayload := writeTestDescriptor(t)
+	var msgpackPayload []byte
+	require.Nil(t, codec.NewEncoderBytes(&msgpackPayload, &codec.MsgpackHandle{WriteExt: true}).Encode(map[string]interface{}{"reason": "ping miss", "count": 2}))
+
+	decoders, err := NewPayloadDecoders(PayloadDecodersConfig{
+		DescriptorFiles: []string{descriptorFile},
+		ContentTypes: []PayloadContentType{
+			{ContentType: "application/x-protobuf", Decoder: protobufDecoder, Message: "test.Reboot"},
+			{ContentType: "application/vnd.example", Decoder: jsonDecoder},
+		},
+	})
+	require.Nil(t, err)
+
+	tests := []struct {
+		description     string
+		contentType     string
+		payload         []byte
+		expected        string
+		expectedDecoder string
+		expectedErr     bool
+	}{
+		{
+			description:     "JSON",
+			contentType:     "application/json",
+			payload:         []byte(`{ "reason": "ping miss" }`),
+			expected:        `{"reason":"ping miss"}`,
+			expectedDecoder: jsonDecoder,
+		},
+		{
+			description:     "JSON With Parameters",
+			contentType:     "Application/JSON; charset=utf-8",
+			payload:         []byte(`[1, 2]`),
+			expected:        `[1,2]`,
+			expectedDecoder: jsonDecoder,
+		},
+		{
+			description:     "JSON Suffix",
+			contentType:     "application/vnd.device+json",
+			payload:         []byte(`{"id": 123456789012345678}`),
+			expected:        `{"id":123456789012345678}`,
+			expectedDecoder: jsonDecoder,
+		},
+		{
+			description:     "Configured JSON",
+			contentType:     "application/vnd.example",
+			payload:         []byte(`true`),
+			expected:        `true`,
+			expectedDecoder: jsonDecoder,
+		},
+		{
+			description:     "Invalid JSON",
+			contentType:     "application/json",
+			payload:         []byte(`{"reason":`),
+			expectedDecoder: jsonDecoder,
+			expectedErr:     true,
+		},
+		{
+			description:     "Msgpack",
+			contentType:     "application/msgpack",
+			payload:         msgpackPayload,
+			expected:        `{"count":2,"reason":"ping miss"}`,
+			expectedDecoder: msgpackDecoder,
+		},
+		{
+			description:     "Invalid Msgpack",
+			contentType:     "application/x-msgpack",
+			payload:         []byte{0xc1},
+			expectedDecoder: msgpackDecoder,
+			expectedErr:     true,
+		},
+		{
+			description:     "Text",
+			contentType:     "text/plain",
+			payload:         []byte("device rebooted"),
+			expected:        `"device rebooted"`,
+			expectedDecoder: textDecoder,
+		},
+		{
+			description:     "Invalid Text",
+			contentType:     "text/plain",
+			payload:         []byte{0xff, 0xfe},
+			expectedDecoder: textDecoder,
+			expectedErr:     true,
+		},
+		{
+			description:     "Protobuf",
+			contentType:     "application/x-protobuf",
+			payload:         protoPayload,
+			expected:        `{"reason":"power loss","count":3}`,
+			expectedDecoder: protobufDecoder,
+		},
+		{
+			description:     "Invalid Protobuf",
+			contentType:     "application/x-protobuf",
+			payload:         []byte{0xff},
+			expectedDecoder: protobufDecoder,
+			expectedErr:     true,
+		},
+		{
+			description: "No Decoder",
+			contentType: "application/octet-stream",
+			payload:     []byte{0x01},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			decoded, decoder, err := decoders.Decode(tc.contentType, tc.payload)
+			assert.Equal(tc.expectedDecoder, decoder)
+			if tc.expectedErr {
+				assert.NotNil(err)
+				return
+			}
+			assert.Nil(err)
+			assert.JSONEq(tc.expected, string(decoded))
+		})
+	}
+}
+
+func TestEncodeEventsWithPayloads(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	p := xmetricstest.NewProvider(nil, Metrics)
+	app := App{measures: NewMeasures(p)}
+
+	events := []model.Event{
+		{Message: wrp.Message{Type: wrp.SimpleEventMessageType, ContentType: "application/json", Payload: []byte(`{"a":1}`)}, BirthDate: 5},
+		{Message: wrp.Message{Type: wrp.SimpleEventMessageType, ContentType: "application/json", Payload: []byte(`{"a":`)}},
+		{Message: wrp.Message{Type: wrp.SimpleEventMessageType, ContentType: "application/octet-stream", Payload: []byte{0x01}}},
+		{Message: wrp.Message{Type: wrp.SimpleEventMessageType}},
+	}
+	data, err := app.encodeEventsWithPayloads(events)
+	require.Nil(err)
+
+	var decoded []map[string]json.RawMessage
+	require.Nil(json.Unmarshal(data, &decoded))
+	require.Len(decoded, len(events))
+
+	// the usual fields are still there
+	assert.JSONEq("5", string(decoded[0]["birth_date"]))
+	assert.Contains(decoded[0], "payload")
+	assert.JSONEq(`{"a":1}`, string(decoded[0][decodedPayloadKey]))
+	assert.NotContains(decoded[0], decodedPayloadErrorKey)
+
+	var decodeErr PayloadDecodeError
+	require.Nil(json.Unmarshal(decoded[1][decodedPayloadErrorKey], &decodeErr))
+	assert.Equal(jsonDecoder, decodeErr.Decoder)
+	assert.Contains(decodeErr.Error, errInvalidJSON.Error())
+	assert.NotContains(decoded[1], decodedPayloadKey)
+
+	var noDecoderErr PayloadDecodeError
+	require.Nil(json.Unmarshal(decoded[2][decodedPayloadErrorKey], &noDecoderErr))
+	assert.Empty(noDecoderErr.Decoder)
+	assert.Contains(noDecoderErr.Error, "no decoder")
+
+	assert.NotContains(decoded[3], decodedPayloadKey)
+	assert.NotContains(decoded[3], decodedPayloadErrorKey)
+
+	p.Assert(t, PayloadDecodeFailureCounter, decoderLabel, jsonDecoder)(xmetricstest.Value(1.0))
+}
+
+func TestHandleGetEventsDecodePayload(t *testing.T) {
+	testassert := assert.New(t)
+	futureTime := time.Now().Add(time.Duration(50000) * time.Minute).UnixNano()
+	event := goodOnlineEvent
+	event.ContentType = "application/json"
+	var data []byte
+	testassert.Nil(wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(&event))
+	records := []db.Record{
+		{Type: db.State, BirthDate: futureTime - 500, DeathDate: futureTime, Data: data, Alg: string(voynicrypto.None), KID: "none"},
+	}
+
+	tests := []struct {
+		description        string
+		query              string
+		expectedStatusCode int
+		expectDecoded      bool
+	}{
+		{
+			description:        "Bad Decode Payload Value",
+			query:              "?decode_payload=maybe",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Not Asked For",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "Decoded",
+			query:              "?decode_payload=true",
+			expectedStatusCode: http.StatusOK,
+			expectDecoded:      true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			mockGetter := new(mockRecordGetter)
+			mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return(records, nil).Once()
+			mockGetter.On("GetStateHash", mock.Anything).Return("123", nil).Once()
+
+			decrypter := new(mockDecrypter)
+			decrypter.On("DecryptMessage", mock.Anything, mock.Anything).Return(nil)
+			ciphers := voynicrypto.Ciphers{
+				Options: map[voynicrypto.AlgorithmType]map[string]voynicrypto.Decrypt{
+					voynicrypto.None: {"none": decrypter},
+				},
+			}
+			app := App{
+				eventGetter:   mockGetter,
+				getEventLimit: 5,
+				logger:        logging.DefaultLogger(),
+				decrypters:    &ciphers,
+				measures:      NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+			}
+
+			auth := bascule.Authentication{
+				Token: bascule.NewToken("jwt", "owner", bascule.NewAttributes(map[string]interface{}{
+					"allowedResources": map[string]interface{}{"allowedPartners": []string{"test1"}},
+				})),
+			}
+			rr := httptest.NewRecorder()
+			request, err := http.NewRequestWithContext(bascule.WithAuthentication(context.Background(), auth),
+				http.MethodGet, "/device/1234/events"+tc.query, nil)
+			require.Nil(err)
+			request = mux.SetURLVars(request, map[string]string{"deviceID": "mac:112233445566"})
+			app.handleGetEvents(rr, request)
+			require.Equal(tc.expectedStatusCode, rr.Code)
+			if tc.expectedStatusCode != http.StatusOK {
+				assert.NotEmpty(rr.Header().Get("X-Codex-Error"))
+				return
+			}
+
+			var decoded []map[string]json.RawMessage
+			require.Nil(json.Unmarshal(rr.Body.Bytes(), &decoded))
+			require.Len(decoded, 1)
+			if tc.expectDecoded {
+				assert.JSONEq(string(event.Payload), string(decoded[0][decodedPayloadKey]))
+			} else {
+				assert.NotContains(decoded[0], decodedPayloadKey)
+			}
+		})
+	}
+}
@@ -7,13 +7,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -37,7 +41,6 @@ import (
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/go-kit/log"
 	"github.com/goph/emperror"
-	"github.com/gorilla/mux"
 	newchecks "github.com/xmidt-org/bascule/basculechecks"
 	db "github.com/xmidt-org/codex-db"
 	"github.com/xmidt-org/webpa-common/v2/basculechecks"  //nolint: staticcheck
@@ -47,16 +50,27 @@ import (
 )
 
 type App struct {
-	eventGetter     db.RecordGetter
-	logger          log.Logger
-	getEventLimit   int
-	getStatusLimit  int
-	longPollSleep   time.Duration
-	longPollTimeout time.Duration
-	decrypters      voynicrypto.Ciphers
+	eventGetter       db.RecordGetter
+	logger            log.Logger
+	getEventLimit     int
+	getStatusLimit    int
+	decodeParallelism int
+	longPollSleep     time.Duration
+	longPollTimeout   time.Duration
+	decrypters        Decrypters
 
 	measures                    *Measures
 	basicAuthPartnerIDHeaderKey string
+	admin                       AdminConfig
+	payloadDecoders             *PayloadDecoders
+	redactor                    *Redactor
+	status                      StatusConfig
+	deviceIDs                   DeviceIDConfig
+	expiredRecords              ExpiredRecordsConfig
+	webhooks                    *Webhooks
+	exports                     *Exports
+	replayer                    *Replayer
+	graphql                     *GraphQL
 }
 
 var (
@@ -65,7 +79,7 @@ var (
 	basicType                    = "basic"
 )
 
-func (app *App) getDeviceInfoAfterHash(deviceID string, requestHash string, ctx context.Context) ([]model.Event, string, error) {
+func (app *App) getDeviceInfoAfterHash(deviceID string, requestHash string, includeExpired bool, ctx context.Context) ([]model.Event, string, error) {
 	var (
 		hash string
 		err  error
@@ -82,18 +96,25 @@ func (app *App) getDeviceInfoAfterHash(deviceID string, requestHash string, ctx
 	if err != nil {
 		logging.Error(app.logger, emperror.Context(err)...).Log(logging.MessageKey(), "Failed to get latest hash from records", logging.ErrorKey(), err.Error())
 	}
-	events := app.parseRecords(records)
+	events := app.parseRecords(records, includeExpired)
 
+	start := time.Now()
 	after := time.After(app.longPollTimeout)
+	// only requests that waited for events are observed, so the histogram
+	// isn't skewed by the ones answered right away
+	waited := false
 	// TODO: improve long poll logic
 	for len(events) == 0 {
+		waited = true
 		select {
 		case <-ctx.Done():
+			app.observeLongPoll(longPollCanceled, start)
 			// request was canceled.
 			// 499 Client Closed Request (from nginx)
 			return []model.Event{}, "", serverErr{emperror.With(ctx.Err(), "device id", deviceID, "hash", requestHash),
 				499}
 		case <-after:
+			app.observeLongPoll(longPollTimedOut, start)
 			return []model.Event{}, "", serverErr{emperror.With(fmt.Errorf("long poll timeout expired after %s", app.longPollTimeout), "device id", deviceID, "hash", requestHash),
 				http.StatusNoContent}
 
@@ -105,6 +126,7 @@ func (app *App) getDeviceInfoAfterHash(deviceID string, requestHash string, ctx
 			}
 			// if both have errors or are empty, return an error
 			if hErr != nil {
+				app.observeLongPoll(longPollFailed, start)
 				return []model.Event{}, "", serverErr{emperror.WrapWith(hErr, "Failed to get events", "device id", deviceID, "hash", requestHash),
 					http.StatusInternalServerError}
 			}
@@ -112,16 +134,23 @@ func (app *App) getDeviceInfoAfterHash(deviceID string, requestHash string, ctx
 			if err != nil {
 				logging.Error(app.logger, emperror.Context(err)...).Log(logging.MessageKey(), "Failed to get latest hash from records", logging.ErrorKey(), err.Error())
 			}
-			events = app.parseRecords(records)
+			events = app.parseRecords(records, includeExpired)
 		}
 	}
 
+	if waited {
+		app.observeLongPoll(longPollReturnedEvents, start)
+	}
 	app.measures.EventsReturnedCount.Add(float64(len(events)))
 
 	return events, hash, nil
 }
 
-func (app *App) getDeviceInfo(deviceID string) ([]model.Event, string, error) {
+func (app *App) observeLongPoll(outcome string, start time.Time) {
+	app.measures.LongPollDuration.With(outcomeLabel, outcome).Observe(time.Since(start).Seconds())
+}
+
+func (app *App) getDeviceInfo(deviceID string, includeExpired bool) ([]model.Event, string, error) {
 
 	records, hErr := app.eventGetter.GetRecords(deviceID, app.getEventLimit, "")
 	// if both have errors or are empty, return an error
@@ -138,7 +167,7 @@ func (app *App) getDeviceInfo(deviceID string) ([]model.Event, string, error) {
 	if err != nil {
 		logging.Warn(app.logger, emperror.Context(err)...).Log(logging.MessageKey(), "Failed to get latest hash from records", logging.ErrorKey(), err.Error(), "hash", hash)
 	}
-	events := app.parseRecords(records)
+	events := app.parseRecords(records, includeExpired)
 
 	if len(events) == 0 {
 		return events, "", serverErr{emperror.With(errors.New("no events found for device id"), "device id", deviceID),
@@ -149,57 +178,160 @@ func (app *App) getDeviceInfo(deviceID string) ([]model.Event, string, error) {
 	return events, hash, nil
 }
 
-func (app *App) parseRecords(records []db.Record) []model.Event {
-	events := []model.Event{}
-	// if all is good, unmarshal everything
-	for _, record := range records {
+// parseRecords decodes the records, skipping expired ones unless
+// includeExpired is set.  Up to decodeParallelism records are decrypted and
+// decoded at once, and the events keep the order of the records.
+func (app *App) parseRecords(records []db.Record, includeExpired bool) []model.Event {
+	var (
+		parsed = make([]model.Event, len(records))
+		keep   = make([]bool, len(records))
+	)
+	parse := func(i int) {
+		record := records[i]
 		// if the record is expired, don't include it
-		if time.Unix(0, record.DeathDate).Before(time.Now()) {
+		if !includeExpired && isExpired(record) {
 			logging.Debug(app.logger).Log(logging.MessageKey(), "the record is expired", "timesince", time.Since(time.Unix(0, record.DeathDate)))
-			continue
+			return
 		}
 
-		event := model.Event{
-			BirthDate: record.BirthDate,
-		}
-		decrypter, ok := app.decrypters.Get(voynicrypto.ParseAlgorithmType(record.Alg), record.KID)
-		if !ok {
-			app.measures.GetDecryptFailure.Add(1.0)
-			logging.Error(app.logger).Log(logging.MessageKey(), "Failed to get decrypter")
-			event.Type = wrp.UnknownMessageType
-			events = append(events, event)
-			continue
-		}
-		data, err := decrypter.DecryptMessage(record.Data, record.Nonce)
+		event, stage, err := app.decodeRecord(record)
 		if err != nil {
-			app.measures.DecryptFailure.Add(1.0)
-			logging.Error(app.logger).Log(logging.MessageKey(), "Failed to decrypt event", logging.ErrorKey(), err.Error())
+			app.recordDecodeFailure(record, stage, err)
 			event.Type = wrp.UnknownMessageType
-			events = append(events, event)
-			continue
+			event.Error = &model.EventError{Stage: stage, Reason: err.Error()}
 		}
+		parsed[i] = event
+		keep[i] = true
+	}
 
-		decoder := wrp.NewDecoderBytes(data, wrp.Msgpack)
-		err = decoder.Decode(&event)
-		if err != nil {
-			app.measures.UnmarshalFailure.Add(1.0)
-			logging.Error(app.logger, emperror.Context(err)...).Log(logging.MessageKey(), "Failed to decode decrypted event", logging.ErrorKey(), err.Error())
-			event.Type = wrp.UnknownMessageType
-			events = append(events, event)
-			continue
+	workers := min(app.decodeParallelism, len(records))
+	if workers <= 1 {
+		for i := range records {
+			parse(i)
+		}
+	} else {
+		var (
+			next = make(chan int)
+			wg   sync.WaitGroup
+		)
+		wg.Add(workers)
+		for w := 0; w < workers; w++ {
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					parse(i)
+				}
+			}()
 		}
+		for i := range records {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+	}
 
-		events = append(events, event)
+	events := make([]model.Event, 0, len(records))
+	for i, event := range parsed {
+		if keep[i] {
+			events = append(events, event)
+		}
 	}
 	return events
 }
 
+// The stages a record goes through before it can be returned.
+const (
+	stageExpiry    = "expiry"
+	stageDecrypter = "decrypter"
+	stageDecrypt   = "decrypt"
+	stageDecode    = "decode"
+	stagePayload   = "payload"
+)
+
+var errNoDecrypter = errors.New("failed to find decrypter")
+
+func isExpired(record db.Record) bool {
+	return time.Unix(0, record.DeathDate).Before(time.Now())
+}
+
+// decodeRecord decrypts and decodes a record.  On failure it returns the
+// stage that failed, along with whatever of the event was decoded.
+func (app *App) decodeRecord(record db.Record) (model.Event, string, error) {
+	event := model.Event{
+		BirthDate: record.BirthDate,
+		DeathDate: record.DeathDate,
+	}
+	decrypter, ok := app.decrypters.Get(voynicrypto.ParseAlgorithmType(record.Alg), record.KID)
+	if !ok {
+		return event, stageDecrypter, errNoDecrypter
+	}
+	data, err := app.decrypt(decrypter, record)
+	if err != nil {
+		return event, stageDecrypt, err
+	}
+
+	decoder := wrp.NewDecoderBytes(data, wrp.Msgpack)
+	if err := decoder.Decode(&event); err != nil {
+		return event, stageDecode, err
+	}
+	return event, "", nil
+}
+
+// recordDecodeFailure counts and logs a record that failed decodeRecord.
+func (app *App) recordDecodeFailure(record db.Record, stage string, err error) {
+	app.countDecodeFailure(record, stage)
+	switch stage {
+	case stageDecrypter:
+		logging.Error(app.logger, recordFields(record)...).Log(logging.MessageKey(), "Failed to get decrypter")
+	case stageDecrypt:
+		logging.Error(app.logger, recordFields(record)...).Log(logging.MessageKey(), "Failed to decrypt event", logging.ErrorKey(), err.Error())
+	case stageDecode:
+		logging.Error(app.logger, append(recordFields(record), emperror.Context(err)...)...).Log(logging.MessageKey(), "Failed to decode decrypted event", logging.ErrorKey(), err.Error())
+	}
+}
+
+// countDecodeFailure increments the failure counter for the stage.
+func (app *App) countDecodeFailure(record db.Record, stage string) {
+	switch stage {
+	case stageDecrypter:
+		app.measures.GetDecryptFailure.With(recordLabels(record)...).Add(1.0)
+	case stageDecrypt:
+		app.measures.DecryptFailure.With(recordLabels(record)...).Add(1.0)
+	case stageDecode:
+		app.measures.UnmarshalFailure.With(recordLabels(record)...).Add(1.0)
+	}
+}
+
+// recordFields returns the log key/value pairs identifying a record, without
+// exposing any of its data.
+func recordFields(record db.Record) []interface{} {
+	return []interface{}{
+		"deviceID", record.DeviceID,
+		"rowID", record.RowID,
+		"birthDate", record.BirthDate,
+		"eventType", record.Type.String(),
+		"alg", record.Alg,
+		"kid", record.KID,
+	}
+}
+
+// decrypt decrypts the record's data, recording how long it took.
+func (app *App) decrypt(decrypter voynicrypto.Decrypt, record db.Record) ([]byte, error) {
+	start := time.Now()
+	data, err := decrypter.DecryptMessage(record.Data, record.Nonce)
+	app.measures.DecryptDuration.With(algorithmLabel, record.Alg, kidLabel, record.KID).Observe(time.Since(start).Seconds())
+	return data, err
+}
+
 /*
  * swagger:route GET /device/{deviceID}/events device getEvents
  *
- * Get all of the events related to a specific device id.
+ * Get all of the events related to a specific device id.  With
+ * decode_payload=true, each event's payload is also rendered as JSON in
+ * decoded_payload, chosen by its content type, or decoded_payload_error
+ * explains why it couldn't be.
  *
- * Parameters: deviceID
+ * Parameters: deviceID, after, decode_payload, include_expired, omit_failed, order, dedupe
  *
  * Produces:
  *    - application/json
@@ -224,10 +356,8 @@ func (app *App) handleGetEvents(writer http.ResponseWriter, request *http.Reques
 		err      error
 		coder    kithttp.StatusCoder
 	)
-	vars := mux.Vars(request)
-	id := strings.ToLower(vars["deviceID"])
-	if id == "" {
-		writer.WriteHeader(http.StatusNotFound)
+	id, ok := app.requestDeviceID(writer, request)
+	if !ok {
 		return
 	}
 
@@ -237,8 +367,36 @@ func (app *App) handleGetEvents(writer http.ResponseWriter, request *http.Reques
 		return
 	}
 
+	includeExpired, ok := app.requestIncludeExpired(writer, request)
+	if !ok {
+		return
+	}
+
+	omit, err := requestOmitFailed(request)
+	if err != nil {
+		writer.Header().Add("X-Codex-Error", err.Error())
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	options, err := requestEventOptions(request)
+	if err != nil {
+		writer.Header().Add("X-Codex-Error", err.Error())
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	decodePayloads := false
+	if p := request.FormValue("decode_payload"); p != "" {
+		if decodePayloads, err = strconv.ParseBool(p); err != nil {
+			writer.Header().Add("X-Codex-Error", fmt.Sprintf("invalid decode_payload value %q", p))
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
 	if requestHash := request.FormValue("after"); requestHash != "" {
-		if d, hash, err = app.getDeviceInfoAfterHash(id, requestHash, request.Context()); err != nil {
+		if d, hash, err = app.getDeviceInfoAfterHash(id, requestHash, includeExpired, request.Context()); err != nil {
 			logging.Error(app.logger, emperror.Context(err)...).Log(logging.MessageKey(),
 				"Failed to get status info", logging.ErrorKey(), err.Error())
 			writer.Header().Add("X-Codex-Error", err.Error())
@@ -250,7 +408,7 @@ func (app *App) handleGetEvents(writer http.ResponseWriter, request *http.Reques
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-	} else if d, hash, err = app.getDeviceInfo(id); err != nil {
+	} else if d, hash, err = app.getDeviceInfo(id, includeExpired); err != nil {
 		logging.Error(app.logger, emperror.Context(err)...).Log(logging.MessageKey(),
 			"Failed to get status info", logging.ErrorKey(), err.Error())
 		writer.Header().Add("X-Codex-Error", err.Error())
@@ -263,24 +421,23 @@ func (app *App) handleGetEvents(writer http.ResponseWriter, request *http.Reques
 		return
 	}
 
-	// if partners contains wildcard, do not filter and send all events
-	if contains(requestPartnerIDs, "*") {
-		filtered = d
-	} else {
-		for _, event := range d {
-			if overlaps(event.PartnerIDs, requestPartnerIDs) {
-				filtered = append(filtered, event)
-			}
-		}
+	// failures are only counted once the events are filtered, so that a
+	// partner doesn't learn about other partners' records
+	filtered = filterPartners(d, requestPartnerIDs)
+	warnings := countFailed(filtered)
+	if omit {
+		filtered = omitFailed(filtered)
 	}
+	filtered, removed := options.apply(filtered)
+	filtered = app.redactor.forRequest(request, requestPartnerIDs).events(filtered)
+	addAuditDevice(request.Context(), id, len(filtered))
 
 	var data []byte
-	// TODO: revert to json spec, aka encode integers > 2^53 as a json string
-	err = codec.NewEncoderBytes(&data, &codec.JsonHandle{
-		BasicHandle: codec.BasicHandle{ //nolint: staticcheck
-			TypeInfos: codec.NewTypeInfos([]string{"wrp"}),
-		},
-	}).Encode(filtered)
+	if decodePayloads {
+		data, err = app.encodeEventsWithPayloads(filtered)
+	} else {
+		data, err = encodeEvents(filtered)
+	}
 	if err != nil {
 		writer.Header().Add("X-Codex-Error", err.Error())
 		writer.WriteHeader(http.StatusInternalServerError)
@@ -290,20 +447,147 @@ func (app *App) handleGetEvents(writer http.ResponseWriter, request *http.Reques
 	if hash != "" {
 		writer.Header().Add("X-Codex-Hash", hash)
 	}
+	writer.Header().Set(warningsHeader, strconv.Itoa(warnings))
+	if options.dedupe != "" {
+		writer.Header().Set(duplicatesRemovedHeader, strconv.Itoa(removed))
+	}
 	writer.WriteHeader(http.StatusOK)
 	writer.Write(data)
 }
 
-//nolint:funlen // this will be fixed with uber fx
-func authChain(basicAuth []string, jwtConfig JWTValidator, tsConfig touchstone.Config, zConfig sallust.Config, capabilityCheck CapabilityConfig, logger log.Logger, registry xmetrics.Registry) (alice.Chain, error) {
-	if registry == nil {
-		return alice.Chain{}, errors.New("nil registry")
+// warningsHeader is the number of records read for a response that couldn't be
+// decrypted or decoded, whether or not they were omitted.  Records that
+// couldn't be decrypted have no partner ids, so they are only counted for
+// callers allowed to see every partner's records.
+const warningsHeader = "X-Codex-Warnings"
+
+// requestOmitFailed reads the omit_failed option, which drops the events that
+// couldn't be decrypted or decoded instead of returning them with an error.
+func requestOmitFailed(request *http.Request) (bool, error) {
+	o := request.FormValue("omit_failed")
+	if o == "" {
+		return false, nil
 	}
+	omit, err := strconv.ParseBool(o)
+	if err != nil {
+		return false, fmt.Errorf("invalid omit_failed value %q", o)
+	}
+	return omit, nil
+}
 
-	basculeMeasures := basculemetrics.NewAuthValidationMeasures(registry)
-	capabilityCheckMeasures := basculechecks.NewAuthCapabilityCheckMeasures(registry)
-	listener := basculemetrics.NewMetricListener(basculeMeasures)
+// countFailed returns how many of the events couldn't be decrypted or decoded.
+func countFailed(events []model.Event) int {
+	var n int
+	for _, e := range events {
+		if e.Error != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// omitFailed returns the events that were decrypted and decoded.
+func omitFailed(events []model.Event) []model.Event {
+	kept := make([]model.Event, 0, len(events))
+	for _, e := range events {
+		if e.Error == nil {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// filterPartners returns the events belonging to any of the partners.
+func filterPartners(events []model.Event, partnerIDs []string) []model.Event {
+	// if partners contains wildcard, do not filter and send all events
+	if contains(partnerIDs, "*") {
+		return events
+	}
+	var filtered []model.Event
+	for _, event := range events {
+		if overlaps(event.PartnerIDs, partnerIDs) {
+			filtered = append(filtered, event)
+		}
+	}
+	return filtered
+}
 
+// parseMessageTypes returns the friendly names of the wrp message types,
+// such as SimpleEvent, which events' types are compared to.
+func parseMessageTypes(types []string) ([]string, error) {
+	names := make([]string, 0, len(types))
+	for _, t := range types {
+		msgType, err := wrp.StringToMessageType(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid type %q", t)
+		}
+		names = append(names, msgType.FriendlyName())
+	}
+	return names, nil
+}
+
+// encodeEvents encodes events as JSON using their wrp field names.
+func encodeEvents(events interface{}) ([]byte, error) {
+	var data []byte
+	// TODO: revert to json spec, aka encode integers > 2^53 as a json string
+	err := codec.NewEncoderBytes(&data, &codec.JsonHandle{
+		BasicHandle: codec.BasicHandle{ //nolint: staticcheck
+			TypeInfos: codec.NewTypeInfos([]string{"wrp"}),
+		},
+	}).Encode(events)
+	return data, err
+}
+
+// AuthSettings holds the basic auth credentials and capability check used by
+// the auth chain.  They can be replaced while gungnir is running without
+// rebuilding the rest of the chain.
+type AuthSettings struct {
+	logger   log.Logger
+	measures *basculechecks.AuthCapabilityCheckMeasures
+
+	basic      atomic.Pointer[basculehttp.BasicTokenFactory]
+	capability atomic.Pointer[capabilityRule]
+	keys       *KeyRefreshStatus
+}
+
+// JWTKeys reports whether the refresher has fetched the keys used to verify
+// JWTs.
+func (a *AuthSettings) JWTKeys() *KeyRefreshStatus {
+	return a.keys
+}
+
+type capabilityRule struct {
+	validator bascule.Validator
+}
+
+// Update replaces the allowed basic auth credentials and the capability check.
+// If the capability check cannot be built, nothing is replaced.
+func (a *AuthSettings) Update(basicAuth []string, capabilityCheck CapabilityConfig) error {
+	validator, err := newCapabilityValidator(capabilityCheck, a.measures, a.logger)
+	if err != nil {
+		return err
+	}
+	basic := basculehttp.BasicTokenFactory(decodeBasicAuth(basicAuth, a.logger))
+	a.basic.Store(&basic)
+	a.capability.Store(&capabilityRule{validator: validator})
+	return nil
+}
+
+// ParseAndValidate checks basic auth against the current credentials.
+func (a *AuthSettings) ParseAndValidate(ctx context.Context, r *http.Request, auth bascule.Authorization, value string) (bascule.Token, error) {
+	return a.basic.Load().ParseAndValidate(ctx, r, auth, value)
+}
+
+// Check runs the current capability check, if one is configured.
+func (a *AuthSettings) Check(ctx context.Context, token bascule.Token) error {
+	rule := a.capability.Load()
+	if rule == nil || rule.validator == nil {
+		return nil
+	}
+	return rule.validator.Check(ctx, token)
+}
+
+func decodeBasicAuth(basicAuth []string, logger log.Logger) map[string]string {
 	basicAllowed := make(map[string]string)
 	for _, a := range basicAuth {
 		decoded, err := base64.StdEncoding.DecodeString(a)
@@ -317,15 +601,62 @@ func authChain(basicAuth []string, jwtConfig JWTValidator, tsConfig touchstone.C
 		}
 	}
 	logging.Debug(logger).Log(logging.MessageKey(), "Created list of allowed basic auths", "allowed", basicAllowed, "config", basicAuth)
+	return basicAllowed
+}
+
+// newCapabilityValidator returns the capability check for bearer tokens, or
+// nil if the configuration doesn't ask for one.
+func newCapabilityValidator(capabilityCheck CapabilityConfig, measures *basculechecks.AuthCapabilityCheckMeasures, logger log.Logger) (bascule.Validator, error) {
+	// only add capability check if the configuration is set
+	if capabilityCheck.Type != "enforce" && capabilityCheck.Type != "monitor" {
+		return nil, nil
+	}
+
+	var endpoints []*regexp.Regexp
+	c, err := basculechecks.NewEndpointRegexCheck(capabilityCheck.Prefix, capabilityCheck.AcceptAllMethod)
+	if err != nil {
+		return nil, emperror.With(err, "failed to create capability check")
+	}
+	for _, e := range capabilityCheck.EndpointBuckets {
+		r, err := regexp.Compile(e)
+		if err != nil {
+			logging.Error(logger).Log(logging.MessageKey(), "failed to compile regular expression", "regex", e, logging.ErrorKey(), err.Error())
+			continue
+		}
+		endpoints = append(endpoints, r)
+	}
+	m := basculechecks.MetricValidator{
+		C:         basculechecks.CapabilitiesValidator{Checker: c},
+		Measures:  measures,
+		Endpoints: endpoints,
+	}
+	return m.CreateValidator(capabilityCheck.Type == "enforce"), nil
+}
+
+//nolint:funlen // this will be fixed with uber fx
+func authChain(basicAuth []string, jwtConfig JWTValidator, tsConfig touchstone.Config, zConfig sallust.Config, capabilityCheck CapabilityConfig, logger log.Logger, registry xmetrics.Registry) (alice.Chain, *AuthSettings, error) {
+	if registry == nil {
+		return alice.Chain{}, nil, errors.New("nil registry")
+	}
+
+	basculeMeasures := basculemetrics.NewAuthValidationMeasures(registry)
+	listener := basculemetrics.NewMetricListener(basculeMeasures)
+
+	settings := &AuthSettings{
+		logger:   logger,
+		measures: basculechecks.NewAuthCapabilityCheckMeasures(registry),
+		keys:     NewKeyRefreshStatus(jwtConfig.Config.Refresh),
+	}
+	if err := settings.Update(basicAuth, capabilityCheck); err != nil {
+		return alice.Chain{}, nil, err
+	}
 
 	options := []basculehttp.COption{
 		basculehttp.WithCLogger(GetLogger),
 		basculehttp.WithCErrorResponseFunc(listener.OnErrorResponse),
 		basculehttp.WithParseURLFunc(basculehttp.CreateRemovePrefixURLFunc(apiBase+"/", basculehttp.DefaultParseURLFunc)),
 	}
-	if len(basicAllowed) > 0 {
-		options = append(options, basculehttp.WithTokenFactory("Basic", basculehttp.BasicTokenFactory(basicAllowed)))
-	}
+	options = append(options, basculehttp.WithTokenFactory("Basic", settings))
 
 	// Instantiate a keyring for refresher and resolver to share
 	kr := clortho.NewKeyRing()
@@ -333,7 +664,7 @@ func authChain(basicAuth []string, jwtConfig JWTValidator, tsConfig touchstone.C
 	// Instantiate a fetcher for refresher and resolver to share
 	f, err := clortho.NewFetcher()
 	if err != nil {
-		return alice.Chain{}, emperror.With(err, "failed to create clortho fetcher")
+		return alice.Chain{}, nil, emperror.With(err, "failed to create clortho fetcher")
 	}
 
 	ref, err := clortho.NewRefresher(
@@ -341,7 +672,7 @@ func authChain(basicAuth []string, jwtConfig JWTValidator, tsConfig touchstone.C
 		clortho.WithFetcher(f),
 	)
 	if err != nil {
-		return alice.Chain{}, emperror.With(err, "failed to create clortho refresher")
+		return alice.Chain{}, nil, emperror.With(err, "failed to create clortho refresher")
 	}
 
 	resolver, err := clortho.NewResolver(
@@ -350,12 +681,12 @@ func authChain(basicAuth []string, jwtConfig JWTValidator, tsConfig touchstone.C
 		clortho.WithFetcher(f),
 	)
 	if err != nil {
-		return alice.Chain{}, emperror.With(err, "failed to create clortho resolver")
+		return alice.Chain{}, nil, emperror.With(err, "failed to create clortho resolver")
 	}
 
 	promReg, ok := registry.(prometheus.Registerer)
 	if !ok {
-		return alice.Chain{}, errors.New("failed to get prometheus registerer")
+		return alice.Chain{}, nil, errors.New("failed to get prometheus registerer")
 	}
 
 	zlogger := zap.Must(zConfig.Build())
@@ -363,7 +694,7 @@ func authChain(basicAuth []string, jwtConfig JWTValidator, tsConfig touchstone.C
 	// Instantiate a metric listener for refresher and resolver to share
 	cml, err := clorthometrics.NewListener(clorthometrics.WithFactory(tf))
 	if err != nil {
-		return alice.Chain{}, emperror.With(err, "failed to create clortho metrics listener")
+		return alice.Chain{}, nil, emperror.With(err, "failed to create clortho metrics listener")
 	}
 
 	// Instantiate a logging listener for refresher and resolver to share
@@ -371,7 +702,7 @@ func authChain(basicAuth []string, jwtConfig JWTValidator, tsConfig touchstone.C
 		clorthozap.WithLogger(zlogger),
 	)
 	if err != nil {
-		return alice.Chain{}, emperror.With(err, "failed to create clortho zap logger listener")
+		return alice.Chain{}, nil, emperror.With(err, "failed to create clortho zap logger listener")
 	}
 
 	resolver.AddListener(cml)
@@ -379,6 +710,7 @@ func authChain(basicAuth []string, jwtConfig JWTValidator, tsConfig touchstone.C
 	ref.AddListener(cml)
 	ref.AddListener(czl)
 	ref.AddListener(kr)
+	ref.AddListener(settings.keys)
 	// context.Background() is for the unused `context.Context` argument in refresher.Start
 	ref.Start(context.Background())
 	// Shutdown refresher's goroutines when SIGTERM
@@ -403,29 +735,7 @@ func authChain(basicAuth []string, jwtConfig JWTValidator, tsConfig touchstone.C
 		newchecks.NonEmptyPrincipal(),
 		newchecks.NonEmptyType(),
 		newchecks.ValidType([]string{"jwt"}),
-	}
-
-	// only add capability check if the configuration is set
-	if capabilityCheck.Type == "enforce" || capabilityCheck.Type == "monitor" {
-		var endpoints []*regexp.Regexp
-		c, err := basculechecks.NewEndpointRegexCheck(capabilityCheck.Prefix, capabilityCheck.AcceptAllMethod)
-		if err != nil {
-			return alice.Chain{}, emperror.With(err, "failed to create capability check")
-		}
-		for _, e := range capabilityCheck.EndpointBuckets {
-			r, err := regexp.Compile(e)
-			if err != nil {
-				logging.Error(logger).Log(logging.MessageKey(), "failed to compile regular expression", "regex", e, logging.ErrorKey(), err.Error())
-				continue
-			}
-			endpoints = append(endpoints, r)
-		}
-		m := basculechecks.MetricValidator{
-			C:         basculechecks.CapabilitiesValidator{Checker: c},
-			Measures:  capabilityCheckMeasures,
-			Endpoints: endpoints,
-		}
-		bearerRules = append(bearerRules, m.CreateValidator(capabilityCheck.Type == "enforce"))
+		settings,
 	}
 
 	authEnforcer := basculehttp.NewEnforcer(
@@ -437,7 +747,7 @@ func authChain(basicAuth []string, jwtConfig JWTValidator, tsConfig touchstone.C
 		basculehttp.WithEErrorResponseFunc(listener.OnErrorResponse),
 	)
 
-	return alice.New(SetLogger(logger), authConstructor, authEnforcer, basculehttp.NewListenerDecorator(listener)), nil
+	return alice.New(SetLogger(logger), authConstructor, authEnforcer, basculehttp.NewListenerDecorator(listener)), settings, nil
 }
 
 func extractPartnerIDs(r *http.Request, basicAuth string) ([]string, error) {
@@ -474,6 +784,15 @@ func extractPartnerIDs(r *http.Request, basicAuth string) ([]string, error) {
 	return nil, errAuthIsNotOfTypeBasicOrJWT
 }
 
+// requestCaller returns the principal and auth type of the request.
+func requestCaller(request *http.Request) (principal string, authType string, ok bool) {
+	auth, ok := bascule.FromContext(request.Context())
+	if !ok || auth.Token == nil || auth.Token.Principal() == "" {
+		return "", "", false
+	}
+	return auth.Token.Principal(), auth.Token.Type(), true
+}
+
 func overlaps(sl1 []string, sl2 []string) bool {
 	for _, s1 := range sl1 {
 		for _, s2 := range sl2 {
@@ -493,3 +812,27 @@ func contains(s []string, e string) bool {
 	}
 	return false
 }
+
+// writeJSON responds with the body encoded as JSON.
+func writeJSON(writer http.ResponseWriter, code int, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	writer.Write(data)
+}
+
+// writeError responds with the error's status code, or a 500 if it has
+// none, and the error in X-Codex-Error.
+func writeError(writer http.ResponseWriter, err error) {
+	writer.Header().Add("X-Codex-Error", err.Error())
+	var coder kithttp.StatusCoder
+	if errors.As(err, &coder) {
+		writer.WriteHeader(coder.StatusCode())
+		return
+	}
+	writer.WriteHeader(http.StatusInternalServerError)
+}
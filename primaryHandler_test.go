@@ -5,6 +5,10 @@ package main
 
 import (
 	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -23,8 +27,10 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/xmidt-org/voynicrypto"
 
+	"github.com/go-kit/kit/metrics"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/stretchr/testify/assert"
+	"github.com/xmidt-org/webpa-common/v2/basculechecks"         //nolint: staticcheck
 	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
 	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
 )
@@ -134,7 +140,7 @@ func TestGetDeviceInfo(t *testing.T) {
 			},
 			expectedFailureMetric: 1.0,
 			expectedEvents: []model.Event{
-				{Message: wrp.Message{Type: 11}, BirthDate: 0},
+				{Message: wrp.Message{Type: 11}, BirthDate: 0, DeathDate: futureTime, Error: &model.EventError{Stage: stageDecode}},
 			},
 		},
 		{
@@ -149,7 +155,7 @@ func TestGetDeviceInfo(t *testing.T) {
 			},
 			decryptErr: errors.New("failed to decrypt"),
 			expectedEvents: []model.Event{
-				{Message: wrp.Message{Type: 11}, BirthDate: 0},
+				{Message: wrp.Message{Type: 11}, BirthDate: 0, DeathDate: futureTime, Error: &model.EventError{Stage: stageDecrypt}},
 			},
 		},
 		{
@@ -164,7 +170,7 @@ func TestGetDeviceInfo(t *testing.T) {
 				},
 			},
 			expectedEvents: []model.Event{
-				{Message: wrp.Message{Type: 11}, BirthDate: prevTime.UnixNano()},
+				{Message: wrp.Message{Type: 11}, BirthDate: prevTime.UnixNano(), DeathDate: futureTime, Error: &model.EventError{Stage: stageDecrypter}},
 			},
 		},
 		{
@@ -179,7 +185,7 @@ func TestGetDeviceInfo(t *testing.T) {
 				},
 			},
 			expectedEvents: []model.Event{
-				{Message: goodOnlineEvent, BirthDate: prevTime.UnixNano()},
+				{Message: goodOnlineEvent, BirthDate: prevTime.UnixNano(), DeathDate: futureTime},
 			},
 		},
 	}
@@ -208,13 +214,22 @@ func TestGetDeviceInfo(t *testing.T) {
 			app := App{
 				eventGetter:   mockGetter,
 				logger:        logging.DefaultLogger(),
-				decrypters:    ciphers,
+				decrypters:    &ciphers,
 				measures:      m,
 				getEventLimit: 5,
 			}
-			p.Assert(t, UnmarshalFailureCounter)(xmetricstest.Value(0.0))
-			events, _, err := app.getDeviceInfo("test")
-			p.Assert(t, UnmarshalFailureCounter)(xmetricstest.Value(tc.expectedFailureMetric))
+			labels := []string{algorithmLabel, string(voynicrypto.None), kidLabel, "none", eventTypeLabel, db.Default.String()}
+			p.Assert(t, UnmarshalFailureCounter, labels...)(xmetricstest.Value(0.0))
+			events, _, err := app.getDeviceInfo("test", false)
+			p.Assert(t, UnmarshalFailureCounter, labels...)(xmetricstest.Value(tc.expectedFailureMetric))
+			// the reasons come from the decrypters and decoder, so only their
+			// presence is checked
+			for _, e := range events {
+				if e.Error != nil {
+					assert.NotEmpty(e.Error.Reason)
+					e.Error.Reason = ""
+				}
+			}
 			assert.Equal(tc.expectedEvents, events)
 
 			if tc.expectedErr == nil || err == nil {
@@ -261,13 +276,13 @@ func TestHandleGetEvents(t *testing.T) {
 		},
 		{
 			description:        "Get Device Info Error",
-			deviceID:           "1234",
+			deviceID:           "mac:112233445566",
 			expectedStatusCode: http.StatusNotFound,
 			auth:               jwtwithpartners,
 		},
 		{
 			description:        "Auth is not basic or jwt Error",
-			deviceID:           "1234",
+			deviceID:           "mac:112233445566",
 			expectedStatusCode: http.StatusBadRequest,
 			auth: bascule.Authentication{
 				Token: bascule.NewToken("spongebob", "owner-from-auth", bascule.NewAttributes(
@@ -276,7 +291,7 @@ func TestHandleGetEvents(t *testing.T) {
 		},
 		{
 			description:        "Jwt Partners do not cast Error",
-			deviceID:           "1234",
+			deviceID:           "mac:112233445566",
 			expectedStatusCode: http.StatusBadRequest,
 			auth: bascule.Authentication{
 				Token: bascule.NewToken("jwt", "owner-from-auth", bascule.NewAttributes(
@@ -285,7 +300,7 @@ func TestHandleGetEvents(t *testing.T) {
 		},
 		{
 			description:        "Jwt auth no partners Error",
-			deviceID:           "1234",
+			deviceID:           "mac:112233445566",
 			expectedStatusCode: http.StatusBadRequest,
 			auth: bascule.Authentication{
 				Token: bascule.NewToken("jwt", "owner-from-auth", bascule.NewAttributes(
@@ -294,7 +309,7 @@ func TestHandleGetEvents(t *testing.T) {
 		},
 		{
 			description: "Jwt Auth Success",
-			deviceID:    "1234",
+			deviceID:    "mac:112233445566",
 			recordsToReturn: []db.Record{
 				{
 					DeathDate: futureTime,
@@ -309,7 +324,7 @@ func TestHandleGetEvents(t *testing.T) {
 		},
 		{
 			description: "Jwt Auth No Matching Partners Success",
-			deviceID:    "1234",
+			deviceID:    "mac:112233445566",
 			recordsToReturn: []db.Record{
 				{
 					DeathDate: futureTime,
@@ -327,7 +342,7 @@ func TestHandleGetEvents(t *testing.T) {
 		},
 		{
 			description: "Basic Auth Success",
-			deviceID:    "1234",
+			deviceID:    "mac:112233445566",
 			recordsToReturn: []db.Record{
 				{
 					DeathDate: futureTime,
@@ -354,24 +369,8 @@ func TestHandleGetEvents(t *testing.T) {
 			mockGetter.On("GetRecords", tc.deviceID, 5, "").Return(tc.recordsToReturn, nil).Once()
 			mockGetter.On("GetStateHash", mock.Anything).Return("123", nil).Once()
 
-			ciphers := voynicrypto.Ciphers{
-				Options: map[voynicrypto.AlgorithmType]map[string]voynicrypto.Decrypt{
-					voynicrypto.None: {
-						"none": new(voynicrypto.NOOP),
-					},
-				},
-			}
-			p := xmetricstest.NewProvider(nil, Metrics)
-			m := NewMeasures(p)
-
-			app := App{
-				eventGetter:                 mockGetter,
-				getEventLimit:               5,
-				logger:                      logging.DefaultLogger(),
-				decrypters:                  ciphers,
-				measures:                    m,
-				basicAuthPartnerIDHeaderKey: "X-Codex-Partner-Ids",
-			}
+			app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+			app.eventGetter = mockGetter
 
 			request, err := http.NewRequestWithContext(bascule.WithAuthentication(context.Background(), tc.auth),
 				http.MethodGet, "http://localhost:8080", nil)
@@ -436,7 +435,7 @@ func TestLongPoll(t *testing.T) {
 				},
 			},
 			expectedEvents: []model.Event{
-				{Message: wrp.Message{Type: 11}, BirthDate: birthDate},
+				{Message: wrp.Message{Type: 11}, BirthDate: birthDate, DeathDate: futureTime, Error: &model.EventError{Stage: stageDecrypter, Reason: errNoDecrypter.Error()}},
 			},
 			contextTimeout:  time.Minute,
 			longPollTimeout: time.Minute,
@@ -451,28 +450,13 @@ func TestLongPoll(t *testing.T) {
 			mockGetter.On("GetRecords", "1234", 5, mock.Anything).Return(tc.recordsToReturn, nil)
 			mockGetter.On("GetStateHash", mock.Anything).Return("123", nil).Once()
 
-			ciphers := voynicrypto.Ciphers{
-				Options: map[voynicrypto.AlgorithmType]map[string]voynicrypto.Decrypt{
-					voynicrypto.None: {
-						"none": new(voynicrypto.NOOP),
-					},
-				},
-			}
-			p := xmetricstest.NewProvider(nil, Metrics)
-			m := NewMeasures(p)
-
-			app := App{
-				eventGetter:     mockGetter,
-				getEventLimit:   5,
-				logger:          logging.DefaultLogger(),
-				decrypters:      ciphers,
-				measures:        m,
-				longPollSleep:   time.Nanosecond,
-				longPollTimeout: tc.longPollTimeout,
-			}
+			app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+			app.eventGetter = mockGetter
+			app.longPollSleep = time.Nanosecond
+			app.longPollTimeout = tc.longPollTimeout
 
 			ctx, cancel := context.WithTimeout(context.Background(), tc.contextTimeout)
-			events, hash, err := app.getDeviceInfoAfterHash("1234", "ee0ce9d6-3ee2-11ea-9dff-1c6fdc758512", ctx)
+			events, hash, err := app.getDeviceInfoAfterHash("1234", "ee0ce9d6-3ee2-11ea-9dff-1c6fdc758512", false, ctx)
 			if err != nil {
 				var coder kithttp.StatusCoder
 				if errors.As(err, &coder) {
@@ -490,3 +474,345 @@ func TestLongPoll(t *testing.T) {
 		})
 	}
 }
+
+// recordingHistogram records the outcome label of every observation.
+type recordingHistogram struct {
+	outcome  string
+	observed *[]string
+}
+
+func (h recordingHistogram) With(labelValues ...string) metrics.Histogram {
+	for i := 0; i+1 < len(labelValues); i += 2 {
+		if labelValues[i] == outcomeLabel {
+			h.outcome = labelValues[i+1]
+		}
+	}
+	return h
+}
+
+func (h recordingHistogram) Observe(float64) {
+	*h.observed = append(*h.observed, h.outcome)
+}
+
+func TestLongPollObserved(t *testing.T) {
+	var goodData []byte
+	require.Nil(t, wrp.NewEncoderBytes(&goodData, wrp.Msgpack).Encode(&goodOnlineEvent))
+	records := []db.Record{{BirthDate: time.Now().UnixNano(), DeathDate: time.Now().Add(time.Hour).UnixNano(),
+		Data: goodData, Alg: string(voynicrypto.None), KID: "none"}}
+
+	tests := []struct {
+		description      string
+		waitedFor        bool
+		expectedOutcomes []string
+	}{
+		{
+			description: "Events Right Away",
+		},
+		{
+			description:      "Waited For Events",
+			waitedFor:        true,
+			expectedOutcomes: []string{longPollReturnedEvents},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			mockGetter := new(mockRecordGetter)
+			if tc.waitedFor {
+				mockGetter.On("GetRecords", "1234", 5, "hash").Return([]db.Record{}, nil).Once()
+			}
+			mockGetter.On("GetRecords", "1234", 5, "hash").Return(records, nil)
+			mockGetter.On("GetStateHash", mock.Anything).Return("next", nil)
+
+			var observed []string
+			m := NewMeasures(xmetricstest.NewProvider(nil, Metrics))
+			m.LongPollDuration = recordingHistogram{observed: &observed}
+			app := testApp(m)
+			app.eventGetter = mockGetter
+			app.longPollSleep = time.Nanosecond
+			app.longPollTimeout = time.Minute
+
+			events, _, err := app.getDeviceInfoAfterHash("1234", "hash", false, context.Background())
+			assert.Nil(err)
+			assert.Len(events, 1)
+			assert.Equal(tc.expectedOutcomes, observed)
+		})
+	}
+}
+
+func TestAuthSettings(t *testing.T) {
+	basicAuth := []string{base64.StdEncoding.EncodeToString([]byte("user:pass"))}
+	userPass := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	otherPass := base64.StdEncoding.EncodeToString([]byte("other:pass"))
+
+	tests := []struct {
+		description       string
+		basicAuth         []string
+		capabilityCheck   CapabilityConfig
+		expectedUpdateErr bool
+		expectedBasic     map[string]bool
+		expectedCheckErr  bool
+	}{
+		{
+			description:   "No Capability Check",
+			basicAuth:     basicAuth,
+			expectedBasic: map[string]bool{userPass: true, otherPass: false},
+		},
+		{
+			description:     "Monitor Capability Check",
+			basicAuth:       basicAuth,
+			capabilityCheck: CapabilityConfig{Type: "monitor", Prefix: "prefix"},
+			expectedBasic:   map[string]bool{userPass: true, otherPass: false},
+		},
+		{
+			description:      "Enforce Capability Check",
+			capabilityCheck:  CapabilityConfig{Type: "enforce", Prefix: "prefix"},
+			expectedBasic:    map[string]bool{userPass: false, otherPass: false},
+			expectedCheckErr: true,
+		},
+		{
+			description:       "Bad Prefix Keeps Previous Settings",
+			capabilityCheck:   CapabilityConfig{Type: "enforce", Prefix: "("},
+			expectedUpdateErr: true,
+			expectedBasic:     map[string]bool{userPass: false, otherPass: true},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			settings := &AuthSettings{
+				logger:   logging.DefaultLogger(),
+				measures: basculechecks.NewAuthCapabilityCheckMeasures(xmetricstest.NewProvider(nil, basculechecks.Metrics)),
+			}
+			require.Nil(settings.Update([]string{otherPass}, CapabilityConfig{}))
+
+			err := settings.Update(tc.basicAuth, tc.capabilityCheck)
+			if tc.expectedUpdateErr {
+				assert.NotNil(err)
+			} else {
+				assert.Nil(err)
+			}
+
+			for value, ok := range tc.expectedBasic {
+				_, err := settings.ParseAndValidate(context.Background(), nil, "Basic", value)
+				assert.Equal(ok, err == nil, value)
+			}
+
+			err = settings.Check(context.Background(), bascule.NewToken("jwt", "owner", bascule.NewAttributes(map[string]interface{}{})))
+			if tc.expectedCheckErr {
+				assert.NotNil(err)
+			} else {
+				assert.Nil(err)
+			}
+		})
+	}
+}
+
+func TestHandleGetEventsFailedRecords(t *testing.T) {
+	futureTime := time.Now().Add(time.Hour).UnixNano()
+	var data []byte
+	require.Nil(t, wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(&goodOnlineEvent))
+	records := []db.Record{
+		{Type: db.State, BirthDate: 2, DeathDate: futureTime, Data: data, Alg: string(voynicrypto.None), KID: "none"},
+		{Type: db.State, BirthDate: 1, DeathDate: futureTime, Data: data, Alg: string(voynicrypto.Box), KID: "missing"},
+	}
+
+	tests := []struct {
+		description        string
+		query              string
+		partnerIDs         string
+		expectedStatusCode int
+		expectedEvents     int
+		expectedWarnings   string
+	}{
+		{
+			description:        "Failed Records Annotated",
+			partnerIDs:         "*",
+			expectedStatusCode: http.StatusOK,
+			expectedEvents:     2,
+			expectedWarnings:   "1",
+		},
+		{
+			description:        "Failed Records Omitted",
+			query:              "?omit_failed=true",
+			partnerIDs:         "*",
+			expectedStatusCode: http.StatusOK,
+			expectedEvents:     1,
+			expectedWarnings:   "1",
+		},
+		{
+			description:        "Failed Records Hidden From Scoped Partner",
+			partnerIDs:         "test1",
+			expectedStatusCode: http.StatusOK,
+			expectedEvents:     1,
+			expectedWarnings:   "0",
+		},
+		{
+			description:        "Invalid Omit Failed",
+			query:              "?omit_failed=maybe",
+			partnerIDs:         "*",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			mockGetter := new(mockRecordGetter)
+			mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return(records, nil).Maybe()
+			mockGetter.On("GetStateHash", mock.Anything).Return("123", nil).Maybe()
+			app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+			app.eventGetter = mockGetter
+
+			auth := bascule.Authentication{Token: bascule.NewToken("basic", "user", bascule.NewAttributes(nil))}
+			request, err := http.NewRequestWithContext(bascule.WithAuthentication(context.Background(), auth),
+				http.MethodGet, "/device/mac:112233445566/events"+tc.query, nil)
+			require.Nil(err)
+			request.Header.Set("X-Codex-Partner-Ids", tc.partnerIDs)
+			request = mux.SetURLVars(request, map[string]string{"deviceID": "mac:112233445566"})
+			rr := httptest.NewRecorder()
+			app.handleGetEvents(rr, request)
+			require.Equal(tc.expectedStatusCode, rr.Code)
+			if tc.expectedStatusCode != http.StatusOK {
+				return
+			}
+
+			// the failure is counted whether or not it is returned, but only
+			// for partners allowed to see it
+			assert.Equal(tc.expectedWarnings, rr.Header().Get(warningsHeader))
+			var events []map[string]json.RawMessage
+			require.Nil(json.Unmarshal(rr.Body.Bytes(), &events))
+			require.Len(events, tc.expectedEvents)
+			assert.NotContains(events[0], "error")
+			if tc.expectedEvents > 1 {
+				assert.JSONEq(`{"stage":"decrypter","reason":"failed to find decrypter"}`, string(events[1]["error"]))
+				assert.JSONEq(`1`, string(events[1]["birth_date"]))
+			}
+		})
+	}
+}
+
+// delayDecrypter returns the cipher unchanged after sleeping for the number
+// of milliseconds in the first byte of the nonce.
+type delayDecrypter struct {
+	voynicrypto.NOOP
+}
+
+func (d *delayDecrypter) DecryptMessage(cipher []byte, nonce []byte) ([]byte, error) {
+	time.Sleep(time.Duration(nonce[0]) * time.Millisecond)
+	return cipher, nil
+}
+
+func TestParseRecordsParallel(t *testing.T) {
+	futureTime := time.Now().Add(time.Hour).UnixNano()
+	var data []byte
+	require.Nil(t, wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(&goodOnlineEvent))
+
+	// later records decrypt faster, so they finish first when run at once
+	var (
+		records  []db.Record
+		expected []int64
+	)
+	for i := 0; i < 10; i++ {
+		birthDate := int64(100 - i)
+		records = append(records, db.Record{
+			BirthDate: birthDate,
+			DeathDate: futureTime,
+			Data:      data,
+			Nonce:     []byte{byte(10 - i)},
+			Alg:       string(voynicrypto.None),
+			KID:       "delay",
+		})
+		expected = append(expected, birthDate)
+	}
+	// expired and failed records are handled the same way at any parallelism
+	records = append(records,
+		db.Record{BirthDate: 50, DeathDate: time.Now().Add(-time.Hour).UnixNano(), Data: data, Alg: string(voynicrypto.None), KID: "delay"},
+		db.Record{BirthDate: 40, DeathDate: futureTime, Data: data, Alg: string(voynicrypto.Box), KID: "missing"},
+	)
+	expected = append(expected, 40)
+
+	for _, parallelism := range []int{0, 1, 4, 20} {
+		t.Run(fmt.Sprintf("Parallelism %d", parallelism), func(t *testing.T) {
+			assert := assert.New(t)
+			p := xmetricstest.NewProvider(nil, Metrics)
+			app := App{
+				logger:            logging.DefaultLogger(),
+				decodeParallelism: parallelism,
+				decrypters: &voynicrypto.Ciphers{
+					Options: map[voynicrypto.AlgorithmType]map[string]voynicrypto.Decrypt{
+						voynicrypto.None: {"delay": &delayDecrypter{}},
+					},
+				},
+				measures: NewMeasures(p),
+			}
+
+			events := app.parseRecords(records, false)
+			birthDates := make([]int64, 0, len(events))
+			for _, e := range events {
+				birthDates = append(birthDates, e.BirthDate)
+			}
+			assert.Equal(expected, birthDates)
+			assert.Equal(goodOnlineEvent.Destination, events[0].Destination)
+			assert.NotNil(events[len(events)-1].Error)
+			p.Assert(t, GetDecrypterFailureCounter, algorithmLabel, string(voynicrypto.Box), kidLabel, "missing", eventTypeLabel, db.Default.String())(xmetricstest.Value(1.0))
+		})
+	}
+
+	assert.Empty(t, (&App{decodeParallelism: 4}).parseRecords(nil, false))
+}
+
+func BenchmarkParseRecords(b *testing.B) {
+	// RSA decryption is the slow part of parsing a record
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(b, err)
+	encrypter := voynicrypto.NewRSAEncrypter(crypto.SHA256, nil, &key.PublicKey, "bench")
+	decrypter := voynicrypto.NewRSADecrypter(crypto.SHA256, key, nil, "bench")
+
+	var data []byte
+	require.Nil(b, wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(&wrp.Message{
+		Type:        wrp.SimpleEventMessageType,
+		Source:      "dns:talaria",
+		Destination: "event:device-status/mac:112233445566/online",
+		PartnerIDs:  []string{"comcast"},
+	}))
+	cipher, nonce, err := encrypter.EncryptMessage(data)
+	require.Nil(b, err)
+
+	futureTime := time.Now().Add(time.Hour).UnixNano()
+	records := make([]db.Record, 200)
+	for i := range records {
+		records[i] = db.Record{
+			BirthDate: int64(len(records) - i),
+			DeathDate: futureTime,
+			Data:      cipher,
+			Nonce:     nonce,
+			Alg:       string(voynicrypto.RSAAsymmetric),
+			KID:       "bench",
+		}
+	}
+
+	for _, parallelism := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("Parallelism %d", parallelism), func(b *testing.B) {
+			app := App{
+				logger:            logging.DefaultLogger(),
+				decodeParallelism: parallelism,
+				decrypters: &voynicrypto.Ciphers{
+					Options: map[voynicrypto.AlgorithmType]map[string]voynicrypto.Decrypt{
+						voynicrypto.RSAAsymmetric: {"bench": decrypter},
+					},
+				},
+				measures: NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+			}
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				if events := app.parseRecords(records, false); len(events) != len(records) {
+					b.Fatalf("expected %d events, got %d", len(records), len(events))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/gungnir/model"
+)
+
+// The ways a field can be redacted.
+const (
+	redactStrip = "strip"
+	redactMask  = "mask"
+)
+
+const defaultRedactionMask = "[REDACTED]"
+
+// RedactionPolicy hides fields from the requests it applies to.  Each field
+// is either left alone (empty), stripped, or masked.
+type RedactionPolicy struct {
+	// PartnerIDs applies the policy to requests for any of these partners.
+	// "*" applies it to every request.
+	PartnerIDs []string
+
+	// Capabilities applies the policy to JWTs carrying any of these
+	// capabilities.
+	Capabilities []string
+
+	// Payload redacts the event payload, and the status fields that come
+	// from it.  A masked payload becomes the mask as text/plain.
+	Payload string
+
+	// Source redacts the event source.
+	Source string
+
+	// Headers redacts every event header.
+	Headers string
+
+	// Metadata redacts the MetadataKeys of the event metadata, or every key
+	// if none are listed.
+	Metadata     string
+	MetadataKeys []string
+}
+
+// RedactionConfig configures what partners and capabilities may not see.
+// When more than one policy applies to a request, the strictest action for
+// each field wins.
+type RedactionConfig struct {
+	// Mask replaces masked values.
+	// (Optional) defaults to [REDACTED]
+	Mask string
+
+	Policies []RedactionPolicy
+}
+
+// Redactor finds the redaction policies that apply to a request.
+type Redactor struct {
+	mask     string
+	policies []RedactionPolicy
+}
+
+// NewRedactor checks the redaction policies, returning every problem found.
+func NewRedactor(config RedactionConfig) (*Redactor, error) {
+	var errs []error
+	for i, p := range config.Policies {
+		if len(p.PartnerIDs) == 0 && len(p.Capabilities) == 0 {
+			errs = append(errs, fmt.Errorf("redaction.policies[%d]: partnerIDs or capabilities are required", i))
+		}
+		for _, f := range []struct{ name, action string }{
+			{"payload", p.Payload},
+			{"source", p.Source},
+			{"headers", p.Headers},
+			{"metadata", p.Metadata},
+		} {
+			if f.action != "" && f.action != redactStrip && f.action != redactMask {
+				errs = append(errs, fmt.Errorf("redaction.policies[%d].%s must be \"strip\", \"mask\" or empty, got %q", i, f.name, f.action))
+			}
+		}
+		if len(p.MetadataKeys) > 0 && p.Metadata == "" {
+			errs = append(errs, fmt.Errorf("redaction.policies[%d]: metadataKeys are set but metadata is empty", i))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	r := &Redactor{mask: config.Mask, policies: config.Policies}
+	if r.mask == "" {
+		r.mask = defaultRedactionMask
+	}
+	return r, nil
+}
+
+// forRequest merges the policies that apply to the request's partners and
+// capabilities.  The result is nil if nothing is redacted.
+func (r *Redactor) forRequest(request *http.Request, partnerIDs []string) *redaction {
+	if r == nil || len(r.policies) == 0 {
+		return nil
+	}
+
+	var capabilities []string
+	if auth, ok := bascule.FromContext(request.Context()); ok && auth.Token != nil && auth.Token.Type() == "jwt" {
+		capabilities = tokenCapabilities(auth.Token)
+	}
+	return r.forCaller(partnerIDs, capabilities)
+}
+
+// forCaller merges the policies that apply to the partners and capabilities.
+// The result is nil if nothing is redacted.
+func (r *Redactor) forCaller(partnerIDs, capabilities []string) *redaction {
+	if r == nil || len(r.policies) == 0 {
+		return nil
+	}
+
+	var result *redaction
+	for _, p := range r.policies {
+		if !contains(p.PartnerIDs, "*") && !overlaps(p.PartnerIDs, partnerIDs) && !overlaps(p.Capabilities, capabilities) {
+			continue
+		}
+		if result == nil {
+			result = &redaction{mask: r.mask, metadataKeys: map[string]string{}}
+		}
+		result.payload = strictest(result.payload, p.Payload)
+		result.source = strictest(result.source, p.Source)
+		result.headers = strictest(result.headers, p.Headers)
+		if len(p.MetadataKeys) == 0 {
+			result.metadata = strictest(result.metadata, p.Metadata)
+			continue
+		}
+		for _, key := range p.MetadataKeys {
+			result.metadataKeys[key] = strictest(result.metadataKeys[key], p.Metadata)
+		}
+	}
+	return result
+}
+
+// strictest returns whichever action hides more.
+func strictest(a, b string) string {
+	if a == redactStrip || b == redactStrip {
+		return redactStrip
+	}
+	if a == redactMask || b == redactMask {
+		return redactMask
+	}
+	return ""
+}
+
+// redaction is what is hidden from one request.  A nil redaction hides
+// nothing.
+type redaction struct {
+	mask         string
+	payload      string
+	source       string
+	headers      string
+	metadata     string
+	metadataKeys map[string]string
+}
+
+// events returns the events with the fields redacted.  The events passed in
+// aren't modified.
+func (r *redaction) events(events []model.Event) []model.Event {
+	if r == nil || events == nil {
+		return events
+	}
+	redacted := make([]model.Event, 0, len(events))
+	for _, e := range events {
+		redacted = append(redacted, r.event(e))
+	}
+	return redacted
+}
+
+func (r *redaction) event(e model.Event) model.Event {
+	switch r.payload {
+	case redactStrip:
+		e.Payload = nil
+		e.ContentType = ""
+	case redactMask:
+		e.Payload = []byte(r.mask)
+		e.ContentType = "text/plain"
+	}
+
+	switch r.source {
+	case redactStrip:
+		e.Source = ""
+	case redactMask:
+		e.Source = r.mask
+	}
+
+	switch r.headers {
+	case redactStrip:
+		e.Headers = nil
+	case redactMask:
+		headers := make([]string, len(e.Headers))
+		for i := range headers {
+			headers[i] = r.mask
+		}
+		e.Headers = headers
+	}
+
+	if len(e.Metadata) > 0 && (r.metadata != "" || len(r.metadataKeys) > 0) {
+		metadata := make(map[string]string, len(e.Metadata))
+		for key, value := range e.Metadata {
+			switch strictest(r.metadata, r.metadataKeys[key]) {
+			case redactStrip:
+				continue
+			case redactMask:
+				value = r.mask
+			}
+			metadata[key] = value
+		}
+		e.Metadata = metadata
+	}
+	return e
+}
+
+// status returns the status with the fields that come from redacted event
+// fields redacted.  keys says where each status metadata value came from.
+func (r *redaction) status(s Status, keys []StatusMetadataKey) Status {
+	if r == nil {
+		return s
+	}
+	switch r.payload {
+	case redactStrip:
+		s.LastOfflineReason = ""
+	case redactMask:
+		if s.LastOfflineReason != "" {
+			s.LastOfflineReason = r.mask
+		}
+	}
+
+	if len(s.Metadata) == 0 {
+		return s
+	}
+	metadata := make(map[string]interface{}, len(s.Metadata))
+	for _, k := range keys {
+		value, ok := s.Metadata[k.Name]
+		if !ok {
+			continue
+		}
+		action := r.payload
+		if k.Source == metadataFromMetadata {
+			action = strictest(r.metadata, r.metadataKeys[k.Path])
+		}
+		switch action {
+		case redactStrip:
+			continue
+		case redactMask:
+			value = r.mask
+		}
+		metadata[k.Name] = value
+	}
+	s.Metadata = metadata
+	return s
+}
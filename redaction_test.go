@@ -0,0 +1,295 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/gungnir/model"
+	"github.com/xmidt-org/voynicrypto"
+	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestNewRedactor(t *testing.T) {
+	tests := []struct {
+		description  string
+		config       RedactionConfig
+		expectedMask string
+		expectedErrs []string
+	}{
+		{
+			description:  "Defaults",
+			expectedMask: defaultRedactionMask,
+		},
+		{
+			description: "Success",
+			config: RedactionConfig{
+				Mask: "***",
+				Policies: []RedactionPolicy{
+					{PartnerIDs: []string{"partner"}, Payload: redactStrip, Metadata: redactMask, MetadataKeys: []string{"/key"}},
+					{Capabilities: []string{"x1:gungnir:limited"}, Source: redactMask, Headers: redactStrip},
+				},
+			},
+			expectedMask: "***",
+		},
+		{
+			description: "All Problems Reported",
+			config: RedactionConfig{
+				Policies: []RedactionPolicy{
+					{Payload: redactStrip},
+					{PartnerIDs: []string{"partner"}, Payload: "hide", Source: "erase", Headers: "drop", Metadata: "blank"},
+					{PartnerIDs: []string{"partner"}, MetadataKeys: []string{"/key"}},
+				},
+			},
+			expectedErrs: []string{
+				"redaction.policies[0]: partnerIDs or capabilities are required",
+				"redaction.policies[1].payload",
+				"redaction.policies[1].source",
+				"redaction.policies[1].headers",
+				"redaction.policies[1].metadata",
+				"redaction.policies[2]: metadataKeys are set but metadata is empty",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			r, err := NewRedactor(tc.config)
+			if len(tc.expectedErrs) == 0 {
+				assert.Nil(err)
+				assert.Equal(tc.expectedMask, r.mask)
+				return
+			}
+			assert.Nil(r)
+			assert.Len(unwrapErrors(err), len(tc.expectedErrs))
+			for _, expected := range tc.expectedErrs {
+				assert.Contains(err.Error(), expected)
+			}
+		})
+	}
+}
+
+func TestRedactEvents(t *testing.T) {
+	event := model.Event{
+		Message: wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      "dns:talaria",
+			ContentType: "application/json",
+			Payload:     []byte(`{"reason":"ping miss"}`),
+			Headers:     []string{"X-Trace:1234"},
+			Metadata:    map[string]string{"/boot-time": "1234", "/hw-model": "tg1682", "/fw-name": "1.2.3"},
+		},
+		BirthDate: 5,
+	}
+	jwt := func(capabilities ...interface{}) bascule.Authentication {
+		return bascule.Authentication{
+			Token: bascule.NewToken("jwt", "owner", bascule.NewAttributes(map[string]interface{}{
+				"capabilities": capabilities,
+			})),
+		}
+	}
+	redactor, err := NewRedactor(RedactionConfig{
+		Policies: []RedactionPolicy{
+			{PartnerIDs: []string{"limited"}, Payload: redactMask, Source: redactMask, Metadata: redactMask, MetadataKeys: []string{"/boot-time", "/hw-model"}},
+			{PartnerIDs: []string{"strict"}, Payload: redactStrip, Source: redactStrip, Headers: redactStrip, Metadata: redactStrip},
+			{PartnerIDs: []string{"limited", "mixed"}, Headers: redactMask, Metadata: redactStrip, MetadataKeys: []string{"/hw-model"}},
+			{Capabilities: []string{"x1:gungnir:no-payload"}, Payload: redactStrip},
+		},
+	})
+	require.Nil(t, err)
+
+	tests := []struct {
+		description string
+		auth        bascule.Authentication
+		partnerIDs  []string
+		expected    model.Event
+	}{
+		{
+			description: "No Policy",
+			auth:        jwt(),
+			partnerIDs:  []string{"other"},
+			expected:    event,
+		},
+		{
+			description: "Masked",
+			auth:        jwt(),
+			partnerIDs:  []string{"limited"},
+			expected: model.Event{
+				Message: wrp.Message{
+					Type:        wrp.SimpleEventMessageType,
+					Source:      defaultRedactionMask,
+					ContentType: "text/plain",
+					Payload:     []byte(defaultRedactionMask),
+					Headers:     []string{defaultRedactionMask},
+					// stripping is stricter than masking
+					Metadata: map[string]string{"/boot-time": defaultRedactionMask, "/fw-name": "1.2.3"},
+				},
+				BirthDate: 5,
+			},
+		},
+		{
+			description: "Stripped",
+			auth:        jwt(),
+			partnerIDs:  []string{"strict"},
+			expected: model.Event{
+				Message: wrp.Message{
+					Type:     wrp.SimpleEventMessageType,
+					Metadata: map[string]string{},
+				},
+				BirthDate: 5,
+			},
+		},
+		{
+			description: "Capability",
+			auth:        jwt("x1:gungnir:no-payload"),
+			partnerIDs:  []string{"other"},
+			expected: model.Event{
+				Message: wrp.Message{
+					Type:     wrp.SimpleEventMessageType,
+					Source:   event.Source,
+					Headers:  event.Headers,
+					Metadata: event.Metadata,
+				},
+				BirthDate: 5,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request = request.WithContext(bascule.WithAuthentication(request.Context(), tc.auth))
+			events := []model.Event{event}
+			redacted := redactor.forRequest(request, tc.partnerIDs).events(events)
+			assert.Equal([]model.Event{tc.expected}, redacted)
+
+			// the original events are untouched
+			assert.Equal("dns:talaria", events[0].Source)
+			assert.Len(events[0].Metadata, 3)
+			assert.Equal([]string{"X-Trace:1234"}, events[0].Headers)
+		})
+	}
+}
+
+func TestRedactStatus(t *testing.T) {
+	status := Status{DeviceID: "1234", State: "offline", LastOfflineReason: "ping miss", PartnerIDs: []string{"partner"}}
+	tests := []struct {
+		description    string
+		policy         RedactionPolicy
+		expectedReason string
+	}{
+		{
+			description:    "Payload Not Redacted",
+			policy:         RedactionPolicy{PartnerIDs: []string{"*"}, Source: redactStrip},
+			expectedReason: "ping miss",
+		},
+		{
+			description:    "Payload Masked",
+			policy:         RedactionPolicy{PartnerIDs: []string{"*"}, Payload: redactMask},
+			expectedReason: defaultRedactionMask,
+		},
+		{
+			description: "Payload Stripped",
+			policy:      RedactionPolicy{PartnerIDs: []string{"*"}, Payload: redactStrip},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			redactor, err := NewRedactor(RedactionConfig{Policies: []RedactionPolicy{tc.policy}})
+			require.Nil(t, err)
+			// a "*" policy applies even without partner ids
+			s := redactor.forRequest(httptest.NewRequest(http.MethodGet, "/", nil), nil).status(status, nil)
+			assert.Equal(tc.expectedReason, s.LastOfflineReason)
+			assert.Equal(status.State, s.State)
+		})
+	}
+}
+
+func TestRedactStatusMetadata(t *testing.T) {
+	assert := assert.New(t)
+	keys := []StatusMetadataKey{
+		{Name: "fw", Source: metadataFromPayload, Path: "fw.name"},
+		{Name: "model", Source: metadataFromMetadata, Path: "/hw-model"},
+		{Name: "boot", Source: metadataFromMetadata, Path: "/boot-time"},
+	}
+	status := Status{State: "online", Metadata: map[string]interface{}{"fw": "1.2.3", "model": "tg1682", "boot": "1234"}}
+	redactor, err := NewRedactor(RedactionConfig{Policies: []RedactionPolicy{
+		{PartnerIDs: []string{"*"}, Payload: redactStrip, Metadata: redactMask, MetadataKeys: []string{"/hw-model"}},
+	}})
+	require.Nil(t, err)
+
+	s := redactor.forRequest(httptest.NewRequest(http.MethodGet, "/", nil), nil).status(status, keys)
+	assert.Equal(map[string]interface{}{"model": defaultRedactionMask, "boot": "1234"}, s.Metadata)
+	// the status passed in is untouched
+	assert.Len(status.Metadata, 3)
+}
+
+func TestHandleGetEventsRedacted(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	futureTime := time.Now().Add(time.Duration(50000) * time.Minute).UnixNano()
+	var data []byte
+	require.Nil(wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(&goodOnlineEvent))
+
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return([]db.Record{
+		{Type: db.State, BirthDate: futureTime - 500, DeathDate: futureTime, Data: data, Alg: string(voynicrypto.None), KID: "none"},
+	}, nil).Once()
+	mockGetter.On("GetStateHash", mock.Anything).Return("123", nil).Once()
+	decrypter := new(mockDecrypter)
+	decrypter.On("DecryptMessage", mock.Anything, mock.Anything).Return(nil)
+	redactor, err := NewRedactor(RedactionConfig{
+		Policies: []RedactionPolicy{{PartnerIDs: []string{"test1"}, Payload: redactStrip}},
+	})
+	require.Nil(err)
+
+	app := App{
+		eventGetter:   mockGetter,
+		getEventLimit: 5,
+		logger:        logging.DefaultLogger(),
+		decrypters: &voynicrypto.Ciphers{
+			Options: map[voynicrypto.AlgorithmType]map[string]voynicrypto.Decrypt{
+				voynicrypto.None: {"none": decrypter},
+			},
+		},
+		measures: NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+		redactor: redactor,
+	}
+
+	auth := bascule.Authentication{
+		Token: bascule.NewToken("jwt", "owner", bascule.NewAttributes(map[string]interface{}{
+			"allowedResources": map[string]interface{}{"allowedPartners": []string{"test1"}},
+		})),
+	}
+	rr := httptest.NewRecorder()
+	request, err := http.NewRequestWithContext(bascule.WithAuthentication(context.Background(), auth),
+		http.MethodGet, "/device/mac:112233445566/events", nil)
+	require.Nil(err)
+	request = mux.SetURLVars(request, map[string]string{"deviceID": "mac:112233445566"})
+	app.handleGetEvents(rr, request)
+	require.Equal(http.StatusOK, rr.Code)
+
+	var events []map[string]json.RawMessage
+	require.Nil(json.Unmarshal(rr.Body.Bytes(), &events))
+	require.Len(events, 1)
+	assert.NotContains(events[0], "payload")
+	assert.JSONEq(`"/test/online"`, string(events[0]["dest"]))
+}
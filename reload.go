@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-kit/log"
+	"github.com/goph/emperror"
+	"github.com/spf13/viper"
+	"github.com/xmidt-org/webpa-common/v2/logging" //nolint: staticcheck
+)
+
+// ReloadableApp serves each request with the App that is current when the
+// request arrives, so that new settings can be swapped in without
+// restarting.  An App is never modified once it is serving requests.
+type ReloadableApp struct {
+	current atomic.Pointer[App]
+}
+
+// NewReloadableApp creates a ReloadableApp that starts out serving app.
+func NewReloadableApp(app *App) *ReloadableApp {
+	r := new(ReloadableApp)
+	r.current.Store(app)
+	return r
+}
+
+// Handle returns a handler that calls method on the current App.
+func (r *ReloadableApp) Handle(method func(*App, http.ResponseWriter, *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		method(r.current.Load(), w, req)
+	})
+}
+
+// Update swaps in a copy of the current App using the limits, long poll,
+// admin, redaction and status settings from config, which must have been
+// validated.
+func (r *ReloadableApp) Update(config *Config) {
+	next := *r.current.Load()
+	next.getEventLimit = config.GetEventsLimit
+	next.getStatusLimit = config.GetStatusLimit
+	next.decodeParallelism = config.DecodeParallelism
+	next.longPollSleep = config.LongPollSleep
+	next.longPollTimeout = config.LongPollTimeout
+	next.admin = config.Admin
+	// validateConfig has already checked the policies
+	next.redactor, _ = NewRedactor(config.Redaction)
+	next.status = config.Status
+	next.deviceIDs = config.DeviceID
+	next.expiredRecords = config.ExpiredRecords
+	r.current.Store(&next)
+}
+
+// ConfigReloader applies changes to the configuration file to the running
+// service.  Only the limits, decode parallelism, long poll settings, admin
+// settings, redaction policies, status settings, device id settings, expired
+// record access, basic auth credentials and capability check are reloaded;
+// everything else still requires a restart.
+type ConfigReloader struct {
+	// lock is held while the configuration is read and applied, as viper
+	// isn't safe to use from more than one goroutine
+	lock     sync.Mutex
+	v        *viper.Viper
+	watcher  *fsnotify.Watcher
+	app      *ReloadableApp
+	auth     *AuthSettings
+	logger   log.Logger
+	measures *Measures
+}
+
+// NewConfigReloader creates a ConfigReloader for the configuration loaded
+// into v.
+func NewConfigReloader(v *viper.Viper, app *ReloadableApp, auth *AuthSettings, logger log.Logger, measures *Measures) *ConfigReloader {
+	return &ConfigReloader{
+		v:        v,
+		app:      app,
+		auth:     auth,
+		logger:   logger,
+		measures: measures,
+	}
+}
+
+// Reload reads the configuration file again and applies it.  If the file
+// can't be read or the new configuration is invalid, the current settings are
+// left in place and the error is returned.
+func (r *ConfigReloader) Reload() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.v.ReadInConfig(); err != nil {
+		return r.result(emperror.Wrap(err, "failed to read config file"))
+	}
+	return r.result(r.apply())
+}
+
+// Watch reloads the configuration whenever the configuration file changes,
+// until Stop is called.  The file's directory is watched rather than the
+// file, so that a file replaced by an editor or by a Kubernetes config map's
+// symlink swap is still seen.  Viper's own watcher isn't used, as it reads
+// the file without holding the lock that Reload does.
+func (r *ConfigReloader) Watch() error {
+	file := filepath.Clean(r.v.ConfigFileUsed())
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return emperror.Wrap(err, "failed to create config file watcher")
+	}
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return emperror.Wrap(err, "failed to watch config file")
+	}
+	r.watcher = watcher
+	go r.watch(watcher, file)
+	return nil
+}
+
+func (r *ConfigReloader) watch(watcher *fsnotify.Watcher, file string) {
+	target, _ := filepath.EvalSymlinks(file)
+	for {
+		select {
+		case e, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			current, _ := filepath.EvalSymlinks(file)
+			written := filepath.Clean(e.Name) == file && e.Op&(fsnotify.Write|fsnotify.Create) != 0
+			if !written && (current == "" || current == target) {
+				continue
+			}
+			target = current
+			logging.Info(r.logger).Log(logging.MessageKey(), "configuration file changed", "file", e.Name, "op", e.Op.String())
+			r.Reload()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logging.Error(r.logger).Log(logging.MessageKey(), "config file watcher failed", logging.ErrorKey(), err.Error())
+		}
+	}
+}
+
+// Stop stops watching the configuration file.
+func (r *ConfigReloader) Stop() {
+	if r.watcher != nil {
+		r.watcher.Close()
+	}
+}
+
+func (r *ConfigReloader) apply() error {
+	config, _, err := loadConfig(r.v)
+	if err != nil {
+		return emperror.Wrap(err, "invalid configuration")
+	}
+
+	if err := r.auth.Update(config.AuthHeader, config.CapabilityCheck); err != nil {
+		return emperror.Wrap(err, "failed to update auth settings")
+	}
+	r.app.Update(config)
+
+	logging.Info(r.logger).Log(logging.MessageKey(), "reloaded configuration",
+		"getEventsLimit", config.GetEventsLimit, "getStatusLimit", config.GetStatusLimit,
+		"longPollSleep", config.LongPollSleep, "longPollTimeout", config.LongPollTimeout,
+		"capabilityCheck", config.CapabilityCheck.Type, "basicAuthCount", len(config.AuthHeader))
+	return nil
+}
+
+func (r *ConfigReloader) result(err error) error {
+	if err != nil {
+		r.measures.ConfigReloadCount.With(outcomeLabel, reloadFailed).Add(1.0)
+		logging.Error(r.logger, emperror.Context(err)...).Log(logging.MessageKey(), "failed to reload configuration", logging.ErrorKey(), err.Error())
+		return err
+	}
+	r.measures.ConfigReloadCount.With(outcomeLabel, reloadSucceeded).Add(1.0)
+	return nil
+}
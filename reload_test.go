@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/webpa-common/v2/basculechecks"         //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+)
+
+func TestReloadableApp(t *testing.T) {
+	assert := assert.New(t)
+	original := &App{getEventLimit: 5, getStatusLimit: 5, longPollSleep: time.Second, longPollTimeout: time.Minute}
+	r := NewReloadableApp(original)
+
+	var seen *App
+	handler := r.Handle(func(app *App, w http.ResponseWriter, _ *http.Request) {
+		seen = app
+	})
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(original, seen)
+
+	r.Update(&Config{GetEventsLimit: 10, GetStatusLimit: 20, LongPollSleep: 2 * time.Second, LongPollTimeout: time.Hour, Admin: AdminConfig{BasicUsers: []string{"admin"}},
+		Redaction: RedactionConfig{Policies: []RedactionPolicy{{PartnerIDs: []string{"partner"}, Payload: redactStrip}}},
+		Status:    StatusConfig{Metadata: []StatusMetadataKey{{Name: "fw", Source: metadataFromPayload, Path: "fw"}}}})
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(10, seen.getEventLimit)
+	assert.Equal(20, seen.getStatusLimit)
+	assert.Equal(2*time.Second, seen.longPollSleep)
+	assert.Equal(time.Hour, seen.longPollTimeout)
+	assert.Equal([]string{"admin"}, seen.admin.BasicUsers)
+	assert.NotNil(seen.redactor)
+	assert.Len(seen.status.Metadata, 1)
+
+	// the App that was serving before the update is left untouched
+	assert.Equal(5, original.getEventLimit)
+}
+
+func TestConfigReloader(t *testing.T) {
+	tests := []struct {
+		description     string
+		config          string
+		expectedErr     bool
+		expectedOutcome string
+		expectedLimit   int
+		expectedTimeout time.Duration
+	}{
+		{
+			description:     "Success",
+			config:          "getEventsLimit: 20\nlongPollTimeout: 5s\n",
+			expectedOutcome: reloadSucceeded,
+			expectedLimit:   20,
+			expectedTimeout: 5 * time.Second,
+		},
+		{
+			description:     "Defaults Applied",
+			config:          "getEventsLimit: 0\n",
+			expectedOutcome: reloadSucceeded,
+			expectedLimit:   defaultGetEventsLimit,
+			expectedTimeout: defaultLongPollTimeout,
+		},
+		{
+			description:     "Unreadable Config Error",
+			config:          "getEventsLimit: [\n",
+			expectedErr:     true,
+			expectedOutcome: reloadFailed,
+			expectedLimit:   5,
+			expectedTimeout: time.Minute,
+		},
+		{
+			description:     "Invalid Capability Check Error",
+			config:          "getEventsLimit: 20\ncapabilityCheck:\n  type: enforce\n  prefix: \"(\"\n",
+			expectedErr:     true,
+			expectedOutcome: reloadFailed,
+			expectedLimit:   5,
+			expectedTimeout: time.Minute,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			configFile := filepath.Join(t.TempDir(), "gungnir.yaml")
+			require.Nil(os.WriteFile(configFile, []byte("getEventsLimit: 5\n"), 0600))
+			v := viper.New()
+			v.SetConfigFile(configFile)
+			require.Nil(v.ReadInConfig())
+
+			p := xmetricstest.NewProvider(nil, Metrics)
+			auth := &AuthSettings{
+				logger:   logging.DefaultLogger(),
+				measures: basculechecks.NewAuthCapabilityCheckMeasures(xmetricstest.NewProvider(nil, basculechecks.Metrics)),
+			}
+			require.Nil(auth.Update(nil, CapabilityConfig{}))
+			app := NewReloadableApp(&App{getEventLimit: 5, longPollTimeout: time.Minute})
+			r := NewConfigReloader(v, app, auth, logging.DefaultLogger(), NewMeasures(p))
+
+			require.Nil(os.WriteFile(configFile, []byte(tc.config), 0600))
+			err := r.Reload()
+			if tc.expectedErr {
+				assert.NotNil(err)
+			} else {
+				assert.Nil(err)
+			}
+			p.Assert(t, ConfigReloadCounter, outcomeLabel, tc.expectedOutcome)(xmetricstest.Value(1.0))
+			assert.Equal(tc.expectedLimit, app.current.Load().getEventLimit)
+			assert.Equal(tc.expectedTimeout, app.current.Load().longPollTimeout)
+		})
+	}
+}
+
+func TestConfigReloaderWatch(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	configFile := filepath.Join(t.TempDir(), "gungnir.yaml")
+	require.Nil(os.WriteFile(configFile, []byte("getEventsLimit: 5\n"), 0600))
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	require.Nil(v.ReadInConfig())
+
+	auth := &AuthSettings{
+		logger:   logging.DefaultLogger(),
+		measures: basculechecks.NewAuthCapabilityCheckMeasures(xmetricstest.NewProvider(nil, basculechecks.Metrics)),
+	}
+	require.Nil(auth.Update(nil, CapabilityConfig{}))
+	app := NewReloadableApp(&App{getEventLimit: 5})
+	r := NewConfigReloader(v, app, auth, logging.DefaultLogger(), NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	require.Nil(r.Watch())
+	defer r.Stop()
+
+	// a SIGHUP reload at the same time as the watcher's doesn't race
+	require.Nil(os.WriteFile(configFile, []byte("getEventsLimit: 20\n"), 0600))
+	r.Reload()
+	assert.Eventually(func() bool {
+		return app.current.Load().getEventLimit == 20
+	}, time.Second, time.Millisecond)
+
+	// the file being replaced, as editors do, is seen too
+	replacement := filepath.Join(filepath.Dir(configFile), "replacement.yaml")
+	require.Nil(os.WriteFile(replacement, []byte("getEventsLimit: 30\n"), 0600))
+	require.Nil(os.Rename(replacement, configFile))
+	assert.Eventually(func() bool {
+		return app.current.Load().getEventLimit == 30
+	}, time.Second, time.Millisecond)
+}
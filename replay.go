@@ -0,0 +1,426 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/gungnir/model"
+	"github.com/xmidt-org/webpa-common/v2/logging" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// replayHeader is sent with every replayed message, so the target can tell
+// replays from live traffic.  It holds the id of the replay.
+const replayHeader = "X-Codex-Replay"
+
+// The WRP encodings events can be replayed in.
+const (
+	replayFormatMsgpack = "msgpack"
+	replayFormatJSON    = "json"
+)
+
+// The outcomes of replaying an event.
+const (
+	replaySent   = "sent"
+	replayFailed = "failed"
+)
+
+const (
+	defaultReplayRate        = 10
+	defaultReplayRecordLimit = 1000
+	defaultReplayTimeout     = 10 * time.Second
+	defaultReplayMaxDuration = 30 * time.Second
+)
+
+// ReplayConfig configures replaying a device's stored events to an HTTP
+// target, so a consumer that lost them can get them again.
+type ReplayConfig struct {
+	// Target is the URL each event is POSTed to as a WRP message.  Replay is
+	// off if it is empty.
+	Target string
+
+	// Format is the WRP encoding of the messages: msgpack, the default, or
+	// json.
+	Format string
+
+	// Authorization is sent as the Authorization header of every request, if
+	// it is set.
+	Authorization string
+
+	// Rate is the most events sent per second.  A replay may ask for fewer.
+	// Defaults to 10.
+	Rate int
+
+	// RecordLimit is the most records read for the device.  When it is
+	// reached, a replay's from must be after the oldest record read.
+	// Defaults to 1000.
+	RecordLimit int
+
+	// Timeout bounds each request to the target.  Defaults to 10s.
+	Timeout time.Duration
+
+	// MaxDuration bounds how long a replay request runs, so it finishes
+	// within the write timeouts of the server and any proxy in front of it.
+	// A replay sends no more events than its rate allows in that time, and
+	// the rest are left for the next replay.  Defaults to 30s.
+	MaxDuration time.Duration
+}
+
+// validateReplayConfig fills in the defaults for a replay configuration and
+// returns every problem with it.
+func validateReplayConfig(config *ReplayConfig) []error {
+	if config.Target == "" {
+		return nil
+	}
+
+	if config.Format == "" {
+		config.Format = replayFormatMsgpack
+	}
+	if config.Rate == 0 {
+		config.Rate = defaultReplayRate
+	}
+	if config.RecordLimit == 0 {
+		config.RecordLimit = defaultReplayRecordLimit
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultReplayTimeout
+	}
+	if config.MaxDuration == 0 {
+		config.MaxDuration = defaultReplayMaxDuration
+	}
+
+	var errs []error
+	if u, err := url.Parse(config.Target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("replay.target must be an http or https URL, got %q", config.Target))
+	}
+	if config.Format != replayFormatMsgpack && config.Format != replayFormatJSON {
+		errs = append(errs, fmt.Errorf("replay.format must be \"msgpack\" or \"json\", got %q", config.Format))
+	}
+	if config.Rate < 0 {
+		errs = append(errs, fmt.Errorf("replay.rate must be positive, got %d", config.Rate))
+	}
+	if config.RecordLimit < 0 {
+		errs = append(errs, fmt.Errorf("replay.recordLimit must be positive, got %d", config.RecordLimit))
+	}
+	if config.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("replay.timeout must be positive, got %s", config.Timeout))
+	}
+	if config.MaxDuration < 0 {
+		errs = append(errs, fmt.Errorf("replay.maxDuration must be positive, got %s", config.MaxDuration))
+	}
+	return errs
+}
+
+// ReplayResult reports what a replay did, or would do for a dry run.
+type ReplayResult struct {
+	ID       string `json:"id"`
+	DeviceID string `json:"device_id"`
+	Target   string `json:"target"`
+	DryRun   bool   `json:"dry_run"`
+	Rate     int    `json:"rate"`
+
+	// Matched is how many events passed the filters.
+	Matched int `json:"matched"`
+
+	// Sent is how many of them the target accepted.
+	Sent int `json:"sent"`
+
+	// Failed is how many records couldn't be decrypted or decoded, and were
+	// skipped.
+	Failed int `json:"failed"`
+
+	// Error is why the replay stopped early.  ResumeFrom is the birth date of
+	// the first event not sent, when the replay stopped early or matched more
+	// events than one replay sends, which can be used as the from of the
+	// next replay.
+	Error      string `json:"error,omitempty"`
+	ResumeFrom int64  `json:"resume_from,omitempty"`
+
+	// Events are the events that would be sent, in order, for a dry run.
+	Events []ReplayedEvent `json:"events,omitempty"`
+}
+
+// ReplayedEvent identifies an event a dry run would send.
+type ReplayedEvent struct {
+	BirthDate       int64  `json:"birth_date"`
+	Type            string `json:"msg_type"`
+	Source          string `json:"source"`
+	Destination     string `json:"dest"`
+	TransactionUUID string `json:"transaction_uuid,omitempty"`
+}
+
+// replayOptions are what a replay request asks for.
+type replayOptions struct {
+	types  []string
+	from   *time.Time
+	to     *time.Time
+	rate   int
+	dryRun bool
+}
+
+// matches reports whether the event passes the type and time filters.
+func (o replayOptions) matches(e model.Event) bool {
+	if len(o.types) > 0 && !contains(o.types, e.Type.FriendlyName()) {
+		return false
+	}
+	if o.from != nil && e.BirthDate < o.from.UnixNano() {
+		return false
+	}
+	if o.to != nil && e.BirthDate >= o.to.UnixNano() {
+		return false
+	}
+	return true
+}
+
+// Replayer sends stored events to the configured target.
+type Replayer struct {
+	config   ReplayConfig
+	format   wrp.Format
+	client   *http.Client
+	measures *Measures
+}
+
+// NewReplayer creates the replayer for the configuration.  If replay is off,
+// it returns nil.
+func NewReplayer(config ReplayConfig, measures *Measures) (*Replayer, error) {
+	if err := errors.Join(validateReplayConfig(&config)...); err != nil {
+		return nil, err
+	}
+	if config.Target == "" {
+		return nil, nil
+	}
+	format := wrp.Msgpack
+	if config.Format == replayFormatJSON {
+		format = wrp.JSON
+	}
+	return &Replayer{
+		config:   config,
+		format:   format,
+		client:   &http.Client{Timeout: config.Timeout},
+		measures: measures,
+	}, nil
+}
+
+// requestReplayOptions reads the types, from, to, rate and dry_run options.
+// The rate may not be more than the configured rate.
+func (r *Replayer) requestReplayOptions(request *http.Request) (replayOptions, error) {
+	o := replayOptions{rate: r.config.Rate}
+	if t := request.FormValue("types"); t != "" {
+		types, err := parseMessageTypes(strings.Split(t, ","))
+		if err != nil {
+			return replayOptions{}, err
+		}
+		o.types = types
+	}
+	for _, v := range []struct {
+		name  string
+		value **time.Time
+	}{
+		{"from", &o.from},
+		{"to", &o.to},
+	} {
+		if s := request.FormValue(v.name); s != "" {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return replayOptions{}, fmt.Errorf("invalid %s value %q, expected an RFC 3339 time", v.name, s)
+			}
+			*v.value = &t
+		}
+	}
+	if o.from != nil && o.to != nil && !o.from.Before(*o.to) {
+		return replayOptions{}, errors.New("from must be before to")
+	}
+	if s := request.FormValue("rate"); s != "" {
+		rate, err := strconv.Atoi(s)
+		if err != nil || rate <= 0 || rate > r.config.Rate {
+			return replayOptions{}, fmt.Errorf("invalid rate value %q, expected 1 to %d events per second", s, r.config.Rate)
+		}
+		o.rate = rate
+	}
+	if s := request.FormValue("dry_run"); s != "" {
+		dryRun, err := strconv.ParseBool(s)
+		if err != nil {
+			return replayOptions{}, fmt.Errorf("invalid dry_run value %q", s)
+		}
+		o.dryRun = dryRun
+	}
+	return o, nil
+}
+
+// checkWindow returns an error if the record limit was reached and the window
+// asked for reaches back past the oldest record read, since older records in
+// the window weren't read and would be silently left out.
+func (r *Replayer) checkWindow(records []db.Record, options replayOptions) error {
+	if len(records) < r.config.RecordLimit {
+		return nil
+	}
+	oldest := records[0].BirthDate
+	for _, record := range records[1:] {
+		if record.BirthDate < oldest {
+			oldest = record.BirthDate
+		}
+	}
+	if options.from != nil && options.from.UnixNano() > oldest {
+		return nil
+	}
+	return fmt.Errorf("only the newest %d records are read, and the oldest of them is from %s: set from after it",
+		r.config.RecordLimit, time.Unix(0, oldest).UTC().Format(time.RFC3339Nano))
+}
+
+// eventLimit is the most events a replay at the rate sends within the
+// configured MaxDuration.  It is at least one.
+func (r *Replayer) eventLimit(rate int) int {
+	interval := time.Second / time.Duration(rate)
+	if limit := int(r.config.MaxDuration / interval); limit > 1 {
+		return limit
+	}
+	return 1
+}
+
+// replay sends the events in order, no faster than the rate, stopping at the
+// first one the target doesn't accept or when ctx is canceled.
+func (r *Replayer) replay(ctx context.Context, result *ReplayResult, events []model.Event, rate int) {
+	interval := time.Second / time.Duration(rate)
+	var next time.Time
+	for i, e := range events {
+		if wait := time.Until(next); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+		err := ctx.Err()
+		if err == nil {
+			next = time.Now().Add(interval)
+			err = r.send(ctx, result.ID, e)
+		}
+		if err != nil {
+			r.measures.ReplayEvents.With(outcomeLabel, replayFailed).Add(1.0)
+			result.Error = fmt.Sprintf("event %d of %d: %s", i+1, len(events), err)
+			result.ResumeFrom = e.BirthDate
+			return
+		}
+		r.measures.ReplayEvents.With(outcomeLabel, replaySent).Add(1.0)
+		result.Sent++
+	}
+}
+
+// send POSTs one event to the target as a WRP message.
+func (r *Replayer) send(ctx context.Context, id string, e model.Event) error {
+	var body []byte
+	if err := wrp.NewEncoderBytes(&body, r.format).Encode(&e.Message); err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.config.Target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", r.format.ContentType())
+	request.Header.Set(replayHeader, id)
+	if r.config.Authorization != "" {
+		request.Header.Set("Authorization", r.config.Authorization)
+	}
+
+	resp, err := r.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("target responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// handleReplay replays a device's stored events to the configured target,
+// oldest first.  The request waits for the replay to finish, which takes no
+// longer than the configured MaxDuration, and canceling it stops the replay.
+// With dry_run=true, the events are listed instead of sent.
+func (app *App) handleReplay(writer http.ResponseWriter, request *http.Request) {
+	if !app.admin.isAdmin(request) {
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	id, ok := app.requestDeviceID(writer, request)
+	if !ok {
+		return
+	}
+
+	options, err := app.replayer.requestReplayOptions(request)
+	if err != nil {
+		writeError(writer, serverErr{err, http.StatusBadRequest})
+		return
+	}
+
+	records, err := app.eventGetter.GetRecords(id, app.replayer.config.RecordLimit, "")
+	if err != nil {
+		logging.Error(app.logger).Log(logging.MessageKey(), "failed to get events to replay", "device id", id,
+			logging.ErrorKey(), err.Error())
+		writeError(writer, serverErr{errors.New("failed to get events"), http.StatusInternalServerError})
+		return
+	}
+	if err := app.replayer.checkWindow(records, options); err != nil {
+		writeError(writer, serverErr{err, http.StatusBadRequest})
+		return
+	}
+	events := app.parseRecords(records, false)
+	result := ReplayResult{
+		ID:       uuid.NewString(),
+		DeviceID: id,
+		Target:   app.replayer.config.Target,
+		DryRun:   options.dryRun,
+		Rate:     options.rate,
+		Failed:   countFailed(events),
+	}
+	var matched []model.Event
+	for _, e := range omitFailed(events) {
+		if options.matches(e) {
+			matched = append(matched, e)
+		}
+	}
+	sortEvents(matched, false)
+	result.Matched = len(matched)
+	if limit := app.replayer.eventLimit(options.rate); len(matched) > limit {
+		result.ResumeFrom = matched[limit].BirthDate
+		matched = matched[:limit]
+	}
+	addAuditDevice(request.Context(), id, len(matched))
+
+	if options.dryRun {
+		result.Events = make([]ReplayedEvent, 0, len(matched))
+		for _, e := range matched {
+			result.Events = append(result.Events, ReplayedEvent{
+				BirthDate:       e.BirthDate,
+				Type:            e.Type.FriendlyName(),
+				Source:          e.Source,
+				Destination:     e.Destination,
+				TransactionUUID: e.TransactionUUID,
+			})
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(request.Context(), app.replayer.config.MaxDuration)
+		app.replayer.replay(ctx, &result, matched, options.rate)
+		cancel()
+	}
+
+	logging.Info(app.logger).Log(logging.MessageKey(), "replayed events", "replay", result.ID, "device id", id,
+		"dry run", result.DryRun, "matched", result.Matched, "sent", result.Sent, "error", result.Error)
+	writeJSON(writer, http.StatusOK, result)
+}
@@ -0,0 +1,387 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestValidateReplayConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	config := ReplayConfig{}
+	assert.Empty(validateReplayConfig(&config))
+	assert.Equal(ReplayConfig{}, config)
+
+	config = ReplayConfig{Target: "https://example.com/events"}
+	assert.Empty(validateReplayConfig(&config))
+	assert.Equal(ReplayConfig{
+		Target:      "https://example.com/events",
+		Format:      replayFormatMsgpack,
+		Rate:        defaultReplayRate,
+		RecordLimit: defaultReplayRecordLimit,
+		Timeout:     defaultReplayTimeout,
+		MaxDuration: defaultReplayMaxDuration,
+	}, config)
+
+	config = ReplayConfig{Target: "example.com", Format: "xml", Rate: -1, RecordLimit: -1, Timeout: -time.Second, MaxDuration: -time.Second}
+	errs := validateReplayConfig(&config)
+	require.Len(t, errs, 6)
+	assert.Contains(errs[0].Error(), "replay.target must be an http or https URL")
+	assert.Contains(errs[1].Error(), "replay.format must be")
+	assert.Contains(errs[2].Error(), "replay.rate must be positive")
+	assert.Contains(errs[3].Error(), "replay.recordLimit must be positive")
+	assert.Contains(errs[4].Error(), "replay.timeout must be positive")
+	assert.Contains(errs[5].Error(), "replay.maxDuration must be positive")
+}
+
+func TestRequestReplayOptions(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		description string
+		query       string
+		expected    replayOptions
+		expectedErr string
+	}{
+		{
+			description: "Defaults",
+			expected:    replayOptions{rate: 10},
+		},
+		{
+			description: "Everything",
+			query:       "?types=event,SimpleRequestResponse&from=2025-01-01T00:00:00Z&to=2025-02-01T00:00:00Z&rate=5&dry_run=true",
+			expected:    replayOptions{types: []string{"SimpleEvent", "SimpleRequestResponse"}, from: &from, to: &to, rate: 5, dryRun: true},
+		},
+		{
+			description: "Bad Type",
+			query:       "?types=Loud",
+			expectedErr: `invalid type "Loud"`,
+		},
+		{
+			description: "Bad Time",
+			query:       "?from=yesterday",
+			expectedErr: `invalid from value "yesterday"`,
+		},
+		{
+			description: "Backwards Window",
+			query:       "?from=2025-02-01T00:00:00Z&to=2025-01-01T00:00:00Z",
+			expectedErr: "from must be before to",
+		},
+		{
+			description: "Rate Too High",
+			query:       "?rate=11",
+			expectedErr: `invalid rate value "11", expected 1 to 10 events per second`,
+		},
+		{
+			description: "Bad Dry Run",
+			query:       "?dry_run=maybe",
+			expectedErr: `invalid dry_run value "maybe"`,
+		},
+	}
+
+	r, err := NewReplayer(ReplayConfig{Target: "https://example.com"}, NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	require.Nil(t, err)
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			o, err := r.requestReplayOptions(httptest.NewRequest(http.MethodPost, "/"+tc.query, nil))
+			if tc.expectedErr != "" {
+				require.NotNil(t, err)
+				assert.Contains(err.Error(), tc.expectedErr)
+				return
+			}
+			assert.Nil(err)
+			assert.Equal(tc.expected, o)
+		})
+	}
+}
+
+// testReplayer returns a replayer with the config.
+func testReplayer(t *testing.T, app *App, config ReplayConfig) *Replayer {
+	replayer, err := NewReplayer(config, app.measures)
+	require.Nil(t, err)
+	return replayer
+}
+
+// testReplayGetter returns the records the replayer reads: three events, a
+// request and a record that fails to decrypt.
+func testReplayGetter(t *testing.T, replayer *Replayer) *mockRecordGetter {
+	msg := goodOnlineEvent
+	msg.Type = wrp.SimpleEventMessageType
+	request := goodOnlineEvent
+	request.Type = wrp.SimpleRequestResponseMessageType
+	failed := testRecord(t, msg, 50)
+	failed.KID = "missing"
+
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", replayer.config.RecordLimit, "").Return([]db.Record{
+		testRecord(t, msg, 300),
+		testRecord(t, request, 250),
+		testRecord(t, msg, 200),
+		testRecord(t, msg, 100),
+		failed,
+	}, nil)
+	return mockGetter
+}
+
+func testReplayRequest(t *testing.T, ctx context.Context, principal, query string) *http.Request {
+	auth := testAuth(principal, "*")
+	request := testRequest(t, auth, http.MethodPost, "/admin/device/mac:112233445566/replay"+query, "", nil)
+	request = request.WithContext(bascule.WithAuthentication(ctx, auth))
+	return mux.SetURLVars(request, map[string]string{"deviceID": "mac:112233445566"})
+}
+
+func TestHandleReplay(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	receiver := new(webhookReceiver)
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	p := xmetricstest.NewProvider(nil, Metrics)
+	app := testApp(NewMeasures(p))
+	app.replayer = testReplayer(t, app, ReplayConfig{Target: server.URL, Authorization: "Basic dXNlcjpwYXNz", Rate: 20})
+	app.eventGetter = testReplayGetter(t, app.replayer)
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	app.handleReplay(rr, testReplayRequest(t, context.Background(), "admin", "?types=SimpleEvent&from=1970-01-01T00:00:00.00000015Z"))
+	require.Equal(http.StatusOK, rr.Code)
+	// two events at 20 a second are at least 50ms apart
+	assert.GreaterOrEqual(time.Since(start), 50*time.Millisecond)
+
+	var result ReplayResult
+	require.Nil(json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.NotEmpty(result.ID)
+	assert.Equal(ReplayResult{
+		ID:       result.ID,
+		DeviceID: "mac:112233445566",
+		Target:   server.URL,
+		Rate:     20,
+		Matched:  2,
+		Sent:     2,
+		Failed:   1,
+	}, result)
+	p.Assert(t, ReplayEventsCounter, outcomeLabel, replaySent)(xmetricstest.Value(2.0))
+
+	require.Equal(2, receiver.received())
+	for _, r := range receiver.requests {
+		assert.Equal(wrp.MimeTypeMsgpack, r.Header.Get("Content-Type"))
+		assert.Equal(result.ID, r.Header.Get(replayHeader))
+		assert.Equal("Basic dXNlcjpwYXNz", r.Header.Get("Authorization"))
+	}
+	var msg wrp.Message
+	require.Nil(wrp.NewDecoderBytes(receiver.bodies[0], wrp.Msgpack).Decode(&msg))
+	assert.Equal(goodOnlineEvent.Destination, msg.Destination)
+	assert.Equal(wrp.SimpleEventMessageType, msg.Type)
+}
+
+func TestHandleReplayDryRun(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	receiver := new(webhookReceiver)
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.replayer = testReplayer(t, app, ReplayConfig{Target: server.URL})
+	app.eventGetter = testReplayGetter(t, app.replayer)
+
+	rr := httptest.NewRecorder()
+	app.handleReplay(rr, testReplayRequest(t, context.Background(), "admin", "?dry_run=true"))
+	require.Equal(http.StatusOK, rr.Code)
+
+	var result ReplayResult
+	require.Nil(json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.True(result.DryRun)
+	assert.Equal(4, result.Matched)
+	assert.Zero(result.Sent)
+	require.Len(result.Events, 4)
+	birthDates := make([]int64, 0, len(result.Events))
+	for _, e := range result.Events {
+		birthDates = append(birthDates, e.BirthDate)
+	}
+	assert.Equal([]int64{100, 200, 250, 300}, birthDates)
+	assert.Equal("SimpleRequestResponse", result.Events[2].Type)
+	assert.Zero(receiver.received())
+}
+
+func TestHandleReplayFailure(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	receiver := &webhookReceiver{codes: []int{http.StatusOK, http.StatusServiceUnavailable}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	p := xmetricstest.NewProvider(nil, Metrics)
+	app := testApp(NewMeasures(p))
+	app.replayer = testReplayer(t, app, ReplayConfig{Target: server.URL, Format: replayFormatJSON, Rate: 1000})
+	app.eventGetter = testReplayGetter(t, app.replayer)
+
+	rr := httptest.NewRecorder()
+	app.handleReplay(rr, testReplayRequest(t, context.Background(), "admin", ""))
+	require.Equal(http.StatusOK, rr.Code)
+
+	var result ReplayResult
+	require.Nil(json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Equal(4, result.Matched)
+	assert.Equal(1, result.Sent)
+	assert.Equal(int64(200), result.ResumeFrom)
+	assert.Contains(result.Error, "event 2 of 4: target responded with 503")
+	assert.Equal(2, receiver.received())
+	assert.Equal(wrp.MimeTypeJson, receiver.requests[0].Header.Get("Content-Type"))
+	p.Assert(t, ReplayEventsCounter, outcomeLabel, replayFailed)(xmetricstest.Value(1.0))
+}
+
+func TestHandleReplayCanceled(t *testing.T) {
+	assert := assert.New(t)
+	receiver := new(webhookReceiver)
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.replayer = testReplayer(t, app, ReplayConfig{Target: server.URL})
+	app.eventGetter = testReplayGetter(t, app.replayer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rr := httptest.NewRecorder()
+	app.handleReplay(rr, testReplayRequest(t, ctx, "admin", ""))
+
+	var result ReplayResult
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Zero(result.Sent)
+	assert.Equal(int64(100), result.ResumeFrom)
+	assert.Contains(result.Error, "context canceled")
+	assert.Zero(receiver.received())
+}
+
+func TestHandleReplayForbidden(t *testing.T) {
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.replayer = testReplayer(t, app, ReplayConfig{Target: "https://example.com"})
+	app.eventGetter = testReplayGetter(t, app.replayer)
+
+	rr := httptest.NewRecorder()
+	app.handleReplay(rr, testReplayRequest(t, context.Background(), "owner", ""))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	app.handleReplay(rr, testReplayRequest(t, context.Background(), "admin", "?rate=0"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("X-Codex-Error"))
+}
+
+func TestHandleReplayRecordLimit(t *testing.T) {
+	// the five records read are all there are room for, the oldest at 50ns
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.replayer = testReplayer(t, app, ReplayConfig{Target: "https://example.com", RecordLimit: 5})
+	app.eventGetter = testReplayGetter(t, app.replayer)
+
+	tests := []struct {
+		query        string
+		expectedCode int
+	}{
+		{"?dry_run=true", http.StatusBadRequest},
+		{"?dry_run=true&from=1970-01-01T00:00:00.00000005Z", http.StatusBadRequest},
+		{"?dry_run=true&to=1970-01-01T00:00:00.00000025Z", http.StatusBadRequest},
+		{"?dry_run=true&from=1970-01-01T00:00:00.000000051Z", http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.handleReplay(rr, testReplayRequest(t, context.Background(), "admin", tc.query))
+			assert.Equal(t, tc.expectedCode, rr.Code)
+			if tc.expectedCode == http.StatusBadRequest {
+				assert.Contains(t, rr.Header().Get("X-Codex-Error"), "1970-01-01T00:00:00.00000005Z")
+			}
+		})
+	}
+}
+
+func TestHandleReplayMaxDuration(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	receiver := new(webhookReceiver)
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	// at 20 events a second, two are sent in 100ms
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.replayer = testReplayer(t, app, ReplayConfig{Target: server.URL, Rate: 20, MaxDuration: 100 * time.Millisecond})
+	app.eventGetter = testReplayGetter(t, app.replayer)
+
+	for _, dryRun := range []bool{true, false} {
+		rr := httptest.NewRecorder()
+		app.handleReplay(rr, testReplayRequest(t, context.Background(), "admin", "?dry_run="+strconv.FormatBool(dryRun)))
+		require.Equal(http.StatusOK, rr.Code)
+
+		var result ReplayResult
+		require.Nil(json.Unmarshal(rr.Body.Bytes(), &result))
+		assert.Equal(4, result.Matched)
+		assert.Equal(int64(250), result.ResumeFrom)
+		assert.Empty(result.Error)
+		if dryRun {
+			assert.Len(result.Events, 2)
+		} else {
+			assert.Equal(2, result.Sent)
+		}
+	}
+	assert.Equal(2, receiver.received())
+
+	// a rate too low to send one event in time still sends one
+	assert.Equal(1, app.replayer.eventLimit(1))
+}
+
+func TestHandleReplaySlowTarget(t *testing.T) {
+	assert := assert.New(t)
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.replayer = testReplayer(t, app, ReplayConfig{Target: server.URL, Rate: 1000, MaxDuration: 50 * time.Millisecond})
+	app.eventGetter = testReplayGetter(t, app.replayer)
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	app.handleReplay(rr, testReplayRequest(t, context.Background(), "admin", ""))
+	assert.Less(time.Since(start), time.Second)
+
+	var result ReplayResult
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Zero(result.Sent)
+	assert.Equal(int64(100), result.ResumeFrom)
+	assert.Contains(result.Error, "context deadline exceeded")
+}
+
+func TestNewReplayer(t *testing.T) {
+	assert := assert.New(t)
+	measures := NewMeasures(xmetricstest.NewProvider(nil, Metrics))
+
+	r, err := NewReplayer(ReplayConfig{}, measures)
+	assert.Nil(r)
+	assert.Nil(err)
+
+	r, err = NewReplayer(ReplayConfig{Target: "ftp://example.com"}, measures)
+	assert.Nil(r)
+	assert.NotNil(err)
+
+	r, err = NewReplayer(ReplayConfig{Target: "https://example.com", Format: replayFormatJSON}, measures)
+	assert.Nil(err)
+	assert.Equal(wrp.JSON, r.format)
+	assert.Equal(defaultReplayTimeout, r.client.Timeout)
+}
@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// The kinds of state event.  Online events start or continue a session and
+// offline events end one.
+const (
+	kindOnline  = "online"
+	kindOffline = "offline"
+)
+
+// stateUnknown is reported when a device seems to be online but hasn't sent
+// an online event within the staleness threshold.
+const stateUnknown = "unknown"
+
+// StatusState maps the destinations of state events to a state.
+type StatusState struct {
+	// Destination is matched against the event destination, where * matches
+	// anything but a /, such as "event:device-status/*/fully-manageable".
+	Destination string
+
+	// State is the state reported for matching events.
+	State string
+
+	// Kind is online for events that start or continue a session, or
+	// offline for events that end one.
+	Kind string
+}
+
+// StatusConfig configures how a device's status is determined.
+type StatusConfig struct {
+	// States maps event destinations to states.  The first match wins.
+	// Without any, the state is the last element of the destination, and
+	// only online and offline are understood.
+	States []StatusState
+
+	// StaleAfter reports the state as unknown when the device seems to be
+	// online but its newest online event is older than this.  0 turns it off.
+	StaleAfter time.Duration
+
+	// Metadata lists the values copied into the status metadata.
+	Metadata []StatusMetadataKey
+}
+
+// validateStatusConfig fills in the defaults for the status configuration and
+// returns every problem with it.
+func validateStatusConfig(config *StatusConfig) []error {
+	var errs []error
+	for i, s := range config.States {
+		if s.Destination == "" {
+			errs = append(errs, fmt.Errorf("status.states[%d].destination is required", i))
+		} else if _, err := path.Match(s.Destination, ""); err != nil {
+			errs = append(errs, fmt.Errorf("status.states[%d].destination %q: %w", i, s.Destination, err))
+		}
+		if s.State == "" || s.State == stateUnknown {
+			errs = append(errs, fmt.Errorf("status.states[%d].state is required and can't be %q", i, stateUnknown))
+		}
+		if s.Kind != kindOnline && s.Kind != kindOffline {
+			errs = append(errs, fmt.Errorf("status.states[%d].kind must be \"online\" or \"offline\", got %q", i, s.Kind))
+		}
+	}
+	if config.StaleAfter < 0 {
+		errs = append(errs, fmt.Errorf("status.staleAfter must be positive, got %s", config.StaleAfter))
+	}
+
+	names := map[string]int{}
+	for i := range config.Metadata {
+		k := &config.Metadata[i]
+		if k.Source != metadataFromPayload && k.Source != metadataFromMetadata {
+			errs = append(errs, fmt.Errorf("status.metadata[%d].source must be \"payload\" or \"metadata\", got %q", i, k.Source))
+		}
+		if k.Path == "" {
+			errs = append(errs, fmt.Errorf("status.metadata[%d].path is required", i))
+			continue
+		}
+		if k.Name == "" {
+			k.Name = k.Path
+		}
+		if j, ok := names[k.Name]; ok {
+			errs = append(errs, fmt.Errorf("status.metadata[%d] and status.metadata[%d] are both named %q", j, i, k.Name))
+			continue
+		}
+		names[k.Name] = i
+	}
+	return errs
+}
+
+// stateOf returns the state and kind of an event sent to destination.  The
+// kind is empty if the state isn't understood.
+func (c StatusConfig) stateOf(destination string) (string, string) {
+	if len(c.States) == 0 {
+		state := path.Base(destination)
+		switch state {
+		case kindOnline, kindOffline:
+			return state, state
+		}
+		return state, ""
+	}
+
+	for _, s := range c.States {
+		if ok, _ := path.Match(s.Destination, destination); ok {
+			return s.State, s.Kind
+		}
+	}
+	return path.Base(destination), ""
+}
+
+// isStale reports whether the newest online event is too old for the device
+// to be considered online.
+func (c StatusConfig) isStale(lastOnline time.Time, now time.Time) bool {
+	return c.StaleAfter > 0 && now.Sub(lastOnline) > c.StaleAfter
+}
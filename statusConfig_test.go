@@ -0,0 +1,261 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/voynicrypto"
+	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestValidateStatusConfig(t *testing.T) {
+	tests := []struct {
+		description  string
+		config       StatusConfig
+		expected     StatusConfig
+		expectedErrs []string
+	}{
+		{
+			description: "Empty",
+		},
+		{
+			description: "Name Defaults To Path",
+			config: StatusConfig{Metadata: []StatusMetadataKey{
+				{Source: metadataFromPayload, Path: "fw.name"},
+				{Name: "model", Source: metadataFromMetadata, Path: "/hw-model"},
+			}},
+			expected: StatusConfig{Metadata: []StatusMetadataKey{
+				{Name: "fw.name", Source: metadataFromPayload, Path: "fw.name"},
+				{Name: "model", Source: metadataFromMetadata, Path: "/hw-model"},
+			}},
+		},
+		{
+			description: "States",
+			config: StatusConfig{
+				States: []StatusState{
+					{Destination: "event:device-status/*/online", State: "online", Kind: kindOnline},
+					{Destination: "event:device-status/*/fully-manageable", State: "fully-manageable", Kind: kindOnline},
+				},
+				StaleAfter: time.Hour,
+			},
+			expected: StatusConfig{
+				States: []StatusState{
+					{Destination: "event:device-status/*/online", State: "online", Kind: kindOnline},
+					{Destination: "event:device-status/*/fully-manageable", State: "fully-manageable", Kind: kindOnline},
+				},
+				StaleAfter: time.Hour,
+			},
+		},
+		{
+			description: "All Problems Reported",
+			config: StatusConfig{
+				States: []StatusState{
+					{State: "online", Kind: kindOnline},
+					{Destination: "event:device-status/[/online", Kind: "sleeping"},
+					{Destination: "*", State: stateUnknown, Kind: kindOffline},
+				},
+				StaleAfter: -time.Second,
+				Metadata: []StatusMetadataKey{
+					{Source: "headers", Path: "a"},
+					{Source: metadataFromPayload},
+					{Name: "a", Source: metadataFromMetadata, Path: "/a"},
+				},
+			},
+			expectedErrs: []string{
+				"status.states[0].destination is required",
+				`status.states[1].destination "event:device-status/[/online": syntax error in pattern`,
+				`status.states[1].state is required and can't be "unknown"`,
+				`status.states[1].kind must be "online" or "offline", got "sleeping"`,
+				`status.states[2].state is required and can't be "unknown"`,
+				"status.staleAfter must be positive, got -1s",
+				`status.metadata[0].source must be "payload" or "metadata", got "headers"`,
+				"status.metadata[1].path is required",
+				`status.metadata[0] and status.metadata[2] are both named "a"`,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			errs := validateStatusConfig(&tc.config)
+			if len(tc.expectedErrs) == 0 {
+				assert.Empty(errs)
+				assert.Equal(tc.expected, tc.config)
+				return
+			}
+			require.Len(t, errs, len(tc.expectedErrs))
+			for i, expected := range tc.expectedErrs {
+				assert.EqualError(errs[i], expected)
+			}
+		})
+	}
+}
+
+func TestStateOf(t *testing.T) {
+	configured := StatusConfig{States: []StatusState{
+		{Destination: "event:device-status/*/fully-manageable", State: "manageable", Kind: kindOnline},
+		{Destination: "event:device-status/*/online", State: "online", Kind: kindOnline},
+		{Destination: "event:device-status/*/*", State: "offline", Kind: kindOffline},
+	}}
+
+	tests := []struct {
+		description   string
+		config        StatusConfig
+		destination   string
+		expectedState string
+		expectedKind  string
+	}{
+		{
+			description:   "Default Online",
+			destination:   "event:device-status/mac:112233445566/online",
+			expectedState: "online",
+			expectedKind:  kindOnline,
+		},
+		{
+			description:   "Default Offline",
+			destination:   "/test/offline",
+			expectedState: "offline",
+			expectedKind:  kindOffline,
+		},
+		{
+			description:   "Default Unrecognized",
+			destination:   "event:device-status/mac:112233445566/fully-manageable",
+			expectedState: "fully-manageable",
+		},
+		{
+			description:   "Configured",
+			config:        configured,
+			destination:   "event:device-status/mac:112233445566/fully-manageable",
+			expectedState: "manageable",
+			expectedKind:  kindOnline,
+		},
+		{
+			description:   "First Match Wins",
+			config:        configured,
+			destination:   "event:device-status/mac:112233445566/online",
+			expectedState: "online",
+			expectedKind:  kindOnline,
+		},
+		{
+			description:   "Configured Catch All",
+			config:        configured,
+			destination:   "event:device-status/mac:112233445566/operational",
+			expectedState: "offline",
+			expectedKind:  kindOffline,
+		},
+		{
+			description:   "Configured Unrecognized",
+			config:        configured,
+			destination:   "/test/online",
+			expectedState: "online",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			state, kind := tc.config.stateOf(tc.destination)
+			assert.Equal(tc.expectedState, state)
+			assert.Equal(tc.expectedKind, kind)
+		})
+	}
+}
+
+func TestGetStatusInfoStates(t *testing.T) {
+	now := time.Now()
+	encode := func(t *testing.T, destination, session string) []byte {
+		var data []byte
+		require.Nil(t, wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(&wrp.Message{
+			Destination: destination,
+			Payload:     []byte(`{}`),
+			SessionID:   session,
+		}))
+		return data
+	}
+	states := []StatusState{
+		{Destination: "event:device-status/*/online", State: "online", Kind: kindOnline},
+		{Destination: "event:device-status/*/fully-manageable", State: "fully-manageable", Kind: kindOnline},
+		{Destination: "event:device-status/*/offline", State: "offline", Kind: kindOffline},
+	}
+
+	tests := []struct {
+		description        string
+		staleAfter         time.Duration
+		onlineAge          time.Duration
+		expectedState      string
+		expectedOnlineFor  bool
+		expectedOfflineFor bool
+	}{
+		{
+			description:       "Custom State",
+			onlineAge:         time.Minute,
+			expectedState:     "fully-manageable",
+			expectedOnlineFor: true,
+		},
+		{
+			description:       "Fresh",
+			staleAfter:        time.Hour,
+			onlineAge:         time.Minute,
+			expectedState:     "fully-manageable",
+			expectedOnlineFor: true,
+		},
+		{
+			description:   "Stale",
+			staleAfter:    time.Hour,
+			onlineAge:     2 * time.Hour,
+			expectedState: stateUnknown,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			deathDate := now.Add(time.Hour).UnixNano()
+			onlineAt := now.Add(-tc.onlineAge).UnixNano()
+			records := []db.Record{
+				{Type: db.State, BirthDate: onlineAt, DeathDate: deathDate, Data: encode(t, "event:device-status/mac:112233445566/fully-manageable", "2"), Alg: string(voynicrypto.None), KID: "none"},
+				{Type: db.State, BirthDate: onlineAt - int64(time.Minute), DeathDate: deathDate, Data: encode(t, "event:device-status/mac:112233445566/online", "2"), Alg: string(voynicrypto.None), KID: "none"},
+				{Type: db.State, BirthDate: onlineAt - int64(2*time.Minute), DeathDate: deathDate, Data: encode(t, "event:device-status/mac:112233445566/offline", "1"), Alg: string(voynicrypto.None), KID: "none"},
+			}
+			mockGetter := new(mockRecordGetter)
+			mockGetter.On("GetRecordsOfType", "test", 5, db.State, "").Return(records, nil).Once()
+			decrypter := new(mockDecrypter)
+			decrypter.On("DecryptMessage", mock.Anything, mock.Anything).Return(nil)
+
+			status := StatusConfig{States: states, StaleAfter: tc.staleAfter}
+			require.Empty(validateStatusConfig(&status))
+			app := App{
+				eventGetter:    mockGetter,
+				getStatusLimit: 5,
+				logger:         logging.DefaultLogger(),
+				decrypters: &voynicrypto.Ciphers{
+					Options: map[voynicrypto.AlgorithmType]map[string]voynicrypto.Decrypt{
+						voynicrypto.None: {"none": decrypter},
+					},
+				},
+				measures: NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+				status:   status,
+			}
+
+			s, err := app.getStatusInfo("test", false)
+			require.Nil(err)
+			assert.Equal(tc.expectedState, s.State)
+			assert.Equal(time.Unix(0, onlineAt), s.Since)
+			assert.Equal(tc.expectedOnlineFor, s.OnlineFor != nil)
+			assert.Equal(tc.expectedOfflineFor, s.OfflineFor != nil)
+			require.NotNil(s.LastOnlineAt)
+			assert.Equal(time.Unix(0, onlineAt), *s.LastOnlineAt)
+		})
+	}
+}
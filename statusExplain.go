@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"time"
+
+	db "github.com/xmidt-org/codex-db"
+)
+
+// The rules determineStatus uses to pick the device's status.
+const (
+	ruleNoCandidates         = "no-candidates"
+	ruleOnlyOnline           = "only-online"
+	ruleOnlyOffline          = "only-offline"
+	ruleNoSessionOnlineNewer = "missing-session-online-newer"
+	ruleSameSession          = "same-session"
+	ruleDifferentSession     = "different-session"
+	ruleStaleOnline          = "stale-online"
+)
+
+var ruleDescriptions = map[string]string{
+	ruleNoCandidates:         "no usable online or offline event was found",
+	ruleOnlyOnline:           "there is no offline event, so the newest online event is used",
+	ruleOnlyOffline:          "there is no online event, so the newest offline event is used",
+	ruleNoSessionOnlineNewer: "an event has no session ID and the online event is newer than the offline event",
+	ruleSameSession:          "the online and offline events have the same session ID, so that session has ended",
+	ruleDifferentSession:     "the online and offline events have different session IDs, so the online event's session is assumed to be current",
+	ruleStaleOnline:          "the device seems to be online, but its newest online event is older than the staleness threshold, so its state is unknown",
+}
+
+// StateRecordExplanation describes how one state record was used when
+// determining a status.
+type StateRecordExplanation struct {
+	RowID     string    `json:"row_id"`
+	BirthDate time.Time `json:"birth_date"`
+	DeathDate time.Time `json:"death_date"`
+	Alg       string    `json:"alg"`
+	KID       string    `json:"kid"`
+	State     string    `json:"state,omitempty"`
+	Kind      string    `json:"kind,omitempty"`
+	SessionID string    `json:"session_id,omitempty"`
+	Skipped   bool      `json:"skipped"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// StatusCandidate is the newest online or offline event, one of which
+// determineStatus chooses between.
+type StatusCandidate struct {
+	RowID     string    `json:"row_id"`
+	State     string    `json:"state"`
+	SessionID string    `json:"session_id"`
+	Since     time.Time `json:"since"`
+}
+
+// StatusExplanation is the response to a status request with explain=true.
+type StatusExplanation struct {
+	// Status is the result, or nil if no status could be determined.
+	Status           *Status                  `json:"status"`
+	Records          []StateRecordExplanation `json:"records"`
+	OnlineCandidate  *StatusCandidate         `json:"online_candidate"`
+	OfflineCandidate *StatusCandidate         `json:"offline_candidate"`
+	Rule             string                   `json:"rule"`
+	RuleDescription  string                   `json:"rule_description"`
+}
+
+// The methods below do nothing on a nil explanation, so that resolveStatus
+// doesn't need to check whether one was asked for.
+
+func (e *StatusExplanation) skip(record db.Record, item eventTuple, reason string) {
+	if e == nil {
+		return
+	}
+	r := newStateRecordExplanation(record, item)
+	r.Skipped = true
+	r.Reason = reason
+	e.Records = append(e.Records, r)
+}
+
+func (e *StatusExplanation) consider(record db.Record, item eventTuple) {
+	if e == nil {
+		return
+	}
+	e.Records = append(e.Records, newStateRecordExplanation(record, item))
+}
+
+// candidates records the chosen online and offline events and explains why
+// every other usable record lost to them.
+func (e *StatusExplanation) candidates(online, offline eventTuple) {
+	if e == nil {
+		return
+	}
+	e.OnlineCandidate = newStatusCandidate(online)
+	e.OfflineCandidate = newStatusCandidate(offline)
+	chosen := map[string]eventTuple{kindOnline: online, kindOffline: offline}
+	for i, r := range e.Records {
+		if r.Skipped {
+			continue
+		}
+		c, ok := chosen[r.Kind]
+		if ok && r.RowID == c.record.RowID && r.BirthDate.Equal(time.Unix(0, c.record.BirthDate)) {
+			e.Records[i].Reason = "chosen as the " + r.Kind + " candidate"
+			// only the first matching record was chosen
+			delete(chosen, r.Kind)
+			continue
+		}
+		e.Records[i].Reason = "not newer than the chosen " + r.Kind + " candidate"
+	}
+}
+
+func (e *StatusExplanation) decide(status *Status, rule string) {
+	if e == nil {
+		return
+	}
+	e.Status = status
+	e.Rule = rule
+	e.RuleDescription = ruleDescriptions[rule]
+}
+
+func newStateRecordExplanation(record db.Record, item eventTuple) StateRecordExplanation {
+	return StateRecordExplanation{
+		RowID:     record.RowID,
+		BirthDate: time.Unix(0, record.BirthDate),
+		DeathDate: time.Unix(0, record.DeathDate),
+		Alg:       record.Alg,
+		KID:       record.KID,
+		State:     item.status.State,
+		Kind:      item.kind,
+		SessionID: item.sessionID,
+	}
+}
+
+func newStatusCandidate(item eventTuple) *StatusCandidate {
+	if item.status.State == "" {
+		return nil
+	}
+	return &StatusCandidate{
+		RowID:     item.record.RowID,
+		State:     item.status.State,
+		SessionID: item.sessionID,
+		Since:     item.status.Since,
+	}
+}
@@ -0,0 +1,235 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/voynicrypto"
+	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestDetermineStatus(t *testing.T) {
+	now := time.Now()
+	online := func(session string, since time.Time) eventTuple {
+		return eventTuple{status: Status{State: "online", Since: since}, sessionID: session}
+	}
+	offline := func(session string, since time.Time) eventTuple {
+		return eventTuple{status: Status{State: "offline", Since: since}, sessionID: session}
+	}
+
+	tests := []struct {
+		description   string
+		online        eventTuple
+		offline       eventTuple
+		expectedState string
+		expectedRule  string
+	}{
+		{
+			description:   "Only Online",
+			online:        online("a", now),
+			expectedState: "online",
+			expectedRule:  ruleOnlyOnline,
+		},
+		{
+			description:   "Only Offline",
+			offline:       offline("a", now),
+			expectedState: "offline",
+			expectedRule:  ruleOnlyOffline,
+		},
+		{
+			description:   "Missing Session Online Newer",
+			online:        online("", now),
+			offline:       offline("a", now.Add(-time.Minute)),
+			expectedState: "online",
+			expectedRule:  ruleNoSessionOnlineNewer,
+		},
+		{
+			description:   "Same Session",
+			online:        online("a", now),
+			offline:       offline("a", now.Add(-time.Minute)),
+			expectedState: "offline",
+			expectedRule:  ruleSameSession,
+		},
+		{
+			description:   "Different Session",
+			online:        online("b", now.Add(-time.Minute)),
+			offline:       offline("a", now),
+			expectedState: "online",
+			expectedRule:  ruleDifferentSession,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			chosen, rule := determineStatus(tc.online, tc.offline)
+			assert.Equal(tc.expectedState, chosen.status.State)
+			assert.Equal(tc.expectedRule, rule)
+			assert.NotEmpty(ruleDescriptions[rule])
+		})
+	}
+}
+
+func TestHandleGetStatusExplain(t *testing.T) {
+	testassert := assert.New(t)
+	futureTime := time.Now().Add(time.Duration(50000) * time.Minute).UnixNano()
+	pastTime := time.Now().Add(-time.Hour).UnixNano()
+
+	encode := func(msg wrp.Message) []byte {
+		var data []byte
+		testassert.Nil(wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(&msg))
+		return data
+	}
+	unknownState := goodOnlineEvent
+	unknownState.Destination = "/test/sleeping"
+
+	admin := bascule.Authentication{Token: bascule.NewToken("basic", "admin", bascule.NewAttributes(nil))}
+	records := []db.Record{
+		{RowID: "expired", DeathDate: pastTime, Data: encode(goodOnlineEvent), Alg: string(voynicrypto.None), KID: "none"},
+		{RowID: "no-decrypter", BirthDate: futureTime - 900, DeathDate: futureTime, Data: encode(goodOnlineEvent), Alg: string(voynicrypto.Box), KID: "none"},
+		{RowID: "unknown", BirthDate: futureTime - 800, DeathDate: futureTime, Data: encode(unknownState), Alg: string(voynicrypto.None), KID: "none"},
+		{RowID: "old-online", BirthDate: futureTime - 700, DeathDate: futureTime, Data: encode(goodOnlineEvent), Alg: string(voynicrypto.None), KID: "none"},
+		{RowID: "online", BirthDate: futureTime - 600, DeathDate: futureTime, Data: encode(goodOnlineEvent), Alg: string(voynicrypto.None), KID: "none"},
+		{RowID: "offline", BirthDate: futureTime - 500, DeathDate: futureTime, Data: encode(goodOfflineEvent), Alg: string(voynicrypto.None), KID: "none"},
+	}
+
+	tests := []struct {
+		description        string
+		auth               bascule.Authentication
+		query              string
+		recordsToReturn    []db.Record
+		getRecordsErr      error
+		expectedStatusCode int
+		expectedRule       string
+		expectedReasons    map[string]string
+	}{
+		{
+			description:        "Bad Explain Value",
+			auth:               admin,
+			query:              "?explain=maybe",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description: "Not Admin",
+			auth: bascule.Authentication{
+				Token: bascule.NewToken("jwt", "owner", bascule.NewAttributes(map[string]interface{}{})),
+			},
+			query:              "?explain=true",
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			description:        "Get Records Error",
+			auth:               admin,
+			query:              "?explain=true",
+			getRecordsErr:      errors.New("get records test error"),
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			description:        "No Candidates",
+			auth:               admin,
+			query:              "?explain=true",
+			recordsToReturn:    records[:2],
+			expectedStatusCode: http.StatusOK,
+			expectedRule:       ruleNoCandidates,
+			expectedReasons: map[string]string{
+				"expired":      "expired",
+				"no-decrypter": "failed to find decrypter",
+			},
+		},
+		{
+			description:        "Success",
+			auth:               admin,
+			query:              "?explain=true",
+			recordsToReturn:    records,
+			expectedStatusCode: http.StatusOK,
+			// the events have different session ids
+			expectedRule: ruleDifferentSession,
+			expectedReasons: map[string]string{
+				"expired":      "expired",
+				"no-decrypter": "failed to find decrypter",
+				"unknown":      `unrecognized state "sleeping"`,
+				"old-online":   "not newer than the chosen online candidate",
+				"online":       "chosen as the online candidate",
+				"offline":      "chosen as the offline candidate",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			mockGetter := new(mockRecordGetter)
+			mockGetter.On("GetRecordsOfType", "mac:112233445566", 5, db.State, "").Return(tc.recordsToReturn, tc.getRecordsErr).Once()
+
+			decrypter := new(mockDecrypter)
+			decrypter.On("DecryptMessage", mock.Anything, mock.Anything).Return(nil)
+			ciphers := voynicrypto.Ciphers{
+				Options: map[voynicrypto.AlgorithmType]map[string]voynicrypto.Decrypt{
+					voynicrypto.None: {"none": decrypter},
+				},
+			}
+
+			p := xmetricstest.NewProvider(nil, Metrics)
+			app := App{
+				eventGetter:    mockGetter,
+				getStatusLimit: 5,
+				logger:         logging.DefaultLogger(),
+				decrypters:     &ciphers,
+				measures:       NewMeasures(p),
+				admin:          AdminConfig{BasicUsers: []string{"admin"}},
+			}
+
+			rr := httptest.NewRecorder()
+			request, err := http.NewRequestWithContext(bascule.WithAuthentication(context.Background(), tc.auth),
+				http.MethodGet, "/device/1234/status"+tc.query, nil)
+			require.Nil(err)
+			request = mux.SetURLVars(request, map[string]string{"deviceID": "mac:112233445566"})
+			app.handleGetStatus(rr, request)
+			require.Equal(tc.expectedStatusCode, rr.Code)
+			p.Assert(t, GetDecrypterFailureCounter)(xmetricstest.Value(0.0))
+			if tc.expectedStatusCode != http.StatusOK {
+				return
+			}
+
+			var explanation StatusExplanation
+			require.Nil(json.Unmarshal(rr.Body.Bytes(), &explanation))
+			assert.Equal(tc.expectedRule, explanation.Rule)
+			assert.NotEmpty(explanation.RuleDescription)
+			require.Len(explanation.Records, len(tc.expectedReasons))
+			for _, r := range explanation.Records {
+				assert.Contains(r.Reason, tc.expectedReasons[r.RowID], r.RowID)
+			}
+
+			if tc.expectedRule == ruleNoCandidates {
+				assert.Nil(explanation.Status)
+				assert.Nil(explanation.OnlineCandidate)
+				assert.Nil(explanation.OfflineCandidate)
+				return
+			}
+			require.NotNil(explanation.Status)
+			assert.Equal("online", explanation.Status.State)
+			require.NotNil(explanation.OnlineCandidate)
+			assert.Equal("online", explanation.OnlineCandidate.RowID)
+			assert.Equal(goodOnlineEvent.SessionID, explanation.OnlineCandidate.SessionID)
+			require.NotNil(explanation.OfflineCandidate)
+			assert.Equal("offline", explanation.OfflineCandidate.RowID)
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import "strings"
+
+// Where a status metadata value can come from.
+const (
+	metadataFromPayload  = "payload"
+	metadataFromMetadata = "metadata"
+)
+
+// StatusMetadataKey is a value copied from the state events into the status
+// metadata.
+type StatusMetadataKey struct {
+	// Name is the key in the status metadata.  Defaults to Path.
+	Name string
+
+	// Source is payload, for the event's JSON payload, or metadata, for the
+	// event's wrp metadata.
+	Source string
+
+	// Path is a dotted path into the payload, such as "fw.name", or a wrp
+	// metadata key, such as "/hw-model", which is used as is.
+	Path string
+}
+
+// extractMetadata copies the configured values found in an event.  The result
+// is nil if none were found.
+func (c StatusConfig) extractMetadata(payload map[string]interface{}, metadata map[string]string) map[string]interface{} {
+	var values map[string]interface{}
+	for _, k := range c.Metadata {
+		var (
+			v  interface{}
+			ok bool
+		)
+		switch k.Source {
+		case metadataFromPayload:
+			v, ok = lookupPath(payload, k.Path)
+		case metadataFromMetadata:
+			v, ok = metadata[k.Path]
+		}
+		if !ok {
+			continue
+		}
+		if values == nil {
+			values = map[string]interface{}{}
+		}
+		values[k.Name] = v
+	}
+	return values
+}
+
+// mergeMetadata returns the metadata of the event that provides the status,
+// with any value it is missing taken from the newest online event, so that
+// the last known values are still reported while the device is offline.
+func mergeMetadata(chosen, lastOnline map[string]interface{}) map[string]interface{} {
+	if len(lastOnline) == 0 {
+		return chosen
+	}
+	merged := make(map[string]interface{}, len(lastOnline)+len(chosen))
+	for k, v := range lastOnline {
+		merged[k] = v
+	}
+	for k, v := range chosen {
+		merged[k] = v
+	}
+	return merged
+}
+
+// lookupPath follows a dotted path through nested JSON objects.
+func lookupPath(v map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = v
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
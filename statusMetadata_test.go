@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/voynicrypto"
+	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestExtractMetadata(t *testing.T) {
+	config := StatusConfig{Metadata: []StatusMetadataKey{
+		{Name: "fw", Source: metadataFromPayload, Path: "fw.name"},
+		{Name: "uptime", Source: metadataFromPayload, Path: "up-time"},
+		{Name: "missing", Source: metadataFromPayload, Path: "fw.name.major"},
+		{Name: "model", Source: metadataFromMetadata, Path: "/hw-model"},
+		{Name: "boot", Source: metadataFromMetadata, Path: "/boot-time"},
+	}}
+	payload := map[string]interface{}{
+		"fw":      map[string]interface{}{"name": "1.2.3"},
+		"up-time": "16m46.6s",
+	}
+	metadata := map[string]string{"/hw-model": "tg1682"}
+
+	assert := assert.New(t)
+	assert.Equal(map[string]interface{}{
+		"fw":     "1.2.3",
+		"uptime": "16m46.6s",
+		"model":  "tg1682",
+	}, config.extractMetadata(payload, metadata))
+	assert.Nil(config.extractMetadata(nil, nil))
+	assert.Nil(StatusConfig{}.extractMetadata(payload, metadata))
+}
+
+func TestMergeMetadata(t *testing.T) {
+	assert := assert.New(t)
+	online := map[string]interface{}{"fw": "1.2.3", "reason": "boot"}
+	assert.Equal(map[string]interface{}{"fw": "1.2.3", "reason": "ping miss"},
+		mergeMetadata(map[string]interface{}{"reason": "ping miss"}, online))
+	assert.Equal(online, mergeMetadata(nil, online))
+	assert.Nil(mergeMetadata(nil, nil))
+}
+
+func TestGetStatusInfoMetadata(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	futureTime := time.Now().Add(time.Duration(50000) * time.Minute).UnixNano()
+
+	encode := func(msg wrp.Message) []byte {
+		var data []byte
+		require.Nil(wrp.NewEncoderBytes(&data, wrp.Msgpack).Encode(&msg))
+		return data
+	}
+	online := wrp.Message{
+		Destination: "event:device-status/mac:112233445566/online",
+		Payload:     []byte(`{"fw":{"name":"1.2.3"}}`),
+		Metadata:    map[string]string{"/hw-model": "tg1682"},
+		SessionID:   "1",
+	}
+	offline := wrp.Message{
+		Destination: "event:device-status/mac:112233445566/offline",
+		Payload:     []byte(`{"reason-for-closure":"ping miss"}`),
+		SessionID:   "1",
+	}
+	records := []db.Record{
+		{Type: db.State, BirthDate: futureTime - 500, DeathDate: futureTime, Data: encode(offline), Alg: string(voynicrypto.None), KID: "none"},
+		{Type: db.State, BirthDate: futureTime - 700, DeathDate: futureTime, Data: encode(online), Alg: string(voynicrypto.None), KID: "none"},
+	}
+
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecordsOfType", "test", 5, db.State, "").Return(records, nil).Once()
+	decrypter := new(mockDecrypter)
+	decrypter.On("DecryptMessage", mock.Anything, mock.Anything).Return(nil)
+
+	status := StatusConfig{Metadata: []StatusMetadataKey{
+		{Name: "fw", Source: metadataFromPayload, Path: "fw.name"},
+		{Name: "reason", Source: metadataFromPayload, Path: "reason-for-closure"},
+		{Name: "model", Source: metadataFromMetadata, Path: "/hw-model"},
+	}}
+	require.Empty(validateStatusConfig(&status))
+	app := App{
+		eventGetter:    mockGetter,
+		getStatusLimit: 5,
+		logger:         logging.DefaultLogger(),
+		decrypters: &voynicrypto.Ciphers{
+			Options: map[voynicrypto.AlgorithmType]map[string]voynicrypto.Decrypt{
+				voynicrypto.None: {"none": decrypter},
+			},
+		},
+		measures: NewMeasures(xmetricstest.NewProvider(nil, Metrics)),
+		status:   status,
+	}
+
+	s, err := app.getStatusInfo("test", false)
+	require.Nil(err)
+	assert.Equal("offline", s.State)
+	// the device is offline, but the last known online values are kept
+	assert.Equal(map[string]interface{}{
+		"fw":     "1.2.3",
+		"reason": "ping miss",
+		"model":  "tg1682",
+	}, s.Metadata)
+}
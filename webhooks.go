@@ -0,0 +1,955 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/spf13/cast"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/gungnir/model"
+	"github.com/xmidt-org/webpa-common/v2/logging" //nolint: staticcheck
+)
+
+// webhookIDRouteVar matches the id of a webhook subscription.
+const webhookIDRouteVar = "{webhookID}"
+
+// The headers sent with every delivery.  The signature is the hex encoded
+// HMAC-SHA256 of the body, keyed with the subscription's secret.
+const (
+	webhookSignatureHeader = "X-Codex-Signature"
+	webhookDeliveryHeader  = "X-Codex-Delivery"
+)
+
+// The kinds of deliveries.
+const (
+	webhookEvents = "events"
+	webhookStatus = "status"
+)
+
+// The outcomes of a delivery attempt.
+const (
+	webhookDelivered    = "delivered"
+	webhookRetried      = "retried"
+	webhookDeadLettered = "dead_lettered"
+)
+
+const (
+	defaultWebhookPollInterval     = 30 * time.Second
+	defaultWebhookMaxSubscriptions = 100
+	defaultWebhookMaxDevices       = 100
+	defaultWebhookWorkers          = 4
+	defaultWebhookQueueSize        = 1000
+	defaultWebhookMaxAttempts      = 5
+	defaultWebhookInitialBackoff   = time.Second
+	defaultWebhookMaxBackoff       = time.Minute
+	defaultWebhookTimeout          = 10 * time.Second
+	defaultWebhookDeadLetters      = 100
+	defaultWebhookMaxTTL           = 24 * time.Hour
+
+	// maxWebhookRequestSize bounds the body of a subscription request.
+	maxWebhookRequestSize = 64 * 1024
+)
+
+// WebhooksConfig configures webhook subscriptions, which deliver new events
+// and status changes for a list of devices to a URL instead of making
+// clients poll for them.  Subscriptions are kept in memory, so they don't
+// survive a restart and each instance has its own.
+type WebhooksConfig struct {
+	// Enabled turns on the webhook subscription API.
+	Enabled bool
+
+	// PollInterval is how often subscribed devices are checked for new
+	// events and status changes.  Defaults to 30s.
+	PollInterval time.Duration
+
+	// MaxSubscriptions is the most subscriptions there may be at once.
+	// Defaults to 100.
+	MaxSubscriptions int
+
+	// MaxDevices is the most devices one subscription may list.  Defaults to
+	// 100.
+	MaxDevices int
+
+	// Workers is how many deliveries are made at once.  Defaults to 4.
+	Workers int
+
+	// QueueSize is how many deliveries can wait for a worker.  Deliveries
+	// that don't fit are dead lettered.  Defaults to 1000.
+	QueueSize int
+
+	// MaxAttempts is how many times a delivery is tried before it is dead
+	// lettered.  Defaults to 5.
+	MaxAttempts int
+
+	// InitialBackoff is how long to wait before retrying a delivery.  The
+	// wait doubles after every attempt, up to MaxBackoff.  Defaults to 1s
+	// and 1m.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Timeout bounds each delivery attempt.  Defaults to 10s.
+	Timeout time.Duration
+
+	// DeadLetters is how many failed deliveries are kept for each
+	// subscription, dropping the oldest first.  Defaults to 100.
+	DeadLetters int
+
+	// MaxTTL is the longest a subscription lasts.  Subscriptions made with a
+	// JWT expire with it if it expires sooner, as they deliver what the
+	// token allowed when they were made.  Defaults to 24h.
+	MaxTTL time.Duration
+
+	// AllowedNetworks are CIDRs, such as 10.0.0.0/8, that deliveries may be
+	// made to.  Loopback, private, link-local and other non-public addresses
+	// are refused unless they are in one of these, so that subscribers can't
+	// make gungnir call internal services or cloud metadata endpoints.
+	AllowedNetworks []string
+}
+
+// validateWebhooksConfig fills in the defaults for a webhooks configuration
+// and returns every problem with it.
+func validateWebhooksConfig(config *WebhooksConfig) []error {
+	if !config.Enabled {
+		return nil
+	}
+
+	var errs []error
+	for _, v := range []struct {
+		name     string
+		value    *int
+		fallback int
+	}{
+		{"maxSubscriptions", &config.MaxSubscriptions, defaultWebhookMaxSubscriptions},
+		{"maxDevices", &config.MaxDevices, defaultWebhookMaxDevices},
+		{"workers", &config.Workers, defaultWebhookWorkers},
+		{"queueSize", &config.QueueSize, defaultWebhookQueueSize},
+		{"maxAttempts", &config.MaxAttempts, defaultWebhookMaxAttempts},
+		{"deadLetters", &config.DeadLetters, defaultWebhookDeadLetters},
+	} {
+		if *v.value == 0 {
+			*v.value = v.fallback
+		}
+		if *v.value < 0 {
+			errs = append(errs, fmt.Errorf("webhooks.%s must be positive, got %d", v.name, *v.value))
+		}
+	}
+	for _, v := range []struct {
+		name     string
+		value    *time.Duration
+		fallback time.Duration
+	}{
+		{"pollInterval", &config.PollInterval, defaultWebhookPollInterval},
+		{"initialBackoff", &config.InitialBackoff, defaultWebhookInitialBackoff},
+		{"maxBackoff", &config.MaxBackoff, defaultWebhookMaxBackoff},
+		{"timeout", &config.Timeout, defaultWebhookTimeout},
+		{"maxTTL", &config.MaxTTL, defaultWebhookMaxTTL},
+	} {
+		if *v.value == 0 {
+			*v.value = v.fallback
+		}
+		if *v.value < 0 {
+			errs = append(errs, fmt.Errorf("webhooks.%s must be positive, got %s", v.name, *v.value))
+		}
+	}
+	if config.InitialBackoff > config.MaxBackoff {
+		errs = append(errs, fmt.Errorf("webhooks.initialBackoff (%s) must not be more than webhooks.maxBackoff (%s)", config.InitialBackoff, config.MaxBackoff))
+	}
+	for i, cidr := range config.AllowedNetworks {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, fmt.Errorf("webhooks.allowedNetworks[%d] must be a CIDR, got %q", i, cidr))
+		}
+	}
+	return errs
+}
+
+// WebhookSubscription asks for a list of devices' new events, status
+// changes, or both, to be POSTed to a URL.
+type WebhookSubscription struct {
+	// ID is assigned when the subscription is created.
+	ID string `json:"id"`
+
+	// Owner is the principal that created the subscription.  Only the owner
+	// and admins may see or delete it.
+	Owner string `json:"owner"`
+
+	// URL is where deliveries are POSTed.  It must be http or https.
+	URL string `json:"url"`
+
+	// DeviceIDs are the devices watched.
+	DeviceIDs []string `json:"device_ids"`
+
+	// PartnerIDs limits the events delivered to those of these partners.  It
+	// defaults to the partners of the caller, and may not include any others.
+	PartnerIDs []string `json:"partner_ids"`
+
+	// Events delivers new events.
+	Events bool `json:"events"`
+
+	// Destinations limits the events delivered to those with destinations
+	// matching any of these patterns, such as event:device-status/*.
+	Destinations []string `json:"destinations,omitempty"`
+
+	// Types limits the events delivered to these wrp message types, such as
+	// SimpleEvent.
+	Types []string `json:"types,omitempty"`
+
+	// Status delivers status changes.
+	Status bool `json:"status"`
+
+	// Secret signs every delivery.  It is only ever sent when creating the
+	// subscription.
+	Secret string `json:"secret,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt is when the subscription is removed, after which the owner
+	// must subscribe again.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// WebhookPayload is the body of a delivery.
+type WebhookPayload struct {
+	DeliveryID     string    `json:"delivery_id"`
+	SubscriptionID string    `json:"subscription_id"`
+	DeviceID       string    `json:"device_id"`
+	Type           string    `json:"type"`
+	Time           time.Time `json:"time"`
+
+	// Events are the device's new events, oldest first, for events
+	// deliveries.
+	Events json.RawMessage `json:"events,omitempty"`
+
+	// Status and PreviousState describe the change, for status deliveries.
+	Status        *Status `json:"status,omitempty"`
+	PreviousState string  `json:"previous_state,omitempty"`
+}
+
+// WebhookDeadLetter is a delivery that was given up on.
+type WebhookDeadLetter struct {
+	DeliveryID string          `json:"delivery_id"`
+	Time       time.Time       `json:"time"`
+	Attempts   int             `json:"attempts"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Error      string          `json:"error"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// webhookSubscription is a subscription along with what is needed to serve
+// it.
+type webhookSubscription struct {
+	WebhookSubscription
+	secret       string
+	ownerType    string
+	capabilities []string
+
+	// cursors are only used by the poller.
+	cursors map[string]*webhookCursor
+
+	// deadLetters are guarded by the Webhooks lock.
+	deadLetters []WebhookDeadLetter
+}
+
+// webhookCursor is how far a subscription has seen into one device.  Nothing
+// is delivered until the device has been polled once, so that subscribing
+// doesn't deliver the device's history.
+type webhookCursor struct {
+	eventsPrimed bool
+	hash         string
+	statusPrimed bool
+	state        string
+}
+
+type webhookDelivery struct {
+	id           string
+	subscription *webhookSubscription
+	body         []byte
+
+	// deviceID and results are audited once the delivery has been made.
+	deviceID string
+	results  int
+}
+
+// Webhooks keeps the webhook subscriptions, polls their devices for new
+// events and status changes, and delivers them in the background.
+type Webhooks struct {
+	config          WebhooksConfig
+	allowedNetworks []*net.IPNet
+	client          *http.Client
+	logger          log.Logger
+	measures        *Measures
+	auditor         *Auditor
+
+	lock          sync.Mutex
+	subscriptions map[string]*webhookSubscription
+
+	deliveries chan webhookDelivery
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	started    atomic.Bool
+}
+
+// NewWebhooks creates the webhook subscriptions for the configuration.  If
+// webhooks are off, it returns nil.  Nothing is polled or delivered until
+// Start is called.  Every delivery is audited by auditor.
+func NewWebhooks(config WebhooksConfig, logger log.Logger, measures *Measures, auditor *Auditor) (*Webhooks, error) {
+	if err := errors.Join(validateWebhooksConfig(&config)...); err != nil {
+		return nil, err
+	}
+	if !config.Enabled {
+		return nil, nil
+	}
+	return newWebhooks(config, logger, measures, auditor), nil
+}
+
+func newWebhooks(config WebhooksConfig, logger log.Logger, measures *Measures, auditor *Auditor) *Webhooks {
+	w := &Webhooks{
+		config:        config,
+		logger:        logger,
+		measures:      measures,
+		auditor:       auditor,
+		subscriptions: map[string]*webhookSubscription{},
+		deliveries:    make(chan webhookDelivery, config.QueueSize),
+	}
+	for _, cidr := range config.AllowedNetworks {
+		// the networks were checked by validateWebhooksConfig
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			w.allowedNetworks = append(w.allowedNetworks, n)
+		}
+	}
+
+	// every connection, including those for redirects, is checked once the
+	// host has been resolved, so a hostname can't be pointed at an internal
+	// address after the subscription is made.  Proxies would hide the
+	// address, so none are used.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   w.checkDial,
+	}).DialContext
+	w.client = &http.Client{Transport: transport}
+
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	return w
+}
+
+// errWebhookAddress is returned for deliveries to addresses that aren't
+// allowed.
+var errWebhookAddress = errors.New("webhook address is not allowed")
+
+// allowedIP reports whether deliveries may be made to the address.
+func (w *Webhooks) allowedIP(ip net.IP) bool {
+	for _, n := range w.allowedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, n := range nonPublicNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// nonPublicNetworks are the ranges that net.IP doesn't consider loopback,
+// private or link-local but aren't public either: "this network", which
+// reaches the local host, and the carrier grade NAT range.
+var nonPublicNetworks = []*net.IPNet{
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+}
+
+// checkDial refuses connections to addresses that aren't allowed.
+func (w *Webhooks) checkDial(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !w.allowedIP(ip) {
+		return fmt.Errorf("%w: %s", errWebhookAddress, host)
+	}
+	return nil
+}
+
+// checkURLHost refuses subscription urls naming an address, or localhost,
+// that deliveries can't be made to.  Other hostnames are checked when they
+// are resolved for each delivery.
+func (w *Webhooks) checkURLHost(host string) error {
+	ip := net.ParseIP(host)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+	if ip != nil && !w.allowedIP(ip) {
+		return fmt.Errorf("%w: %s", errWebhookAddress, host)
+	}
+	return nil
+}
+
+// Start polls the subscribed devices with whichever App is current and
+// delivers what is found.
+func (w *Webhooks) Start(apps *ReloadableApp) {
+	if w == nil || w.started.Swap(true) {
+		return
+	}
+	for i := 0; i < w.config.Workers; i++ {
+		w.wg.Add(1)
+		go w.work()
+	}
+	w.wg.Add(1)
+	go w.run(apps)
+}
+
+// Stop stops polling and abandons the deliveries that haven't been made.
+func (w *Webhooks) Stop() {
+	if w == nil {
+		return
+	}
+	w.cancel()
+	w.wg.Wait()
+	w.client.CloseIdleConnections()
+}
+
+func (w *Webhooks) run(apps *ReloadableApp) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+			w.poll(apps.current.Load())
+		}
+	}
+}
+
+func (w *Webhooks) work() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case d := <-w.deliveries:
+			w.deliver(w.ctx, d)
+		}
+	}
+}
+
+// poll removes the expired subscriptions and checks every other subscribed
+// device once.
+func (w *Webhooks) poll(app *App) {
+	now := time.Now()
+	w.lock.Lock()
+	subscriptions := make([]*webhookSubscription, 0, len(w.subscriptions))
+	for id, s := range w.subscriptions {
+		if s.expired(now) {
+			delete(w.subscriptions, id)
+			logging.Info(w.logger).Log(logging.MessageKey(), "webhook subscription expired", "subscription", s.ID, "owner", s.Owner)
+			continue
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	w.lock.Unlock()
+
+	for _, s := range subscriptions {
+		for _, deviceID := range s.DeviceIDs {
+			if w.ctx.Err() != nil {
+				return
+			}
+			c := s.cursors[deviceID]
+			if s.Events {
+				w.pollEvents(app, s, deviceID, c)
+			}
+			if s.Status {
+				w.pollStatus(app, s, deviceID, c)
+			}
+		}
+	}
+}
+
+// pollEvents delivers the events the device has sent since it was last
+// polled.  At most getEventLimit events are read each time.
+func (w *Webhooks) pollEvents(app *App, s *webhookSubscription, deviceID string, c *webhookCursor) {
+	records, err := app.eventGetter.GetRecords(deviceID, app.getEventLimit, c.hash)
+	if err != nil {
+		logging.Error(w.logger).Log(logging.MessageKey(), "failed to get events for webhook",
+			"subscription", s.ID, "device id", deviceID, logging.ErrorKey(), err.Error())
+		return
+	}
+	if len(records) > 0 {
+		hash, err := app.eventGetter.GetStateHash(records)
+		if err != nil {
+			logging.Error(w.logger).Log(logging.MessageKey(), "failed to get latest hash from records",
+				"subscription", s.ID, "device id", deviceID, logging.ErrorKey(), err.Error())
+			return
+		}
+		c.hash = hash
+	}
+	if !c.eventsPrimed {
+		c.eventsPrimed = true
+		return
+	}
+
+	events := filterPartners(omitFailed(app.parseRecords(records, false)), s.PartnerIDs)
+	matched := make([]model.Event, 0, len(events))
+	for _, e := range events {
+		if s.matches(e) {
+			matched = append(matched, e)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+	sortEvents(matched, false)
+	matched = app.redactor.forCaller(s.PartnerIDs, s.capabilities).events(matched)
+
+	data, err := encodeEvents(matched)
+	if err != nil {
+		logging.Error(w.logger).Log(logging.MessageKey(), "failed to encode events for webhook",
+			"subscription", s.ID, "device id", deviceID, logging.ErrorKey(), err.Error())
+		return
+	}
+	w.enqueue(s, WebhookPayload{DeviceID: deviceID, Type: webhookEvents, Events: data}, len(matched))
+}
+
+// pollStatus delivers the device's status if its state has changed since it
+// was last polled.
+func (w *Webhooks) pollStatus(app *App, s *webhookSubscription, deviceID string, c *webhookCursor) {
+	status, err := app.getStatusInfo(deviceID, false)
+	if err != nil {
+		// a device without a status yet isn't a problem
+		var coder kithttp.StatusCoder
+		if !errors.As(err, &coder) || coder.StatusCode() != http.StatusNotFound {
+			logging.Error(w.logger).Log(logging.MessageKey(), "failed to get status for webhook",
+				"subscription", s.ID, "device id", deviceID, logging.ErrorKey(), err.Error())
+		}
+		return
+	}
+	previous := c.state
+	c.state = status.State
+	if !c.statusPrimed {
+		c.statusPrimed = true
+		return
+	}
+	if status.State == previous {
+		return
+	}
+
+	status = app.redactor.forCaller(s.PartnerIDs, s.capabilities).status(status, app.status.Metadata)
+	w.enqueue(s, WebhookPayload{DeviceID: deviceID, Type: webhookStatus, Status: &status, PreviousState: previous}, 1)
+}
+
+// expired reports whether the subscription has expired.
+func (s *webhookSubscription) expired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
+
+// matches reports whether the event passes the subscription's destination
+// and type filters.
+func (s *webhookSubscription) matches(e model.Event) bool {
+	if len(s.Types) > 0 && !contains(s.Types, e.Type.FriendlyName()) {
+		return false
+	}
+	if len(s.Destinations) == 0 {
+		return true
+	}
+	for _, pattern := range s.Destinations {
+		// the patterns were checked when the subscription was created
+		if ok, _ := path.Match(pattern, e.Destination); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue queues the payload, which holds results events or statuses, for
+// delivery, dead lettering it if the queue is full.
+func (w *Webhooks) enqueue(s *webhookSubscription, payload WebhookPayload, results int) {
+	payload.DeliveryID = uuid.NewString()
+	payload.SubscriptionID = s.ID
+	payload.Time = time.Now().UTC()
+	body, err := json.Marshal(&payload)
+	if err != nil {
+		logging.Error(w.logger).Log(logging.MessageKey(), "failed to encode webhook payload",
+			"subscription", s.ID, logging.ErrorKey(), err.Error())
+		return
+	}
+
+	d := webhookDelivery{id: payload.DeliveryID, subscription: s, body: body, deviceID: payload.DeviceID, results: results}
+	select {
+	case w.deliveries <- d:
+	default:
+		w.deadLetter(d, 0, 0, errors.New("delivery queue is full"))
+	}
+}
+
+// deliver POSTs the delivery, retrying with backoff until it succeeds, it
+// has been tried MaxAttempts times, or ctx is canceled.  The outcome is
+// audited as a request for the device's data by the subscription's owner.
+func (w *Webhooks) deliver(ctx context.Context, d webhookDelivery) {
+	// what was queued before the subscription expired isn't delivered
+	if d.subscription.expired(time.Now()) {
+		return
+	}
+
+	var (
+		start      = time.Now()
+		statusCode int
+		err        error
+	)
+	defer func() {
+		w.audit(d, start, statusCode)
+	}()
+
+	backoff := w.config.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		statusCode, err = w.post(ctx, d)
+		if err == nil {
+			w.measures.WebhookDeliveries.With(outcomeLabel, webhookDelivered).Add(1.0)
+			return
+		}
+		if attempt >= w.config.MaxAttempts || ctx.Err() != nil {
+			w.deadLetter(d, attempt, statusCode, err)
+			return
+		}
+
+		w.measures.WebhookDeliveries.With(outcomeLabel, webhookRetried).Add(1.0)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			w.deadLetter(d, attempt, statusCode, err)
+			return
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, w.config.MaxBackoff)
+	}
+}
+
+// post makes one delivery attempt.  Anything but a 2xx response is a
+// failure.
+func (w *Webhooks) post(ctx context.Context, d webhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.config.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, d.subscription.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(webhookDeliveryHeader, d.id)
+	request.Header.Set(webhookSignatureHeader, webhookSignature(d.subscription.secret, d.body))
+
+	resp, err := w.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("subscriber responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// audit records a delivery that was attempted.  The status code is the
+// subscriber's response to the last attempt, or 0 if it never responded.
+func (w *Webhooks) audit(d webhookDelivery, start time.Time, statusCode int) {
+	s := d.subscription
+	w.auditor.Record(AuditRecord{
+		Time:           start.UTC(),
+		Principal:      s.Owner,
+		AuthType:       s.ownerType,
+		PartnerIDs:     s.PartnerIDs,
+		DeviceID:       d.deviceID,
+		Endpoint:       webhooksEndpoint,
+		Method:         http.MethodPost,
+		ResultCount:    d.results,
+		StatusCode:     statusCode,
+		LatencySeconds: time.Since(start).Seconds(),
+	})
+}
+
+// webhookSignature signs the body with the secret.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter keeps a delivery that was given up on, dropping the oldest dead
+// letter if the subscription has too many.
+func (w *Webhooks) deadLetter(d webhookDelivery, attempts int, statusCode int, err error) {
+	w.measures.WebhookDeliveries.With(outcomeLabel, webhookDeadLettered).Add(1.0)
+	logging.Warn(w.logger).Log(logging.MessageKey(), "webhook delivery failed", "subscription", d.subscription.ID,
+		"delivery", d.id, "attempts", attempts, logging.ErrorKey(), err.Error())
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	s := d.subscription
+	s.deadLetters = append(s.deadLetters, WebhookDeadLetter{
+		DeliveryID: d.id,
+		Time:       time.Now().UTC(),
+		Attempts:   attempts,
+		StatusCode: statusCode,
+		Error:      err.Error(),
+		Payload:    d.body,
+	})
+	if extra := len(s.deadLetters) - w.config.DeadLetters; extra > 0 {
+		s.deadLetters = append([]WebhookDeadLetter(nil), s.deadLetters[extra:]...)
+	}
+}
+
+// newSubscription checks the subscription asked for and fills in what the
+// request doesn't say.
+func (app *App) newSubscription(request *http.Request, sub WebhookSubscription) (*webhookSubscription, error) {
+	principal, authType, ok := requestCaller(request)
+	if !ok {
+		return nil, serverErr{errors.New("unable to identify the caller"), http.StatusForbidden}
+	}
+	callerPartnerIDs, err := extractPartnerIDs(request, app.basicAuthPartnerIDHeaderKey)
+	if err != nil || len(callerPartnerIDs) == 0 {
+		return nil, serverErr{errGettingPartnerIDs, http.StatusBadRequest}
+	}
+
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, serverErr{fmt.Errorf("url must be an http or https URL, got %q", sub.URL), http.StatusBadRequest}
+	}
+	if err := app.webhooks.checkURLHost(strings.ToLower(u.Hostname())); err != nil {
+		return nil, serverErr{err, http.StatusBadRequest}
+	}
+	if sub.Secret == "" {
+		return nil, serverErr{errors.New("secret is required"), http.StatusBadRequest}
+	}
+	if !sub.Events && !sub.Status {
+		return nil, serverErr{errors.New("events, status or both must be true"), http.StatusBadRequest}
+	}
+
+	if len(sub.DeviceIDs) == 0 {
+		return nil, serverErr{errors.New("device_ids is required"), http.StatusBadRequest}
+	}
+	if len(sub.DeviceIDs) > app.webhooks.config.MaxDevices {
+		return nil, serverErr{fmt.Errorf("at most %d device_ids may be watched, got %d", app.webhooks.config.MaxDevices, len(sub.DeviceIDs)), http.StatusBadRequest}
+	}
+	deviceIDs := make([]string, 0, len(sub.DeviceIDs))
+	for _, raw := range sub.DeviceIDs {
+		id, err := parseDeviceID(raw, app.deviceIDs.StripServiceSuffix)
+		if err != nil {
+			return nil, serverErr{err, http.StatusBadRequest}
+		}
+		if !contains(deviceIDs, id) {
+			deviceIDs = append(deviceIDs, id)
+		}
+	}
+
+	if len(sub.PartnerIDs) == 0 {
+		sub.PartnerIDs = callerPartnerIDs
+	} else if !contains(callerPartnerIDs, "*") {
+		for _, p := range sub.PartnerIDs {
+			if !contains(callerPartnerIDs, p) {
+				return nil, serverErr{fmt.Errorf("not allowed to subscribe to partner %q", p), http.StatusForbidden}
+			}
+		}
+	}
+
+	for _, pattern := range sub.Destinations {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, serverErr{fmt.Errorf("invalid destination pattern %q", pattern), http.StatusBadRequest}
+		}
+	}
+	types, err := parseMessageTypes(sub.Types)
+	if err != nil {
+		return nil, serverErr{err, http.StatusBadRequest}
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(app.webhooks.config.MaxTTL)
+	auth, _ := bascule.FromContext(request.Context())
+	if authType == "jwt" {
+		if exp, ok := tokenExpiry(auth.Token); ok && exp.Before(expiresAt) {
+			expiresAt = exp.UTC()
+		}
+	}
+
+	s := &webhookSubscription{
+		WebhookSubscription: WebhookSubscription{
+			ID:           uuid.NewString(),
+			Owner:        principal,
+			URL:          sub.URL,
+			DeviceIDs:    deviceIDs,
+			PartnerIDs:   sub.PartnerIDs,
+			Events:       sub.Events,
+			Destinations: sub.Destinations,
+			Types:        types,
+			Status:       sub.Status,
+			CreatedAt:    now,
+			ExpiresAt:    expiresAt,
+		},
+		secret:    sub.Secret,
+		ownerType: authType,
+		cursors:   make(map[string]*webhookCursor, len(deviceIDs)),
+	}
+	if authType == "jwt" {
+		s.capabilities = tokenCapabilities(auth.Token)
+	}
+	for _, id := range deviceIDs {
+		s.cursors[id] = new(webhookCursor)
+	}
+	return s, nil
+}
+
+// tokenExpiry returns when a JWT expires, from its exp claim.
+func tokenExpiry(token bascule.Token) (time.Time, bool) {
+	val, ok := token.Attributes().Get("exp")
+	if !ok {
+		return time.Time{}, false
+	}
+	exp, err := cast.ToInt64E(val)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(exp, 0), true
+}
+
+// subscription returns the subscription named in the request path, if the
+// caller owns it or is an admin.  Other callers are told it doesn't exist.
+func (app *App) subscription(request *http.Request) (*webhookSubscription, error) {
+	id := mux.Vars(request)["webhookID"]
+	app.webhooks.lock.Lock()
+	s, ok := app.webhooks.subscriptions[id]
+	app.webhooks.lock.Unlock()
+	if !ok || !app.ownsSubscription(request, s) {
+		return nil, serverErr{fmt.Errorf("no webhook subscription %q", id), http.StatusNotFound}
+	}
+	return s, nil
+}
+
+// ownsSubscription reports whether the caller may see and delete the
+// subscription.
+func (app *App) ownsSubscription(request *http.Request, s *webhookSubscription) bool {
+	if app.admin.isAdmin(request) {
+		return true
+	}
+	principal, authType, ok := requestCaller(request)
+	return ok && principal == s.Owner && authType == s.ownerType
+}
+
+// handleCreateWebhook subscribes the caller to devices' events and status
+// changes.
+func (app *App) handleCreateWebhook(writer http.ResponseWriter, request *http.Request) {
+	var sub WebhookSubscription
+	if err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxWebhookRequestSize)).Decode(&sub); err != nil {
+		writeError(writer, serverErr{fmt.Errorf("invalid subscription: %w", err), http.StatusBadRequest})
+		return
+	}
+	s, err := app.newSubscription(request, sub)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+
+	app.webhooks.lock.Lock()
+	if len(app.webhooks.subscriptions) >= app.webhooks.config.MaxSubscriptions {
+		app.webhooks.lock.Unlock()
+		writeError(writer, serverErr{errors.New("too many webhook subscriptions"), http.StatusConflict})
+		return
+	}
+	app.webhooks.subscriptions[s.ID] = s
+	app.webhooks.lock.Unlock()
+
+	logging.Info(app.logger).Log(logging.MessageKey(), "created webhook subscription", "subscription", s.ID,
+		"owner", s.Owner, "url", s.URL, "devices", len(s.DeviceIDs))
+	writeJSON(writer, http.StatusCreated, s.WebhookSubscription)
+}
+
+// handleListWebhooks lists the caller's subscriptions, or every subscription
+// for admins.
+func (app *App) handleListWebhooks(writer http.ResponseWriter, request *http.Request) {
+	app.webhooks.lock.Lock()
+	subscriptions := make([]WebhookSubscription, 0, len(app.webhooks.subscriptions))
+	for _, s := range app.webhooks.subscriptions {
+		if app.ownsSubscription(request, s) {
+			subscriptions = append(subscriptions, s.WebhookSubscription)
+		}
+	}
+	app.webhooks.lock.Unlock()
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if !subscriptions[i].CreatedAt.Equal(subscriptions[j].CreatedAt) {
+			return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+		}
+		return subscriptions[i].ID < subscriptions[j].ID
+	})
+	writeJSON(writer, http.StatusOK, subscriptions)
+}
+
+// handleGetWebhook responds with one subscription.
+func (app *App) handleGetWebhook(writer http.ResponseWriter, request *http.Request) {
+	s, err := app.subscription(request)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+	writeJSON(writer, http.StatusOK, s.WebhookSubscription)
+}
+
+// handleDeleteWebhook unsubscribes.  Deliveries already queued are still
+// made.
+func (app *App) handleDeleteWebhook(writer http.ResponseWriter, request *http.Request) {
+	s, err := app.subscription(request)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+	app.webhooks.lock.Lock()
+	delete(app.webhooks.subscriptions, s.ID)
+	app.webhooks.lock.Unlock()
+
+	logging.Info(app.logger).Log(logging.MessageKey(), "deleted webhook subscription", "subscription", s.ID, "owner", s.Owner)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetWebhookDeadLetters responds with the subscription's failed
+// deliveries, oldest first.
+func (app *App) handleGetWebhookDeadLetters(writer http.ResponseWriter, request *http.Request) {
+	s, err := app.subscription(request)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+	app.webhooks.lock.Lock()
+	deadLetters := append([]WebhookDeadLetter{}, s.deadLetters...)
+	app.webhooks.lock.Unlock()
+	writeJSON(writer, http.StatusOK, deadLetters)
+}
@@ -0,0 +1,640 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/bascule"
+	db "github.com/xmidt-org/codex-db"
+	"github.com/xmidt-org/gungnir/model"
+	"github.com/xmidt-org/webpa-common/v2/logging"               //nolint: staticcheck
+	"github.com/xmidt-org/webpa-common/v2/xmetrics/xmetricstest" //nolint: staticcheck
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestValidateWebhooksConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	config := WebhooksConfig{}
+	assert.Empty(validateWebhooksConfig(&config))
+	assert.Equal(WebhooksConfig{}, config)
+
+	config = WebhooksConfig{Enabled: true}
+	assert.Empty(validateWebhooksConfig(&config))
+	assert.Equal(WebhooksConfig{
+		Enabled:          true,
+		PollInterval:     defaultWebhookPollInterval,
+		MaxSubscriptions: defaultWebhookMaxSubscriptions,
+		MaxDevices:       defaultWebhookMaxDevices,
+		Workers:          defaultWebhookWorkers,
+		QueueSize:        defaultWebhookQueueSize,
+		MaxAttempts:      defaultWebhookMaxAttempts,
+		InitialBackoff:   defaultWebhookInitialBackoff,
+		MaxBackoff:       defaultWebhookMaxBackoff,
+		Timeout:          defaultWebhookTimeout,
+		DeadLetters:      defaultWebhookDeadLetters,
+		MaxTTL:           defaultWebhookMaxTTL,
+	}, config)
+
+	config = WebhooksConfig{Enabled: true, Workers: -1, PollInterval: -time.Second, InitialBackoff: time.Hour, AllowedNetworks: []string{"10.0.0.0/8", "10.0.0.1"}}
+	errs := validateWebhooksConfig(&config)
+	require.Len(t, errs, 4)
+	assert.Contains(errs[0].Error(), "webhooks.workers must be positive")
+	assert.Contains(errs[1].Error(), "webhooks.pollInterval must be positive")
+	assert.Contains(errs[2].Error(), "webhooks.initialBackoff (1h0m0s) must not be more than webhooks.maxBackoff")
+	assert.Contains(errs[3].Error(), "webhooks.allowedNetworks[1] must be a CIDR")
+}
+
+func TestWebhookAllowedAddresses(t *testing.T) {
+	assert := assert.New(t)
+	w := testWebhooks(testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics))), WebhooksConfig{Enabled: true, AllowedNetworks: []string{"10.0.0.0/8"}})
+	for _, address := range []string{"93.184.216.34", "2606:2800:220:1::1", "10.1.2.3"} {
+		assert.True(w.allowedIP(net.ParseIP(address)), address)
+	}
+	for _, address := range []string{
+		"127.0.0.1", "::1", "::ffff:127.0.0.1", "0.0.0.0", "0.1.2.3", "169.254.169.254", "fe80::1",
+		"172.16.0.1", "192.168.1.1", "fd00::1", "100.64.0.1", "224.0.0.1", "255.255.255.255",
+	} {
+		assert.False(w.allowedIP(net.ParseIP(address)), address)
+	}
+}
+
+func TestWebhookDeliveryAddressNotAllowed(t *testing.T) {
+	assert := assert.New(t)
+	receiver := new(webhookReceiver)
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	// a hostname resolving to an address that isn't allowed is only caught
+	// when delivering
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.webhooks = testWebhooks(app, WebhooksConfig{Enabled: true, MaxAttempts: 1, AllowedNetworks: []string{}})
+	s := testWebhookSubscription(app, strings.Replace(server.URL, "127.0.0.1", "localhost", 1), WebhookSubscription{})
+	app.webhooks.deliver(context.Background(), webhookDelivery{id: "1", subscription: s, body: []byte(`{}`)})
+	assert.Zero(receiver.received())
+	if assert.Len(s.deadLetters, 1) {
+		assert.Contains(s.deadLetters[0].Error, errWebhookAddress.Error())
+	}
+}
+
+// testWebhooks returns the app's webhooks, allowed to deliver to loopback
+// unless the config says otherwise.
+func testWebhooks(app *App, config WebhooksConfig) *Webhooks {
+	if config.AllowedNetworks == nil {
+		// the test receivers listen on loopback
+		config.AllowedNetworks = []string{"127.0.0.0/8"}
+	}
+	_ = validateWebhooksConfig(&config)
+	return newWebhooks(config, app.logger, app.measures, nil)
+}
+
+func TestHandleCreateWebhook(t *testing.T) {
+	tests := []struct {
+		description        string
+		body               string
+		expectedStatusCode int
+		expected           WebhookSubscription
+	}{
+		{
+			description:        "Success",
+			body:               `{"url": "https://example.com/hook", "device_ids": ["MAC:112233445566", "mac:112233445566"], "events": true, "types": ["event"], "secret": "shh"}`,
+			expectedStatusCode: http.StatusCreated,
+			expected: WebhookSubscription{
+				Owner:      "owner",
+				URL:        "https://example.com/hook",
+				DeviceIDs:  []string{"mac:112233445566"},
+				PartnerIDs: []string{"test1"},
+				Events:     true,
+				Types:      []string{"SimpleEvent"},
+			},
+		},
+		{
+			description:        "Status And Partner",
+			body:               `{"url": "http://localhost:8080", "device_ids": ["mac:112233445566"], "partner_ids": ["test1"], "status": true, "destinations": ["/test/*"], "secret": "shh"}`,
+			expectedStatusCode: http.StatusCreated,
+			expected: WebhookSubscription{
+				Owner:        "owner",
+				URL:          "http://localhost:8080",
+				DeviceIDs:    []string{"mac:112233445566"},
+				PartnerIDs:   []string{"test1"},
+				Destinations: []string{"/test/*"},
+				Status:       true,
+			},
+		},
+		{
+			description:        "Invalid JSON",
+			body:               `{"url":`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Invalid URL",
+			body:               `{"url": "ftp://example.com", "device_ids": ["mac:112233445566"], "events": true, "secret": "shh"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Metadata Address",
+			body:               `{"url": "http://169.254.169.254/latest/meta-data", "device_ids": ["mac:112233445566"], "events": true, "secret": "shh"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Private Address",
+			body:               `{"url": "https://10.1.2.3/hook", "device_ids": ["mac:112233445566"], "events": true, "secret": "shh"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Loopback Address Not Allowed",
+			body:               `{"url": "http://[::1]:8080", "device_ids": ["mac:112233445566"], "events": true, "secret": "shh"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "No Secret",
+			body:               `{"url": "https://example.com", "device_ids": ["mac:112233445566"], "events": true}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Nothing Subscribed To",
+			body:               `{"url": "https://example.com", "device_ids": ["mac:112233445566"], "secret": "shh"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "No Devices",
+			body:               `{"url": "https://example.com", "events": true, "secret": "shh"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Too Many Devices",
+			body:               `{"url": "https://example.com", "device_ids": ["mac:112233445566", "mac:112233445567", "mac:112233445568"], "events": true, "secret": "shh"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Invalid Device",
+			body:               `{"url": "https://example.com", "device_ids": ["mac:1122"], "events": true, "secret": "shh"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Other Partner",
+			body:               `{"url": "https://example.com", "device_ids": ["mac:112233445566"], "partner_ids": ["test2"], "events": true, "secret": "shh"}`,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			description:        "Invalid Destination",
+			body:               `{"url": "https://example.com", "device_ids": ["mac:112233445566"], "destinations": ["["], "events": true, "secret": "shh"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			description:        "Invalid Type",
+			body:               `{"url": "https://example.com", "device_ids": ["mac:112233445566"], "types": ["Loud"], "events": true, "secret": "shh"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+			app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+			app.webhooks = testWebhooks(app, WebhooksConfig{Enabled: true, MaxDevices: 2})
+			rr := httptest.NewRecorder()
+			app.handleCreateWebhook(rr, testRequest(t, testAuth("owner", "test1"), http.MethodPost, "/webhooks", tc.body, nil))
+			require.Equal(tc.expectedStatusCode, rr.Code)
+			if tc.expectedStatusCode != http.StatusCreated {
+				assert.NotEmpty(rr.Header().Get("X-Codex-Error"))
+				assert.Empty(app.webhooks.subscriptions)
+				return
+			}
+
+			assert.NotContains(rr.Body.String(), "shh")
+			var sub WebhookSubscription
+			require.Nil(json.Unmarshal(rr.Body.Bytes(), &sub))
+			require.Contains(app.webhooks.subscriptions, sub.ID)
+			assert.Equal("shh", app.webhooks.subscriptions[sub.ID].secret)
+			assert.Equal([]string{"x1:gungnir:owner"}, app.webhooks.subscriptions[sub.ID].capabilities)
+			assert.NotEmpty(sub.ID)
+			assert.False(sub.CreatedAt.IsZero())
+			// the token doesn't expire, so the subscription lasts maxTTL
+			assert.Equal(sub.CreatedAt.Add(defaultWebhookMaxTTL), sub.ExpiresAt)
+			sub.ID, sub.CreatedAt, sub.ExpiresAt = "", time.Time{}, time.Time{}
+			assert.Equal(tc.expected, sub)
+		})
+	}
+}
+
+func TestHandleCreateWebhookLimit(t *testing.T) {
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.webhooks = testWebhooks(app, WebhooksConfig{Enabled: true, MaxSubscriptions: 1})
+	body := `{"url": "https://example.com", "device_ids": ["mac:112233445566"], "events": true, "secret": "shh"}`
+	for _, expected := range []int{http.StatusCreated, http.StatusConflict} {
+		rr := httptest.NewRecorder()
+		app.handleCreateWebhook(rr, testRequest(t, testAuth("owner", "test1"), http.MethodPost, "/webhooks", body, nil))
+		assert.Equal(t, expected, rr.Code)
+	}
+}
+
+func TestWebhookAccess(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.webhooks = testWebhooks(app, WebhooksConfig{Enabled: true})
+	var (
+		owner = testAuth("owner", "test1")
+		other = testAuth("other", "test1")
+		admin = testAuth("admin", "*")
+	)
+
+	rr := httptest.NewRecorder()
+	app.handleCreateWebhook(rr, testRequest(t, owner, http.MethodPost, "/webhooks",
+		`{"url": "https://example.com", "device_ids": ["mac:112233445566"], "events": true, "secret": "shh"}`, nil))
+	require.Equal(http.StatusCreated, rr.Code)
+	var sub WebhookSubscription
+	require.Nil(json.Unmarshal(rr.Body.Bytes(), &sub))
+	app.webhooks.subscriptions[sub.ID].deadLetters = []WebhookDeadLetter{{DeliveryID: "1", Attempts: 5, Error: "bad", Payload: json.RawMessage(`{}`)}}
+
+	list := func(auth bascule.Authentication) []WebhookSubscription {
+		rr := httptest.NewRecorder()
+		app.handleListWebhooks(rr, testRequest(t, auth, http.MethodGet, "/webhooks", "", nil))
+		require.Equal(http.StatusOK, rr.Code)
+		var subs []WebhookSubscription
+		require.Nil(json.Unmarshal(rr.Body.Bytes(), &subs))
+		return subs
+	}
+	assert.Len(list(owner), 1)
+	assert.Empty(list(other))
+	assert.Len(list(admin), 1)
+
+	for _, tc := range []struct {
+		auth     bascule.Authentication
+		expected int
+	}{
+		{owner, http.StatusOK},
+		{other, http.StatusNotFound},
+		{admin, http.StatusOK},
+	} {
+		rr = httptest.NewRecorder()
+		app.handleGetWebhook(rr, testRequest(t, tc.auth, http.MethodGet, "/webhooks/"+sub.ID, "", map[string]string{"webhookID": sub.ID}))
+		assert.Equal(tc.expected, rr.Code)
+		assert.NotContains(rr.Body.String(), "shh")
+
+		rr = httptest.NewRecorder()
+		app.handleGetWebhookDeadLetters(rr, testRequest(t, tc.auth, http.MethodGet, "/webhooks/"+sub.ID+"/deadletters", "", map[string]string{"webhookID": sub.ID}))
+		assert.Equal(tc.expected, rr.Code)
+	}
+	var deadLetters []WebhookDeadLetter
+	require.Nil(json.Unmarshal(rr.Body.Bytes(), &deadLetters))
+	assert.Equal([]WebhookDeadLetter{{DeliveryID: "1", Attempts: 5, Error: "bad", Payload: json.RawMessage(`{}`)}}, deadLetters)
+
+	rr = httptest.NewRecorder()
+	app.handleDeleteWebhook(rr, testRequest(t, other, http.MethodDelete, "/webhooks/"+sub.ID, "", map[string]string{"webhookID": sub.ID}))
+	assert.Equal(http.StatusNotFound, rr.Code)
+	rr = httptest.NewRecorder()
+	app.handleDeleteWebhook(rr, testRequest(t, owner, http.MethodDelete, "/webhooks/"+sub.ID, "", map[string]string{"webhookID": sub.ID}))
+	assert.Equal(http.StatusNoContent, rr.Code)
+	assert.Empty(list(owner))
+}
+
+// webhookReceiver is a subscriber that responds with the status codes given,
+// then 200s.
+type webhookReceiver struct {
+	lock     sync.Mutex
+	codes    []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	body, _ := io.ReadAll(request.Body)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.requests = append(r.requests, request)
+	r.bodies = append(r.bodies, body)
+	if len(r.codes) > 0 {
+		w.WriteHeader(r.codes[0])
+		r.codes = r.codes[1:]
+	}
+}
+
+func (r *webhookReceiver) received() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.bodies)
+}
+
+func testWebhookSubscription(app *App, url string, sub WebhookSubscription) *webhookSubscription {
+	sub.ID = "sub"
+	sub.URL = url
+	if sub.ExpiresAt.IsZero() {
+		sub.ExpiresAt = time.Now().Add(time.Hour)
+	}
+	s := &webhookSubscription{
+		WebhookSubscription: sub,
+		secret:              "shh",
+		cursors:             map[string]*webhookCursor{},
+	}
+	for _, id := range sub.DeviceIDs {
+		s.cursors[id] = new(webhookCursor)
+	}
+	app.webhooks.subscriptions[s.ID] = s
+	return s
+}
+
+func TestWebhookExpiry(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	receiver := new(webhookReceiver)
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.webhooks = testWebhooks(app, WebhooksConfig{Enabled: true})
+
+	// a subscription made with a JWT expires with it
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	auth := testAuth("owner", "test1")
+	auth.Token = bascule.NewToken("jwt", "owner", bascule.NewAttributes(map[string]interface{}{
+		"allowedResources": map[string]interface{}{"allowedPartners": []string{"test1"}},
+		"exp":              float64(exp.Unix()),
+	}))
+	rr := httptest.NewRecorder()
+	app.handleCreateWebhook(rr, testRequest(t, auth, http.MethodPost, "/webhooks",
+		`{"url": "`+server.URL+`", "device_ids": ["mac:112233445566"], "events": true, "secret": "shh"}`, nil))
+	require.Equal(http.StatusCreated, rr.Code)
+	var sub WebhookSubscription
+	require.Nil(json.Unmarshal(rr.Body.Bytes(), &sub))
+	assert.True(exp.Equal(sub.ExpiresAt))
+
+	// once expired, nothing more is delivered and the next poll removes it
+	s := app.webhooks.subscriptions[sub.ID]
+	s.ExpiresAt = time.Now()
+	app.webhooks.deliver(context.Background(), webhookDelivery{id: "1", subscription: s, body: []byte(`{}`)})
+	assert.Zero(receiver.received())
+	app.webhooks.poll(app)
+	assert.Empty(app.webhooks.subscriptions)
+}
+
+func TestWebhookEvents(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	receiver := new(webhookReceiver)
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	online, offline, other := goodOnlineEvent, goodOfflineEvent, goodOnlineEvent
+	online.Type, offline.Type, other.Type = wrp.SimpleEventMessageType, wrp.SimpleEventMessageType, wrp.SimpleEventMessageType
+	other.PartnerIDs = []string{"other"}
+	records := []db.Record{
+		testRecord(t, online, 300),
+		testRecord(t, other, 250),
+		testRecord(t, offline, 200),
+	}
+
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return(records[2:], nil).Once()
+	mockGetter.On("GetStateHash", records[2:]).Return("first", nil).Once()
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "first").Return(records[:2], nil).Once()
+	mockGetter.On("GetStateHash", records[:2]).Return("second", nil).Once()
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "second").Return([]db.Record{}, nil).Once()
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.webhooks = testWebhooks(app, WebhooksConfig{Enabled: true})
+	app.eventGetter = mockGetter
+	s := testWebhookSubscription(app, server.URL, WebhookSubscription{
+		Owner:        "owner",
+		DeviceIDs:    []string{"mac:112233445566"},
+		PartnerIDs:   []string{"test1"},
+		Events:       true,
+		Destinations: []string{"/test/*"},
+	})
+	s.ownerType = "jwt"
+
+	// the first poll only finds where the device is
+	app.webhooks.poll(app)
+	assert.Empty(app.webhooks.deliveries)
+	app.webhooks.poll(app)
+	require.Len(app.webhooks.deliveries, 1)
+	app.webhooks.poll(app)
+	require.Len(app.webhooks.deliveries, 1)
+	mockGetter.AssertExpectations(t)
+
+	auditor, sink := testAuditor()
+	app.webhooks.auditor = auditor
+	app.webhooks.deliver(context.Background(), <-app.webhooks.deliveries)
+	require.Equal(1, receiver.received())
+	request, body := receiver.requests[0], receiver.bodies[0]
+	assert.Equal(webhookSignature("shh", body), request.Header.Get(webhookSignatureHeader))
+	assert.True(strings.HasPrefix(request.Header.Get(webhookSignatureHeader), "sha256="))
+	assert.Equal("application/json", request.Header.Get("Content-Type"))
+
+	var payload struct {
+		WebhookPayload
+		Events []model.Event `json:"events"`
+	}
+	require.Nil(json.Unmarshal(body, &payload))
+	assert.Equal(request.Header.Get(webhookDeliveryHeader), payload.DeliveryID)
+	assert.Equal("sub", payload.SubscriptionID)
+	assert.Equal("mac:112233445566", payload.DeviceID)
+	assert.Equal(webhookEvents, payload.Type)
+	// the other partner's event isn't delivered
+	require.Len(payload.Events, 1)
+	assert.Equal(int64(300), payload.Events[0].BirthDate)
+
+	// the delivery is audited as the owner's request for the device's events
+	auditor.Stop()
+	audited := sink.records()
+	require.Len(audited, 1)
+	assert.Equal("owner", audited[0].Principal)
+	assert.Equal("jwt", audited[0].AuthType)
+	assert.Equal([]string{"test1"}, audited[0].PartnerIDs)
+	assert.Equal("mac:112233445566", audited[0].DeviceID)
+	assert.Equal(webhooksEndpoint, audited[0].Endpoint)
+	assert.Equal(1, audited[0].ResultCount)
+	assert.Equal(http.StatusOK, audited[0].StatusCode)
+
+	p := xmetricstest.NewProvider(nil, Metrics)
+	app.webhooks.measures = NewMeasures(p)
+	app.webhooks.deliver(context.Background(), webhookDelivery{id: "1", subscription: s, body: body})
+	p.Assert(t, WebhookDeliveriesCounter, outcomeLabel, webhookDelivered)(xmetricstest.Value(1.0))
+}
+
+func TestWebhookStatus(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	// the device goes offline in the session it came online in
+	offlineEvent := goodOfflineEvent
+	offlineEvent.SessionID = goodOnlineEvent.SessionID
+	online := []db.Record{testRecord(t, goodOnlineEvent, time.Now().Add(-time.Minute).UnixNano())}
+	offline := []db.Record{testRecord(t, offlineEvent, time.Now().UnixNano())}
+
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecordsOfType", "mac:112233445566", 5, db.State, "").Return(online, nil).Twice()
+	mockGetter.On("GetRecordsOfType", "mac:112233445566", 5, db.State, "").Return(append(offline, online...), nil).Once()
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.webhooks = testWebhooks(app, WebhooksConfig{Enabled: true})
+	app.getStatusLimit = 5
+	app.eventGetter = mockGetter
+	testWebhookSubscription(app, "http://localhost", WebhookSubscription{
+		DeviceIDs: []string{"mac:112233445566"},
+		Status:    true,
+	})
+
+	app.webhooks.poll(app)
+	app.webhooks.poll(app)
+	assert.Empty(app.webhooks.deliveries)
+	app.webhooks.poll(app)
+	require.Len(app.webhooks.deliveries, 1)
+
+	var payload WebhookPayload
+	require.Nil(json.Unmarshal((<-app.webhooks.deliveries).body, &payload))
+	assert.Equal(webhookStatus, payload.Type)
+	assert.Equal("online", payload.PreviousState)
+	require.NotNil(payload.Status)
+	assert.Equal("offline", payload.Status.State)
+	assert.Nil(payload.Events)
+}
+
+func TestWebhookRetries(t *testing.T) {
+	tests := []struct {
+		description         string
+		codes               []int
+		expectedReceived    int
+		expectedRetried     float64
+		expectedDeadLetters []WebhookDeadLetter
+	}{
+		{
+			description:      "Delivered After Retries",
+			codes:            []int{http.StatusInternalServerError, http.StatusBadGateway},
+			expectedReceived: 3,
+			expectedRetried:  2,
+		},
+		{
+			description:      "Dead Lettered",
+			codes:            []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusNotFound},
+			expectedReceived: 3,
+			expectedRetried:  2,
+			expectedDeadLetters: []WebhookDeadLetter{
+				{DeliveryID: "1", Attempts: 3, StatusCode: http.StatusNotFound, Error: "subscriber responded with 404 Not Found", Payload: json.RawMessage(`{}`)},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+			receiver := &webhookReceiver{codes: tc.codes}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			p := xmetricstest.NewProvider(nil, Metrics)
+			app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+			app.webhooks = testWebhooks(app, WebhooksConfig{Enabled: true, MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond})
+			app.webhooks.measures = NewMeasures(p)
+			s := testWebhookSubscription(app, server.URL, WebhookSubscription{})
+
+			app.webhooks.deliver(context.Background(), webhookDelivery{id: "1", subscription: s, body: []byte(`{}`)})
+			assert.Equal(tc.expectedReceived, receiver.received())
+			for _, body := range receiver.bodies {
+				assert.Equal([]byte(`{}`), body)
+			}
+			p.Assert(t, WebhookDeliveriesCounter, outcomeLabel, webhookRetried)(xmetricstest.Value(tc.expectedRetried))
+			if tc.expectedDeadLetters == nil {
+				p.Assert(t, WebhookDeliveriesCounter, outcomeLabel, webhookDelivered)(xmetricstest.Value(1.0))
+				assert.Empty(s.deadLetters)
+				return
+			}
+			p.Assert(t, WebhookDeliveriesCounter, outcomeLabel, webhookDeadLettered)(xmetricstest.Value(1.0))
+			for i := range s.deadLetters {
+				assert.False(s.deadLetters[i].Time.IsZero())
+				s.deadLetters[i].Time = time.Time{}
+			}
+			assert.Equal(tc.expectedDeadLetters, s.deadLetters)
+		})
+	}
+}
+
+func TestWebhookDeadLetterLimits(t *testing.T) {
+	assert := assert.New(t)
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.webhooks = testWebhooks(app, WebhooksConfig{Enabled: true, QueueSize: 1, DeadLetters: 2})
+	s := testWebhookSubscription(app, "http://localhost", WebhookSubscription{})
+
+	for i := 0; i < 4; i++ {
+		app.webhooks.enqueue(s, WebhookPayload{DeviceID: "mac:112233445566", Type: webhookEvents}, 1)
+	}
+	assert.Len(app.webhooks.deliveries, 1)
+	// only the newest dead letters are kept
+	assert.Len(s.deadLetters, 2)
+	for _, d := range s.deadLetters {
+		assert.Equal("delivery queue is full", d.Error)
+		assert.Zero(d.Attempts)
+	}
+
+	// a canceled delivery is dead lettered without waiting out the backoff
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	app.webhooks.deliver(ctx, <-app.webhooks.deliveries)
+	assert.Len(s.deadLetters, 2)
+	assert.Equal(1, s.deadLetters[1].Attempts)
+}
+
+func TestWebhooksStartStop(t *testing.T) {
+	require := require.New(t)
+	receiver := new(webhookReceiver)
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	msg := goodOnlineEvent
+	msg.Type = wrp.SimpleEventMessageType
+	records := []db.Record{testRecord(t, msg, 100)}
+	mockGetter := new(mockRecordGetter)
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return([]db.Record{}, nil).Once()
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "").Return(records, nil).Once()
+	mockGetter.On("GetStateHash", mock.Anything).Return("hash", nil)
+	mockGetter.On("GetRecords", "mac:112233445566", 5, "hash").Return([]db.Record{}, nil)
+
+	app := testApp(NewMeasures(xmetricstest.NewProvider(nil, Metrics)))
+	app.webhooks = testWebhooks(app, WebhooksConfig{Enabled: true, PollInterval: 5 * time.Millisecond})
+	app.eventGetter = mockGetter
+	testWebhookSubscription(app, server.URL, WebhookSubscription{
+		DeviceIDs:  []string{"mac:112233445566"},
+		PartnerIDs: []string{"*"},
+		Events:     true,
+	})
+
+	app.webhooks.Start(NewReloadableApp(app))
+	require.Eventually(func() bool { return receiver.received() == 1 }, 5*time.Second, 5*time.Millisecond)
+	app.webhooks.Stop()
+	require.True(bytes.Contains(receiver.bodies[0], []byte(`"device_id":"mac:112233445566"`)))
+
+	// stopping without starting, or without webhooks, does nothing
+	testWebhooks(app, WebhooksConfig{Enabled: true}).Stop()
+	(*Webhooks)(nil).Start(nil)
+	(*Webhooks)(nil).Stop()
+}
+
+func TestNewWebhooks(t *testing.T) {
+	assert := assert.New(t)
+	measures := NewMeasures(xmetricstest.NewProvider(nil, Metrics))
+
+	w, err := NewWebhooks(WebhooksConfig{}, logging.DefaultLogger(), measures, nil)
+	assert.Nil(w)
+	assert.Nil(err)
+
+	w, err = NewWebhooks(WebhooksConfig{Enabled: true, Timeout: -time.Second}, logging.DefaultLogger(), measures, nil)
+	assert.Nil(w)
+	assert.NotNil(err)
+
+	w, err = NewWebhooks(WebhooksConfig{Enabled: true}, logging.DefaultLogger(), measures, nil)
+	assert.Nil(err)
+	assert.Equal(defaultWebhookWorkers, w.config.Workers)
+}